@@ -1,12 +1,17 @@
 package main
 
 import (
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"time"
 
 	"filedownloader-20240926/internal/config"
 	"filedownloader-20240926/internal/handler"
+	"filedownloader-20240926/internal/repository"
 	"filedownloader-20240926/internal/service"
+	"filedownloader-20240926/internal/version"
 	"filedownloader-20240926/pkg/logger"
 )
 
@@ -17,30 +22,99 @@ func main() {
 		os.Exit(1)
 	}
 
-	setupLogging(cfg)
+	if err := setupLogging(cfg); err != nil {
+		logger.Logger.Error("Failed to set up logging", "error", err)
+		os.Exit(1)
+	}
 
 	logger.Logger.Info("Starting File Downloader Service",
+		"version", version.Version,
 		"server_port", cfg.Server.Port,
 		"worker_count", cfg.Worker.Count,
-		"debug_mode", cfg.IsDebugMode())
+		"debug_mode", cfg.IsDebugMode(),
+		"auth_enabled", cfg.Server.AuthToken != "",
+		"storage_backend", cfg.Storage.Backend,
+		"state_dir", cfg.Storage.StateDir)
 
 	logger.Logger.Info("Initializing components")
-	taskManager := service.NewTaskManager()
+	storage, err := repository.NewStorage(cfg.Storage.Backend, cfg.Storage.StateDir, cfg.Storage.Compress, cfg.Storage.Compact)
+	if err != nil {
+		logger.Logger.Error("Failed to create storage", "error", err)
+		os.Exit(1)
+	}
+	taskManager := service.NewTaskManagerWithStorage(storage)
+	taskManager.SetMaxURLsPerTask(cfg.Download.MaxURLsPerTask)
+	taskManager.SetMaxOutstandingFiles(cfg.Download.MaxOutstandingFiles)
+	taskManager.SetSaveInterval(time.Duration(cfg.Storage.SaveIntervalMS) * time.Millisecond)
+	taskManager.SetMaxTasksInMemory(cfg.Storage.MaxTasksInMemory)
+	downloader := service.NewDownloaderWithConfig(cfg.Download)
+	if err := downloader.SetTLSConfig(cfg.Download.TLS); err != nil {
+		logger.Logger.Error("Failed to configure TLS", "error", err)
+		os.Exit(1)
+	}
+	if err := downloader.SetEgressGuard(cfg.Download.EgressGuard); err != nil {
+		logger.Logger.Error("Failed to configure egress guard", "error", err)
+		os.Exit(1)
+	}
+	if !cfg.Download.EgressGuard.Enabled {
+		logger.Logger.Warn("Egress guard is disabled: downloads may reach loopback and private addresses",
+			"setting", "download.egress_guard.enabled")
+	}
 	workerPool := service.NewWorkerPool(cfg.Worker.Count, taskManager)
+	workerPool.SetDownloader(downloader)
+	if err := workerPool.SetCallbackEgressGuard(cfg.Download.EgressGuard); err != nil {
+		logger.Logger.Error("Failed to configure egress guard", "error", err)
+		os.Exit(1)
+	}
+	workerPool.SetTaskTimeout(time.Duration(cfg.Download.TaskTimeoutSeconds) * time.Second)
+	workerPool.SetMaxPerHost(cfg.Worker.MaxPerHost)
+	workerPool.SetMaxPerTask(cfg.Worker.MaxPerTask)
+	workerPool.SetMaxConnections(cfg.Worker.MaxConnections)
+	workerPool.SetMaxActiveTasks(cfg.Worker.MaxActiveTasks)
+	workerPool.SetExtract(cfg.Download.Extract)
+	workerPool.SetRetryPolicy(cfg.Download.MaxAttempts,
+		time.Duration(cfg.Download.RetryBackoffSeconds)*time.Second)
 	workerPool.Start()
 
 	logger.Logger.Info("Recovering incomplete tasks")
-	taskManager.RecoverIncompleteTasks()
+	workerPool.ResumeIncompleteTasks()
+
+	sweeper := service.NewSweeper(taskManager, downloader,
+		time.Duration(cfg.Cleanup.TaskTTLSeconds)*time.Second,
+		time.Duration(cfg.Cleanup.IntervalSeconds)*time.Second,
+		cfg.Cleanup.DeleteFiles)
+	sweeper.Start()
+
+	diskMonitor := service.NewDiskMonitor(workerPool, downloader,
+		cfg.Download.FreeSpaceGuard.MinFreeMB<<20,
+		time.Duration(cfg.Download.FreeSpaceGuard.IntervalSeconds)*time.Second)
+	diskMonitor.Start()
 
 	logger.Logger.Info("Setting up HTTP server")
 	th := handler.NewTaskHandler(taskManager, workerPool)
+	th.SetStatusETag(cfg.Server.StatusETag)
+	rateLimiter := handler.NewRateLimiter(cfg.Server.RateLimit.RequestsPerSecond,
+		cfg.Server.RateLimit.Burst, cfg.Server.RateLimit.PerIP)
+	routeOpts := handler.RouteOptions{
+		AuthToken:        cfg.Server.AuthToken,
+		RateLimiter:      rateLimiter,
+		DisableAccessLog: !cfg.Server.AccessLog,
+	}
 	server := &http.Server{
 		Addr:    cfg.GetServerAddr(),
-		Handler: handler.SetupRoutes(th),
+		Handler: handler.SetupRoutes(th, handler.NewAdminHandler(workerPool, taskManager), routeOpts),
 	}
 
 	logger.Logger.Info("Setting up graceful shutdown")
 	graceful := service.NewGracefulShutdown(server, workerPool, taskManager)
+	graceful.SetSweeper(sweeper)
+	graceful.SetDiskMonitor(diskMonitor)
+	graceful.SetReload((&reloader{
+		current:     cfg,
+		workerPool:  workerPool,
+		downloader:  downloader,
+		rateLimiter: rateLimiter,
+	}).Reload)
 
 	logger.Logger.Info("Server starting", "addr", cfg.GetServerAddr())
 	if err := graceful.Start(); err != nil {
@@ -50,17 +124,77 @@ func main() {
 }
 
 // setupLogging configures logging based on the configuration
-func setupLogging(cfg *config.Config) {
+func setupLogging(cfg *config.Config) error {
 	if cfg.IsDebugMode() {
 		logger.SetDebug()
 	} else {
 		logger.SetProduction()
 	}
 
+	switch {
+	case len(cfg.Logging.Outputs) > 0:
+		multiLogger, err := newMultiOutputLogger(cfg.Logging.Outputs)
+		if err != nil {
+			return err
+		}
+		logger.Logger = multiLogger
+	case cfg.Logging.Output == "file":
+		w, err := logger.NewRotatingWriter(cfg.Logging.FilePath, cfg.Logging.MaxSizeMB, cfg.Logging.MaxBackups)
+		if err != nil {
+			return err
+		}
+		logger.Logger = logger.NewLogger(w, slog.LevelInfo, &logger.JSONFormatter{}, false, cfg.Logging.TimeFormat)
+	case cfg.Logging.TimeFormat != "":
+		var formatter logger.Formatter = &logger.JSONFormatter{}
+		if cfg.IsDebugMode() {
+			formatter = &logger.TextFormatter{}
+		}
+		logger.Logger = logger.NewLogger(os.Stdout, slog.LevelInfo, formatter, false, cfg.Logging.TimeFormat)
+	}
+
+	if cfg.IsDebugMode() {
+		logger.SetLevel(slog.LevelDebug)
+	} else if len(cfg.Logging.Outputs) == 0 {
+		if level, err := logger.ParseLevel(cfg.Logging.Level); err == nil {
+			logger.SetLevel(level)
+		}
+	}
+
 	logger.Logger.Info("Configuration loaded",
 		"config_file", "config.yaml",
 		"server_port", cfg.Server.Port,
 		"worker_count", cfg.Worker.Count,
 		"log_level", cfg.Logging.Level,
-		"log_format", cfg.Logging.Format)
+		"log_format", cfg.Logging.Format,
+		"log_output", cfg.Logging.Output)
+
+	return nil
+}
+
+// newMultiOutputLogger builds a logger writing to every configured output with its own format and level
+func newMultiOutputLogger(outputs []config.LogOutputConfig) (*slog.Logger, error) {
+	handlers := make([]slog.Handler, 0, len(outputs))
+	for _, out := range outputs {
+		level, err := logger.ParseLevel(out.Level)
+		if err != nil {
+			return nil, err
+		}
+
+		var writer io.Writer = os.Stdout
+		if out.Type == "file" {
+			rw, err := logger.NewRotatingWriter(out.FilePath, out.MaxSizeMB, out.MaxBackups)
+			if err != nil {
+				return nil, err
+			}
+			writer = rw
+		}
+
+		handlers = append(handlers, logger.NewCustomHandler(writer, &logger.HandlerOptions{
+			Level:      level,
+			Formatter:  logger.NewFormatter(out.Format),
+			TimeFormat: out.TimeFormat,
+		}))
+	}
+
+	return slog.New(logger.NewMultiHandler(handlers...)), nil
 }
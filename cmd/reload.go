@@ -0,0 +1,74 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"filedownloader-20240926/internal/config"
+	"filedownloader-20240926/internal/handler"
+	"filedownloader-20240926/internal/service"
+	"filedownloader-20240926/pkg/logger"
+)
+
+// reloader re-reads the configuration and applies the settings that can
+// change while the service runs; in-memory task state is left untouched
+type reloader struct {
+	mu          sync.Mutex
+	current     *config.Config
+	workerPool  *service.WorkerPool
+	downloader  *service.Downloader
+	rateLimiter *handler.RateLimiter
+}
+
+// Reload loads config.yaml and the environment again. An invalid
+// configuration is rejected as a whole and the running one is kept.
+func (r *reloader) Reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	next, err := config.LoadConfig()
+	if err != nil {
+		logger.Logger.Error("Config reload failed, keeping the current configuration", "error", err)
+		return
+	}
+
+	for _, setting := range r.current.RestartRequired(next) {
+		logger.Logger.Warn("Setting changed but requires restart, ignoring", "setting", setting)
+	}
+
+	applied := r.current.ApplyLive(next)
+	// settings left unchanged in the file keep values set through the admin API
+	if applied.Logging.Level != r.current.Logging.Level && len(applied.Logging.Outputs) == 0 {
+		level := applied.Logging.Level
+		if applied.IsDebugMode() {
+			level = "debug"
+		}
+		if parsed, err := logger.ParseLevel(level); err == nil {
+			logger.SetLevel(parsed)
+		}
+	}
+	if applied.Worker.Count != r.current.Worker.Count {
+		if err := r.workerPool.Resize(applied.Worker.Count); err != nil {
+			logger.Logger.Error("Failed to resize worker pool", "error", err)
+			applied.Worker.Count = r.current.Worker.Count
+		}
+	}
+	if applied.Worker.MaxConnections != r.current.Worker.MaxConnections {
+		r.workerPool.SetMaxConnections(applied.Worker.MaxConnections)
+	}
+	rl := applied.Server.RateLimit
+	if rl != r.current.Server.RateLimit {
+		r.rateLimiter.SetLimits(rl.RequestsPerSecond, rl.Burst, rl.PerIP)
+	}
+	r.workerPool.SetTaskTimeout(time.Duration(applied.Download.TaskTimeoutSeconds) * time.Second)
+	r.downloader.SetStallTimeout(time.Duration(applied.Download.StallTimeoutSeconds) * time.Second)
+
+	r.current = applied
+	logger.Logger.Info("Configuration reloaded",
+		"log_level", applied.Logging.Level,
+		"worker_count", applied.Worker.Count,
+		"max_connections", applied.Worker.MaxConnections,
+		"rate_limit", rl.RequestsPerSecond,
+		"task_timeout_seconds", applied.Download.TaskTimeoutSeconds,
+		"stall_timeout_seconds", applied.Download.StallTimeoutSeconds)
+}
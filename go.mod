@@ -2,6 +2,9 @@ module filedownloader-20240926
 
 go 1.21
 
-require github.com/gorilla/mux v1.8.1
+require (
+	github.com/gorilla/mux v1.8.1
+	golang.org/x/time v0.5.0
+)
 
 require gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -1,47 +1,323 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"net/netip"
+	"net/url"
 	"os"
+	"path"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Server  ServerConfig  `yaml:"server" json:"server"`
-	Worker  WorkerConfig  `yaml:"worker" json:"worker"`
-	Logging LoggingConfig `yaml:"logging" json:"logging"`
+	Server   ServerConfig   `yaml:"server" json:"server"`
+	Worker   WorkerConfig   `yaml:"worker" json:"worker"`
+	Download DownloadConfig `yaml:"download" json:"download"`
+	Logging  LoggingConfig  `yaml:"logging" json:"logging"`
+	Storage  StorageConfig  `yaml:"storage" json:"storage"`
+	Cleanup  CleanupConfig  `yaml:"cleanup" json:"cleanup"`
 }
 
 type ServerConfig struct {
-	Port int `yaml:"port" json:"port"`
+	Port      int             `yaml:"port" json:"port"`
+	AuthToken string          `yaml:"auth_token" json:"-"`
+	RateLimit RateLimitConfig `yaml:"rate_limit" json:"rate_limit"`
+	// AccessLog logs every HTTP request; disable it when a proxy in front already does
+	AccessLog bool `yaml:"access_log" json:"access_log"`
+	// StatusETag lets clients poll task status with If-None-Match and get 304 when unchanged
+	StatusETag bool `yaml:"status_etag" json:"status_etag"`
+}
+
+type RateLimitConfig struct {
+	// RequestsPerSecond limits POST /api/v1/tasks; 0 disables rate limiting
+	RequestsPerSecond float64 `yaml:"requests_per_second" json:"requests_per_second"`
+	Burst             int     `yaml:"burst" json:"burst"`
+	// PerIP applies the limit to each client IP instead of all clients together
+	PerIP bool `yaml:"per_ip" json:"per_ip"`
 }
 
 type WorkerConfig struct {
 	Count int `yaml:"count" json:"count"`
+	// MaxPerHost limits concurrent downloads from the same host; 0 means unlimited
+	MaxPerHost int `yaml:"max_per_host" json:"max_per_host"`
+	// MaxPerTask limits concurrent downloads of one task's files; 0 means unlimited
+	MaxPerTask int `yaml:"max_per_task" json:"max_per_task"`
+	// MaxConnections limits the concurrent requests of all downloads and size
+	// probes together, independently of the worker count; 0 means one per worker
+	MaxConnections int `yaml:"max_connections" json:"max_connections"`
+	// MaxActiveTasks limits how many tasks download at once; further tasks are
+	// queued until a slot frees up. 0 means unlimited
+	MaxActiveTasks int `yaml:"max_active_tasks" json:"max_active_tasks"`
+}
+
+type DownloadConfig struct {
+	AllowedContentTypes []string `yaml:"allowed_content_types" json:"allowed_content_types"`
+	BlockedContentTypes []string `yaml:"blocked_content_types" json:"blocked_content_types"`
+	DiskSpaceMarginMB   int64    `yaml:"disk_space_margin_mb" json:"disk_space_margin_mb"`
+	// FreeSpaceGuard pauses the worker pool while the downloads volume is low on space
+	FreeSpaceGuard FreeSpaceGuardConfig `yaml:"free_space_guard" json:"free_space_guard"`
+	// Extract unpacks downloaded archives
+	Extract ExtractConfig `yaml:"extract" json:"extract"`
+	// Dedup stores the content of downloads once per SHA-256 and links task files to it
+	Dedup DedupConfig `yaml:"dedup" json:"dedup"`
+	// TaskTimeoutSeconds bounds the total time of all downloads of a task; 0 disables it
+	TaskTimeoutSeconds int `yaml:"task_timeout_seconds" json:"task_timeout_seconds"`
+	// StallTimeoutSeconds fails a download when no bytes arrive for this long; 0 disables it
+	StallTimeoutSeconds int `yaml:"stall_timeout_seconds" json:"stall_timeout_seconds"`
+	// RequestTimeoutSeconds bounds each request until its response headers arrive,
+	// including DNS, connecting and TLS; 0 disables it
+	RequestTimeoutSeconds int `yaml:"request_timeout_seconds" json:"request_timeout_seconds"`
+	// TransferTimeoutSeconds bounds reading a response body; 0 disables it
+	TransferTimeoutSeconds int `yaml:"transfer_timeout_seconds" json:"transfer_timeout_seconds"`
+	// TransferMinKBps extends the transfer deadline by the time the Content-Length
+	// takes at this rate, so large files get proportionally longer; 0 disables it
+	TransferMinKBps int64 `yaml:"transfer_min_kbps" json:"transfer_min_kbps"`
+	// MaxSpeedKBps caps the read rate of each download; 0 disables the cap
+	MaxSpeedKBps int64 `yaml:"max_speed_kbps" json:"max_speed_kbps"`
+	// SpeedRampSeconds ramps each download up to MaxSpeedKBps over this long
+	// instead of starting at the full rate; 0 applies the cap from the start
+	SpeedRampSeconds int `yaml:"speed_ramp_seconds" json:"speed_ramp_seconds"`
+	// MaxAttempts is how many times a file is tried before it fails for good;
+	// only transient errors such as timeouts and 5xx responses are retried
+	MaxAttempts int `yaml:"max_attempts" json:"max_attempts"`
+	// RetryBackoffSeconds is the delay before the first retry; it doubles with every further attempt
+	RetryBackoffSeconds int `yaml:"retry_backoff_seconds" json:"retry_backoff_seconds"`
+	// ExtensionPolicy decides which side wins when the URL extension contradicts
+	// the Content-Type: "trust_url" keeps the name, "trust_server" renames the file;
+	// "sniff" keeps the name too but derives a missing extension from the content
+	ExtensionPolicy string `yaml:"extension_policy" json:"extension_policy"`
+	// Layout is "per_task" to store files under downloads/<task_id>/ or "flat" for a single directory
+	Layout string `yaml:"layout" json:"layout"`
+	// ProxyURL routes downloads through an http, https or socks5 proxy;
+	// when empty HTTP_PROXY/HTTPS_PROXY/NO_PROXY are honored
+	ProxyURL string    `yaml:"proxy_url" json:"proxy_url"`
+	TLS      TLSConfig `yaml:"tls" json:"tls"`
+	// Transport bounds the header phase of responses, which the overall
+	// client timeout alone leaves open to oversized or trickled headers
+	Transport TransportConfig `yaml:"transport" json:"transport"`
+	// EgressGuard blocks downloads from loopback, link-local and private addresses
+	EgressGuard EgressGuardConfig `yaml:"egress_guard" json:"egress_guard"`
+	// MaxURLsPerTask limits the number of URLs in a single task; 0 disables the limit
+	MaxURLsPerTask int `yaml:"max_urls_per_task" json:"max_urls_per_task"`
+	// MaxOutstandingFiles limits unfinished files across all tasks; 0 disables the limit
+	MaxOutstandingFiles int `yaml:"max_outstanding_files" json:"max_outstanding_files"`
+	// MaxPages caps how many pages a follow_next file joins by following rel="next" links
+	MaxPages int `yaml:"max_pages" json:"max_pages"`
+	// ConditionalRequests revalidates files downloaded before with If-None-Match/If-Modified-Since
+	// and reuses the local copy on 304; disable it to always fetch fresh bytes
+	ConditionalRequests bool `yaml:"conditional_requests" json:"conditional_requests"`
+	// PreserveMtime sets the mtime of saved files to the Last-Modified time of the response
+	PreserveMtime bool `yaml:"preserve_mtime" json:"preserve_mtime"`
+	// ResumeFallback restarts a download from scratch when the server does not
+	// continue its partial; disable it to fail the attempt instead
+	ResumeFallback bool `yaml:"resume_fallback" json:"resume_fallback"`
+	// OverwritePolicy decides what happens when a file of the same name already
+	// exists: rename saves alongside it, overwrite replaces it and skip keeps it
+	// when it matches the remote size and checksum
+	OverwritePolicy string `yaml:"overwrite_policy" json:"overwrite_policy"`
+	// UserAgent replaces the default "FileDownloader/<version>" User-Agent
+	UserAgent string `yaml:"user_agent" json:"user_agent"`
+	// AllowLocalURLs accepts data: URLs and file: URLs below FileRoot. It is off by
+	// default because it lets API clients read files of the host.
+	AllowLocalURLs bool   `yaml:"allow_local_urls" json:"allow_local_urls"`
+	FileRoot       string `yaml:"file_root" json:"file_root"`
+}
+
+type TLSConfig struct {
+	// CAFile is a PEM bundle trusted in addition to the system roots
+	CAFile string `yaml:"ca_file" json:"ca_file"`
+	// CertFile and KeyFile hold an optional client certificate
+	CertFile string `yaml:"cert_file" json:"cert_file"`
+	KeyFile  string `yaml:"key_file" json:"key_file"`
+	// InsecureSkipVerify disables certificate verification; never use it in production
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify" json:"insecure_skip_verify"`
+}
+
+type TransportConfig struct {
+	// DialTimeoutSeconds bounds establishing a connection, so that unreachable
+	// hosts fail fast while long transfers are unaffected; 0 disables it
+	DialTimeoutSeconds int `yaml:"dial_timeout_seconds" json:"dial_timeout_seconds"`
+	// MaxResponseHeaderKB limits the size of response headers; 0 uses the Go default of 1 MB
+	MaxResponseHeaderKB int64 `yaml:"max_response_header_kb" json:"max_response_header_kb"`
+	// ResponseHeaderTimeoutSeconds bounds the wait for response headers once the request is sent; 0 disables it
+	ResponseHeaderTimeoutSeconds int `yaml:"response_header_timeout_seconds" json:"response_header_timeout_seconds"`
+	// TLSHandshakeTimeoutSeconds bounds the TLS handshake; 0 disables it
+	TLSHandshakeTimeoutSeconds int `yaml:"tls_handshake_timeout_seconds" json:"tls_handshake_timeout_seconds"`
+	// ExpectContinueTimeoutSeconds bounds the wait for "100 Continue" on requests with a body; 0 sends the body at once
+	ExpectContinueTimeoutSeconds int `yaml:"expect_continue_timeout_seconds" json:"expect_continue_timeout_seconds"`
+	// ForceHTTP1 disables HTTP/2 for upstreams that misbehave with it
+	ForceHTTP1 bool `yaml:"force_http1" json:"force_http1"`
+	// DisableKeepAlives closes every connection after one request instead of reusing it
+	DisableKeepAlives bool `yaml:"disable_keep_alives" json:"disable_keep_alives"`
+	// DisableCompression stops the transport from requesting gzip on its own
+	// for requests that do not set Accept-Encoding
+	DisableCompression bool `yaml:"disable_compression" json:"disable_compression"`
+}
+
+type EgressGuardConfig struct {
+	// Enabled refuses connections to loopback, link-local and private addresses;
+	// disable it only when the service fetches from trusted internal hosts
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// Allow lists CIDR prefixes or addresses that are reachable despite the guard
+	Allow []string `yaml:"allow" json:"allow"`
+	// Deny lists CIDR prefixes or addresses blocked in addition to the defaults
+	Deny []string `yaml:"deny" json:"deny"`
+}
+
+type FreeSpaceGuardConfig struct {
+	// MinFreeMB pauses dispatching below this much free space; 0 disables the guard
+	MinFreeMB int64 `yaml:"min_free_mb" json:"min_free_mb"`
+	// IntervalSeconds is how often the free space is checked
+	IntervalSeconds int `yaml:"interval_seconds" json:"interval_seconds"`
+}
+
+type ExtractConfig struct {
+	// Enabled extracts .zip, .tar.gz and .tgz downloads of every task; tasks
+	// created with extract are extracted either way
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// MaxSizeMB limits the total uncompressed size of an archive
+	MaxSizeMB int64 `yaml:"max_size_mb" json:"max_size_mb"`
+	// MaxFiles limits the number of files extracted from an archive
+	MaxFiles int `yaml:"max_files" json:"max_files"`
+	// KeepArchive leaves the archive next to the extracted directory; when
+	// disabled it is deleted after a successful extraction
+	KeepArchive bool `yaml:"keep_archive" json:"keep_archive"`
+}
+
+type DedupConfig struct {
+	// Enabled moves every completed download into a blob store keyed on its
+	// SHA-256 and leaves a link in its place; files expected to have stored
+	// content are linked without downloading them
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// BlobDir holds the blobs; empty uses .blobs in the downloads directory.
+	// Hardlinks need it on the same filesystem as the downloads.
+	BlobDir string `yaml:"blob_dir" json:"blob_dir"`
+	// LinkMode is "hardlink" or "symlink"; a link type the filesystem does not
+	// support falls back to a symlink and then to a plain copy
+	LinkMode string `yaml:"link_mode" json:"link_mode"`
+}
+
+type StorageConfig struct {
+	// Backend selects where tasks are persisted: "file" or "memory"
+	Backend string `yaml:"backend" json:"backend"`
+	// StateDir is the directory of the task files of the file backend,
+	// relative to the working directory unless absolute
+	StateDir string `yaml:"state_dir" json:"state_dir"`
+	// Compress gzips the task files of the file backend
+	Compress bool `yaml:"compress" json:"compress"`
+	// Compact writes the task files of the file backend without indentation
+	Compact bool `yaml:"compact" json:"compact"`
+	// SaveIntervalMS coalesces updates of a task into one write per interval;
+	// finished tasks are written at once, 0 writes every update
+	SaveIntervalMS int `yaml:"save_interval_ms" json:"save_interval_ms"`
+	// MaxTasksInMemory bounds the tasks held in memory; the least recently
+	// used finished tasks beyond it are loaded from storage on demand, 0 keeps all
+	MaxTasksInMemory int `yaml:"max_tasks_in_memory" json:"max_tasks_in_memory"`
+}
+
+type CleanupConfig struct {
+	// TaskTTLSeconds deletes completed and failed tasks this long after they finished; 0 keeps them forever
+	TaskTTLSeconds int `yaml:"task_ttl_seconds" json:"task_ttl_seconds"`
+	// IntervalSeconds is how often expired tasks are looked for
+	IntervalSeconds int `yaml:"interval_seconds" json:"interval_seconds"`
+	// DeleteFiles also removes the downloaded files of deleted tasks
+	DeleteFiles bool `yaml:"delete_files" json:"delete_files"`
 }
 
 type LoggingConfig struct {
-	Level     string `yaml:"level" json:"level"`
-	Format    string `yaml:"format" json:"format"`
-	DebugMode bool   `yaml:"debug_mode" json:"debug_mode"`
+	Level      string `yaml:"level" json:"level"`
+	Format     string `yaml:"format" json:"format"`
+	DebugMode  bool   `yaml:"debug_mode" json:"debug_mode"`
+	Output     string `yaml:"output" json:"output"`
+	FilePath   string `yaml:"file_path" json:"file_path"`
+	MaxSizeMB  int    `yaml:"max_size_mb" json:"max_size_mb"`
+	MaxBackups int    `yaml:"max_backups" json:"max_backups"`
+	TimeFormat string `yaml:"time_format" json:"time_format"`
+	// Outputs, when set, replaces Output with several destinations that
+	// each have their own format and level
+	Outputs []LogOutputConfig `yaml:"outputs" json:"outputs"`
+}
+
+type LogOutputConfig struct {
+	Type       string `yaml:"type" json:"type"`
+	Format     string `yaml:"format" json:"format"`
+	Level      string `yaml:"level" json:"level"`
+	FilePath   string `yaml:"file_path" json:"file_path"`
+	MaxSizeMB  int    `yaml:"max_size_mb" json:"max_size_mb"`
+	MaxBackups int    `yaml:"max_backups" json:"max_backups"`
+	TimeFormat string `yaml:"time_format" json:"time_format"`
 }
 
 // DefaultConfig returns default configuration values
 func DefaultConfig() *Config {
 	return &Config{
 		Server: ServerConfig{
-			Port: 8080,
+			Port:       8080,
+			AccessLog:  true,
+			StatusETag: true,
+			RateLimit: RateLimitConfig{
+				Burst: 10,
+				PerIP: true,
+			},
 		},
 		Worker: WorkerConfig{
 			Count: 3,
 		},
+		Download: DownloadConfig{
+			DiskSpaceMarginMB:   10,
+			ExtensionPolicy:     "trust_url",
+			Layout:              "per_task",
+			MaxURLsPerTask:      1000,
+			MaxPages:            100,
+			ConditionalRequests: true,
+			ResumeFallback:      true,
+			OverwritePolicy:     "rename",
+			EgressGuard: EgressGuardConfig{
+				Enabled: true,
+			},
+			MaxAttempts:           3,
+			RetryBackoffSeconds:   2,
+			RequestTimeoutSeconds: 60,
+			FreeSpaceGuard: FreeSpaceGuardConfig{
+				IntervalSeconds: 30,
+			},
+			Extract: ExtractConfig{
+				MaxSizeMB:   1024,
+				MaxFiles:    10000,
+				KeepArchive: true,
+			},
+			Dedup: DedupConfig{
+				LinkMode: "hardlink",
+			},
+			Transport: TransportConfig{
+				DialTimeoutSeconds:           10,
+				MaxResponseHeaderKB:          64,
+				ResponseHeaderTimeoutSeconds: 30,
+				TLSHandshakeTimeoutSeconds:   10,
+				ExpectContinueTimeoutSeconds: 1,
+			},
+		},
 		Logging: LoggingConfig{
-			Level:     "info",
-			Format:    "json",
-			DebugMode: false,
+			Level:      "info",
+			Format:     "json",
+			DebugMode:  false,
+			Output:     "stdout",
+			FilePath:   "logs/filedownloader.log",
+			MaxSizeMB:  100,
+			MaxBackups: 3,
+		},
+		Storage: StorageConfig{
+			Backend:        "file",
+			StateDir:       "./state",
+			SaveIntervalMS: 500,
+		},
+		Cleanup: CleanupConfig{
+			IntervalSeconds: 300,
 		},
 	}
 }
@@ -57,6 +333,7 @@ func LoadConfig() (*Config, error) {
 	}
 
 	loadFromEnv(config)
+	applyLogOutputDefaults(config)
 
 	if err := validateConfig(config); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
@@ -86,12 +363,250 @@ func loadFromEnv(config *Config) {
 			config.Server.Port = p
 		}
 	}
+	if token := os.Getenv("SERVER_AUTH_TOKEN"); token != "" {
+		config.Server.AuthToken = token
+	}
+	if accessLog := os.Getenv("ACCESS_LOG"); accessLog != "" {
+		config.Server.AccessLog = accessLog == "true" || accessLog == "1"
+	}
+	if statusETag := os.Getenv("STATUS_ETAG"); statusETag != "" {
+		config.Server.StatusETag = statusETag == "true" || statusETag == "1"
+	}
+	if rps := os.Getenv("RATE_LIMIT_RPS"); rps != "" {
+		if r, err := strconv.ParseFloat(rps, 64); err == nil && r >= 0 {
+			config.Server.RateLimit.RequestsPerSecond = r
+		}
+	}
+	if burst := os.Getenv("RATE_LIMIT_BURST"); burst != "" {
+		if b, err := strconv.Atoi(burst); err == nil && b > 0 {
+			config.Server.RateLimit.Burst = b
+		}
+	}
 
 	if count := os.Getenv("WORKER_COUNT"); count != "" {
 		if c, err := strconv.Atoi(count); err == nil && c > 0 {
 			config.Worker.Count = c
 		}
 	}
+	if perHost := os.Getenv("WORKER_MAX_PER_HOST"); perHost != "" {
+		if n, err := strconv.Atoi(perHost); err == nil && n >= 0 {
+			config.Worker.MaxPerHost = n
+		}
+	}
+	if perTask := os.Getenv("WORKER_MAX_PER_TASK"); perTask != "" {
+		if n, err := strconv.Atoi(perTask); err == nil && n >= 0 {
+			config.Worker.MaxPerTask = n
+		}
+	}
+	if conns := os.Getenv("WORKER_MAX_CONNECTIONS"); conns != "" {
+		if n, err := strconv.Atoi(conns); err == nil && n >= 0 {
+			config.Worker.MaxConnections = n
+		}
+	}
+	if activeTasks := os.Getenv("MAX_ACTIVE_TASKS"); activeTasks != "" {
+		if n, err := strconv.Atoi(activeTasks); err == nil && n >= 0 {
+			config.Worker.MaxActiveTasks = n
+		}
+	}
+
+	if allowed := os.Getenv("ALLOWED_CONTENT_TYPES"); allowed != "" {
+		config.Download.AllowedContentTypes = splitList(allowed)
+	}
+	if blocked := os.Getenv("BLOCKED_CONTENT_TYPES"); blocked != "" {
+		config.Download.BlockedContentTypes = splitList(blocked)
+	}
+
+	if margin := os.Getenv("DISK_SPACE_MARGIN_MB"); margin != "" {
+		if m, err := strconv.ParseInt(margin, 10, 64); err == nil && m >= 0 {
+			config.Download.DiskSpaceMarginMB = m
+		}
+	}
+	if minFree := os.Getenv("FREE_SPACE_MIN_MB"); minFree != "" {
+		if m, err := strconv.ParseInt(minFree, 10, 64); err == nil && m >= 0 {
+			config.Download.FreeSpaceGuard.MinFreeMB = m
+		}
+	}
+	if interval := os.Getenv("FREE_SPACE_INTERVAL_SECONDS"); interval != "" {
+		if i, err := strconv.Atoi(interval); err == nil && i > 0 {
+			config.Download.FreeSpaceGuard.IntervalSeconds = i
+		}
+	}
+
+	if extract := os.Getenv("EXTRACT_ENABLED"); extract != "" {
+		config.Download.Extract.Enabled = extract == "true" || extract == "1"
+	}
+	if size := os.Getenv("EXTRACT_MAX_SIZE_MB"); size != "" {
+		if s, err := strconv.ParseInt(size, 10, 64); err == nil && s > 0 {
+			config.Download.Extract.MaxSizeMB = s
+		}
+	}
+	if files := os.Getenv("EXTRACT_MAX_FILES"); files != "" {
+		if n, err := strconv.Atoi(files); err == nil && n > 0 {
+			config.Download.Extract.MaxFiles = n
+		}
+	}
+	if keep := os.Getenv("EXTRACT_KEEP_ARCHIVE"); keep != "" {
+		config.Download.Extract.KeepArchive = keep == "true" || keep == "1"
+	}
+
+	if dedup := os.Getenv("DEDUP_ENABLED"); dedup != "" {
+		config.Download.Dedup.Enabled = dedup == "true" || dedup == "1"
+	}
+	if dir := os.Getenv("DEDUP_BLOB_DIR"); dir != "" {
+		config.Download.Dedup.BlobDir = dir
+	}
+	if mode := os.Getenv("DEDUP_LINK_MODE"); mode != "" {
+		config.Download.Dedup.LinkMode = strings.ToLower(mode)
+	}
+
+	if timeout := os.Getenv("TASK_TIMEOUT_SECONDS"); timeout != "" {
+		if t, err := strconv.Atoi(timeout); err == nil && t >= 0 {
+			config.Download.TaskTimeoutSeconds = t
+		}
+	}
+	if stall := os.Getenv("STALL_TIMEOUT_SECONDS"); stall != "" {
+		if t, err := strconv.Atoi(stall); err == nil && t >= 0 {
+			config.Download.StallTimeoutSeconds = t
+		}
+	}
+	if timeout := os.Getenv("REQUEST_TIMEOUT_SECONDS"); timeout != "" {
+		if t, err := strconv.Atoi(timeout); err == nil && t >= 0 {
+			config.Download.RequestTimeoutSeconds = t
+		}
+	}
+	if timeout := os.Getenv("TRANSFER_TIMEOUT_SECONDS"); timeout != "" {
+		if t, err := strconv.Atoi(timeout); err == nil && t >= 0 {
+			config.Download.TransferTimeoutSeconds = t
+		}
+	}
+	if rate := os.Getenv("TRANSFER_MIN_KBPS"); rate != "" {
+		if r, err := strconv.ParseInt(rate, 10, 64); err == nil && r >= 0 {
+			config.Download.TransferMinKBps = r
+		}
+	}
+	if speed := os.Getenv("MAX_SPEED_KBPS"); speed != "" {
+		if s, err := strconv.ParseInt(speed, 10, 64); err == nil && s >= 0 {
+			config.Download.MaxSpeedKBps = s
+		}
+	}
+	if ramp := os.Getenv("SPEED_RAMP_SECONDS"); ramp != "" {
+		if r, err := strconv.Atoi(ramp); err == nil && r >= 0 {
+			config.Download.SpeedRampSeconds = r
+		}
+	}
+	if attempts := os.Getenv("MAX_ATTEMPTS"); attempts != "" {
+		if a, err := strconv.Atoi(attempts); err == nil && a > 0 {
+			config.Download.MaxAttempts = a
+		}
+	}
+	if backoff := os.Getenv("RETRY_BACKOFF_SECONDS"); backoff != "" {
+		if b, err := strconv.Atoi(backoff); err == nil && b >= 0 {
+			config.Download.RetryBackoffSeconds = b
+		}
+	}
+
+	if maxURLs := os.Getenv("MAX_URLS_PER_TASK"); maxURLs != "" {
+		if m, err := strconv.Atoi(maxURLs); err == nil && m >= 0 {
+			config.Download.MaxURLsPerTask = m
+		}
+	}
+	if outstanding := os.Getenv("MAX_OUTSTANDING_FILES"); outstanding != "" {
+		if m, err := strconv.Atoi(outstanding); err == nil && m >= 0 {
+			config.Download.MaxOutstandingFiles = m
+		}
+	}
+	if pages := os.Getenv("MAX_PAGES"); pages != "" {
+		if p, err := strconv.Atoi(pages); err == nil && p > 0 {
+			config.Download.MaxPages = p
+		}
+	}
+
+	if conditional := os.Getenv("CONDITIONAL_REQUESTS"); conditional != "" {
+		config.Download.ConditionalRequests = conditional == "true" || conditional == "1"
+	}
+	if mtime := os.Getenv("PRESERVE_MTIME"); mtime != "" {
+		config.Download.PreserveMtime = mtime == "true" || mtime == "1"
+	}
+	if fallback := os.Getenv("RESUME_FALLBACK"); fallback != "" {
+		config.Download.ResumeFallback = fallback == "true" || fallback == "1"
+	}
+
+	if ua := os.Getenv("USER_AGENT"); ua != "" {
+		config.Download.UserAgent = ua
+	}
+	if local := os.Getenv("ALLOW_LOCAL_URLS"); local != "" {
+		config.Download.AllowLocalURLs = local == "true" || local == "1"
+	}
+	if root := os.Getenv("FILE_ROOT"); root != "" {
+		config.Download.FileRoot = root
+	}
+
+	if policy := os.Getenv("EXTENSION_POLICY"); policy != "" {
+		config.Download.ExtensionPolicy = strings.ToLower(policy)
+	}
+	if policy := os.Getenv("OVERWRITE_POLICY"); policy != "" {
+		config.Download.OverwritePolicy = strings.ToLower(policy)
+	}
+
+	if proxy := os.Getenv("PROXY_URL"); proxy != "" {
+		config.Download.ProxyURL = proxy
+	}
+
+	if ca := os.Getenv("TLS_CA_FILE"); ca != "" {
+		config.Download.TLS.CAFile = ca
+	}
+	if insecure := os.Getenv("TLS_INSECURE_SKIP_VERIFY"); insecure != "" {
+		config.Download.TLS.InsecureSkipVerify = insecure == "true" || insecure == "1"
+	}
+
+	if headerKB := os.Getenv("MAX_RESPONSE_HEADER_KB"); headerKB != "" {
+		if kb, err := strconv.ParseInt(headerKB, 10, 64); err == nil && kb >= 0 {
+			config.Download.Transport.MaxResponseHeaderKB = kb
+		}
+	}
+	if timeout := os.Getenv("DIAL_TIMEOUT_SECONDS"); timeout != "" {
+		if t, err := strconv.Atoi(timeout); err == nil && t >= 0 {
+			config.Download.Transport.DialTimeoutSeconds = t
+		}
+	}
+	if timeout := os.Getenv("RESPONSE_HEADER_TIMEOUT_SECONDS"); timeout != "" {
+		if t, err := strconv.Atoi(timeout); err == nil && t >= 0 {
+			config.Download.Transport.ResponseHeaderTimeoutSeconds = t
+		}
+	}
+	if timeout := os.Getenv("TLS_HANDSHAKE_TIMEOUT_SECONDS"); timeout != "" {
+		if t, err := strconv.Atoi(timeout); err == nil && t >= 0 {
+			config.Download.Transport.TLSHandshakeTimeoutSeconds = t
+		}
+	}
+	if timeout := os.Getenv("EXPECT_CONTINUE_TIMEOUT_SECONDS"); timeout != "" {
+		if t, err := strconv.Atoi(timeout); err == nil && t >= 0 {
+			config.Download.Transport.ExpectContinueTimeoutSeconds = t
+		}
+	}
+	if http1 := os.Getenv("FORCE_HTTP1"); http1 != "" {
+		config.Download.Transport.ForceHTTP1 = http1 == "true" || http1 == "1"
+	}
+	if keepAlives := os.Getenv("DISABLE_KEEP_ALIVES"); keepAlives != "" {
+		config.Download.Transport.DisableKeepAlives = keepAlives == "true" || keepAlives == "1"
+	}
+	if compression := os.Getenv("DISABLE_COMPRESSION"); compression != "" {
+		config.Download.Transport.DisableCompression = compression == "true" || compression == "1"
+	}
+
+	if guard := os.Getenv("EGRESS_GUARD"); guard != "" {
+		config.Download.EgressGuard.Enabled = guard == "true" || guard == "1"
+	}
+	if allow := os.Getenv("EGRESS_ALLOW"); allow != "" {
+		config.Download.EgressGuard.Allow = splitList(allow)
+	}
+	if deny := os.Getenv("EGRESS_DENY"); deny != "" {
+		config.Download.EgressGuard.Deny = splitList(deny)
+	}
+
+	if layout := os.Getenv("DOWNLOAD_LAYOUT"); layout != "" {
+		config.Download.Layout = strings.ToLower(layout)
+	}
 
 	if level := os.Getenv("LOG_LEVEL"); level != "" {
 		config.Logging.Level = strings.ToLower(level)
@@ -99,36 +614,308 @@ func loadFromEnv(config *Config) {
 	if format := os.Getenv("LOG_FORMAT"); format != "" {
 		config.Logging.Format = strings.ToLower(format)
 	}
+	if output := os.Getenv("LOG_OUTPUT"); output != "" {
+		config.Logging.Output = strings.ToLower(output)
+	}
+	if path := os.Getenv("LOG_FILE_PATH"); path != "" {
+		config.Logging.FilePath = path
+	}
 	if debug := os.Getenv("DEBUG"); debug != "" {
 		config.Logging.DebugMode = debug == "true" || debug == "1"
 	}
+
+	if backend := os.Getenv("STORAGE_BACKEND"); backend != "" {
+		config.Storage.Backend = strings.ToLower(backend)
+	}
+	if dir := os.Getenv("STATE_DIR"); dir != "" {
+		config.Storage.StateDir = dir
+	}
+	if compress := os.Getenv("STORAGE_COMPRESS"); compress != "" {
+		config.Storage.Compress = compress == "true" || compress == "1"
+	}
+	if compact := os.Getenv("STORAGE_COMPACT"); compact != "" {
+		config.Storage.Compact = compact == "true" || compact == "1"
+	}
+	if interval := os.Getenv("STORAGE_SAVE_INTERVAL_MS"); interval != "" {
+		if i, err := strconv.Atoi(interval); err == nil && i >= 0 {
+			config.Storage.SaveIntervalMS = i
+		}
+	}
+	if maxTasks := os.Getenv("STORAGE_MAX_TASKS_IN_MEMORY"); maxTasks != "" {
+		if i, err := strconv.Atoi(maxTasks); err == nil && i >= 0 {
+			config.Storage.MaxTasksInMemory = i
+		}
+	}
+
+	if ttl := os.Getenv("CLEANUP_TASK_TTL_SECONDS"); ttl != "" {
+		if t, err := strconv.Atoi(ttl); err == nil && t >= 0 {
+			config.Cleanup.TaskTTLSeconds = t
+		}
+	}
+	if interval := os.Getenv("CLEANUP_INTERVAL_SECONDS"); interval != "" {
+		if i, err := strconv.Atoi(interval); err == nil && i > 0 {
+			config.Cleanup.IntervalSeconds = i
+		}
+	}
+	if deleteFiles := os.Getenv("CLEANUP_DELETE_FILES"); deleteFiles != "" {
+		config.Cleanup.DeleteFiles = deleteFiles == "true" || deleteFiles == "1"
+	}
 }
 
-// validateConfig validates the configuration
+// applyLogOutputDefaults fills unset fields of each log output from the main logging settings
+func applyLogOutputDefaults(config *Config) {
+	for i := range config.Logging.Outputs {
+		out := &config.Logging.Outputs[i]
+		out.Type = strings.ToLower(out.Type)
+		if out.Format == "" {
+			out.Format = config.Logging.Format
+		}
+		if out.Level == "" {
+			out.Level = config.Logging.Level
+		}
+		if out.TimeFormat == "" {
+			out.TimeFormat = config.Logging.TimeFormat
+		}
+		if out.Type == "file" {
+			if out.FilePath == "" {
+				out.FilePath = config.Logging.FilePath
+			}
+			if out.MaxSizeMB == 0 {
+				out.MaxSizeMB = config.Logging.MaxSizeMB
+			}
+		}
+	}
+}
+
+// splitList splits a comma-separated environment value into trimmed items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, strings.ToLower(item))
+		}
+	}
+	return items
+}
+
+// validAddressRange reports whether entry is a CIDR prefix or a single IP address
+func validAddressRange(entry string) bool {
+	entry = strings.TrimSpace(entry)
+	if _, err := netip.ParsePrefix(entry); err == nil {
+		return true
+	}
+	_, err := netip.ParseAddr(entry)
+	return err == nil
+}
+
+// validateConfig validates the configuration and reports every invalid
+// setting at once, one per line
 func validateConfig(config *Config) error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
 	if config.Server.Port <= 0 || config.Server.Port > 65535 {
-		return fmt.Errorf("invalid server port: %d", config.Server.Port)
+		fail("invalid server port: %d", config.Server.Port)
+	}
+
+	if config.Server.RateLimit.RequestsPerSecond < 0 {
+		fail("rate limit must not be negative: %v", config.Server.RateLimit.RequestsPerSecond)
+	}
+	if config.Server.RateLimit.RequestsPerSecond > 0 && config.Server.RateLimit.Burst <= 0 {
+		fail("rate limit burst must be positive: %d", config.Server.RateLimit.Burst)
 	}
 
 	if config.Worker.Count <= 0 {
-		return fmt.Errorf("worker count must be positive: %d", config.Worker.Count)
+		fail("worker count must be positive: %d", config.Worker.Count)
+	}
+	if config.Worker.MaxPerHost < 0 {
+		fail("max downloads per host must not be negative: %d", config.Worker.MaxPerHost)
+	}
+	if config.Worker.MaxPerTask < 0 {
+		fail("max downloads per task must not be negative: %d", config.Worker.MaxPerTask)
+	}
+	if config.Worker.MaxConnections < 0 {
+		fail("max connections must not be negative: %d", config.Worker.MaxConnections)
+	}
+	if config.Worker.MaxActiveTasks < 0 {
+		fail("max active tasks must not be negative: %d", config.Worker.MaxActiveTasks)
+	}
+
+	if config.Download.DiskSpaceMarginMB < 0 {
+		fail("disk space margin must not be negative: %d", config.Download.DiskSpaceMarginMB)
+	}
+	if guard := config.Download.FreeSpaceGuard; guard.MinFreeMB < 0 {
+		fail("minimum free space must not be negative: %d", guard.MinFreeMB)
+	} else if guard.MinFreeMB > 0 && guard.IntervalSeconds <= 0 {
+		fail("free space check interval must be positive: %d", guard.IntervalSeconds)
+	}
+
+	if extract := config.Download.Extract; extract.MaxSizeMB <= 0 || extract.MaxFiles <= 0 {
+		fail("extraction limits must be positive: max_size_mb %d, max_files %d", extract.MaxSizeMB, extract.MaxFiles)
+	}
+	switch config.Download.Dedup.LinkMode {
+	case "hardlink", "symlink":
+	default:
+		fail("invalid dedup link mode: %s", config.Download.Dedup.LinkMode)
+	}
+
+	if config.Download.RequestTimeoutSeconds < 0 || config.Download.TransferTimeoutSeconds < 0 || config.Download.TransferMinKBps < 0 {
+		fail("request and transfer timeouts must not be negative")
+	}
+	if config.Download.TaskTimeoutSeconds < 0 || config.Download.StallTimeoutSeconds < 0 {
+		fail("download timeouts must not be negative")
+	}
+	if config.Download.MaxSpeedKBps < 0 || config.Download.SpeedRampSeconds < 0 {
+		fail("speed cap and ramp must not be negative: max_speed_kbps %d, speed_ramp_seconds %d",
+			config.Download.MaxSpeedKBps, config.Download.SpeedRampSeconds)
+	}
+	if config.Download.MaxAttempts < 1 {
+		fail("max attempts must be at least 1: %d", config.Download.MaxAttempts)
+	}
+	if config.Download.RetryBackoffSeconds < 0 {
+		fail("retry backoff must not be negative: %d", config.Download.RetryBackoffSeconds)
+	}
+
+	if strings.ContainsFunc(config.Download.UserAgent, unicode.IsControl) {
+		fail("user agent must not contain control characters")
+	}
+
+	if config.Download.AllowLocalURLs && config.Download.FileRoot != "" {
+		if info, err := os.Stat(config.Download.FileRoot); err != nil || !info.IsDir() {
+			fail("file root must be an existing directory: %s", config.Download.FileRoot)
+		}
+	}
+
+	if config.Download.MaxURLsPerTask < 0 || config.Download.MaxOutstandingFiles < 0 {
+		fail("task limits must not be negative")
+	}
+	if config.Download.MaxPages < 1 {
+		fail("max pages must be at least 1")
+	}
+
+	for _, pattern := range append(config.Download.AllowedContentTypes, config.Download.BlockedContentTypes...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			fail("invalid content type pattern: %s", pattern)
+		}
+	}
+
+	switch config.Download.ExtensionPolicy {
+	case "trust_url", "trust_server", "sniff":
+	default:
+		fail("invalid extension policy: %s", config.Download.ExtensionPolicy)
+	}
+
+	switch config.Download.OverwritePolicy {
+	case "rename", "overwrite", "skip":
+	default:
+		fail("invalid overwrite policy: %s", config.Download.OverwritePolicy)
+	}
+
+	if config.Download.Layout != "per_task" && config.Download.Layout != "flat" {
+		fail("invalid download layout: %s", config.Download.Layout)
+	}
+
+	if config.Download.ProxyURL != "" {
+		u, err := url.Parse(config.Download.ProxyURL)
+		switch {
+		case err != nil || u.Host == "":
+			fail("invalid proxy URL: %s", config.Download.ProxyURL)
+		case u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5" && u.Scheme != "socks5h":
+			fail("unsupported proxy scheme: %s", u.Scheme)
+		}
+	}
+
+	if t := config.Download.Transport; t.DialTimeoutSeconds < 0 || t.MaxResponseHeaderKB < 0 || t.ResponseHeaderTimeoutSeconds < 0 ||
+		t.TLSHandshakeTimeoutSeconds < 0 || t.ExpectContinueTimeoutSeconds < 0 {
+		fail("transport limits must not be negative")
+	}
+
+	if (config.Download.TLS.CertFile == "") != (config.Download.TLS.KeyFile == "") {
+		fail("tls cert_file and key_file must be set together")
+	}
+
+	for _, entry := range append(append([]string(nil), config.Download.EgressGuard.Allow...), config.Download.EgressGuard.Deny...) {
+		if !validAddressRange(entry) {
+			fail("invalid egress guard entry: %s", entry)
+		}
 	}
 
 	validLogLevels := map[string]bool{
 		"debug": true, "info": true, "warn": true, "error": true,
 	}
 	if !validLogLevels[config.Logging.Level] {
-		return fmt.Errorf("invalid log level: %s", config.Logging.Level)
+		fail("invalid log level: %s", config.Logging.Level)
 	}
 
 	validLogFormats := map[string]bool{
 		"json": true, "text": true,
 	}
 	if !validLogFormats[config.Logging.Format] {
-		return fmt.Errorf("invalid log format: %s", config.Logging.Format)
+		fail("invalid log format: %s", config.Logging.Format)
 	}
 
-	return nil
+	validLogOutputs := map[string]bool{
+		"stdout": true, "file": true,
+	}
+	if !validLogOutputs[config.Logging.Output] {
+		fail("invalid log output: %s", config.Logging.Output)
+	}
+
+	if config.Logging.Output == "file" {
+		if config.Logging.FilePath == "" {
+			fail("log file path is required for file output")
+		}
+		if config.Logging.MaxSizeMB <= 0 {
+			fail("log max size must be positive: %d", config.Logging.MaxSizeMB)
+		}
+		if config.Logging.MaxBackups < 0 {
+			fail("log max backups must not be negative: %d", config.Logging.MaxBackups)
+		}
+	}
+
+	for i, out := range config.Logging.Outputs {
+		if !validLogOutputs[out.Type] {
+			fail("invalid log output %d type: %s", i, out.Type)
+		}
+		if !validLogFormats[out.Format] {
+			fail("invalid log output %d format: %s", i, out.Format)
+		}
+		if !validLogLevels[out.Level] {
+			fail("invalid log output %d level: %s", i, out.Level)
+		}
+		if out.Type == "file" && (out.FilePath == "" || out.MaxSizeMB <= 0 || out.MaxBackups < 0) {
+			fail("invalid log output %d file settings", i)
+		}
+	}
+
+	validStorageBackends := map[string]bool{
+		"file": true, "memory": true,
+	}
+	if config.Storage.Backend == "sqlite" {
+		fail("storage backend sqlite is not supported yet")
+	} else if !validStorageBackends[config.Storage.Backend] {
+		fail("invalid storage backend: %s", config.Storage.Backend)
+	}
+	if config.Storage.Backend == "file" && strings.TrimSpace(config.Storage.StateDir) == "" {
+		fail("storage state dir must not be empty for the file backend")
+	}
+	if config.Storage.SaveIntervalMS < 0 {
+		fail("storage save interval must not be negative: %d", config.Storage.SaveIntervalMS)
+	}
+	if config.Storage.MaxTasksInMemory < 0 {
+		fail("storage max tasks in memory must not be negative: %d", config.Storage.MaxTasksInMemory)
+	}
+
+	if config.Cleanup.TaskTTLSeconds < 0 {
+		fail("task TTL must not be negative: %d", config.Cleanup.TaskTTLSeconds)
+	}
+	if config.Cleanup.TaskTTLSeconds > 0 && config.Cleanup.IntervalSeconds <= 0 {
+		fail("cleanup interval must be positive: %d", config.Cleanup.IntervalSeconds)
+	}
+
+	return errors.Join(errs...)
 }
 
 // GetServerAddr returns the server address string
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// ApplyLive copies the settings that can change without a restart from next
+// into a copy of c: the log level, worker count, connection cap, rate limits
+// and download timeouts. Everything else keeps its current value.
+func (c *Config) ApplyLive(next *Config) *Config {
+	applied := *c
+	applied.Logging.Level = next.Logging.Level
+	applied.Worker.Count = next.Worker.Count
+	applied.Worker.MaxConnections = next.Worker.MaxConnections
+	applied.Server.RateLimit = next.Server.RateLimit
+	applied.Download.TaskTimeoutSeconds = next.Download.TaskTimeoutSeconds
+	applied.Download.StallTimeoutSeconds = next.Download.StallTimeoutSeconds
+	return &applied
+}
+
+// RestartRequired returns the YAML paths of settings that differ between c
+// and next but only take effect after a restart, such as "server.port"
+func (c *Config) RestartRequired(next *Config) []string {
+	var changed []string
+	diffFields("", reflect.ValueOf(*c.ApplyLive(next)), reflect.ValueOf(*next), &changed)
+	return changed
+}
+
+// diffFields appends the paths of the struct fields that differ between a and b
+func diffFields(prefix string, a, b reflect.Value, changed *[]string) {
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			name = strings.ToLower(field.Name)
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		fa, fb := a.Field(i), b.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			diffFields(path, fa, fb, changed)
+			continue
+		}
+		if !reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+			*changed = append(*changed, path)
+		}
+	}
+}
@@ -3,10 +3,35 @@ package domain
 import "time"
 
 type File struct {
-	URL        string    `json:"url"`
-	Filename   string    `json:"filename"`
-	Status     Status    `json:"status"`
-	Size       int64     `json:"size"`
-	Downloaded int64     `json:"downloaded"`
-	CreatedAt  time.Time `json:"created_at"`
+	URL               string       `json:"url"`
+	Filename          string       `json:"filename"`
+	Status            Status       `json:"status"`
+	Size              int64        `json:"size"`
+	Downloaded        int64        `json:"downloaded"`
+	CreatedAt         time.Time    `json:"created_at"`
+	CompletedAt       *time.Time   `json:"completed_at,omitempty"`
+	Error             string       `json:"error,omitempty"`
+	ErrorCode         string       `json:"error_code,omitempty"`
+	Mirrors           []string     `json:"mirrors,omitempty"`
+	SourceURL         string       `json:"source_url,omitempty"`
+	FinalURL          string       `json:"final_url,omitempty"`
+	ContentType       string       `json:"content_type,omitempty"`
+	ETag              string       `json:"etag,omitempty"`
+	LastModified      string       `json:"last_modified,omitempty"`
+	Attempts          int          `json:"attempts,omitempty"`
+	Speed             int64        `json:"speed,omitempty"`
+	SHA256            string       `json:"sha256,omitempty"`
+	ExpectedSize      int64        `json:"expected_size,omitempty"`
+	ExtractedTo       string       `json:"extracted_to,omitempty"`
+	ExtractedFiles    int          `json:"extracted_files,omitempty"`
+	ExtractError      string       `json:"extract_error,omitempty"`
+	Credentials       string       `json:"credentials,omitempty"`
+	MirrorCredentials []string     `json:"mirror_credentials,omitempty"`
+	PartETag          string       `json:"part_etag,omitempty"`
+	Pieces            *PieceHashes `json:"pieces,omitempty"`
+}
+
+type PieceHashes struct {
+	Size   int64    `json:"size"`
+	SHA256 []string `json:"sha256"`
 }
@@ -1,7 +1,40 @@
 package domain
 
+import "time"
+
 type CreateTaskRequest struct {
-	URLs []string `json:"urls"`
+	URLs            []string          `json:"urls"`
+	Files           []FileRequest     `json:"files,omitempty"`
+	Priority        int               `json:"priority,omitempty"`
+	TimeoutSeconds  int               `json:"timeout_seconds,omitempty"`
+	Decompress      bool              `json:"decompress,omitempty"`
+	Transform       string            `json:"transform,omitempty"`
+	CallbackURL     string            `json:"callback_url,omitempty"`
+	UserAgent       string            `json:"user_agent,omitempty"`
+	DryRun          bool              `json:"dry_run,omitempty"`
+	MaxConcurrency  int               `json:"max_concurrency,omitempty"`
+	FollowNext      bool              `json:"follow_next,omitempty"`
+	ManifestURL     string            `json:"manifest_url,omitempty"`
+	Extract         bool              `json:"extract,omitempty"`
+	OverwritePolicy string            `json:"overwrite_policy,omitempty"`
+	CompletionMode  string            `json:"completion_mode,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Request         *RequestOptions   `json:"request,omitempty"`
+}
+
+type FileRequest struct {
+	URL     string       `json:"url"`
+	Mirrors []string     `json:"mirrors,omitempty"`
+	SHA256  string       `json:"sha256,omitempty"`
+	Size    int64        `json:"size,omitempty"`
+	Pieces  *PieceHashes `json:"pieces,omitempty"`
+}
+
+type RequestOptions struct {
+	Method      string            `json:"method,omitempty"`
+	ProbeMethod string            `json:"probe_method,omitempty"`
+	Body        string            `json:"body,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
 }
 
 type CreateTaskResponse struct {
@@ -9,12 +42,182 @@ type CreateTaskResponse struct {
 }
 
 type TaskStatusResponse struct {
-	ID       string `json:"id"`
-	Status   string `json:"status"`
-	Progress int    `json:"progress"`
-	Files    []File `json:"files"`
+	ID            string            `json:"id"`
+	Status        string            `json:"status"`
+	Progress      int               `json:"progress"`
+	Files         []FileStatus      `json:"files,omitempty"`
+	Summary       *TaskSummary      `json:"summary,omitempty"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+	CompletedAt   *time.Time        `json:"completed_at,omitempty"`
+	DryRun        bool              `json:"dry_run,omitempty"`
+	QueuePosition int               `json:"queue_position,omitempty"`
+	Queue         *QueueEstimate    `json:"queue,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Version       int64             `json:"version"`
+}
+
+type QueueEstimate struct {
+	FilesAhead           int        `json:"files_ahead"`
+	EstimatedWaitSeconds *int64     `json:"estimated_wait_seconds,omitempty"`
+	EstimatedStartAt     *time.Time `json:"estimated_start_at,omitempty"`
+	Approximate          bool       `json:"approximate"`
+}
+
+type TaskListResponse struct {
+	Tasks []TaskStatusResponse `json:"tasks"`
+	Total int                  `json:"total"`
+}
+
+// NewTaskStatusResponse builds the status representation of a task
+func NewTaskStatusResponse(task *Task) TaskStatusResponse {
+	return TaskStatusResponse{
+		ID:          task.ID,
+		Status:      string(task.Status),
+		Progress:    task.Progress,
+		Files:       NewFileStatuses(task.Files),
+		CreatedAt:   task.CreatedAt,
+		UpdatedAt:   task.UpdatedAt,
+		CompletedAt: task.CompletedAt,
+		DryRun:      task.DryRun,
+		Labels:      task.Labels,
+		Version:     task.Version,
+	}
+}
+
+type TaskSummary struct {
+	Total     int            `json:"total"`
+	Completed int            `json:"completed"`
+	Failed    int            `json:"failed"`
+	Cancelled int            `json:"cancelled,omitempty"`
+	Errors    []ErrorSummary `json:"errors,omitempty"`
+}
+
+type ErrorSummary struct {
+	Code    string `json:"code"`
+	Count   int    `json:"count"`
+	Example string `json:"example"`
+}
+
+type FileStatus struct {
+	File
+	Percent    int    `json:"percent"`
+	ETASeconds *int64 `json:"eta_seconds,omitempty"`
+	FilesAhead *int   `json:"files_ahead,omitempty"`
+}
+
+// NewFileStatuses adds the percent done and, for files downloading at a known
+// speed and size, the estimated seconds remaining to each file
+func NewFileStatuses(files []File) []FileStatus {
+	statuses := make([]FileStatus, len(files))
+	for i, f := range files {
+		status := FileStatus{File: f}
+		status.Credentials = ""
+		status.MirrorCredentials = nil
+		// piece hashes can run into thousands and only repeat the request
+		status.Pieces = nil
+		if f.Status != StatusDownloading {
+			status.Speed = 0
+		}
+		switch {
+		case f.Status == StatusCompleted:
+			status.Percent = 100
+		case f.Size > 0:
+			status.Percent = int(min(max(f.Downloaded, 0), f.Size) * 100 / f.Size)
+		}
+		if status.Speed > 0 && f.Size > 0 {
+			eta := max(f.Size-f.Downloaded, 0) / status.Speed
+			status.ETASeconds = &eta
+		}
+		statuses[i] = status
+	}
+	return statuses
+}
+
+type ImportTasksResponse struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+type BatchTasksResponse struct {
+	TaskIDs []string `json:"task_ids"`
+	Files   int      `json:"files"`
+	Error   string   `json:"error,omitempty"`
 }
 
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error  string   `json:"error"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+type LogLevelRequest struct {
+	Level string `json:"level"`
+}
+
+type LogLevelResponse struct {
+	Level string `json:"level"`
+}
+
+type WorkersRequest struct {
+	Count int `json:"count"`
+}
+
+type WorkersResponse struct {
+	Count int `json:"count"`
+}
+
+type RecoverResponse struct {
+	Recovered int `json:"recovered"`
+	Resumed   int `json:"resumed"`
+}
+
+type StatsResponse struct {
+	QueueLength   int            `json:"queue_length"`
+	QueueCapacity int            `json:"queue_capacity"`
+	Workers       int            `json:"workers"`
+	BusyWorkers   int            `json:"busy_workers"`
+	Paused        bool           `json:"paused"`
+	Tasks         map[Status]int `json:"tasks"`
+}
+
+type ServiceStatsResponse struct {
+	UptimeSeconds            int64          `json:"uptime_seconds"`
+	TasksTotal               int            `json:"tasks_total"`
+	Tasks                    map[Status]int `json:"tasks"`
+	FilesTotal               int            `json:"files_total"`
+	Files                    map[Status]int `json:"files"`
+	DownloadsCompleted       int64          `json:"downloads_completed"`
+	BytesDownloaded          int64          `json:"bytes_downloaded"`
+	AverageDownloadSeconds   float64        `json:"average_download_seconds"`
+	ThroughputBytesPerSecond int64          `json:"throughput_bytes_per_second"`
+	Workers                  int            `json:"workers"`
+	BusyWorkers              int            `json:"busy_workers"`
+	WorkerUtilization        float64        `json:"worker_utilization"`
+	GeneratedAt              time.Time      `json:"generated_at"`
+}
+
+type TaskManifest struct {
+	TaskID      string         `json:"task_id"`
+	Status      Status         `json:"status"`
+	CompletedAt *time.Time     `json:"completed_at,omitempty"`
+	SHA256      string         `json:"sha256"`
+	Files       []ManifestFile `json:"files"`
+	GeneratedAt time.Time      `json:"generated_at"`
+}
+
+type ManifestFile struct {
+	Index    int    `json:"index"`
+	URL      string `json:"url"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	SHA256   string `json:"sha256,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type HealthResponse struct {
+	Status     string            `json:"status"`
+	Checks     map[string]string `json:"checks"`
+	QueueDepth int               `json:"queue_depth"`
+	Workers    int               `json:"workers"`
+	Tasks      map[Status]int    `json:"tasks"`
 }
@@ -7,4 +7,9 @@ const (
 	StatusDownloading Status = "downloading"
 	StatusCompleted   Status = "completed"
 	StatusFailed      Status = "failed"
+	StatusPaused      Status = "paused"
+	StatusValidated   Status = "validated"
+	StatusRetrying    Status = "retrying"
+	StatusQueued      Status = "queued"
+	StatusCancelled   Status = "cancelled"
 )
@@ -1,12 +1,68 @@
 package domain
 
-import "time"
+import (
+	"maps"
+	"time"
+)
 
 type Task struct {
-	ID        string    `json:"id"`
-	URLs      []string  `json:"urls"`
-	Status    Status    `json:"status"`
-	Files     []File    `json:"files"`
-	CreatedAt time.Time `json:"created_at"`
-	Progress  int       `json:"progress"`
+	ID              string            `json:"id"`
+	URLs            []string          `json:"urls"`
+	Status          Status            `json:"status"`
+	Files           []File            `json:"files"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+	CompletedAt     *time.Time        `json:"completed_at,omitempty"`
+	Progress        int               `json:"progress"`
+	RequestID       string            `json:"request_id,omitempty"`
+	Priority        int               `json:"priority"`
+	TimeoutSeconds  int               `json:"timeout_seconds,omitempty"`
+	Decompress      bool              `json:"decompress,omitempty"`
+	Transform       string            `json:"transform,omitempty"`
+	CallbackURL     string            `json:"callback_url,omitempty"`
+	UserAgent       string            `json:"user_agent,omitempty"`
+	DryRun          bool              `json:"dry_run,omitempty"`
+	MaxConcurrency  int               `json:"max_concurrency,omitempty"`
+	FollowNext      bool              `json:"follow_next,omitempty"`
+	Extract         bool              `json:"extract,omitempty"`
+	OverwritePolicy string            `json:"overwrite_policy,omitempty"`
+	CompletionMode  string            `json:"completion_mode,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Request         *RequestOptions   `json:"request,omitempty"`
+	Version         int64             `json:"version"`
+}
+
+// Clone returns a deep copy of the task that shares no memory with the original
+func (t *Task) Clone() *Task {
+	c := *t
+	c.URLs = append([]string(nil), t.URLs...)
+	c.Files = append([]File(nil), t.Files...)
+	c.Labels = maps.Clone(t.Labels)
+	if t.Request != nil {
+		request := *t.Request
+		request.Headers = maps.Clone(t.Request.Headers)
+		c.Request = &request
+	}
+	if t.CompletedAt != nil {
+		completedAt := *t.CompletedAt
+		c.CompletedAt = &completedAt
+	}
+	for i := range c.Files {
+		if c.Files[i].Mirrors != nil {
+			c.Files[i].Mirrors = append([]string(nil), c.Files[i].Mirrors...)
+		}
+		if c.Files[i].MirrorCredentials != nil {
+			c.Files[i].MirrorCredentials = append([]string(nil), c.Files[i].MirrorCredentials...)
+		}
+		if c.Files[i].Pieces != nil {
+			pieces := *c.Files[i].Pieces
+			pieces.SHA256 = append([]string(nil), pieces.SHA256...)
+			c.Files[i].Pieces = &pieces
+		}
+		if c.Files[i].CompletedAt != nil {
+			completedAt := *c.Files[i].CompletedAt
+			c.Files[i].CompletedAt = &completedAt
+		}
+	}
+	return &c
 }
@@ -0,0 +1,108 @@
+package domain
+
+import "time"
+
+type TaskV2 struct {
+	ID            string            `json:"id"`
+	Status        string            `json:"status"`
+	Progress      int               `json:"progress"`
+	Version       int64             `json:"version"`
+	QueuePosition int               `json:"queue_position"`
+	Queue         *QueueEstimate    `json:"queue"`
+	DryRun        bool              `json:"dry_run"`
+	Summary       TaskSummary       `json:"summary"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+	CompletedAt   *time.Time        `json:"completed_at"`
+	Labels        map[string]string `json:"labels,omitempty"`
+	Files         []FileV2          `json:"files,omitempty"`
+}
+
+type FileV2 struct {
+	URL                 string     `json:"url"`
+	Filename            string     `json:"filename"`
+	Status              string     `json:"status"`
+	SizeBytes           int64      `json:"size_bytes"`
+	DownloadedBytes     int64      `json:"downloaded_bytes"`
+	Percent             int        `json:"percent"`
+	SpeedBytesPerSecond int64      `json:"speed_bytes_per_second"`
+	ETASeconds          *int64     `json:"eta_seconds"`
+	FilesAhead          *int       `json:"files_ahead"`
+	Attempts            int        `json:"attempts"`
+	SourceURL           string     `json:"source_url,omitempty"`
+	FinalURL            string     `json:"final_url,omitempty"`
+	ContentType         string     `json:"content_type,omitempty"`
+	SHA256              string     `json:"sha256,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	CompletedAt         *time.Time `json:"completed_at"`
+	Error               *ErrorV2   `json:"error"`
+}
+
+type ErrorV2 struct {
+	Code    string   `json:"code"`
+	Message string   `json:"message"`
+	Details []string `json:"details,omitempty"`
+}
+
+type ErrorResponseV2 struct {
+	Error ErrorV2 `json:"error"`
+}
+
+// NewTaskV2 builds the v2 representation of a task with all timestamps in
+// UTC. The summary and queue position are left for the caller to fill in.
+func NewTaskV2(task *Task) TaskV2 {
+	return TaskV2{
+		ID:          task.ID,
+		Status:      string(task.Status),
+		Progress:    task.Progress,
+		Version:     task.Version,
+		DryRun:      task.DryRun,
+		CreatedAt:   task.CreatedAt.UTC(),
+		UpdatedAt:   task.UpdatedAt.UTC(),
+		CompletedAt: utcTime(task.CompletedAt),
+		Labels:      task.Labels,
+		Files:       NewFilesV2(task.Files),
+	}
+}
+
+// NewFilesV2 builds the v2 representation of files, with the same percent
+// and ETA as the v1 file statuses and failures as error objects
+func NewFilesV2(files []File) []FileV2 {
+	statuses := NewFileStatuses(files)
+	result := make([]FileV2, len(statuses))
+	for i, s := range statuses {
+		result[i] = FileV2{
+			URL:                 s.URL,
+			Filename:            s.Filename,
+			Status:              string(s.Status),
+			SizeBytes:           s.Size,
+			DownloadedBytes:     s.Downloaded,
+			Percent:             s.Percent,
+			SpeedBytesPerSecond: s.Speed,
+			ETASeconds:          s.ETASeconds,
+			Attempts:            s.Attempts,
+			SourceURL:           s.SourceURL,
+			FinalURL:            s.FinalURL,
+			ContentType:         s.ContentType,
+			SHA256:              s.SHA256,
+			CreatedAt:           s.CreatedAt.UTC(),
+			CompletedAt:         utcTime(s.CompletedAt),
+		}
+		if s.Error != "" {
+			code := s.ErrorCode
+			if code == "" {
+				code = "unknown"
+			}
+			result[i].Error = &ErrorV2{Code: code, Message: s.Error}
+		}
+	}
+	return result
+}
+
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
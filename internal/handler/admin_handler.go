@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/service"
+	"filedownloader-20240926/pkg/logger"
+)
+
+type AdminHandler struct {
+	wp          *service.WorkerPool
+	taskManager *service.TaskManager
+}
+
+// NewAdminHandler creates a new admin handler instance
+func NewAdminHandler(wp *service.WorkerPool, tm *service.TaskManager) *AdminHandler {
+	return &AdminHandler{wp: wp, taskManager: tm}
+}
+
+// GetLogLevel handles HTTP request to read the current log level
+func (h *AdminHandler) GetLogLevel(w http.ResponseWriter, r *http.Request) {
+	resp := domain.LogLevelResponse{Level: strings.ToLower(logger.GetLevel().String())}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// SetLogLevel handles HTTP request to change the log level at runtime
+func (h *AdminHandler) SetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req domain.LogLevelRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Logger.Error("Failed to decode request", "error", err)
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	level, err := logger.ParseLevel(req.Level)
+	if err != nil {
+		logger.Logger.Warn("Invalid log level", "level", req.Level)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	previous := logger.GetLevel()
+	logger.SetLevel(level)
+	logger.Logger.Info("Log level changed", "from", previous.String(), "to", level.String())
+
+	resp := domain.LogLevelResponse{Level: strings.ToLower(level.String())}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetWorkers handles HTTP request to read the current worker count
+func (h *AdminHandler) GetWorkers(w http.ResponseWriter, r *http.Request) {
+	resp := domain.WorkersResponse{Count: h.wp.WorkerCount()}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// SetWorkers handles HTTP request to resize the worker pool at runtime
+func (h *AdminHandler) SetWorkers(w http.ResponseWriter, r *http.Request) {
+	var req domain.WorkersRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		logger.Logger.Error("Failed to decode request", "error", err)
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	if err := h.wp.Resize(req.Count); err != nil {
+		if errors.Is(err, service.ErrPoolStopped) {
+			writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		logger.Logger.Warn("Invalid worker count", "count", req.Count, "error", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	resp := domain.WorkersResponse{Count: h.wp.WorkerCount()}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Recover handles HTTP request to rerun the startup recovery, resetting stuck
+// tasks and enqueueing their pending files again
+func (h *AdminHandler) Recover(w http.ResponseWriter, r *http.Request) {
+	recovered, resumed, err := h.wp.Recover()
+	if err != nil {
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	logger.Logger.Info("Recovery triggered", "recovered", recovered, "resumed", resumed)
+
+	resp := domain.RecoverResponse{Recovered: recovered, Resumed: resumed}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetStats handles HTTP request to report the worker pool load and task counts
+func (h *AdminHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	resp := domain.StatsResponse{
+		QueueLength:   h.wp.QueueLen(),
+		QueueCapacity: 0,
+		Workers:       h.wp.WorkerCount(),
+		BusyWorkers:   h.wp.BusyWorkers(),
+		Paused:        h.wp.Paused(),
+		Tasks:         h.taskManager.CountByStatus(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+	"filedownloader-20240926/internal/service"
+)
+
+// TestAdminHandlerStats tests the worker pool and task counts reported by /admin/stats
+func TestAdminHandlerStats(t *testing.T) {
+	tests := []struct {
+		name          string
+		workers       int
+		urls          int
+		expectedBusy  int
+		expectedQueue int
+	}{
+		{
+			name:          "idle pool",
+			workers:       2,
+			urls:          0,
+			expectedBusy:  0,
+			expectedQueue: 0,
+		},
+		{
+			name:          "all workers busy with a backlog",
+			workers:       2,
+			urls:          5,
+			expectedBusy:  2,
+			expectedQueue: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "HEAD" {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				<-release
+				w.Write([]byte("data"))
+			}))
+			defer srv.Close()
+			defer close(release)
+
+			tm := service.NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := service.NewWorkerPool(tt.workers, tm)
+			wp.Start()
+			// stopping cancels the blocked downloads before anything is written to disk
+			defer wp.Stop()
+
+			if tt.urls > 0 {
+				urls := make([]string, tt.urls)
+				for i := range urls {
+					urls[i] = srv.URL + "/file.txt"
+				}
+				task, err := tm.CreateTask(urls)
+				if err != nil {
+					t.Fatalf("failed to create task: %v", err)
+				}
+				wp.ProcessFiles(task.ID, task.Files)
+			}
+
+			deadline := time.Now().Add(2 * time.Second)
+			for wp.BusyWorkers() != tt.expectedBusy && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			router := SetupRoutes(NewTaskHandler(tm, wp), NewAdminHandler(wp, tm), RouteOptions{})
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/admin/stats", nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+			}
+			var resp domain.StatsResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Workers != tt.workers {
+				t.Errorf("expected %d workers, got %d", tt.workers, resp.Workers)
+			}
+			if resp.BusyWorkers != tt.expectedBusy {
+				t.Errorf("expected %d busy workers, got %d", tt.expectedBusy, resp.BusyWorkers)
+			}
+			if resp.QueueLength != tt.expectedQueue {
+				t.Errorf("expected queue length %d, got %d", tt.expectedQueue, resp.QueueLength)
+			}
+			if tt.urls > 0 && resp.Tasks[domain.StatusDownloading] != 1 {
+				t.Errorf("expected 1 downloading task, got %v", resp.Tasks)
+			}
+		})
+	}
+}
+
+// TestAdminHandlerRecover tests triggering recovery through /admin/recover
+func TestAdminHandlerRecover(t *testing.T) {
+	tests := []struct {
+		name              string
+		start             bool
+		token             string
+		expectedStatus    int
+		expectedRecovered int
+	}{
+		{
+			name:              "stuck task is recovered",
+			start:             true,
+			expectedStatus:    http.StatusOK,
+			expectedRecovered: 1,
+		},
+		{
+			name:           "stopped pool",
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "requires the admin token",
+			start:          true,
+			token:          "secret",
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := service.NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := service.NewWorkerPool(1, tm)
+			wp.SetPaused(true)
+			if tt.start {
+				wp.Start()
+				defer wp.Stop()
+			}
+			if _, err := tm.CreateTask([]string{"http://example.com/file.txt"}); err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+
+			router := SetupRoutes(NewTaskHandler(tm, wp), NewAdminHandler(wp, tm), RouteOptions{AuthToken: tt.token})
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("POST", "/admin/recover", nil))
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			var resp domain.RecoverResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Recovered != tt.expectedRecovered || resp.Resumed != tt.expectedRecovered {
+				t.Errorf("expected %d recovered and resumed tasks, got %+v", tt.expectedRecovered, resp)
+			}
+			if wp.QueueLen() != 1 {
+				t.Errorf("expected the file to be queued, got queue length %d", wp.QueueLen())
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/service"
+	"filedownloader-20240926/pkg/logger"
+)
+
+// maxBatchLineBytes bounds a single line of an NDJSON batch
+const maxBatchLineBytes = 64 * 1024
+
+// CreateTasksBatch handles an application/x-ndjson body with one file per
+// line, either a bare URL or a {"url": ..., "mirrors": [...]} object. The
+// body is read line by line and every per_task lines become a task, so the
+// whole batch is never held in memory. per_task defaults to the per-task URL
+// limit, or a single task when there is none.
+func (h *TaskHandler) CreateTasksBatch(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-ndjson" {
+		writeJSONError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/x-ndjson")
+		return
+	}
+
+	maxURLs := h.taskManager.MaxURLsPerTask()
+	perTask := maxURLs
+	if raw := r.URL.Query().Get("per_task"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "per_task must be a positive integer")
+			return
+		}
+		if maxURLs > 0 && n > maxURLs {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("per_task must not exceed %d", maxURLs))
+			return
+		}
+		perTask = n
+	}
+
+	resp := domain.BatchTasksResponse{TaskIDs: []string{}}
+	var (
+		urls     []string
+		mirrors  [][]string
+		expected []service.ExpectedFile
+	)
+	flush := func() error {
+		if len(urls) == 0 {
+			return nil
+		}
+		task, err := h.taskManager.CreateTaskWithOptions(urls, service.TaskOptions{
+			RequestID: RequestIDFromContext(r.Context()),
+			Mirrors:   mirrors,
+			Expected:  expected,
+		})
+		if err != nil {
+			return err
+		}
+		if h.wp != nil {
+			h.wp.ProcessFiles(task.ID, task.Files)
+		}
+		resp.TaskIDs = append(resp.TaskIDs, task.ID)
+		resp.Files += len(urls)
+		urls, mirrors, expected = nil, nil, nil
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, 4096), maxBatchLineBytes)
+	line := 0
+	var err error
+	for err == nil && scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var file domain.FileRequest
+		if file, err = parseBatchLine(text); err != nil {
+			err = fmt.Errorf("line %d: %w", line, err)
+			break
+		}
+		urls = append(urls, file.URL)
+		mirrors = append(mirrors, file.Mirrors)
+		expected = append(expected, service.ExpectedFile{SHA256: file.SHA256, Size: file.Size, Pieces: file.Pieces})
+		if perTask > 0 && len(urls) == perTask {
+			err = flush()
+		}
+	}
+	if err == nil {
+		err = scanner.Err()
+		if errors.Is(err, bufio.ErrTooLong) {
+			err = fmt.Errorf("line %d: longer than %d bytes", line+1, maxBatchLineBytes)
+		}
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err == nil && len(resp.TaskIDs) == 0 {
+		err = errors.New("batch contains no URLs")
+	}
+
+	status := http.StatusOK
+	switch {
+	case errors.Is(err, service.ErrSaturated):
+		status = http.StatusServiceUnavailable
+	case err != nil:
+		status = http.StatusBadRequest
+	}
+	if err != nil {
+		// tasks created before the failure stay queued and are reported
+		// alongside the error so that the client can resume after them
+		resp.Error = err.Error()
+		logger.Logger.Warn("Batch submission stopped", "error", err, "tasks_created", len(resp.TaskIDs))
+	} else {
+		logger.Logger.Info("Created batch tasks", "tasks_count", len(resp.TaskIDs), "urls_count", resp.Files)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// parseBatchLine parses one non-empty NDJSON line into a file request
+func parseBatchLine(text string) (domain.FileRequest, error) {
+	var file domain.FileRequest
+	switch text[0] {
+	case '{':
+		if err := decodeStrict(strings.NewReader(text), &file); err != nil {
+			return file, err
+		}
+	case '"':
+		if err := json.Unmarshal([]byte(text), &file.URL); err != nil {
+			return file, fmt.Errorf("invalid JSON string: %v", err)
+		}
+	default:
+		file.URL = text
+	}
+	if problem := checkURL("url", file.URL); problem != "" {
+		return file, errors.New(problem)
+	}
+	for i, m := range file.Mirrors {
+		if problem := checkURL(fmt.Sprintf("mirrors[%d]", i), m); problem != "" {
+			return file, errors.New(problem)
+		}
+	}
+	if file.SHA256 != "" && !service.ValidSHA256(file.SHA256) {
+		return file, errors.New("sha256 must be a hex-encoded SHA-256 digest")
+	}
+	if file.Size < 0 {
+		return file, errors.New("size must not be negative")
+	}
+	if file.Pieces != nil {
+		if err := service.ValidatePieces(*file.Pieces, file.Size); err != nil {
+			return file, fmt.Errorf("pieces.%v", err)
+		}
+	}
+	return file, nil
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+	"filedownloader-20240926/internal/service"
+)
+
+// TestCreateTasksBatch tests splitting an NDJSON body into tasks
+func TestCreateTasksBatch(t *testing.T) {
+	tests := []struct {
+		name           string
+		contentType    string
+		query          string
+		maxURLs        int
+		body           string
+		expectedStatus int
+		expectedFiles  []int
+		expectedError  string
+	}{
+		{
+			name:           "single task without limit",
+			contentType:    "application/x-ndjson",
+			body:           "http://example.com/a.txt\nhttp://example.com/b.txt\n\nhttp://example.com/c.txt\n",
+			expectedStatus: http.StatusOK,
+			expectedFiles:  []int{3},
+		},
+		{
+			name:           "split by per_task",
+			contentType:    "application/x-ndjson",
+			query:          "?per_task=2",
+			body:           "http://example.com/a.txt\nhttp://example.com/b.txt\nhttp://example.com/c.txt\nhttp://example.com/d.txt\nhttp://example.com/e.txt",
+			expectedStatus: http.StatusOK,
+			expectedFiles:  []int{2, 2, 1},
+		},
+		{
+			name:           "split by URL limit by default",
+			contentType:    "application/x-ndjson; charset=utf-8",
+			maxURLs:        2,
+			body:           "http://example.com/a.txt\nhttp://example.com/b.txt\nhttp://example.com/c.txt\n",
+			expectedStatus: http.StatusOK,
+			expectedFiles:  []int{2, 1},
+		},
+		{
+			name:           "objects and JSON strings",
+			contentType:    "application/x-ndjson",
+			body:           "{\"url\": \"http://example.com/a.txt\", \"mirrors\": [\"http://mirror.example.com/a.txt\"]}\n\"http://example.com/b.txt\"\n",
+			expectedStatus: http.StatusOK,
+			expectedFiles:  []int{2},
+		},
+		{
+			name:           "invalid line keeps earlier tasks",
+			contentType:    "application/x-ndjson",
+			query:          "?per_task=1",
+			body:           "http://example.com/a.txt\n{\"uri\": \"http://example.com/b.txt\"}\n",
+			expectedStatus: http.StatusBadRequest,
+			expectedFiles:  []int{1},
+			expectedError:  "line 2: unknown field 'uri', did you mean 'url'?",
+		},
+		{
+			name:           "relative URL",
+			contentType:    "application/x-ndjson",
+			body:           "http://example.com/a.txt\nfiles/b.txt\n",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  `line 2: url is not an absolute URL: "files/b.txt"`,
+		},
+		{
+			name:           "relative mirror",
+			contentType:    "application/x-ndjson",
+			body:           "{\"url\": \"http://example.com/a.txt\", \"mirrors\": [\"/a.txt\"]}\n",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  `line 1: mirrors[0] is not an absolute URL: "/a.txt"`,
+		},
+		{
+			name:           "empty batch",
+			contentType:    "application/x-ndjson",
+			body:           "\n\n",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "batch contains no URLs",
+		},
+		{
+			name:           "per_task over URL limit",
+			contentType:    "application/x-ndjson",
+			query:          "?per_task=5",
+			maxURLs:        2,
+			body:           "http://example.com/a.txt\n",
+			expectedStatus: http.StatusBadRequest,
+			expectedError:  "per_task must not exceed 2",
+		},
+		{
+			name:           "wrong content type",
+			contentType:    "application/json",
+			body:           `{"urls": ["http://example.com/a.txt"]}`,
+			expectedStatus: http.StatusUnsupportedMediaType,
+			expectedError:  "Content-Type must be application/x-ndjson",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := service.NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			tm.SetMaxURLsPerTask(tt.maxURLs)
+			h := NewTaskHandler(tm, nil)
+
+			req := httptest.NewRequest("POST", "/api/v1/tasks/batch"+tt.query, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			rec := httptest.NewRecorder()
+			h.CreateTasksBatch(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+
+			var resp domain.BatchTasksResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if !strings.Contains(resp.Error, tt.expectedError) {
+				t.Errorf("expected error containing %q, got %q", tt.expectedError, resp.Error)
+			}
+			if len(resp.TaskIDs) != len(tt.expectedFiles) {
+				t.Fatalf("expected %d tasks, got %d", len(tt.expectedFiles), len(resp.TaskIDs))
+			}
+			for i, id := range resp.TaskIDs {
+				task, ok := tm.GetTask(id)
+				if !ok {
+					t.Fatalf("task %s not found", id)
+				}
+				if len(task.Files) != tt.expectedFiles[i] {
+					t.Errorf("expected task %d to have %d files, got %d", i, tt.expectedFiles[i], len(task.Files))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/service"
+	"filedownloader-20240926/pkg/logger"
+)
+
+type HealthHandler struct {
+	taskManager *service.TaskManager
+	wp          *service.WorkerPool
+}
+
+// NewHealthHandler creates a new health handler instance
+func NewHealthHandler(tm *service.TaskManager, wp *service.WorkerPool) *HealthHandler {
+	return &HealthHandler{taskManager: tm, wp: wp}
+}
+
+// Readiness handles HTTP request to check whether the service can accept work.
+// It responds 503 when the worker pool is stopped or draining for shutdown, or
+// when the task storage is unwritable.
+func (h *HealthHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	resp := domain.HealthResponse{
+		Status: "ok",
+		Checks: make(map[string]string),
+	}
+
+	switch {
+	case h.wp != nil && h.wp.Draining():
+		resp.Status = "unavailable"
+		resp.Checks["worker_pool"] = "draining"
+	case h.wp != nil && h.wp.Running():
+		resp.Checks["worker_pool"] = "ok"
+		resp.QueueDepth = h.wp.QueueLen()
+		resp.Workers = h.wp.WorkerCount()
+	default:
+		resp.Status = "unavailable"
+		resp.Checks["worker_pool"] = "stopped"
+	}
+
+	if h.taskManager != nil {
+		if err := h.taskManager.HealthCheck(); err != nil {
+			logger.Logger.Warn("Storage health check failed", "error", err)
+			resp.Status = "unavailable"
+			resp.Checks["storage"] = err.Error()
+		} else {
+			resp.Checks["storage"] = "ok"
+		}
+		resp.Tasks = h.taskManager.CountByStatus()
+	}
+
+	status := http.StatusOK
+	if resp.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Liveness handles HTTP request to check that the process is serving requests
+func (h *HealthHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("OK"))
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+	"filedownloader-20240926/internal/service"
+)
+
+// unwritableStorage is a memory storage whose health check always fails
+type unwritableStorage struct {
+	*repository.MemoryStorage
+}
+
+func (unwritableStorage) HealthCheck() error {
+	return errors.New("state dir is not writable")
+}
+
+// TestHealthHandlerReadiness tests the readiness report of /health
+func TestHealthHandlerReadiness(t *testing.T) {
+	tests := []struct {
+		name           string
+		storage        repository.Storage
+		startPool      bool
+		drain          bool
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "ready",
+			storage:        repository.NewMemoryStorage(),
+			startPool:      true,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+		},
+		{
+			name:           "worker pool stopped",
+			storage:        repository.NewMemoryStorage(),
+			startPool:      false,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "unavailable",
+		},
+		{
+			name:           "worker pool draining",
+			storage:        repository.NewMemoryStorage(),
+			startPool:      true,
+			drain:          true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "unavailable",
+		},
+		{
+			name:           "storage unwritable",
+			storage:        unwritableStorage{repository.NewMemoryStorage()},
+			startPool:      true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := service.NewTaskManagerWithStorage(tt.storage)
+			wp := service.NewWorkerPool(2, tm)
+			if tt.startPool {
+				wp.Start()
+				defer wp.Stop()
+			}
+			if tt.drain {
+				wp.StartDraining()
+			}
+			if _, err := tm.CreateTask([]string{"http://example.com/a.txt"}); err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+
+			router := SetupRoutes(NewTaskHandler(tm, wp), NewAdminHandler(wp, tm), RouteOptions{})
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+			var resp domain.HealthResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.Status != tt.expectedBody {
+				t.Errorf("expected status %q, got %q", tt.expectedBody, resp.Status)
+			}
+			if resp.Tasks[domain.StatusPending] != 1 {
+				t.Errorf("expected 1 pending task, got %v", resp.Tasks)
+			}
+		})
+	}
+}
+
+// TestHealthHandlerLiveness tests that /livez responds without checking dependencies
+func TestHealthHandlerLiveness(t *testing.T) {
+	tm := service.NewTaskManagerWithStorage(unwritableStorage{repository.NewMemoryStorage()})
+	wp := service.NewWorkerPool(1, tm)
+
+	router := SetupRoutes(NewTaskHandler(tm, wp), NewAdminHandler(wp, tm), RouteOptions{})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/livez", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+}
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"filedownloader-20240926/internal/service"
+	"filedownloader-20240926/pkg/logger"
+)
+
+// AuthMiddleware rejects requests without a valid bearer token or basic auth password
+func AuthMiddleware(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !validCredentials(r, token) {
+				logger.Logger.Warn("Unauthorized request", "method", r.Method, "path", r.URL.Path)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="filedownloader"`)
+				writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// DrainingMiddleware rejects requests that change state with 503 once the
+// worker pool is draining for shutdown, so that clients do not get task IDs
+// that never make progress. Reads such as the task status keep working.
+func DrainingMiddleware(wp *service.WorkerPool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+			default:
+				if wp != nil && wp.Draining() {
+					logger.Logger.Warn("Rejected request while draining", "method", r.Method, "path", r.URL.Path)
+					writeJSONError(w, http.StatusServiceUnavailable, service.ErrPoolDraining.Error())
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// validCredentials checks the Authorization header against the configured token.
+// Basic auth accepts any username as long as the password matches the token.
+func validCredentials(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	if bearer, ok := strings.CutPrefix(auth, "Bearer "); ok {
+		return subtle.ConstantTimeCompare([]byte(strings.TrimSpace(bearer)), []byte(token)) == 1
+	}
+
+	if _, password, ok := r.BasicAuth(); ok {
+		return subtle.ConstantTimeCompare([]byte(password), []byte(token)) == 1
+	}
+
+	return false
+}
+
+// RequestIDHeader is the header used to read and echo the request ID
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey string
+
+const requestIDKey contextKey = "request_id"
+
+// RequestIDFromContext returns the request ID stored by RequestIDMiddleware
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// RequestIDMiddleware assigns a request ID and echoes it in the response
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = generateRequestID()
+		}
+
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+// AccessLogMiddleware logs one line per request with its status, response size and duration.
+// It must run after RequestIDMiddleware to include the request ID.
+func AccessLogMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		logger.Logger.Info("HTTP request",
+			"request_id", RequestIDFromContext(r.Context()),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rw.status,
+			"bytes", rw.bytes,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"remote_addr", r.RemoteAddr)
+	})
+}
+
+// statusRecorder captures the status code and the number of body bytes written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush lets streaming handlers flush through the recorder
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// validRequestID accepts short client-supplied IDs made of safe characters only
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// generateRequestID generates a random request ID
+func generateRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "req_" + time.Now().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"filedownloader-20240926/internal/repository"
+	"filedownloader-20240926/internal/service"
+	"filedownloader-20240926/pkg/logger"
+)
+
+// TestAuthMiddleware tests bearer and basic auth validation
+func TestAuthMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		setupRequest   func(*http.Request)
+		expectedStatus int
+	}{
+		{
+			name:           "missing credentials",
+			setupRequest:   func(r *http.Request) {},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "valid bearer token",
+			setupRequest: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer secret")
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "invalid bearer token",
+			setupRequest: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer wrong")
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "valid basic auth",
+			setupRequest: func(r *http.Request) {
+				r.SetBasicAuth("user", "secret")
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "invalid basic auth",
+			setupRequest: func(r *http.Request) {
+				r.SetBasicAuth("user", "wrong")
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+			h := AuthMiddleware("secret")(next)
+
+			req := httptest.NewRequest("GET", "/api/v1/tasks/1/status", nil)
+			tt.setupRequest(req)
+			rec := httptest.NewRecorder()
+
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("expected JSON error body, got %s", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+// TestRequestIDMiddleware tests request ID generation and propagation
+func TestRequestIDMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		incomingID string
+		expectSame bool
+	}{
+		{
+			name:       "generated ID",
+			incomingID: "",
+			expectSame: false,
+		},
+		{
+			name:       "client supplied ID",
+			incomingID: "abc-123",
+			expectSame: true,
+		},
+		{
+			name:       "unsafe client ID replaced",
+			incomingID: "bad id\nvalue",
+			expectSame: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctxID string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxID = RequestIDFromContext(r.Context())
+				w.WriteHeader(http.StatusAccepted)
+			})
+			h := RequestIDMiddleware(next)
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.incomingID != "" {
+				req.Header.Set(RequestIDHeader, tt.incomingID)
+			}
+			rec := httptest.NewRecorder()
+
+			h.ServeHTTP(rec, req)
+
+			respID := rec.Header().Get(RequestIDHeader)
+			if respID == "" {
+				t.Fatalf("expected request ID header")
+			}
+			if respID != ctxID {
+				t.Errorf("expected context ID %s to match header %s", ctxID, respID)
+			}
+			if tt.expectSame && respID != tt.incomingID {
+				t.Errorf("expected ID %s, got %s", tt.incomingID, respID)
+			}
+			if !tt.expectSame && respID == tt.incomingID {
+				t.Errorf("expected a generated ID, got %s", respID)
+			}
+			if rec.Code != http.StatusAccepted {
+				t.Errorf("expected status %d, got %d", http.StatusAccepted, rec.Code)
+			}
+		})
+	}
+}
+
+// TestAccessLogMiddleware tests that each request is logged with its status and size
+func TestAccessLogMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		expectedStatus float64
+		expectedBytes  float64
+	}{
+		{
+			name: "implicit status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("hello"))
+			},
+			expectedStatus: http.StatusOK,
+			expectedBytes:  5,
+		},
+		{
+			name: "explicit status",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("missing"))
+				w.Write([]byte("!"))
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBytes:  8,
+		},
+		{
+			name: "no body",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			},
+			expectedStatus: http.StatusNoContent,
+			expectedBytes:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			original := logger.Logger
+			logger.Logger = logger.NewJSONLogger(&buf, slog.LevelInfo)
+			defer func() { logger.Logger = original }()
+
+			h := RequestIDMiddleware(AccessLogMiddleware(tt.handler))
+			req := httptest.NewRequest("GET", "/some/path", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			var entry map[string]interface{}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("failed to parse access log %q: %v", buf.String(), err)
+			}
+			if entry["status"] != tt.expectedStatus {
+				t.Errorf("expected status %v, got %v", tt.expectedStatus, entry["status"])
+			}
+			if entry["bytes"] != tt.expectedBytes {
+				t.Errorf("expected bytes %v, got %v", tt.expectedBytes, entry["bytes"])
+			}
+			if entry["method"] != "GET" || entry["path"] != "/some/path" || entry["remote_addr"] != "192.0.2.1:1234" {
+				t.Errorf("unexpected request fields: %v", entry)
+			}
+			if entry["request_id"] == "" || entry["request_id"] == nil {
+				t.Errorf("expected request_id in access log")
+			}
+			if _, ok := entry["duration_ms"]; !ok {
+				t.Errorf("expected duration_ms in access log")
+			}
+		})
+	}
+}
+
+// TestDrainingMiddleware tests that mutating requests are rejected while the pool drains
+func TestDrainingMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		drain          bool
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "create task while running",
+			method:         "POST",
+			path:           "/api/v1/tasks",
+			body:           `{"urls": ["http://example.com/a.txt"]}`,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "create task while draining",
+			drain:          true,
+			method:         "POST",
+			path:           "/api/v1/tasks",
+			body:           `{"urls": ["http://example.com/a.txt"]}`,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "pause while draining",
+			drain:          true,
+			method:         "POST",
+			path:           "/api/v1/tasks/{id}/pause",
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "admin change while draining",
+			drain:          true,
+			method:         "PUT",
+			path:           "/admin/workers",
+			body:           `{"count": 4}`,
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "status while draining",
+			drain:          true,
+			method:         "GET",
+			path:           "/api/v1/tasks/{id}/status",
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := service.NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := service.NewWorkerPool(1, tm)
+			wp.SetPaused(true)
+			wp.Start()
+			defer wp.Stop()
+			task, err := tm.CreateTask([]string{"http://example.com/a.txt"})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			if tt.drain {
+				wp.StartDraining()
+			}
+
+			router := SetupRoutes(NewTaskHandler(tm, wp), NewAdminHandler(wp, tm), RouteOptions{DisableAccessLog: true})
+			path := strings.ReplaceAll(tt.path, "{id}", task.ID)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tt.method, path, strings.NewReader(tt.body)))
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
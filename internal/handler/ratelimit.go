@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"filedownloader-20240926/pkg/logger"
+)
+
+const (
+	// limiterIdleTTL is how long a per-IP limiter is kept after its last request
+	limiterIdleTTL = 10 * time.Minute
+	// limiterSweepInterval bounds how often idle per-IP limiters are removed
+	limiterSweepInterval = time.Minute
+)
+
+// RateLimitMiddleware limits requests to rps per second with the given burst,
+// either per client IP or globally, and answers 429 with Retry-After when exceeded
+func RateLimitMiddleware(rps float64, burst int, perIP bool) func(http.Handler) http.Handler {
+	return NewRateLimiter(rps, burst, perIP).Middleware
+}
+
+// RateLimiter is a rate limit whose settings can be replaced while it serves requests
+type RateLimiter struct {
+	mu       sync.RWMutex
+	limiters *limiterSet
+}
+
+// NewRateLimiter creates a limiter allowing rps requests per second with the
+// given burst; an rps of 0 lets every request through
+func NewRateLimiter(rps float64, burst int, perIP bool) *RateLimiter {
+	l := &RateLimiter{}
+	l.SetLimits(rps, burst, perIP)
+	return l
+}
+
+// SetLimits replaces the limits; the buckets of all clients start over full
+func (l *RateLimiter) SetLimits(rps float64, burst int, perIP bool) {
+	var limiters *limiterSet
+	if rps > 0 {
+		limiters = newLimiterSet(rate.Limit(rps), burst, perIP)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limiters = limiters
+}
+
+// Middleware rejects requests over the current limit with 429 and Retry-After
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.mu.RLock()
+		limiters := l.limiters
+		l.mu.RUnlock()
+		if limiters == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := clientIP(r)
+		reservation := limiters.get(key).Reserve()
+		if delay := reservation.Delay(); !reservation.OK() || delay > 0 {
+			reservation.Cancel()
+			retryAfter := int(math.Ceil(delay.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			logger.Logger.Warn("Rate limit exceeded", "client", key, "method", r.Method, "path", r.URL.Path)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeJSONError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// limiterSet holds a global limiter or one limiter per client IP
+type limiterSet struct {
+	limit     rate.Limit
+	burst     int
+	perIP     bool
+	global    *rate.Limiter
+	mu        sync.Mutex
+	clients   map[string]*clientLimiter
+	lastSweep time.Time
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newLimiterSet(limit rate.Limit, burst int, perIP bool) *limiterSet {
+	return &limiterSet{
+		limit:     limit,
+		burst:     burst,
+		perIP:     perIP,
+		global:    rate.NewLimiter(limit, burst),
+		clients:   make(map[string]*clientLimiter),
+		lastSweep: time.Now(),
+	}
+}
+
+// get returns the limiter for a client, dropping limiters of clients that have gone idle
+func (s *limiterSet) get(key string) *rate.Limiter {
+	if !s.perIP {
+		return s.global
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > limiterSweepInterval {
+		for k, c := range s.clients {
+			if now.Sub(c.lastSeen) > limiterIdleTTL {
+				delete(s.clients, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	c, ok := s.clients[key]
+	if !ok {
+		c = &clientLimiter{limiter: rate.NewLimiter(s.limit, s.burst)}
+		s.clients[key] = c
+	}
+	c.lastSeen = now
+	return c.limiter
+}
+
+// clientIP returns the IP of the connection peer; forwarding headers are not trusted
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"filedownloader-20240926/internal/repository"
+	"filedownloader-20240926/internal/service"
+)
+
+// TestRateLimitMiddleware tests that task creation beyond the limit is rejected with 429
+func TestRateLimitMiddleware(t *testing.T) {
+	tests := []struct {
+		name          string
+		perIP         bool
+		clients       []string
+		expectedCodes []int
+	}{
+		{
+			name:          "global limit",
+			perIP:         false,
+			clients:       []string{"10.0.0.1:1000", "10.0.0.2:1000", "10.0.0.3:1000"},
+			expectedCodes: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:          "per ip limit",
+			perIP:         true,
+			clients:       []string{"10.0.0.1:1000", "10.0.0.1:2000", "10.0.0.1:3000", "10.0.0.2:1000"},
+			expectedCodes: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests, http.StatusOK},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := service.NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			router := SetupRoutes(NewTaskHandler(tm, nil), NewAdminHandler(nil, tm), RouteOptions{
+				RateLimit: 0.001,
+				RateBurst: 2,
+				RatePerIP: tt.perIP,
+			})
+
+			for i, client := range tt.clients {
+				req := httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(`{"urls": ["http://example.com/a.txt"]}`))
+				req.RemoteAddr = client
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+
+				if rec.Code != tt.expectedCodes[i] {
+					t.Errorf("request %d: expected status %d, got %d", i, tt.expectedCodes[i], rec.Code)
+				}
+				if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+					t.Errorf("request %d: expected Retry-After header", i)
+				}
+			}
+
+			// health checks are never limited
+			for i := 0; i < 5; i++ {
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, httptest.NewRequest("GET", "/livez", nil))
+				if rec.Code != http.StatusOK {
+					t.Errorf("expected /livez to stay available, got %d", rec.Code)
+				}
+			}
+		})
+	}
+}
+
+// TestRateLimiterSetLimits tests that changed limits apply to the following requests
+func TestRateLimiterSetLimits(t *testing.T) {
+	tests := []struct {
+		name          string
+		initialRPS    float64
+		updatedRPS    float64
+		expectedCodes []int
+	}{
+		{
+			name:          "limit enabled",
+			initialRPS:    0,
+			updatedRPS:    0.001,
+			expectedCodes: []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:          "limit disabled",
+			initialRPS:    0.001,
+			updatedRPS:    0,
+			expectedCodes: []int{http.StatusOK, http.StatusOK},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewRateLimiter(tt.initialRPS, 1, false)
+			h := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			// exhaust the initial budget, if any
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/v1/tasks", nil))
+			limiter.SetLimits(tt.updatedRPS, 1, false)
+
+			for i, expected := range tt.expectedCodes {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/tasks", nil))
+				if rec.Code != expected {
+					t.Errorf("request %d: expected status %d, got %d", i, expected, rec.Code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"filedownloader-20240926/internal/domain"
+)
+
+// writeJSONError writes an ErrorResponse with the given HTTP status
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(domain.ErrorResponse{Error: msg})
+}
+
+// writeValidationErrors writes a 400 ErrorResponse listing every problem
+// found in a request; error joins them so that older clients see all of them
+func writeValidationErrors(w http.ResponseWriter, problems []string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(domain.ErrorResponse{
+		Error:  strings.Join(problems, "; "),
+		Errors: problems,
+	})
+}
+
+// Error codes of the API errors
+const (
+	errorCodeInvalidRequest    = "invalid_request"
+	errorCodeValidation        = "validation_failed"
+	errorCodeManifest          = "manifest_unusable"
+	errorCodeTooManyURLs       = "too_many_urls"
+	errorCodeSaturated         = "saturated"
+	errorCodeNotFound          = "not_found"
+	errorCodeNoFailedFiles     = "no_failed_files"
+	errorCodeInvalidTransition = "invalid_transition"
+	errorCodeInternal          = "internal"
+)
+
+// apiError is a failed request shared by the API versions, each of which
+// writes it in its own shape
+type apiError struct {
+	status  int
+	code    string
+	message string
+	details []string
+}
+
+func newAPIError(status int, code, message string) *apiError {
+	return &apiError{status: status, code: code, message: message}
+}
+
+// newValidationError reports every problem found in a request
+func newValidationError(problems []string) *apiError {
+	return &apiError{
+		status:  http.StatusBadRequest,
+		code:    errorCodeValidation,
+		message: strings.Join(problems, "; "),
+		details: problems,
+	}
+}
+
+// writeV1 writes the error as a v1 ErrorResponse
+func (e *apiError) writeV1(w http.ResponseWriter) {
+	if len(e.details) > 0 {
+		writeValidationErrors(w, e.details)
+		return
+	}
+	writeJSONError(w, e.status, e.message)
+}
+
+// writeV2 writes the error as a v2 error object with a machine-readable code
+func (e *apiError) writeV2(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.status)
+	json.NewEncoder(w).Encode(domain.ErrorResponseV2{Error: domain.ErrorV2{
+		Code:    e.code,
+		Message: e.message,
+		Details: e.details,
+	}})
+}
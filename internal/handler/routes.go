@@ -6,16 +6,94 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// RouteOptions configures optional behaviour of the HTTP routes
+type RouteOptions struct {
+	// AuthToken enables authentication of the API and admin routes when not empty
+	AuthToken string
+	// RateLimit limits task creation to this many requests per second; 0 disables it
+	RateLimit float64
+	// RateBurst is the number of requests allowed above the rate in a short burst
+	RateBurst int
+	// RatePerIP applies the limit to each client IP instead of globally
+	RatePerIP bool
+	// RateLimiter, when set, is used instead of RateLimit, RateBurst and
+	// RatePerIP so that the limits can be changed at runtime
+	RateLimiter *RateLimiter
+	// DisableAccessLog turns off the per-request access log
+	DisableAccessLog bool
+}
+
 // SetupRoutes configures HTTP API routes
-func SetupRoutes(th *TaskHandler) *mux.Router {
+func SetupRoutes(th *TaskHandler, ah *AdminHandler, opts RouteOptions) *mux.Router {
 	r := mux.NewRouter()
+	r.Use(RequestIDMiddleware)
+	if !opts.DisableAccessLog {
+		r.Use(AccessLogMiddleware)
+	}
 	api := r.PathPrefix("/api/v1").Subrouter()
-	api.HandleFunc("/tasks", th.CreateTask).Methods("POST")
+	if opts.AuthToken != "" {
+		api.Use(AuthMiddleware(opts.AuthToken))
+	}
+	api.Use(DrainingMiddleware(th.wp))
+	var createTask http.Handler = http.HandlerFunc(th.CreateTask)
+	var createBatch http.Handler = http.HandlerFunc(th.CreateTasksBatch)
+	limiter := opts.RateLimiter
+	if limiter == nil && opts.RateLimit > 0 {
+		limiter = NewRateLimiter(opts.RateLimit, opts.RateBurst, opts.RatePerIP)
+	}
+	if limiter != nil {
+		// all ways of creating tasks draw from the same budget
+		createTask = limiter.Middleware(createTask)
+		createBatch = limiter.Middleware(createBatch)
+	}
+	api.Handle("/tasks", createTask).Methods("POST")
+	api.HandleFunc("/tasks", th.ListTasks).Methods("GET")
+	api.Handle("/tasks/batch", createBatch).Methods("POST")
+	api.HandleFunc("/tasks/export", th.ExportTasks).Methods("GET")
+	api.HandleFunc("/tasks/import", th.ImportTasks).Methods("POST")
+	api.HandleFunc("/fetch", th.Fetch).Methods("GET")
 	api.HandleFunc("/tasks/{id}/status", th.GetTaskStatus).Methods("GET")
-	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	}).Methods("GET")
+	api.HandleFunc("/tasks/{id}/pause", th.PauseTask).Methods("POST")
+	api.HandleFunc("/tasks/{id}/resume", th.ResumeTask).Methods("POST")
+	api.HandleFunc("/tasks/{id}/retry", th.RetryTask).Methods("POST")
+	api.HandleFunc("/tasks/{id}/files/{index}", th.CancelFile).Methods("DELETE")
+	api.HandleFunc("/tasks/{id}/manifest", th.GetTaskManifest).Methods("GET")
+	stats := NewStatsHandler(th.taskManager, th.wp)
+	api.HandleFunc("/stats", stats.GetStats).Methods("GET")
+
+	// v2 shares the handler logic with v1 but answers in its own schema
+	v2 := r.PathPrefix("/api/v2").Subrouter()
+	if opts.AuthToken != "" {
+		v2.Use(AuthMiddleware(opts.AuthToken))
+	}
+	v2.Use(DrainingMiddleware(th.wp))
+	var createTaskV2 http.Handler = http.HandlerFunc(th.CreateTaskV2)
+	if limiter != nil {
+		createTaskV2 = limiter.Middleware(createTaskV2)
+	}
+	v2.Handle("/tasks", createTaskV2).Methods("POST")
+	v2.HandleFunc("/tasks/{id}", th.GetTaskV2).Methods("GET")
+	v2.HandleFunc("/tasks/{id}/pause", th.PauseTaskV2).Methods("POST")
+	v2.HandleFunc("/tasks/{id}/resume", th.ResumeTaskV2).Methods("POST")
+	v2.HandleFunc("/tasks/{id}/retry", th.RetryTaskV2).Methods("POST")
+	v2.HandleFunc("/tasks/{id}/files/{index}", th.CancelFileV2).Methods("DELETE")
+	v2.HandleFunc("/tasks/{id}/manifest", th.GetTaskManifestV2).Methods("GET")
+
+	admin := r.PathPrefix("/admin").Subrouter()
+	if opts.AuthToken != "" {
+		admin.Use(AuthMiddleware(opts.AuthToken))
+	}
+	admin.Use(DrainingMiddleware(ah.wp))
+	admin.HandleFunc("/loglevel", ah.GetLogLevel).Methods("GET")
+	admin.HandleFunc("/loglevel", ah.SetLogLevel).Methods("PUT")
+	admin.HandleFunc("/workers", ah.GetWorkers).Methods("GET")
+	admin.HandleFunc("/workers", ah.SetWorkers).Methods("PUT")
+	admin.HandleFunc("/stats", ah.GetStats).Methods("GET")
+	admin.HandleFunc("/recover", ah.Recover).Methods("POST")
+
+	health := NewHealthHandler(th.taskManager, th.wp)
+	r.HandleFunc("/health", health.Readiness).Methods("GET")
+	r.HandleFunc("/livez", health.Liveness).Methods("GET")
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("File Downloader API"))
 	}).Methods("GET")
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/service"
+)
+
+// statsCacheTTL is how long a computed stats snapshot is served before it is
+// recomputed, so that frequent scrapes do not walk all tasks every time
+const statsCacheTTL = 2 * time.Second
+
+type StatsHandler struct {
+	taskManager *service.TaskManager
+	wp          *service.WorkerPool
+	startedAt   time.Time
+	ttl         time.Duration
+
+	mu       sync.Mutex
+	cached   domain.ServiceStatsResponse
+	cachedAt time.Time
+}
+
+// NewStatsHandler creates a new stats handler instance
+func NewStatsHandler(tm *service.TaskManager, wp *service.WorkerPool) *StatsHandler {
+	startedAt := time.Now()
+	if wp != nil {
+		startedAt = wp.StartedAt()
+	}
+	return &StatsHandler{taskManager: tm, wp: wp, startedAt: startedAt, ttl: statsCacheTTL}
+}
+
+// GetStats handles HTTP request to report aggregate statistics of the
+// service: tasks and files by status, download totals, throughput and
+// worker utilization
+func (h *StatsHandler) GetStats(w http.ResponseWriter, r *http.Request) {
+	resp := h.snapshot(time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// snapshot returns the cached statistics, recomputing them once they are
+// older than the cache TTL
+func (h *StatsHandler) snapshot(now time.Time) domain.ServiceStatsResponse {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.cachedAt.IsZero() && now.Sub(h.cachedAt) < h.ttl {
+		return h.cached
+	}
+	h.cached = h.compute(now)
+	h.cachedAt = now
+	return h.cached
+}
+
+// compute builds the statistics from a single pass over the tasks and the
+// counters of the worker pool
+func (h *StatsHandler) compute(now time.Time) domain.ServiceStatsResponse {
+	totals := h.taskManager.Totals()
+	resp := domain.ServiceStatsResponse{
+		UptimeSeconds:            int64(now.Sub(h.startedAt).Seconds()),
+		Tasks:                    totals.Tasks,
+		Files:                    totals.Files,
+		ThroughputBytesPerSecond: totals.Speed,
+		GeneratedAt:              now.UTC(),
+	}
+	for _, n := range totals.Tasks {
+		resp.TasksTotal += n
+	}
+	for _, n := range totals.Files {
+		resp.FilesTotal += n
+	}
+
+	if h.wp != nil {
+		downloads := h.wp.DownloadTotals()
+		resp.DownloadsCompleted = downloads.Completed
+		resp.BytesDownloaded = downloads.Bytes
+		resp.AverageDownloadSeconds = downloads.AverageDuration().Seconds()
+		resp.Workers = h.wp.WorkerCount()
+		resp.BusyWorkers = h.wp.BusyWorkers()
+		if resp.Workers > 0 {
+			resp.WorkerUtilization = float64(resp.BusyWorkers) / float64(resp.Workers)
+		}
+	}
+	return resp
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+	"filedownloader-20240926/internal/service"
+)
+
+// TestStatsHandler tests the aggregate counts of /api/v1/stats and that snapshots are cached briefly
+func TestStatsHandler(t *testing.T) {
+	tm := service.NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	statuses := [][]domain.Status{
+		{domain.StatusCompleted, domain.StatusCompleted},
+		{domain.StatusDownloading, domain.StatusPending},
+		{domain.StatusFailed},
+	}
+	for i, files := range statuses {
+		urls := make([]string, len(files))
+		for j := range urls {
+			urls[j] = "http://example.com/file.txt"
+		}
+		task, err := tm.CreateTask(urls)
+		if err != nil {
+			t.Fatalf("failed to create task: %v", err)
+		}
+		if _, err := tm.ModifyTask(task.ID, func(task *domain.Task) error {
+			for j, status := range files {
+				task.Files[j].Status = status
+				if status == domain.StatusDownloading {
+					task.Files[j].Speed = 1000 * int64(i)
+				}
+			}
+			task.Status = files[0]
+			return nil
+		}); err != nil {
+			t.Fatalf("failed to update task: %v", err)
+		}
+	}
+
+	wp := service.NewWorkerPool(4, tm)
+	router := SetupRoutes(NewTaskHandler(tm, wp), NewAdminHandler(wp, tm), RouteOptions{DisableAccessLog: true})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/stats", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	var resp domain.ServiceStatsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.TasksTotal != 3 || resp.Tasks[domain.StatusCompleted] != 1 || resp.Tasks[domain.StatusDownloading] != 1 {
+		t.Errorf("expected 3 tasks with 1 completed and 1 downloading, got %d %v", resp.TasksTotal, resp.Tasks)
+	}
+	if resp.FilesTotal != 5 || resp.Files[domain.StatusCompleted] != 2 || resp.Files[domain.StatusPending] != 1 {
+		t.Errorf("expected 5 files with 2 completed and 1 pending, got %d %v", resp.FilesTotal, resp.Files)
+	}
+	if resp.ThroughputBytesPerSecond != 1000 {
+		t.Errorf("expected throughput 1000, got %d", resp.ThroughputBytesPerSecond)
+	}
+	if resp.Workers != 4 || resp.BusyWorkers != 0 || resp.WorkerUtilization != 0 {
+		t.Errorf("expected 4 idle workers, got %d busy of %d (%v)", resp.BusyWorkers, resp.Workers, resp.WorkerUtilization)
+	}
+
+	h := NewStatsHandler(tm, wp)
+	now := time.Now()
+	first := h.snapshot(now)
+	if _, err := tm.CreateTask([]string{"http://example.com/new.txt"}); err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	if cached := h.snapshot(now.Add(time.Second)); cached.TasksTotal != first.TasksTotal {
+		t.Errorf("expected the cached %d tasks within the TTL, got %d", first.TasksTotal, cached.TasksTotal)
+	}
+	if fresh := h.snapshot(now.Add(statsCacheTTL)); fresh.TasksTotal != first.TasksTotal+1 {
+		t.Errorf("expected %d tasks after the TTL, got %d", first.TasksTotal+1, fresh.TasksTotal)
+	}
+}
@@ -1,8 +1,21 @@
 package handler
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"filedownloader-20240926/internal/domain"
 	"filedownloader-20240926/internal/service"
@@ -14,67 +27,986 @@ import (
 type TaskHandler struct {
 	taskManager *service.TaskManager
 	wp          *service.WorkerPool
+	statusETag  bool
 }
 
 // NewTaskHandler creates a new task handler instance
 func NewTaskHandler(tm *service.TaskManager, wp *service.WorkerPool) *TaskHandler {
-	return &TaskHandler{taskManager: tm, wp: wp}
+	return &TaskHandler{taskManager: tm, wp: wp, statusETag: true}
+}
+
+// SetStatusETag enables or disables the ETag and If-None-Match handling of task status responses
+func (h *TaskHandler) SetStatusETag(enabled bool) {
+	h.statusETag = enabled
 }
 
 // CreateTask handles HTTP request to create a new download task
 func (h *TaskHandler) CreateTask(w http.ResponseWriter, r *http.Request) {
-	var req domain.CreateTaskRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		logger.Logger.Error("Failed to decode request", "error", err)
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	var problems []string
+	wait, waitTimeout, err := parseWait(r.URL.Query())
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	task, apiErr := h.createTask(r, problems)
+	if apiErr != nil {
+		apiErr.writeV1(w)
 		return
 	}
 
-	if len(req.URLs) == 0 {
-		logger.Logger.Warn("Empty URLs array")
-		http.Error(w, "URLs array cannot be empty", http.StatusBadRequest)
+	if wait && h.wp != nil {
+		h.waitForTask(w, r, task.ID, waitTimeout)
 		return
 	}
 
-	task, err := h.taskManager.CreateTask(req.URLs)
-	if err != nil {
+	resp := domain.CreateTaskResponse{TaskID: task.ID}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// createTask decodes and validates a task creation request, creates the task
+// and queues its files. Problems found by the caller, e.g. in the query, are
+// reported together with those of the body.
+func (h *TaskHandler) createTask(r *http.Request, problems []string) (*domain.Task, *apiError) {
+	var req domain.CreateTaskRequest
+	if err := decodeStrict(r.Body, &req); err != nil {
+		logger.Logger.Warn("Failed to decode request", "error", err)
+		return nil, newAPIError(http.StatusBadRequest, errorCodeInvalidRequest, err.Error())
+	}
+
+	normalizeRequestOptions(req.Request)
+	urls, mirrors, expected := req.URLs, [][]string(nil), []service.ExpectedFile(nil)
+	if len(req.Files) > 0 {
+		urls, mirrors, expected = mergeFileRequests(req.URLs, req.Files)
+	}
+
+	// the manifest is only fetched for a request that is valid otherwise
+	problems = append(validateCreateTask(req, urls), problems...)
+	if len(problems) > 0 {
+		logger.Logger.Warn("Rejected invalid task", "problems", len(problems))
+		return nil, newValidationError(problems)
+	}
+
+	if req.ManifestURL != "" {
+		files, status, err := h.fetchManifest(r.Context(), req.ManifestURL)
+		if err != nil {
+			logger.Logger.Warn("Failed to load manifest", "manifest_url", req.ManifestURL, "error", err)
+			return nil, newAPIError(status, errorCodeManifest, err.Error())
+		}
+		req.Files = append(req.Files, files...)
+		urls, mirrors, expected = mergeFileRequests(req.URLs, req.Files)
+
+		// the entries of the manifest get the same checks as those of the body
+		if problems := validateCreateTask(req, urls); len(problems) > 0 {
+			logger.Logger.Warn("Rejected invalid manifest", "manifest_url", req.ManifestURL, "problems", len(problems))
+			return nil, newValidationError(problems)
+		}
+	}
+
+	task, err := h.taskManager.CreateTaskWithOptions(urls, service.TaskOptions{
+		RequestID:       RequestIDFromContext(r.Context()),
+		Priority:        req.Priority,
+		TimeoutSeconds:  req.TimeoutSeconds,
+		Decompress:      req.Decompress,
+		Transform:       req.Transform,
+		CallbackURL:     req.CallbackURL,
+		Mirrors:         mirrors,
+		Expected:        expected,
+		UserAgent:       req.UserAgent,
+		DryRun:          req.DryRun,
+		MaxConcurrency:  req.MaxConcurrency,
+		FollowNext:      req.FollowNext,
+		Extract:         req.Extract,
+		OverwritePolicy: req.OverwritePolicy,
+		CompletionMode:  req.CompletionMode,
+		Labels:          req.Labels,
+		Request:         req.Request,
+	})
+	switch {
+	case errors.Is(err, service.ErrTooManyURLs):
+		logger.Logger.Warn("Rejected task with too many URLs", "urls_count", len(urls))
+		return nil, newAPIError(http.StatusBadRequest, errorCodeTooManyURLs, err.Error())
+	case errors.Is(err, service.ErrSaturated):
+		logger.Logger.Warn("Rejected task, too many outstanding files", "urls_count", len(urls))
+		return nil, newAPIError(http.StatusServiceUnavailable, errorCodeSaturated, err.Error())
+	case err != nil:
 		logger.Logger.Error("Failed to create task", "error", err)
-		http.Error(w, "Failed to create task", http.StatusInternalServerError)
-		return
+		return nil, newAPIError(http.StatusInternalServerError, errorCodeInternal, "Failed to create task")
 	}
 
 	if h.wp != nil {
 		h.wp.ProcessFiles(task.ID, task.Files)
 	}
 
-	logger.Logger.Info("Created task", "task_id", task.ID, "urls_count", len(req.URLs))
+	logger.Logger.Info("Created task", "task_id", task.ID, "request_id", task.RequestID, "urls_count", len(urls))
+	return task, nil
+}
 
-	resp := domain.CreateTaskResponse{TaskID: task.ID}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+// fetchManifest downloads the manifest of a task and returns its files, or
+// the status to answer with when it cannot be used: 400 for a malformed
+// manifest, 502 when it cannot be fetched. The URL is checked by
+// validateCreateTask beforehand.
+func (h *TaskHandler) fetchManifest(ctx context.Context, manifestURL string) ([]domain.FileRequest, int, error) {
+	if h.wp == nil {
+		return nil, http.StatusServiceUnavailable, errors.New("manifest downloads are not available")
+	}
+	files, err := h.wp.Downloader().FetchManifest(ctx, manifestURL)
+	switch {
+	case errors.Is(err, service.ErrBadManifest):
+		return nil, http.StatusBadRequest, err
+	case err != nil:
+		return nil, http.StatusBadGateway, err
+	}
+	return files, http.StatusOK, nil
+}
+
+const (
+	// defaultWaitTimeout is how long ?wait=true blocks when no timeout is given
+	defaultWaitTimeout = 60 * time.Second
+	// maxWaitTimeout caps the timeout a client may ask to block for
+	maxWaitTimeout = 10 * time.Minute
+)
+
+// parseWait reads the ?wait=true and ?timeout=<seconds> parameters of task creation
+func parseWait(query url.Values) (bool, time.Duration, error) {
+	if query.Get("wait") != "true" {
+		return false, 0, nil
+	}
+	raw := query.Get("timeout")
+	if raw == "" {
+		return true, defaultWaitTimeout, nil
+	}
+	seconds, err := strconv.Atoi(raw)
+	if err != nil || seconds <= 0 {
+		return false, 0, fmt.Errorf("timeout must be a positive number of seconds")
+	}
+	return true, min(time.Duration(seconds)*time.Second, maxWaitTimeout), nil
+}
+
+// waitForTask blocks until the new task finishes and writes its final status.
+// When the timeout expires first, it answers 202 with the task ID so that the
+// client can poll; when the client goes away, the wait is dropped silently.
+func (h *TaskHandler) waitForTask(w http.ResponseWriter, r *http.Request, taskID string, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	task, err := h.wp.WaitForTask(ctx, taskID)
+	switch {
+	case err == nil:
+		h.writeTaskStatus(w, task)
+	case r.Context().Err() != nil:
+		logger.Logger.Info("Client disconnected while waiting for task", "task_id", taskID)
+	case errors.Is(err, context.DeadlineExceeded):
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/api/v1/tasks/"+taskID+"/status")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(domain.CreateTaskResponse{TaskID: taskID})
+	default:
+		logger.Logger.Error("Failed to wait for task", "task_id", taskID, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to wait for task")
+	}
 }
 
 // GetTaskStatus handles HTTP request to get task status
 func (h *TaskHandler) GetTaskStatus(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	taskID := vars["id"]
+	task, apiErr := h.lookupTask(mux.Vars(r)["id"])
+	if apiErr != nil {
+		apiErr.writeV1(w)
+		return
+	}
+
+	// ?files=false leaves out the file list; the summary still describes it
+	resp := taskStatusResponse(task, r.URL.Query().Get("files") != "false")
+	h.setQueueStatus(&resp)
+	h.writeStatusJSON(w, r, resp)
+}
 
+// lookupTask returns the task with the given ID for a status request
+func (h *TaskHandler) lookupTask(taskID string) (*domain.Task, *apiError) {
 	task, exists := h.taskManager.GetTask(taskID)
 	if !exists {
 		logger.Logger.Warn("Task not found", "task_id", taskID)
-		http.Error(w, "Task not found", http.StatusNotFound)
+		return nil, newAPIError(http.StatusNotFound, errorCodeNotFound, "Task not found")
+	}
+	logger.Logger.Debug("Returning task status", "task_id", taskID, "status", task.Status)
+	return task, nil
+}
+
+// writeStatusJSON writes a task status response. Unless disabled, it carries
+// an ETag and a matching If-None-Match is answered with 304 Not Modified.
+func (h *TaskHandler) writeStatusJSON(w http.ResponseWriter, r *http.Request, resp any) {
+	if !h.statusETag {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
 		return
 	}
 
-	resp := domain.TaskStatusResponse{
-		ID:       task.ID,
-		Status:   string(task.Status),
-		Progress: task.Progress,
-		Files:    task.Files,
+	// the ETag hashes the encoded status, so any change of the task or of a
+	// file's progress produces a new one
+	var body bytes.Buffer
+	json.NewEncoder(&body).Encode(resp)
+	sum := fnv.New64a()
+	sum.Write(body.Bytes())
+	etag := fmt.Sprintf(`W/"%016x"`, sum.Sum64())
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body.Bytes())
+}
 
-	logger.Logger.Debug("Returning task status", "task_id", taskID, "status", task.Status)
+// etagMatches reports whether an If-None-Match header matches etag using the
+// weak comparison of RFC 9110
+func etagMatches(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// PauseTask handles HTTP request to pause a download task
+func (h *TaskHandler) PauseTask(w http.ResponseWriter, r *http.Request) {
+	task, apiErr := h.pauseTask(mux.Vars(r)["id"])
+	if apiErr != nil {
+		apiErr.writeV1(w)
+		return
+	}
+	h.writeTaskStatus(w, task)
+}
+
+// ResumeTask handles HTTP request to resume a paused download task
+func (h *TaskHandler) ResumeTask(w http.ResponseWriter, r *http.Request) {
+	task, apiErr := h.resumeTask(mux.Vars(r)["id"])
+	if apiErr != nil {
+		apiErr.writeV1(w)
+		return
+	}
+	h.writeTaskStatus(w, task)
+}
+
+// RetryTask handles HTTP request to retry the failed files of a task
+func (h *TaskHandler) RetryTask(w http.ResponseWriter, r *http.Request) {
+	task, apiErr := h.retryTask(mux.Vars(r)["id"])
+	if apiErr != nil {
+		apiErr.writeV1(w)
+		return
+	}
+	h.writeTaskStatus(w, task)
+}
+
+// CancelFile handles HTTP request to cancel a single file of a task
+func (h *TaskHandler) CancelFile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	task, apiErr := h.cancelFile(vars["id"], vars["index"])
+	if apiErr != nil {
+		apiErr.writeV1(w)
+		return
+	}
+	h.writeTaskStatus(w, task)
+}
+
+// GetTaskManifest handles HTTP request to get the manifest of the files a finished task produced
+func (h *TaskHandler) GetTaskManifest(w http.ResponseWriter, r *http.Request) {
+	manifest, apiErr := h.taskManifest(mux.Vars(r)["id"])
+	if apiErr != nil {
+		apiErr.writeV1(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// pauseTask pauses a task
+func (h *TaskHandler) pauseTask(taskID string) (*domain.Task, *apiError) {
+	task, err := h.taskManager.PauseTask(taskID)
+	if err != nil {
+		return nil, transitionError(taskID, err)
+	}
+
+	logger.Logger.Info("Paused task", "task_id", taskID)
+	return task, nil
+}
+
+// resumeTask resumes a paused task and queues its pending files
+func (h *TaskHandler) resumeTask(taskID string) (*domain.Task, *apiError) {
+	task, err := h.taskManager.ResumeTask(taskID)
+	if err != nil {
+		return nil, transitionError(taskID, err)
+	}
+
+	if h.wp != nil {
+		h.wp.ResumeTasks([]*domain.Task{task})
+	}
+
+	logger.Logger.Info("Resumed task", "task_id", taskID)
+	return task, nil
+}
+
+// retryTask resets the failed files of a task and queues them again
+func (h *TaskHandler) retryTask(taskID string) (*domain.Task, *apiError) {
+	task, err := h.taskManager.RetryFailedFiles(taskID)
+	if err != nil {
+		return nil, transitionError(taskID, err)
+	}
+
+	if h.wp != nil {
+		h.wp.ResumeTasks([]*domain.Task{task})
+	}
+
+	logger.Logger.Info("Retrying failed files", "task_id", taskID)
+	return task, nil
+}
+
+// cancelFile cancels the file of a task at the given index, interrupting its
+// download when the worker pool is processing it
+func (h *TaskHandler) cancelFile(taskID, rawIndex string) (*domain.Task, *apiError) {
+	index, err := strconv.Atoi(rawIndex)
+	if err != nil {
+		return nil, newAPIError(http.StatusBadRequest, errorCodeInvalidRequest, fmt.Sprintf("file index must be a number, got %q", rawIndex))
+	}
+
+	var task *domain.Task
+	if h.wp != nil {
+		task, err = h.wp.CancelFile(taskID, index)
+	} else {
+		task, err = h.taskManager.CancelFile(taskID, index)
+	}
+	if errors.Is(err, service.ErrFileNotFound) {
+		logger.Logger.Warn("File not found", "task_id", taskID, "file_index", index)
+		return nil, newAPIError(http.StatusNotFound, errorCodeNotFound, "File not found")
+	}
+	if err != nil {
+		return nil, transitionError(taskID, err)
+	}
+
+	logger.Logger.Info("Cancelled file", "task_id", taskID, "file_index", index)
+	return task, nil
+}
+
+// taskManifest builds the manifest of a finished task, hashing the files
+// whose digest is not known yet
+func (h *TaskHandler) taskManifest(taskID string) (*domain.TaskManifest, *apiError) {
+	if h.wp == nil {
+		return nil, newAPIError(http.StatusServiceUnavailable, errorCodeInternal, "downloader not available")
+	}
+	manifest, err := h.wp.TaskManifest(taskID)
+	switch {
+	case errors.Is(err, service.ErrTaskNotFound):
+		logger.Logger.Warn("Task not found", "task_id", taskID)
+		return nil, newAPIError(http.StatusNotFound, errorCodeNotFound, "Task not found")
+	case errors.Is(err, service.ErrTaskNotFinished):
+		return nil, newAPIError(http.StatusConflict, errorCodeInvalidTransition, err.Error())
+	case err != nil:
+		logger.Logger.Error("Failed to build task manifest", "task_id", taskID, "error", err)
+		return nil, newAPIError(http.StatusInternalServerError, errorCodeInternal, "Failed to build task manifest")
+	}
+	return manifest, nil
+}
+
+// Fetch handles HTTP request to stream a remote file straight to the client
+// without storing it or creating a task. The download size limit, timeouts
+// and egress guard apply as for tasks.
+func (h *TaskHandler) Fetch(w http.ResponseWriter, r *http.Request) {
+	rawURL := r.URL.Query().Get("url")
+	parsed, err := url.Parse(rawURL)
+	if err != nil || !parsed.IsAbs() {
+		writeJSONError(w, http.StatusBadRequest, "url must be an absolute URL")
+		return
+	}
+	// the password of embedded credentials is kept out of the logs
+	logURL := parsed.Redacted()
+	if h.wp == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "downloader not available")
+		return
+	}
+
+	resp, err := h.wp.Downloader().Open(r.Context(), rawURL, service.DownloadOptions{})
+	if err != nil {
+		logger.Logger.Warn("Fetch failed", "url", logURL, "error", err)
+		writeJSONError(w, fetchErrorStatus(err), err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, name := range []string{"Content-Type", "Content-Encoding", "Content-Disposition", "Last-Modified", "ETag"} {
+		if v := resp.Header.Get(name); v != "" {
+			w.Header().Set(name, v)
+		}
+	}
+	if resp.ContentLength >= 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+
+	written, err := io.Copy(w, resp.Body)
+	if err != nil {
+		// the status is already sent; abort the connection so the client
+		// cannot mistake the truncated body for the whole file
+		logger.Logger.Warn("Fetch interrupted", "url", logURL, "bytes", written, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+	logger.Logger.Info("Fetched file", "url", logURL, "bytes", written)
+}
+
+// fetchErrorStatus maps a failed fetch to the HTTP status returned to the client
+func fetchErrorStatus(err error) int {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, service.ErrBlockedAddress):
+		return http.StatusForbidden
+	case errors.Is(err, service.ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, service.ErrContentTypeNotAllowed):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return http.StatusGatewayTimeout
+	}
+	// upstream errors, including unexpected status codes
+	return http.StatusBadGateway
+}
+
+// ExportTasks handles HTTP request to dump all tasks, including their files,
+// as a JSON array ordered by creation time. URL credentials and the request
+// body and headers are left out unless the include_secrets query parameter
+// is true.
+func (h *TaskHandler) ExportTasks(w http.ResponseWriter, r *http.Request) {
+	includeSecrets := false
+	if raw := r.URL.Query().Get("include_secrets"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "include_secrets must be a boolean")
+			return
+		}
+		includeSecrets = v
+	}
+
+	all := h.taskManager.GetAllTasks()
+	tasks := make([]*domain.Task, 0, len(all))
+	for _, task := range all {
+		if !includeSecrets {
+			redactSecrets(task)
+		}
+		tasks = append(tasks, task)
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].CreatedAt.Equal(tasks[j].CreatedAt) {
+			return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
+		}
+		return tasks[i].ID < tasks[j].ID
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, "[")
+	enc := json.NewEncoder(w)
+	for i, task := range tasks {
+		if i > 0 {
+			io.WriteString(w, ",")
+		}
+		if err := enc.Encode(task); err != nil {
+			logger.Logger.Error("Failed to export tasks", "error", err)
+			return
+		}
+	}
+	io.WriteString(w, "]\n")
+
+	logger.Logger.Info("Exported tasks", "tasks_count", len(tasks), "include_secrets", includeSecrets)
+}
+
+// redactSecrets removes from a task copy everything that may hold a secret:
+// the credentials split off its URLs and the body and headers of its request
+func redactSecrets(task *domain.Task) {
+	for i := range task.Files {
+		task.Files[i].Credentials = ""
+		task.Files[i].MirrorCredentials = nil
+	}
+	if task.Request != nil {
+		task.Request.Body = ""
+		task.Request.Headers = nil
+	}
+}
+
+// ListTasks handles HTTP request to list tasks. Each label query parameter,
+// key=value or just key for any value, narrows the list to the tasks
+// carrying that label. Tasks are described without their file lists.
+func (h *TaskHandler) ListTasks(w http.ResponseWriter, r *http.Request) {
+	labels, problems := parseLabelFilter(r.URL.Query()["label"])
+	if len(problems) > 0 {
+		newValidationError(problems).writeV1(w)
+		return
+	}
+
+	tasks := h.taskManager.FindTasksByLabels(labels)
+	resp := domain.TaskListResponse{Tasks: make([]domain.TaskStatusResponse, 0, len(tasks)), Total: len(tasks)}
+	for _, task := range tasks {
+		resp.Tasks = append(resp.Tasks, taskStatusResponse(task, false))
+	}
+
+	logger.Logger.Debug("Listing tasks", "labels", len(labels), "tasks_count", len(tasks))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// parseLabelFilter turns label query parameters into the labels a task must
+// carry; a filter without a value matches any value of its key
+func parseLabelFilter(filters []string) (map[string]string, []string) {
+	var problems []string
+	labels := make(map[string]string, len(filters))
+	for _, filter := range filters {
+		key, value, _ := strings.Cut(filter, "=")
+		if !validLabelKey(key) {
+			problems = append(problems, fmt.Sprintf("label filter %q must start with a valid label key", filter))
+			continue
+		}
+		if prev, ok := labels[key]; ok && prev != value {
+			problems = append(problems, fmt.Sprintf("label %q is filtered on more than one value", key))
+			continue
+		}
+		labels[key] = value
+	}
+	return labels, problems
+}
+
+// ImportTasks handles HTTP request to restore tasks from an export dump.
+// Existing task IDs are skipped unless the overwrite query parameter is true.
+// Unfinished files of the imported tasks are queued for download.
+func (h *TaskHandler) ImportTasks(w http.ResponseWriter, r *http.Request) {
+	overwrite := false
+	if raw := r.URL.Query().Get("overwrite"); raw != "" {
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "overwrite must be a boolean")
+			return
+		}
+		overwrite = v
+	}
+
+	var tasks []*domain.Task
+	if err := decodeStrict(r.Body, &tasks); err != nil {
+		logger.Logger.Warn("Failed to decode import", "error", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if problems := validateImport(tasks); len(problems) > 0 {
+		logger.Logger.Warn("Rejected import", "problems", len(problems))
+		writeValidationErrors(w, problems)
+		return
+	}
+
+	imported, skipped, err := h.taskManager.ImportTasks(tasks, overwrite)
+	switch {
+	case errors.Is(err, service.ErrInvalidTask):
+		logger.Logger.Warn("Rejected import", "error", err)
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
+		logger.Logger.Error("Failed to import tasks", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to import tasks")
+		return
+	}
+
+	if h.wp != nil {
+		h.wp.ResumeTasks(imported)
+	}
+
+	logger.Logger.Info("Imported tasks", "imported", len(imported), "skipped", skipped, "overwrite", overwrite)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(domain.ImportTasksResponse{Imported: len(imported), Skipped: skipped})
+}
+
+// transitionError maps task manager errors to API errors
+func transitionError(taskID string, err error) *apiError {
+	switch {
+	case errors.Is(err, service.ErrTaskNotFound):
+		logger.Logger.Warn("Task not found", "task_id", taskID)
+		return newAPIError(http.StatusNotFound, errorCodeNotFound, "Task not found")
+	case errors.Is(err, service.ErrNoFailedFiles):
+		logger.Logger.Warn("No failed files to retry", "task_id", taskID)
+		return newAPIError(http.StatusBadRequest, errorCodeNoFailedFiles, err.Error())
+	case errors.Is(err, service.ErrInvalidTransition):
+		logger.Logger.Warn("Invalid task transition", "task_id", taskID, "error", err)
+		return newAPIError(http.StatusConflict, errorCodeInvalidTransition, err.Error())
+	default:
+		logger.Logger.Error("Failed to update task", "task_id", taskID, "error", err)
+		return newAPIError(http.StatusInternalServerError, errorCodeInternal, "Failed to update task")
+	}
+}
+
+// writeTaskStatus writes task status as JSON response
+func (h *TaskHandler) writeTaskStatus(w http.ResponseWriter, task *domain.Task) {
+	resp := taskStatusResponse(task, true)
+	h.setQueueStatus(&resp)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+// queuePosition returns the place of a task waiting for an active task slot, or 0
+func (h *TaskHandler) queuePosition(taskID string) int {
+	if h.wp == nil {
+		return 0
+	}
+	return h.wp.QueuePosition(taskID)
+}
+
+// setQueueStatus adds where a task stands in the queues to its status
+func (h *TaskHandler) setQueueStatus(resp *domain.TaskStatusResponse) {
+	resp.QueuePosition = h.queuePosition(resp.ID)
+	var ahead map[int]int
+	resp.Queue, ahead = h.queueEstimate(resp.ID)
+	for i := range resp.Files {
+		if n, ok := ahead[i]; ok {
+			resp.Files[i].FilesAhead = &n
+		}
+	}
+}
+
+// queueEstimate returns how many files are ahead of the queued files of a
+// task in the worker pool queue, with an approximate start time, and the
+// files ahead of each queued file by index. It returns nil when no file of
+// the task waits for a worker.
+func (h *TaskHandler) queueEstimate(taskID string) (*domain.QueueEstimate, map[int]int) {
+	if h.wp == nil {
+		return nil, nil
+	}
+	est, ok := h.wp.QueueEstimate(taskID)
+	if !ok {
+		return nil, nil
+	}
+	resp := &domain.QueueEstimate{FilesAhead: est.FilesAhead, Approximate: true}
+	if est.WaitKnown {
+		seconds := int64(est.Wait.Round(time.Second) / time.Second)
+		startAt := time.Now().Add(est.Wait).UTC().Truncate(time.Second)
+		resp.EstimatedWaitSeconds = &seconds
+		resp.EstimatedStartAt = &startAt
+	}
+	return resp, est.Files
+}
+
+// maxSummaryErrors bounds the distinct error codes listed in a task summary
+const maxSummaryErrors = 5
+
+// taskStatusResponse builds the status response of a task with a summary of
+// its files, optionally leaving out the file list itself
+func taskStatusResponse(task *domain.Task, withFiles bool) domain.TaskStatusResponse {
+	resp := domain.NewTaskStatusResponse(task)
+	summary := summarizeFiles(task.Files)
+	resp.Summary = &summary
+	if !withFiles {
+		resp.Files = nil
+	}
+	return resp
+}
+
+// summarizeFiles counts the files of a task by outcome and groups failures by
+// error code, most frequent first, keeping the first message of each code as
+// an example
+func summarizeFiles(files []domain.File) domain.TaskSummary {
+	summary := domain.TaskSummary{Total: len(files)}
+	byCode := make(map[string]*domain.ErrorSummary)
+	for _, f := range files {
+		switch f.Status {
+		case domain.StatusCompleted, domain.StatusValidated:
+			summary.Completed++
+		case domain.StatusFailed:
+			summary.Failed++
+			code := f.ErrorCode
+			if code == "" {
+				code = service.ErrorCodeUnknown
+			}
+			entry, ok := byCode[code]
+			if !ok {
+				entry = &domain.ErrorSummary{Code: code, Example: f.Error}
+				byCode[code] = entry
+			}
+			entry.Count++
+		case domain.StatusCancelled:
+			summary.Cancelled++
+		}
+	}
+
+	for _, entry := range byCode {
+		summary.Errors = append(summary.Errors, *entry)
+	}
+	sort.Slice(summary.Errors, func(i, j int) bool {
+		a, b := summary.Errors[i], summary.Errors[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Code < b.Code
+	})
+	if len(summary.Errors) > maxSummaryErrors {
+		summary.Errors = summary.Errors[:maxSummaryErrors]
+	}
+	return summary
+}
+
+// validateCreateTask returns every problem found in a task request, so that a
+// client can fix them all at once; urls are the request URLs merged with the
+// file entries. A request with a manifest_url may have no URLs of its own.
+func validateCreateTask(req domain.CreateTaskRequest, urls []string) []string {
+	problems := validateURLs(req)
+	if len(urls) == 0 && req.ManifestURL == "" {
+		problems = append(problems, "URLs array cannot be empty")
+	}
+	if req.ManifestURL != "" && !validCallbackURL(req.ManifestURL) {
+		problems = append(problems, "manifest_url must be an absolute http or https URL")
+	}
+	if req.TimeoutSeconds < 0 {
+		problems = append(problems, "timeout_seconds must not be negative")
+	}
+	if req.MaxConcurrency < 0 {
+		problems = append(problems, "max_concurrency must not be negative")
+	}
+	if strings.ContainsFunc(req.UserAgent, unicode.IsControl) {
+		problems = append(problems, "user_agent must not contain control characters")
+	}
+	if req.CallbackURL != "" && !validCallbackURL(req.CallbackURL) {
+		problems = append(problems, "callback_url must be an absolute http or https URL")
+	}
+	if req.OverwritePolicy != "" && !service.ValidOverwritePolicy(req.OverwritePolicy) {
+		problems = append(problems, fmt.Sprintf("overwrite_policy must be one of rename, overwrite or skip, got %q", req.OverwritePolicy))
+	}
+	if req.CompletionMode != "" && !service.ValidCompletionMode(req.CompletionMode) {
+		problems = append(problems, fmt.Sprintf("completion_mode must be best_effort or fail_fast, got %q", req.CompletionMode))
+	}
+	transformed := req.Transform != "" && req.Transform != service.TransformNone
+	if req.Transform != "" && !service.ValidTransform(req.Transform) {
+		problems = append(problems, fmt.Sprintf("transform must be none or gunzip, got %q", req.Transform))
+	} else if transformed && req.FollowNext {
+		// a transform reads one stream, not a series of pages
+		problems = append(problems, "transform cannot be combined with follow_next")
+	}
+	problems = append(problems, validateLabels(req.Labels)...)
+	problems = append(problems, validateRequestOptions(req.Request)...)
+	for i, f := range req.Files {
+		if f.SHA256 != "" && !service.ValidSHA256(f.SHA256) {
+			problems = append(problems, fmt.Sprintf("files[%d].sha256 must be a hex-encoded SHA-256 digest", i))
+		}
+		if f.Size < 0 {
+			problems = append(problems, fmt.Sprintf("files[%d].size must not be negative", i))
+		}
+		if f.Pieces == nil {
+			continue
+		}
+		if err := service.ValidatePieces(*f.Pieces, f.Size); err != nil {
+			problems = append(problems, fmt.Sprintf("files[%d].pieces.%v", i, err))
+		}
+		if req.Decompress || req.FollowNext || transformed {
+			// the hashes describe the bytes as served, piece by piece
+			problems = append(problems, fmt.Sprintf("files[%d].pieces cannot be combined with decompress, follow_next or transform", i))
+		}
+	}
+	return problems
+}
+
+// validateImport runs the tasks of an export dump through the checks of task
+// creation, since an imported task is downloaded just like a created one.
+// Null tasks and bad IDs are left to the task manager.
+func validateImport(tasks []*domain.Task) []string {
+	var problems []string
+	for i, task := range tasks {
+		if task == nil {
+			continue
+		}
+		normalizeRequestOptions(task.Request)
+		req := domain.CreateTaskRequest{
+			Priority:        task.Priority,
+			TimeoutSeconds:  task.TimeoutSeconds,
+			Decompress:      task.Decompress,
+			Transform:       task.Transform,
+			CallbackURL:     task.CallbackURL,
+			UserAgent:       task.UserAgent,
+			MaxConcurrency:  task.MaxConcurrency,
+			FollowNext:      task.FollowNext,
+			Extract:         task.Extract,
+			OverwritePolicy: task.OverwritePolicy,
+			CompletionMode:  task.CompletionMode,
+			Labels:          task.Labels,
+			Request:         task.Request,
+		}
+		urls := make([]string, 0, len(task.Files))
+		for _, f := range task.Files {
+			urls = append(urls, f.URL)
+			req.Files = append(req.Files, domain.FileRequest{
+				URL:     f.URL,
+				Mirrors: f.Mirrors,
+				SHA256:  f.SHA256,
+				Size:    f.ExpectedSize,
+				Pieces:  f.Pieces,
+			})
+		}
+		for _, problem := range validateCreateTask(req, urls) {
+			problems = append(problems, fmt.Sprintf("task %d: %s", i, problem))
+		}
+	}
+	return problems
+}
+
+const (
+	maxLabels         = 32
+	maxLabelKeyLength = 63
+	maxLabelValueSize = 256
+)
+
+// validateLabels describes each problem with the labels of a task request
+func validateLabels(labels map[string]string) []string {
+	var problems []string
+	if len(labels) > maxLabels {
+		problems = append(problems, fmt.Sprintf("labels must have at most %d entries, got %d", maxLabels, len(labels)))
+	}
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	// sorted so that the problems come out in a stable order
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !validLabelKey(key) {
+			problems = append(problems, fmt.Sprintf("label key %q must be 1 to %d letters, digits, '-', '_', '.' or '/'", key, maxLabelKeyLength))
+			continue
+		}
+		value := labels[key]
+		if len(value) > maxLabelValueSize {
+			problems = append(problems, fmt.Sprintf("labels[%s] must be at most %d bytes", key, maxLabelValueSize))
+		}
+		if strings.ContainsFunc(value, unicode.IsControl) {
+			problems = append(problems, fmt.Sprintf("labels[%s] must not contain control characters", key))
+		}
+	}
+	return problems
+}
+
+// validLabelKey reports whether key can name a label; keys never contain '='
+// so that they can be told apart from the value in a label filter
+func validLabelKey(key string) bool {
+	if key == "" || len(key) > maxLabelKeyLength {
+		return false
+	}
+	for _, c := range key {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+const maxRequestHeaders = 32
+
+// normalizeRequestOptions upper-cases the methods of a task request, so that
+// they are matched and sent in their canonical form
+func normalizeRequestOptions(request *domain.RequestOptions) {
+	if request == nil {
+		return
+	}
+	request.Method = strings.ToUpper(strings.TrimSpace(request.Method))
+	request.ProbeMethod = strings.ToUpper(strings.TrimSpace(request.ProbeMethod))
+}
+
+// validateRequestOptions describes each problem with the custom request of a
+// task. Header values and the body may hold secrets and are never quoted.
+func validateRequestOptions(request *domain.RequestOptions) []string {
+	if request == nil {
+		return nil
+	}
+	var problems []string
+	if request.Method != "" && !service.ValidDownloadMethod(request.Method) {
+		problems = append(problems, fmt.Sprintf("request.method must be GET or POST, got %q", request.Method))
+	}
+	if request.ProbeMethod != "" && !service.ValidProbeMethod(request.ProbeMethod) {
+		problems = append(problems, fmt.Sprintf("request.probe_method must be HEAD, GET or POST, got %q", request.ProbeMethod))
+	}
+	if len(request.Body) > service.MaxRequestBodySize {
+		problems = append(problems, fmt.Sprintf("request.body must be at most %d bytes", service.MaxRequestBodySize))
+	}
+	if request.Body != "" && request.Method != http.MethodPost && request.ProbeMethod != http.MethodPost {
+		problems = append(problems, "request.body is only sent with POST requests; set request.method or request.probe_method to POST")
+	}
+	if len(request.Headers) > maxRequestHeaders {
+		problems = append(problems, fmt.Sprintf("request.headers must have at most %d entries, got %d", maxRequestHeaders, len(request.Headers)))
+	}
+	names := make([]string, 0, len(request.Headers))
+	for name := range request.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !service.ValidRequestHeader(name) {
+			problems = append(problems, fmt.Sprintf("request.headers[%q] is not a header a task may set", name))
+			continue
+		}
+		if strings.ContainsFunc(request.Headers[name], unicode.IsControl) {
+			problems = append(problems, fmt.Sprintf("request.headers[%s] must not contain control characters", name))
+		}
+	}
+	return problems
+}
+
+// validateURLs checks that every URL and mirror in the request is a
+// non-empty, parseable absolute URL and describes each one that is not
+func validateURLs(req domain.CreateTaskRequest) []string {
+	var problems []string
+	check := func(field, u string) {
+		if problem := checkURL(field, u); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	for i, u := range req.URLs {
+		check(fmt.Sprintf("urls[%d]", i), u)
+	}
+	for i, f := range req.Files {
+		check(fmt.Sprintf("files[%d].url", i), f.URL)
+		for j, m := range f.Mirrors {
+			check(fmt.Sprintf("files[%d].mirrors[%d]", i, j), m)
+		}
+	}
+	return problems
+}
+
+// checkURL describes why u in the given field is not a non-empty, parseable
+// absolute URL, or returns an empty string when it is one
+func checkURL(field, u string) string {
+	if strings.TrimSpace(u) == "" {
+		return field + " must be a non-empty string"
+	}
+	if parsed, err := url.Parse(u); err != nil || parsed.Scheme == "" {
+		return fmt.Sprintf("%s is not an absolute URL: %q", field, u)
+	}
+	return ""
+}
+
+// mergeFileRequests appends file entries after the plain URLs and returns
+// the mirror lists and expected checksums aligned with the combined URLs
+func mergeFileRequests(urls []string, files []domain.FileRequest) ([]string, [][]string, []service.ExpectedFile) {
+	merged := append([]string(nil), urls...)
+	mirrors := make([][]string, len(urls), len(urls)+len(files))
+	expected := make([]service.ExpectedFile, len(urls), len(urls)+len(files))
+	for _, f := range files {
+		merged = append(merged, f.URL)
+		mirrors = append(mirrors, f.Mirrors)
+		expected = append(expected, service.ExpectedFile{SHA256: f.SHA256, Size: f.Size, Pieces: f.Pieces})
+	}
+	return merged, mirrors, expected
+}
+
+// validCallbackURL reports whether u is an absolute http(s) URL
+func validCallbackURL(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	return (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"filedownloader-20240926/internal/domain"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateTaskV2 handles HTTP request to create a download task through the v2
+// API. It takes the same body as v1 and answers 201 with the new task.
+func (h *TaskHandler) CreateTaskV2(w http.ResponseWriter, r *http.Request) {
+	task, apiErr := h.createTask(r, nil)
+	if apiErr != nil {
+		apiErr.writeV2(w)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v2/tasks/"+task.ID)
+	h.writeTaskV2(w, http.StatusCreated, task)
+}
+
+// GetTaskV2 handles HTTP request to get a task through the v2 API;
+// ?files=false leaves out the file list as in v1
+func (h *TaskHandler) GetTaskV2(w http.ResponseWriter, r *http.Request) {
+	task, apiErr := h.lookupTask(mux.Vars(r)["id"])
+	if apiErr != nil {
+		apiErr.writeV2(w)
+		return
+	}
+
+	resp := h.taskV2(task)
+	if r.URL.Query().Get("files") == "false" {
+		resp.Files = nil
+	}
+	h.writeStatusJSON(w, r, resp)
+}
+
+// PauseTaskV2 handles HTTP request to pause a task through the v2 API
+func (h *TaskHandler) PauseTaskV2(w http.ResponseWriter, r *http.Request) {
+	task, apiErr := h.pauseTask(mux.Vars(r)["id"])
+	if apiErr != nil {
+		apiErr.writeV2(w)
+		return
+	}
+	h.writeTaskV2(w, http.StatusOK, task)
+}
+
+// ResumeTaskV2 handles HTTP request to resume a task through the v2 API
+func (h *TaskHandler) ResumeTaskV2(w http.ResponseWriter, r *http.Request) {
+	task, apiErr := h.resumeTask(mux.Vars(r)["id"])
+	if apiErr != nil {
+		apiErr.writeV2(w)
+		return
+	}
+	h.writeTaskV2(w, http.StatusOK, task)
+}
+
+// RetryTaskV2 handles HTTP request to retry the failed files of a task through the v2 API
+func (h *TaskHandler) RetryTaskV2(w http.ResponseWriter, r *http.Request) {
+	task, apiErr := h.retryTask(mux.Vars(r)["id"])
+	if apiErr != nil {
+		apiErr.writeV2(w)
+		return
+	}
+	h.writeTaskV2(w, http.StatusOK, task)
+}
+
+// CancelFileV2 handles HTTP request to cancel a single file of a task through the v2 API
+func (h *TaskHandler) CancelFileV2(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	task, apiErr := h.cancelFile(vars["id"], vars["index"])
+	if apiErr != nil {
+		apiErr.writeV2(w)
+		return
+	}
+	h.writeTaskV2(w, http.StatusOK, task)
+}
+
+// GetTaskManifestV2 handles HTTP request to get the manifest of a finished task through the v2 API
+func (h *TaskHandler) GetTaskManifestV2(w http.ResponseWriter, r *http.Request) {
+	manifest, apiErr := h.taskManifest(mux.Vars(r)["id"])
+	if apiErr != nil {
+		apiErr.writeV2(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(manifest)
+}
+
+// taskV2 builds the v2 representation of a task with its summary and where it
+// stands in the queues
+func (h *TaskHandler) taskV2(task *domain.Task) domain.TaskV2 {
+	resp := domain.NewTaskV2(task)
+	resp.Summary = summarizeFiles(task.Files)
+	resp.QueuePosition = h.queuePosition(task.ID)
+	var ahead map[int]int
+	resp.Queue, ahead = h.queueEstimate(task.ID)
+	for i := range resp.Files {
+		if n, ok := ahead[i]; ok {
+			resp.Files[i].FilesAhead = &n
+		}
+	}
+	return resp
+}
+
+// writeTaskV2 writes a task in the v2 representation with the given status
+func (h *TaskHandler) writeTaskV2(w http.ResponseWriter, status int, task *domain.Task) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(h.taskV2(task))
+}
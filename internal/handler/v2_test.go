@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+	"filedownloader-20240926/internal/service"
+)
+
+// TestTaskAPIV2 tests the v2 task schema and error objects next to the unchanged v1 routes
+func TestTaskAPIV2(t *testing.T) {
+	tests := []struct {
+		name             string
+		method           string
+		path             string
+		body             string
+		expectedStatus   int
+		expectedCode     string
+		expectedDetails  bool
+		expectedFiles    int
+		expectedLocation bool
+	}{
+		{
+			name:             "create task",
+			method:           "POST",
+			path:             "/api/v2/tasks",
+			body:             `{"urls":["http://example.com/new.txt"]}`,
+			expectedStatus:   http.StatusCreated,
+			expectedFiles:    1,
+			expectedLocation: true,
+		},
+		{
+			name:            "create task with an empty url",
+			method:          "POST",
+			path:            "/api/v2/tasks",
+			body:            `{"urls":["http://example.com/a.txt",""]}`,
+			expectedStatus:  http.StatusBadRequest,
+			expectedCode:    errorCodeValidation,
+			expectedDetails: true,
+		},
+		{
+			name:           "get task",
+			method:         "GET",
+			path:           "/api/v2/tasks/{id}",
+			expectedStatus: http.StatusOK,
+			expectedFiles:  2,
+		},
+		{
+			name:           "get task without files",
+			method:         "GET",
+			path:           "/api/v2/tasks/{id}?files=false",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "unknown task",
+			method:         "GET",
+			path:           "/api/v2/tasks/missing",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   errorCodeNotFound,
+		},
+		{
+			name:           "cancel a file out of range",
+			method:         "DELETE",
+			path:           "/api/v2/tasks/{id}/files/5",
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   errorCodeNotFound,
+		},
+		{
+			name:           "resume a task that is not paused",
+			method:         "POST",
+			path:           "/api/v2/tasks/{id}/resume",
+			expectedStatus: http.StatusConflict,
+			expectedCode:   errorCodeInvalidTransition,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := service.NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			task, err := tm.CreateTask([]string{"http://example.com/a.txt", "http://example.com/b.txt"})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			if _, err := tm.ModifyTask(task.ID, func(task *domain.Task) error {
+				task.Files[1].Status = domain.StatusFailed
+				task.Files[1].ErrorCode = "http_404"
+				task.Files[1].Error = "unexpected status 404"
+				return nil
+			}); err != nil {
+				t.Fatalf("failed to update task: %v", err)
+			}
+
+			router := SetupRoutes(NewTaskHandler(tm, nil), NewAdminHandler(nil, tm), RouteOptions{DisableAccessLog: true})
+			path := strings.Replace(tt.path, "{id}", task.ID, 1)
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tt.method, path, strings.NewReader(tt.body)))
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if tt.expectedCode != "" {
+				var resp domain.ErrorResponseV2
+				if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if resp.Error.Code != tt.expectedCode || resp.Error.Message == "" {
+					t.Errorf("expected error code %s with a message, got %+v", tt.expectedCode, resp.Error)
+				}
+				if hasDetails := len(resp.Error.Details) > 0; hasDetails != tt.expectedDetails {
+					t.Errorf("expected details %v, got %q", tt.expectedDetails, resp.Error.Details)
+				}
+				return
+			}
+
+			var resp domain.TaskV2
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if location := rec.Header().Get("Location"); tt.expectedLocation != (location == "/api/v2/tasks/"+resp.ID) {
+				t.Errorf("expected location %v, got %q", tt.expectedLocation, location)
+			}
+			if len(resp.Files) != tt.expectedFiles {
+				t.Fatalf("expected %d files, got %d", tt.expectedFiles, len(resp.Files))
+			}
+			if resp.CreatedAt.Location() != time.UTC {
+				t.Errorf("expected timestamps in UTC, got %s", resp.CreatedAt.Location())
+			}
+			if resp.Version == 0 || resp.Summary.Total == 0 {
+				t.Errorf("expected a version and a summary, got %+v", resp)
+			}
+			if tt.expectedFiles == 2 {
+				if resp.Files[0].Error != nil {
+					t.Errorf("expected no error for a pending file, got %+v", resp.Files[0].Error)
+				}
+				if err := resp.Files[1].Error; err == nil || err.Code != "http_404" || err.Message == "" {
+					t.Errorf("expected an http_404 error object, got %+v", err)
+				}
+			}
+		})
+	}
+}
+
+// TestTaskAPIV1Unchanged tests that v1 keeps its response shapes and flat error messages next to v2
+func TestTaskAPIV1Unchanged(t *testing.T) {
+	tm := service.NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	router := SetupRoutes(NewTaskHandler(tm, nil), NewAdminHandler(nil, tm), RouteOptions{DisableAccessLog: true})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/tasks/missing/status", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	var errResp domain.ErrorResponse
+	if err := json.NewDecoder(rec.Body).Decode(&errResp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if errResp.Error == "" {
+		t.Errorf("expected a flat error message, got %+v", errResp)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/api/v1/tasks", strings.NewReader(`{"urls":["http://example.com/a.txt"]}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if rec.Header().Get("Location") != "" {
+		t.Errorf("expected no location header on v1")
+	}
+	var resp domain.CreateTaskResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.TaskID == "" {
+		t.Errorf("expected the v1 create response, got %+v", resp)
+	}
+}
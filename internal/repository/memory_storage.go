@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"fmt"
+	"sync"
+
+	"filedownloader-20240926/internal/domain"
+)
+
+// MemoryStorage keeps tasks in a map without any disk I/O
+type MemoryStorage struct {
+	tasks map[string]*domain.Task
+	mutex sync.RWMutex
+}
+
+// NewMemoryStorage creates a new in-memory storage instance
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		tasks: make(map[string]*domain.Task),
+	}
+}
+
+// SaveTask stores a copy of the task
+func (ms *MemoryStorage) SaveTask(task *domain.Task) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.tasks[task.ID] = task.Clone()
+	return nil
+}
+
+// LoadTask returns a copy of the stored task
+func (ms *MemoryStorage) LoadTask(taskID string) (*domain.Task, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	task, ok := ms.tasks[taskID]
+	if !ok {
+		return nil, fmt.Errorf("task %s not found in memory storage", taskID)
+	}
+	return task.Clone(), nil
+}
+
+// LoadAllTasks returns copies of all stored tasks
+func (ms *MemoryStorage) LoadAllTasks() (map[string]*domain.Task, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	tasks := make(map[string]*domain.Task, len(ms.tasks))
+	for id, task := range ms.tasks {
+		tasks[id] = task.Clone()
+	}
+	return tasks, nil
+}
+
+// DeleteTask removes a task; deleting a missing task is not an error
+func (ms *MemoryStorage) DeleteTask(taskID string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	delete(ms.tasks, taskID)
+	return nil
+}
+
+// UpdateTask updates existing task
+func (ms *MemoryStorage) UpdateTask(task *domain.Task) error {
+	return ms.SaveTask(task)
+}
+
+// HealthCheck always succeeds since memory storage has no external dependencies
+func (ms *MemoryStorage) HealthCheck() error {
+	return nil
+}
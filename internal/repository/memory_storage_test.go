@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"testing"
+
+	"filedownloader-20240926/internal/domain"
+)
+
+// TestMemoryStorage tests saving, loading and deleting tasks in memory
+func TestMemoryStorage(t *testing.T) {
+	tests := []struct {
+		name   string
+		delete bool
+	}{
+		{
+			name:   "save and load",
+			delete: false,
+		},
+		{
+			name:   "delete",
+			delete: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := NewMemoryStorage()
+			task := &domain.Task{
+				ID:     "task-1",
+				URLs:   []string{"http://example.com/a.txt"},
+				Status: domain.StatusPending,
+				Files:  []domain.File{{URL: "http://example.com/a.txt", Status: domain.StatusPending}},
+			}
+			if err := ms.SaveTask(task); err != nil {
+				t.Fatalf("SaveTask failed: %v", err)
+			}
+
+			task.Files[0].Status = domain.StatusCompleted
+
+			if tt.delete {
+				if err := ms.DeleteTask(task.ID); err != nil {
+					t.Fatalf("DeleteTask failed: %v", err)
+				}
+				if _, err := ms.LoadTask(task.ID); err == nil {
+					t.Error("expected error loading deleted task")
+				}
+				all, _ := ms.LoadAllTasks()
+				if len(all) != 0 {
+					t.Errorf("expected no tasks, got %d", len(all))
+				}
+				return
+			}
+
+			loaded, err := ms.LoadTask(task.ID)
+			if err != nil {
+				t.Fatalf("LoadTask failed: %v", err)
+			}
+			if loaded.Files[0].Status != domain.StatusPending {
+				t.Errorf("expected stored copy to be unaffected by later changes, got %s", loaded.Files[0].Status)
+			}
+			all, _ := ms.LoadAllTasks()
+			if len(all) != 1 {
+				t.Errorf("expected 1 task, got %d", len(all))
+			}
+		})
+	}
+}
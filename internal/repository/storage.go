@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"fmt"
+
+	"filedownloader-20240926/internal/domain"
+)
+
+const (
+	// BackendFile persists tasks as JSON files in the state directory
+	BackendFile = "file"
+	// BackendMemory keeps tasks in memory only; nothing survives a restart
+	BackendMemory = "memory"
+)
+
+// Storage persists tasks between restarts
+type Storage interface {
+	SaveTask(task *domain.Task) error
+	LoadTask(taskID string) (*domain.Task, error)
+	LoadAllTasks() (map[string]*domain.Task, error)
+	DeleteTask(taskID string) error
+	UpdateTask(task *domain.Task) error
+	// HealthCheck reports whether the storage can currently persist tasks
+	HealthCheck() error
+}
+
+// NewStorage creates the storage for the given backend name. The file backend
+// keeps its files in stateDir, DefaultStateDir when empty; compress gzips them
+// and compact writes them without indentation.
+func NewStorage(backend, stateDir string, compress, compact bool) (Storage, error) {
+	switch backend {
+	case BackendFile, "":
+		if stateDir == "" {
+			stateDir = DefaultStateDir
+		}
+		ts := NewTaskStorageWithDir(stateDir)
+		ts.SetCompress(compress)
+		ts.SetCompact(compact)
+		return ts, nil
+	case BackendMemory:
+		return NewMemoryStorage(), nil
+	default:
+		return nil, fmt.Errorf("unsupported storage backend: %s", backend)
+	}
+}
@@ -1,49 +1,67 @@
 package repository
 
 import (
+	"bytes"
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/pkg/logger"
 )
 
+const (
+	taskFileExt           = ".json"
+	compressedTaskFileExt = ".json.gz"
+)
+
+// DefaultStateDir is where task files are kept unless configured otherwise,
+// relative to the working directory
+const DefaultStateDir = "./state"
+
 type TaskStorage struct {
 	stateDir string
+	compress bool
+	compact  bool
 	mutex    sync.RWMutex
 }
 
-// NewTaskStorage creates a new task storage instance
+// NewTaskStorage creates a task storage that keeps its files in DefaultStateDir
 func NewTaskStorage() *TaskStorage {
-	wd, err := os.Getwd()
-	if err != nil {
-		return &TaskStorage{
-			stateDir: "./state",
-		}
-	}
+	return NewTaskStorageWithDir(DefaultStateDir)
+}
 
-	projectRoot := wd
-	for {
-		if _, err := os.Stat(filepath.Join(projectRoot, "go.mod")); err == nil {
-			break
-		}
-		parent := filepath.Dir(projectRoot)
-		if parent == projectRoot {
-			projectRoot = wd
-			break
-		}
-		projectRoot = parent
-	}
+// NewTaskStorageWithDir creates a task storage that keeps its files in dir
+func NewTaskStorageWithDir(dir string) *TaskStorage {
+	return &TaskStorage{stateDir: dir}
+}
 
-	return &TaskStorage{
-		stateDir: filepath.Join(projectRoot, "state"),
-	}
+// SetCompress makes the storage write gzip-compressed <id>.json.gz files.
+// Both plain and compressed files are read regardless of the setting, and a
+// task saved in one form replaces its file in the other, so existing state
+// migrates as tasks are updated.
+func (ts *TaskStorage) SetCompress(compress bool) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.compress = compress
 }
 
-// SaveTask saves task to JSON file
+// SetCompact makes the storage write task files without indentation, which
+// makes files with many entries much smaller; files are read either way.
+func (ts *TaskStorage) SetCompact(compact bool) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.compact = compact
+}
+
+// SaveTask saves task to JSON file. The data is written to a temporary file in
+// the same directory and renamed over the target, so a crash mid-write never
+// leaves a truncated task file behind.
 func (ts *TaskStorage) SaveTask(task *domain.Task) error {
 	ts.mutex.Lock()
 	defer ts.mutex.Unlock()
@@ -52,43 +70,104 @@ func (ts *TaskStorage) SaveTask(task *domain.Task) error {
 		return fmt.Errorf("failed to create state dir: %w", err)
 	}
 
-	filePath := filepath.Join(ts.stateDir, task.ID+".json")
+	ext, staleExt := taskFileExt, compressedTaskFileExt
+	if ts.compress {
+		ext, staleExt = compressedTaskFileExt, taskFileExt
+	}
+	filePath := filepath.Join(ts.stateDir, task.ID+ext)
 
-	data, err := json.MarshalIndent(task, "", "  ")
+	data, err := ts.marshal(task)
 	if err != nil {
 		return fmt.Errorf("failed to marshal task: %w", err)
 	}
+	if ts.compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return fmt.Errorf("failed to compress task: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("failed to compress task: %w", err)
+		}
+		data = buf.Bytes()
+	}
 
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	if err := writeFileAtomic(filePath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write task file: %w", err)
 	}
+	// drop the copy in the other format so that loading never sees an older version
+	if err := os.Remove(filepath.Join(ts.stateDir, task.ID+staleExt)); err != nil && !os.IsNotExist(err) {
+		logger.Logger.Warn("Failed to remove stale task file", "task_id", task.ID, "error", err)
+	}
 
-	fmt.Printf("DEBUG: Saved task %s to %s\n", task.ID, filePath)
+	logger.Logger.Debug("Saved task", "task_id", task.ID, "path", filePath)
 	return nil
 }
 
-// LoadTask loads task from JSON file
+// marshal encodes a task, indented unless the storage is compact. The caller must hold the mutex.
+func (ts *TaskStorage) marshal(task *domain.Task) ([]byte, error) {
+	if ts.compact {
+		return json.Marshal(task)
+	}
+	return json.MarshalIndent(task, "", "  ")
+}
+
+// LoadTask loads task from JSON file, plain or gzip-compressed
 func (ts *TaskStorage) LoadTask(taskID string) (*domain.Task, error) {
 	ts.mutex.RLock()
 	defer ts.mutex.RUnlock()
+	return ts.loadTask(taskID)
+}
+
+// loadTask reads a task in the format currently written, which is the newer
+// one if both exist. When that file is unreadable, the copy in the other
+// format is used if there is one, so that a task left in both forms by an
+// interrupted migration survives the newer file being corrupt. The caller
+// must hold the mutex.
+func (ts *TaskStorage) loadTask(taskID string) (*domain.Task, error) {
+	exts := []string{taskFileExt, compressedTaskFileExt}
+	if ts.compress {
+		exts[0], exts[1] = exts[1], exts[0]
+	}
+	filePath := filepath.Join(ts.stateDir, taskID+exts[0])
+	task, err := decodeTaskFile(filePath)
+	if err != nil {
+		fallbackPath := filepath.Join(ts.stateDir, taskID+exts[1])
+		fallback, fallbackErr := decodeTaskFile(fallbackPath)
+		switch {
+		case fallbackErr == nil:
+			if !os.IsNotExist(err) {
+				logger.Logger.Warn("Task file is unreadable, using the copy in the other format", "task_id", taskID, "path", filePath, "error", err)
+			}
+			task, filePath, err = fallback, fallbackPath, nil
+		case os.IsNotExist(err):
+			err = fallbackErr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
 
-	filePath := filepath.Join(ts.stateDir, taskID+".json")
+	logger.Logger.Debug("Loaded task", "task_id", taskID, "path", filePath)
+	return task, nil
+}
 
-	data, err := os.ReadFile(filePath)
+// decodeTaskFile reads and unmarshals a single task file
+func decodeTaskFile(path string) (*domain.Task, error) {
+	data, err := readTaskFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read task file: %w", err)
 	}
-
 	var task domain.Task
 	if err := json.Unmarshal(data, &task); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal task: %w", err)
 	}
-
-	fmt.Printf("DEBUG: Loaded task %s from %s\n", taskID, filePath)
 	return &task, nil
 }
 
-// LoadAllTasks loads all tasks from state directory
+// LoadAllTasks loads all tasks from state directory. Unreadable or corrupt
+// task files, e.g. truncated by a crash or a full disk, are logged and
+// skipped, so that one bad file does not hide every other task.
 func (ts *TaskStorage) LoadAllTasks() (map[string]*domain.Task, error) {
 	ts.mutex.RLock()
 	defer ts.mutex.RUnlock()
@@ -98,33 +177,51 @@ func (ts *TaskStorage) LoadAllTasks() (map[string]*domain.Task, error) {
 	entries, err := os.ReadDir(ts.stateDir)
 	if err != nil {
 		if os.IsNotExist(err) {
-			fmt.Printf("DEBUG: State dir %s does not exist, starting fresh\n", ts.stateDir)
+			logger.Logger.Debug("State dir does not exist, starting fresh", "state_dir", ts.stateDir)
 			return tasks, nil
 		}
 		return nil, fmt.Errorf("failed to read state dir: %w", err)
 	}
 
+	skipped := make(map[string]struct{})
 	for _, entry := range entries {
 		if entry.IsDir() {
 			continue
 		}
-		if filepath.Ext(entry.Name()) != ".json" {
+
+		name := entry.Name()
+		var taskID string
+		switch {
+		case strings.HasSuffix(name, compressedTaskFileExt):
+			taskID = strings.TrimSuffix(name, compressedTaskFileExt)
+		case strings.HasSuffix(name, taskFileExt):
+			taskID = strings.TrimSuffix(name, taskFileExt)
+		default:
+			continue
+		}
+		if _, loaded := tasks[taskID]; loaded {
+			// both formats exist during migration; LoadTask picked one already
 			continue
 		}
 
-		name := entry.Name()
-		taskID := name[:len(name)-5]
+		if _, bad := skipped[taskID]; bad {
+			continue
+		}
 
-		task, err := ts.LoadTask(taskID)
+		task, err := ts.loadTask(taskID)
 		if err != nil {
-			log.Printf("WARNING: Failed to load task %s: %v", taskID, err)
+			logger.Logger.Warn("Skipping unreadable task file", "task_id", taskID, "error", err)
+			skipped[taskID] = struct{}{}
 			continue
 		}
 
 		tasks[taskID] = task
 	}
 
-	fmt.Printf("DEBUG: Loaded %d tasks from state\n", len(tasks))
+	if len(skipped) > 0 {
+		logger.Logger.Warn("Skipped unreadable task files", "state_dir", ts.stateDir, "skipped", len(skipped), "loaded", len(tasks))
+	}
+	logger.Logger.Debug("Loaded tasks from state", "count", len(tasks))
 	return tasks, nil
 }
 
@@ -133,16 +230,14 @@ func (ts *TaskStorage) DeleteTask(taskID string) error {
 	ts.mutex.Lock()
 	defer ts.mutex.Unlock()
 
-	filePath := filepath.Join(ts.stateDir, taskID+".json")
-
-	if err := os.Remove(filePath); err != nil {
-		if os.IsNotExist(err) {
-			return nil
+	for _, ext := range []string{taskFileExt, compressedTaskFileExt} {
+		filePath := filepath.Join(ts.stateDir, taskID+ext)
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete task file: %w", err)
 		}
-		return fmt.Errorf("failed to delete task file: %w", err)
 	}
 
-	fmt.Printf("DEBUG: Deleted task %s\n", taskID)
+	logger.Logger.Debug("Deleted task", "task_id", taskID)
 	return nil
 }
 
@@ -150,3 +245,69 @@ func (ts *TaskStorage) DeleteTask(taskID string) error {
 func (ts *TaskStorage) UpdateTask(task *domain.Task) error {
 	return ts.SaveTask(task)
 }
+
+// readTaskFile reads a task file, decompressing it when its name ends in .gz
+func readTaskFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil || !strings.HasSuffix(path, ".gz") {
+		return data, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	defer zr.Close()
+	data, err = io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it into place
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// HealthCheck verifies that the state directory exists or can be created and is writable
+func (ts *TaskStorage) HealthCheck() error {
+	if err := os.MkdirAll(ts.stateDir, 0755); err != nil {
+		return fmt.Errorf("state dir is not available: %w", err)
+	}
+	probe, err := os.CreateTemp(ts.stateDir, ".healthcheck-*")
+	if err != nil {
+		return fmt.Errorf("state dir is not writable: %w", err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
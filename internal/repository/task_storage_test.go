@@ -0,0 +1,404 @@
+package repository
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"filedownloader-20240926/internal/domain"
+)
+
+// TestTaskStorageAtomicSave tests that saves leave no temporary files and
+// that damaged task files are skipped on load
+func TestTaskStorageAtomicSave(t *testing.T) {
+	tests := []struct {
+		name          string
+		leftovers     map[string]string
+		expectedTasks int
+	}{
+		{
+			name:          "clean state",
+			leftovers:     nil,
+			expectedTasks: 1,
+		},
+		{
+			name: "truncated task file",
+			leftovers: map[string]string{
+				"broken.json": `{"id": "broken", "urls": ["http://exa`,
+			},
+			expectedTasks: 1,
+		},
+		{
+			name: "interrupted temp file",
+			leftovers: map[string]string{
+				"good.json.tmp-123": `{"id": "good", "status": "comp`,
+			},
+			expectedTasks: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ts := &TaskStorage{stateDir: dir}
+
+			task := &domain.Task{
+				ID:     "good",
+				URLs:   []string{"http://example.com/a.txt"},
+				Status: domain.StatusPending,
+			}
+			if err := ts.SaveTask(task); err != nil {
+				t.Fatalf("SaveTask failed: %v", err)
+			}
+			task.Status = domain.StatusCompleted
+			if err := ts.UpdateTask(task); err != nil {
+				t.Fatalf("UpdateTask failed: %v", err)
+			}
+
+			entries, _ := os.ReadDir(dir)
+			for _, e := range entries {
+				if strings.Contains(e.Name(), ".tmp-") {
+					t.Errorf("unexpected temporary file left behind: %s", e.Name())
+				}
+			}
+
+			for name, content := range tt.leftovers {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatalf("failed to write leftover file: %v", err)
+				}
+			}
+
+			tasks, err := ts.LoadAllTasks()
+			if err != nil {
+				t.Fatalf("LoadAllTasks failed: %v", err)
+			}
+			if len(tasks) != tt.expectedTasks {
+				t.Errorf("expected %d tasks, got %d", tt.expectedTasks, len(tasks))
+			}
+			if got, ok := tasks["good"]; !ok || got.Status != domain.StatusCompleted {
+				t.Errorf("expected good task with status completed, got %+v", got)
+			}
+		})
+	}
+}
+
+// TestTaskStorageLoadAllTasksCorrupt tests that corrupt plain and compressed
+// task files are skipped without hiding the other tasks
+func TestTaskStorageLoadAllTasksCorrupt(t *testing.T) {
+	gzipped := func(data string) string {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write([]byte(data))
+		zw.Close()
+		return buf.String()
+	}
+	valid := gzipped(`{"id": "broken", "status": "completed", "urls": ["http://example.com/a.txt"]}`)
+
+	tests := []struct {
+		name          string
+		files         map[string]string
+		expectedTasks []string
+	}{
+		{
+			name:          "truncated compressed file",
+			files:         map[string]string{"broken.json.gz": valid[:len(valid)/2]},
+			expectedTasks: []string{"good", "other"},
+		},
+		{
+			name:          "compressed file that is not gzip",
+			files:         map[string]string{"broken.json.gz": `{"id": "broken"}`},
+			expectedTasks: []string{"good", "other"},
+		},
+		{
+			name:          "compressed file with invalid JSON",
+			files:         map[string]string{"broken.json.gz": gzipped(`{"id": "broken", "status": `)},
+			expectedTasks: []string{"good", "other"},
+		},
+		{
+			name:          "empty plain file",
+			files:         map[string]string{"broken.json": ""},
+			expectedTasks: []string{"good", "other"},
+		},
+		{
+			name:          "several corrupt files",
+			files:         map[string]string{"a.json.gz": "", "b.json": "{", "c.json.gz": valid[:10]},
+			expectedTasks: []string{"good", "other"},
+		},
+		{
+			name: "corrupt file with a copy in the other format",
+			files: map[string]string{
+				"broken.json.gz": valid[:len(valid)/2],
+				"broken.json":    `{"id": "broken", "status": "pending"}`,
+			},
+			expectedTasks: []string{"broken", "good", "other"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ts := NewTaskStorageWithDir(dir)
+			ts.SetCompress(true)
+			for _, id := range []string{"good", "other"} {
+				if err := ts.SaveTask(&domain.Task{ID: id, Status: domain.StatusCompleted}); err != nil {
+					t.Fatalf("SaveTask failed: %v", err)
+				}
+			}
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatalf("failed to write task file: %v", err)
+				}
+			}
+
+			tasks, err := ts.LoadAllTasks()
+			if err != nil {
+				t.Fatalf("LoadAllTasks failed: %v", err)
+			}
+			var ids []string
+			for id := range tasks {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			if fmt.Sprint(ids) != fmt.Sprint(tt.expectedTasks) {
+				t.Errorf("expected tasks %v, got %v", tt.expectedTasks, ids)
+			}
+		})
+	}
+}
+
+// TestTaskStorageCompression tests round-tripping tasks through gzip-compressed
+// files and loading a state directory that mixes both formats
+func TestTaskStorageCompression(t *testing.T) {
+	tests := []struct {
+		name          string
+		writeCompress bool
+		readCompress  bool
+		expectedFile  string
+	}{
+		{
+			name:          "compressed round trip",
+			writeCompress: true,
+			readCompress:  true,
+			expectedFile:  "task.json.gz",
+		},
+		{
+			name:          "compressed file read without compression",
+			writeCompress: true,
+			readCompress:  false,
+			expectedFile:  "task.json.gz",
+		},
+		{
+			name:          "plain file read with compression",
+			writeCompress: false,
+			readCompress:  true,
+			expectedFile:  "task.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writer := NewTaskStorageWithDir(dir)
+			writer.SetCompress(tt.writeCompress)
+
+			task := &domain.Task{
+				ID:     "task",
+				URLs:   []string{"http://example.com/a.txt"},
+				Status: domain.StatusCompleted,
+				Files:  []domain.File{{URL: "http://example.com/a.txt", Status: domain.StatusCompleted, Size: 42}},
+			}
+			if err := writer.SaveTask(task); err != nil {
+				t.Fatalf("SaveTask failed: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, tt.expectedFile)); err != nil {
+				t.Fatalf("expected %s to exist: %v", tt.expectedFile, err)
+			}
+
+			reader := NewTaskStorageWithDir(dir)
+			reader.SetCompress(tt.readCompress)
+			loaded, err := reader.LoadTask("task")
+			if err != nil {
+				t.Fatalf("LoadTask failed: %v", err)
+			}
+			if loaded.Status != domain.StatusCompleted || len(loaded.Files) != 1 || loaded.Files[0].Size != 42 {
+				t.Errorf("unexpected task after round trip: %+v", loaded)
+			}
+		})
+	}
+}
+
+// TestTaskStorageCompact tests that compact task files have no indentation and load like indented ones
+func TestTaskStorageCompact(t *testing.T) {
+	tests := []struct {
+		name             string
+		compact          bool
+		expectedIndented bool
+	}{
+		{
+			name:             "indented",
+			compact:          false,
+			expectedIndented: true,
+		},
+		{
+			name:             "compact",
+			compact:          true,
+			expectedIndented: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			ts := NewTaskStorageWithDir(dir)
+			ts.SetCompact(tt.compact)
+			if err := ts.SaveTask(manyFilesTask(3)); err != nil {
+				t.Fatalf("SaveTask failed: %v", err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, "task.json"))
+			if err != nil {
+				t.Fatalf("failed to read task file: %v", err)
+			}
+			if indented := strings.Contains(string(data), "\n  "); indented != tt.expectedIndented {
+				t.Errorf("expected indented %v, got %s", tt.expectedIndented, data)
+			}
+
+			// the reader is configured the other way to show that both forms load
+			reader := NewTaskStorageWithDir(dir)
+			reader.SetCompact(!tt.compact)
+			loaded, err := reader.LoadTask("task")
+			if err != nil {
+				t.Fatalf("LoadTask failed: %v", err)
+			}
+			if len(loaded.Files) != 3 || loaded.Files[2].Size != 1002 {
+				t.Errorf("unexpected task after round trip: %+v", loaded)
+			}
+		})
+	}
+}
+
+// BenchmarkTaskStorageSave compares saving a task with many files indented and
+// compact; the bytes/task metric shows the size of the written file
+func BenchmarkTaskStorageSave(b *testing.B) {
+	task := manyFilesTask(1000)
+	for _, compact := range []bool{false, true} {
+		name := "indented"
+		if compact {
+			name = "compact"
+		}
+		b.Run(name, func(b *testing.B) {
+			dir := b.TempDir()
+			ts := NewTaskStorageWithDir(dir)
+			ts.SetCompact(compact)
+			for i := 0; i < b.N; i++ {
+				if err := ts.SaveTask(task); err != nil {
+					b.Fatalf("SaveTask failed: %v", err)
+				}
+			}
+			info, err := os.Stat(filepath.Join(dir, "task.json"))
+			if err != nil {
+				b.Fatalf("failed to stat task file: %v", err)
+			}
+			b.ReportMetric(float64(info.Size()), "bytes/task")
+		})
+	}
+}
+
+// manyFilesTask returns a completed task with n files
+func manyFilesTask(n int) *domain.Task {
+	task := &domain.Task{ID: "task", Status: domain.StatusCompleted}
+	for i := 0; i < n; i++ {
+		url := fmt.Sprintf("http://example.com/files/%d.bin", i)
+		task.URLs = append(task.URLs, url)
+		task.Files = append(task.Files, domain.File{
+			URL:        url,
+			Filename:   fmt.Sprintf("%d.bin", i),
+			Status:     domain.StatusCompleted,
+			Size:       int64(1000 + i),
+			Downloaded: int64(1000 + i),
+		})
+	}
+	return task
+}
+
+// TestTaskStorageCompressionMigration tests that saving a task in the other
+// format replaces its old file and that mixed directories load every task once
+func TestTaskStorageCompressionMigration(t *testing.T) {
+	dir := t.TempDir()
+	ts := NewTaskStorageWithDir(dir)
+
+	for _, id := range []string{"old", "migrated"} {
+		if err := ts.SaveTask(&domain.Task{ID: id, Status: domain.StatusPending}); err != nil {
+			t.Fatalf("SaveTask failed: %v", err)
+		}
+	}
+
+	ts.SetCompress(true)
+	if err := ts.SaveTask(&domain.Task{ID: "migrated", Status: domain.StatusCompleted}); err != nil {
+		t.Fatalf("SaveTask failed: %v", err)
+	}
+	if err := ts.SaveTask(&domain.Task{ID: "new", Status: domain.StatusPending}); err != nil {
+		t.Fatalf("SaveTask failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "migrated.json")); !os.IsNotExist(err) {
+		t.Errorf("expected plain file of migrated task to be removed, got %v", err)
+	}
+
+	tasks, err := ts.LoadAllTasks()
+	if err != nil {
+		t.Fatalf("LoadAllTasks failed: %v", err)
+	}
+	if len(tasks) != 3 {
+		t.Errorf("expected 3 tasks, got %d", len(tasks))
+	}
+	if got := tasks["migrated"]; got == nil || got.Status != domain.StatusCompleted {
+		t.Errorf("expected migrated task with status completed, got %+v", got)
+	}
+
+	if err := ts.DeleteTask("migrated"); err != nil {
+		t.Fatalf("DeleteTask failed: %v", err)
+	}
+	if _, err := ts.LoadTask("migrated"); err == nil {
+		t.Errorf("expected deleted task to be gone")
+	}
+}
+
+// TestNewStorageStateDir tests that the file backend keeps its files in the configured state directory
+func TestNewStorageStateDir(t *testing.T) {
+	tests := []struct {
+		name        string
+		stateDir    string
+		expectedDir string
+	}{
+		{
+			name:        "configured directory",
+			stateDir:    "data/tasks",
+			expectedDir: "data/tasks",
+		},
+		{
+			name:        "default directory",
+			expectedDir: DefaultStateDir,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage, err := NewStorage(BackendFile, tt.stateDir, false, false)
+			if err != nil {
+				t.Fatalf("NewStorage failed: %v", err)
+			}
+			ts, ok := storage.(*TaskStorage)
+			if !ok {
+				t.Fatalf("expected file storage, got %T", storage)
+			}
+			if ts.stateDir != tt.expectedDir {
+				t.Errorf("expected state dir %s, got %s", tt.expectedDir, ts.stateDir)
+			}
+		})
+	}
+}
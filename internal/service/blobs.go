@@ -0,0 +1,257 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"filedownloader-20240926/internal/config"
+)
+
+const (
+	// LinkModeHardlink makes task files hardlinks to their blobs, falling back
+	// to symlinks where the filesystem does not support hardlinks
+	LinkModeHardlink = "hardlink"
+	// LinkModeSymlink makes task files symlinks to their blobs
+	LinkModeSymlink = "symlink"
+)
+
+// blobDirName is the directory of the blob store inside the downloads
+// directory unless one is configured
+const blobDirName = ".blobs"
+
+// blobStore keeps the content of downloaded files once per SHA-256 digest,
+// in <dir>/<first two hex digits>/<digest>. Task files refer to their blob
+// by a hardlink or symlink; where neither can be created, they are plain
+// copies, which still saves downloading known content again but not disk.
+type blobStore struct {
+	dir  string
+	mode string
+	// mu serializes adding blobs, so that two downloads of the same content
+	// finishing together agree on one blob
+	mu sync.Mutex
+	// hardlink and symlink create links; tests replace them to simulate
+	// filesystems without link support
+	hardlink func(oldname, newname string) error
+	symlink  func(oldname, newname string) error
+}
+
+func newBlobStore(dir, mode string) *blobStore {
+	if mode == "" {
+		mode = LinkModeHardlink
+	}
+	return &blobStore{dir: dir, mode: mode, hardlink: os.Link, symlink: os.Symlink}
+}
+
+// SetDedup enables or disables the content-addressable blob store
+func (d *Downloader) SetDedup(cfg config.DedupConfig) {
+	if !cfg.Enabled {
+		d.blobs = nil
+		return
+	}
+	dir := cfg.BlobDir
+	if dir == "" {
+		dir = filepath.Join(d.downloadsDir, blobDirName)
+	}
+	d.blobs = newBlobStore(dir, cfg.LinkMode)
+}
+
+// path returns where the blob of a digest is kept
+func (b *blobStore) path(sum string) string {
+	sum = strings.ToLower(sum)
+	return filepath.Join(b.dir, sum[:2], sum)
+}
+
+// has reports whether the store holds a blob for the digest
+func (b *blobStore) has(sum string) bool {
+	if !ValidSHA256(sum) {
+		return false
+	}
+	info, err := os.Stat(b.path(sum))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// add moves the downloaded file at path into the store under its digest and
+// puts a link to the blob in its place. When the store already holds the
+// content, the file is dropped in favour of a link to the existing blob, and
+// add reports the hit. A blob that no longer matches its digest is replaced.
+func (b *blobStore) add(path, sum string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	blob := b.path(sum)
+	if err := os.MkdirAll(filepath.Dir(blob), 0755); err != nil {
+		return false, fmt.Errorf("failed to create blob dir: %w", err)
+	}
+	if b.has(sum) {
+		if err := verifyFile(blob, ExpectedFile{SHA256: sum}); err == nil {
+			return true, b.link(blob, path)
+		}
+		// a damaged blob would hand wrong content to every later task
+		os.Remove(blob)
+	}
+
+	if err := moveFile(path, blob); err != nil {
+		return false, fmt.Errorf("failed to store blob: %w", err)
+	}
+	if err := b.link(blob, path); err != nil {
+		// put the download back so that the task keeps its file
+		if restoreErr := moveFile(blob, path); restoreErr != nil {
+			return false, errors.Join(err, restoreErr)
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// materialize makes the blob of a digest available in dir under name and
+// returns the name it got, which differs from name under the rename policy
+// when a file of that name exists
+func (b *blobStore) materialize(sum, dir, name string, replace bool) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if !replace {
+		// the placeholder reserves a unique name; the link replaces it
+		placeholder, unique, err := createUniqueFile(dir, name)
+		if err != nil {
+			return "", err
+		}
+		placeholder.Close()
+		name = unique
+	}
+	dst := filepath.Join(dir, name)
+	if err := b.link(b.path(sum), dst); err != nil {
+		if !replace {
+			os.Remove(dst)
+		}
+		return "", err
+	}
+	return name, nil
+}
+
+// link makes dst refer to blob, replacing whatever is at dst. It tries the
+// configured link type, then a symlink, then a copy, since the blob store
+// and the task directory may be on different filesystems or on one without
+// link support. The link is created next to dst and renamed over it, so dst
+// never goes missing in between.
+func (b *blobStore) link(blob, dst string) error {
+	tmp := dst + ".blob-tmp"
+	os.Remove(tmp)
+
+	var linkErr error
+	if b.mode == LinkModeHardlink {
+		if linkErr = b.hardlink(blob, tmp); linkErr == nil {
+			return renameLink(tmp, dst)
+		}
+	}
+	if abs, err := filepath.Abs(blob); err == nil {
+		// absolute, so that the link stays valid wherever the task dir is
+		err = b.symlink(abs, tmp)
+		if err == nil {
+			return renameLink(tmp, dst)
+		}
+		linkErr = errors.Join(linkErr, err)
+	}
+	if err := copyFile(blob, tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to link blob: %w", errors.Join(linkErr, err))
+	}
+	return renameLink(tmp, dst)
+}
+
+// renameLink moves a freshly created link into place
+func renameLink(tmp, dst string) error {
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to link blob: %w", err)
+	}
+	return nil
+}
+
+// moveFile renames src to dst, copying it when they are on different filesystems
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	tmp := dst + ".tmp"
+	if err := copyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyFile copies the content of src to a new file dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// linkBlob saves a download when the store already holds the content a file
+// is expected to have: the blob is linked into the task directory instead.
+// It reports the saved name and whether the blob was used.
+func (d *Downloader) linkBlob(log *slog.Logger, taskID, filename, sum string, opts DownloadOptions) (string, bool) {
+	if d.blobs == nil || !d.blobs.has(sum) {
+		return "", false
+	}
+	name, err := d.blobs.materialize(sum, d.TaskDir(taskID), filename, d.replacesExisting(opts))
+	if err != nil {
+		log.Warn("Failed to link stored content, downloading", "sha256", sum, "error", err)
+		return "", false
+	}
+	log.Info("Content already stored, skipping download", "sha256", strings.ToLower(sum), "filename", name)
+	return name, true
+}
+
+// dedup moves a completed download into the blob store and returns its
+// digest. sum is the digest when it is already known from verification.
+// Failures are logged and leave the file as it is, since it was downloaded
+// fine; only the digest is missing then.
+func (d *Downloader) dedup(log *slog.Logger, taskID, savedName, sum string) string {
+	if d.blobs == nil {
+		return ""
+	}
+	path := filepath.Join(d.TaskDir(taskID), savedName)
+	if info, err := os.Lstat(path); err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	if sum == "" {
+		var err error
+		if sum, err = hashFile(path); err != nil {
+			log.Warn("Failed to hash download for dedup", "path", path, "error", err)
+			return ""
+		}
+	}
+	sum = strings.ToLower(sum)
+	hit, err := d.blobs.add(path, sum)
+	if err != nil {
+		log.Warn("Failed to store download in the blob store", "path", path, "error", err)
+		return sum
+	}
+	if hit {
+		log.Info("Downloaded content already stored, linked to it", "sha256", sum, "filename", savedName)
+	}
+	return sum
+}
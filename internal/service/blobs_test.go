@@ -0,0 +1,187 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"filedownloader-20240926/internal/config"
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+)
+
+// TestBlobStoreLink tests how task files refer to blobs and the fallbacks where links are not supported
+func TestBlobStoreLink(t *testing.T) {
+	unsupported := func(oldname, newname string) error { return errors.New("operation not supported") }
+	tests := []struct {
+		name            string
+		mode            string
+		noHardlinks     bool
+		noSymlinks      bool
+		expectedSame    bool
+		expectedSymlink bool
+	}{
+		{
+			name:         "hardlink",
+			mode:         LinkModeHardlink,
+			expectedSame: true,
+		},
+		{
+			name:            "hardlink falls back to a symlink",
+			mode:            LinkModeHardlink,
+			noHardlinks:     true,
+			expectedSame:    true,
+			expectedSymlink: true,
+		},
+		{
+			name:        "hardlink falls back to a copy",
+			mode:        LinkModeHardlink,
+			noHardlinks: true,
+			noSymlinks:  true,
+		},
+		{
+			name:            "symlink",
+			mode:            LinkModeSymlink,
+			expectedSame:    true,
+			expectedSymlink: true,
+		},
+		{
+			name:       "symlink falls back to a copy",
+			mode:       LinkModeSymlink,
+			noSymlinks: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store := newBlobStore(filepath.Join(dir, blobDirName), tt.mode)
+			if tt.noHardlinks {
+				store.hardlink = unsupported
+			}
+			if tt.noSymlinks {
+				store.symlink = unsupported
+			}
+
+			const content = "blob content"
+			sum := sha256.Sum256([]byte(content))
+			digest := hex.EncodeToString(sum[:])
+			path := filepath.Join(dir, "file.txt")
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			hit, err := store.add(path, digest)
+			if err != nil || hit {
+				t.Fatalf("expected the first add to store the blob, got hit %v, error %v", hit, err)
+			}
+			other := filepath.Join(dir, "other.txt")
+			if err := os.WriteFile(other, []byte(content), 0644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+			if hit, err = store.add(other, digest); err != nil || !hit {
+				t.Fatalf("expected the second add to hit, got hit %v, error %v", hit, err)
+			}
+
+			blobInfo, err := os.Stat(store.path(digest))
+			if err != nil {
+				t.Fatalf("expected the blob to exist: %v", err)
+			}
+			for _, p := range []string{path, other} {
+				if got, err := os.ReadFile(p); err != nil || string(got) != content {
+					t.Errorf("expected %s to read %q, got %q (%v)", p, content, got, err)
+				}
+				info, err := os.Lstat(p)
+				if err != nil {
+					t.Fatalf("failed to stat %s: %v", p, err)
+				}
+				if symlink := info.Mode()&os.ModeSymlink != 0; symlink != tt.expectedSymlink {
+					t.Errorf("expected %s to be a symlink: %v, got %v", p, tt.expectedSymlink, symlink)
+				}
+				info, _ = os.Stat(p)
+				if same := os.SameFile(info, blobInfo); same != tt.expectedSame {
+					t.Errorf("expected %s to share the blob: %v, got %v", p, tt.expectedSame, same)
+				}
+			}
+		})
+	}
+}
+
+// TestWorkerPoolDedup tests that identical downloads share one blob and that stored content is not downloaded again
+func TestWorkerPoolDedup(t *testing.T) {
+	const content = "shared content"
+	sum := sha256.Sum256([]byte(content))
+	digest := hex.EncodeToString(sum[:])
+
+	var gets atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			gets.Add(1)
+		}
+		io.WriteString(w, content)
+	}))
+	defer srv.Close()
+
+	tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	wp := NewWorkerPool(1, tm)
+	wp.downloader.downloadsDir = t.TempDir()
+	wp.downloader.SetDedup(config.DedupConfig{Enabled: true})
+
+	download := func(name, sha string) (domain.File, os.FileInfo) {
+		task, err := tm.CreateTaskWithOptions([]string{srv.URL + "/" + name}, TaskOptions{
+			Expected: []ExpectedFile{{SHA256: sha}},
+		})
+		if err != nil {
+			t.Fatalf("failed to create task: %v", err)
+		}
+		wp.processTask(0, DownloadTask{TaskID: task.ID, FileIndex: 0})
+		task, _ = tm.GetTask(task.ID)
+		file := task.Files[0]
+		if file.Status != domain.StatusCompleted {
+			t.Fatalf("expected %s to complete, got %s (%s)", name, file.Status, file.Error)
+		}
+		path := filepath.Join(wp.downloader.TaskDir(task.ID), file.Filename)
+		if got, err := os.ReadFile(path); err != nil || string(got) != content {
+			t.Errorf("expected %s to read %q, got %q (%v)", name, content, got, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("failed to stat %s: %v", name, err)
+		}
+		return file, info
+	}
+
+	first, firstInfo := download("a.txt", "")
+	if first.SHA256 != digest {
+		t.Errorf("expected the digest %s to be recorded, got %q", digest, first.SHA256)
+	}
+	_, secondInfo := download("b.txt", "")
+	if n := gets.Load(); n != 2 {
+		t.Fatalf("expected both files without a checksum to be downloaded, got %d downloads", n)
+	}
+	blob, err := os.Stat(wp.downloader.blobs.path(digest))
+	if err != nil {
+		t.Fatalf("expected the blob to exist: %v", err)
+	}
+	if !os.SameFile(firstInfo, blob) || !os.SameFile(secondInfo, blob) {
+		t.Errorf("expected both files to be links to the blob")
+	}
+
+	third, thirdInfo := download("c.txt", digest)
+	if n := gets.Load(); n != 2 {
+		t.Errorf("expected stored content not to be downloaded again, got %d downloads", n)
+	}
+	if third.Size != int64(len(content)) || third.Filename != "c.txt" {
+		t.Errorf("expected c.txt of %d bytes, got %q of %d", len(content), third.Filename, third.Size)
+	}
+	if !os.SameFile(thirdInfo, blob) {
+		t.Errorf("expected c.txt to be linked to the blob")
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filedownloader-20240926/internal/domain"
+)
+
+// ErrChecksumMismatch is returned when a downloaded file does not match its expected size or SHA-256
+var ErrChecksumMismatch = errors.New("file does not match the expected checksum")
+
+// ErrorCodeChecksum is recorded for files that failed verification
+const ErrorCodeChecksum = "checksum"
+
+// ExpectedFile describes the content a file must have once downloaded;
+// zero values are not checked
+type ExpectedFile struct {
+	SHA256 string
+	Size   int64
+	// Pieces verifies the file piece by piece while it downloads
+	Pieces *domain.PieceHashes
+}
+
+// ValidSHA256 reports whether s is a hex-encoded SHA-256 digest
+func ValidSHA256(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// verifyFile checks the file at path against the expected size and digest
+func verifyFile(path string, expected ExpectedFile) error {
+	if expected.SHA256 == "" && expected.Size <= 0 {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for verification: %w", path, err)
+	}
+	defer f.Close()
+
+	if expected.Size > 0 {
+		info, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		if info.Size() != expected.Size {
+			return fmt.Errorf("%w: size %d, expected %d", ErrChecksumMismatch, info.Size(), expected.Size)
+		}
+	}
+	if expected.SHA256 == "" {
+		return nil
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, expected.SHA256) {
+		return fmt.Errorf("%w: sha256 %s, expected %s", ErrChecksumMismatch, sum, strings.ToLower(expected.SHA256))
+	}
+	return nil
+}
+
+// hashFile returns the hex-encoded SHA-256 digest of the file at path
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+)
+
+// TestWorkerPoolVerifiesChecksums tests that downloads are checked against their expected size and SHA-256
+func TestWorkerPoolVerifiesChecksums(t *testing.T) {
+	// sha256("hello")
+	const sum = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	tests := []struct {
+		name           string
+		expected       ExpectedFile
+		mirror         bool
+		expectedStatus domain.Status
+		expectedSource string
+	}{
+		{
+			name:           "matching checksum and size",
+			expected:       ExpectedFile{SHA256: strings.ToUpper(sum), Size: 5},
+			expectedStatus: domain.StatusCompleted,
+			expectedSource: "/good",
+		},
+		{
+			name:           "checksum mismatch",
+			expected:       ExpectedFile{SHA256: strings.Repeat("0", 64)},
+			expectedStatus: domain.StatusFailed,
+		},
+		{
+			name:           "size mismatch",
+			expected:       ExpectedFile{SHA256: sum, Size: 6},
+			expectedStatus: domain.StatusFailed,
+		},
+		{
+			name:           "corrupt primary falls back to mirror",
+			expected:       ExpectedFile{SHA256: sum},
+			mirror:         true,
+			expectedStatus: domain.StatusCompleted,
+			expectedSource: "/good",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/good" {
+					w.Write([]byte("hello"))
+					return
+				}
+				w.Write([]byte("HELLO"))
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(1, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			wp.Start()
+			defer wp.Stop()
+
+			primary, mirrors := srv.URL+"/good", [][]string(nil)
+			if tt.mirror || tt.expectedStatus == domain.StatusFailed {
+				primary = srv.URL + "/corrupt"
+			}
+			if tt.mirror {
+				mirrors = [][]string{{srv.URL + "/good"}}
+			}
+			task, err := tm.CreateTaskWithOptions([]string{primary}, TaskOptions{
+				Mirrors:  mirrors,
+				Expected: []ExpectedFile{tt.expected},
+			})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			wp.ProcessFiles(task.ID, task.Files)
+
+			var file domain.File
+			deadline := time.Now().Add(3 * time.Second)
+			for time.Now().Before(deadline) {
+				current, _ := tm.GetTask(task.ID)
+				if file = current.Files[0]; fileTerminal(file.Status) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			if file.Status != tt.expectedStatus {
+				t.Fatalf("expected status %s, got %s (%s)", tt.expectedStatus, file.Status, file.Error)
+			}
+			if tt.expectedStatus == domain.StatusFailed {
+				if file.ErrorCode != ErrorCodeChecksum {
+					t.Errorf("expected error code %s, got %s", ErrorCodeChecksum, file.ErrorCode)
+				}
+				if entries, _ := os.ReadDir(wp.downloader.TaskDir(task.ID)); len(entries) != 0 {
+					t.Errorf("expected the mismatched file to be removed, got %d files", len(entries))
+				}
+				return
+			}
+			if file.SourceURL != srv.URL+tt.expectedSource {
+				t.Errorf("expected source %s, got %s", srv.URL+tt.expectedSource, file.SourceURL)
+			}
+			if _, err := os.Stat(filepath.Join(wp.downloader.TaskDir(task.ID), file.Filename)); err != nil {
+				t.Errorf("expected the verified file on disk: %v", err)
+			}
+		})
+	}
+}
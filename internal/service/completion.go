@@ -0,0 +1,65 @@
+package service
+
+import (
+	"fmt"
+
+	"filedownloader-20240926/internal/domain"
+)
+
+const (
+	// CompletionModeBestEffort downloads every file of a task regardless of
+	// failures; the task fails at the end when any file did
+	CompletionModeBestEffort = "best_effort"
+	// CompletionModeFailFast cancels the remaining files of a task and fails
+	// it as soon as one of its files fails for good
+	CompletionModeFailFast = "fail_fast"
+)
+
+// ErrorCodeFailFast is recorded on the files a fail-fast task cancelled
+// because another of its files failed
+const ErrorCodeFailFast = "fail_fast"
+
+// ValidCompletionMode reports whether mode is one of the completion modes
+func ValidCompletionMode(mode string) bool {
+	return mode == CompletionModeBestEffort || mode == CompletionModeFailFast
+}
+
+// failFast cancels the unfinished files of a fail-fast task once one of its
+// files has failed, interrupting those a worker is downloading. A retrying
+// file has not failed yet. It returns the index of the failed file and the
+// cancelled files, or -1 when nothing was cancelled. The caller must hold the
+// manager lock, like for cancelFile.
+func (wp *WorkerPool) failFast(task *domain.Task) (int, []int) {
+	if task.CompletionMode != CompletionModeFailFast {
+		return -1, nil
+	}
+	failed := -1
+	for i := range task.Files {
+		if task.Files[i].Status == domain.StatusFailed {
+			failed = i
+			break
+		}
+	}
+	if failed < 0 {
+		return -1, nil
+	}
+
+	var cancelled []int
+	for i := range task.Files {
+		if fileTerminal(task.Files[i].Status) {
+			continue
+		}
+		file := &task.Files[i]
+		file.Status = domain.StatusCancelled
+		file.Speed = 0
+		file.PartETag = ""
+		file.ErrorCode = ErrorCodeFailFast
+		file.Error = fmt.Sprintf("cancelled after file %d failed", failed)
+		wp.interruptFile(fileRef{taskID: task.ID, index: i})
+		cancelled = append(cancelled, i)
+	}
+	if len(cancelled) == 0 {
+		return -1, nil
+	}
+	return failed, cancelled
+}
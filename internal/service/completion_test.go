@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+)
+
+// TestWorkerPoolCompletionMode tests that fail-fast tasks cancel their remaining files on the first failure and best-effort tasks do not
+func TestWorkerPoolCompletionMode(t *testing.T) {
+	tests := []struct {
+		name              string
+		mode              string
+		workers           int
+		paths             []string
+		expected          []domain.Status
+		expectedRequested []string
+		expectedRetried   []domain.Status
+	}{
+		{
+			name:              "best effort downloads the good files",
+			mode:              CompletionModeBestEffort,
+			workers:           1,
+			paths:             []string{"/missing", "/a.txt", "/b.txt"},
+			expected:          []domain.Status{domain.StatusFailed, domain.StatusCompleted, domain.StatusCompleted},
+			expectedRequested: []string{"/missing", "/a.txt", "/b.txt"},
+			expectedRetried:   []domain.Status{domain.StatusPending, domain.StatusCompleted, domain.StatusCompleted},
+		},
+		{
+			name:              "default is best effort",
+			workers:           1,
+			paths:             []string{"/a.txt", "/missing", "/b.txt"},
+			expected:          []domain.Status{domain.StatusCompleted, domain.StatusFailed, domain.StatusCompleted},
+			expectedRequested: []string{"/a.txt", "/missing", "/b.txt"},
+			expectedRetried:   []domain.Status{domain.StatusCompleted, domain.StatusPending, domain.StatusCompleted},
+		},
+		{
+			name:              "fail fast cancels the pending files",
+			mode:              CompletionModeFailFast,
+			workers:           1,
+			paths:             []string{"/a.txt", "/missing", "/b.txt", "/c.txt"},
+			expected:          []domain.Status{domain.StatusCompleted, domain.StatusFailed, domain.StatusCancelled, domain.StatusCancelled},
+			expectedRequested: []string{"/a.txt", "/missing"},
+			expectedRetried:   []domain.Status{domain.StatusCompleted, domain.StatusPending, domain.StatusPending, domain.StatusPending},
+		},
+		{
+			name:            "fail fast interrupts downloads in flight",
+			mode:            CompletionModeFailFast,
+			workers:         2,
+			paths:           []string{"/endless.bin", "/missing", "/a.txt"},
+			expected:        []domain.Status{domain.StatusCancelled, domain.StatusFailed, domain.StatusCancelled},
+			expectedRetried: []domain.Status{domain.StatusPending, domain.StatusPending, domain.StatusPending},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu        sync.Mutex
+				requested []string
+			)
+			streaming := make(chan struct{}, 1)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/missing" {
+					if r.Method == http.MethodGet && r.Header.Get("Range") == "" {
+						// fails only once the endless download is under way
+						select {
+						case <-streaming:
+						case <-time.After(time.Second):
+						}
+						mu.Lock()
+						requested = append(requested, r.URL.Path)
+						mu.Unlock()
+					}
+					http.NotFound(w, r)
+					return
+				}
+				if r.Method == http.MethodHead {
+					return
+				}
+				mu.Lock()
+				requested = append(requested, r.URL.Path)
+				mu.Unlock()
+				if r.URL.Path != "/endless.bin" {
+					io.WriteString(w, "content")
+					return
+				}
+				streaming <- struct{}{}
+				for {
+					if _, err := io.WriteString(w, "chunk"); err != nil {
+						return
+					}
+					w.(http.Flusher).Flush()
+					select {
+					case <-time.After(10 * time.Millisecond):
+					case <-r.Context().Done():
+						return
+					}
+				}
+			}))
+			defer srv.Close()
+			if tt.workers == 1 {
+				// no endless download to wait for
+				close(streaming)
+			}
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(tt.workers, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			wp.Start()
+			defer wp.Stop()
+
+			urls := make([]string, len(tt.paths))
+			for i, path := range tt.paths {
+				urls[i] = srv.URL + path
+			}
+			task, err := tm.CreateTaskWithOptions(urls, TaskOptions{CompletionMode: tt.mode})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			wp.ProcessFiles(task.ID, task.Files)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			task, err = wp.WaitForTask(ctx, task.ID)
+			if err != nil {
+				t.Fatalf("task did not finish: %v", err)
+			}
+			if task.Status != domain.StatusFailed {
+				t.Errorf("expected the task to fail, got %s", task.Status)
+			}
+			for i, status := range tt.expected {
+				if task.Files[i].Status != status {
+					t.Errorf("expected file %d to be %s, got %s (%s)", i, status, task.Files[i].Status, task.Files[i].Error)
+				}
+				if status == domain.StatusCancelled && task.Files[i].ErrorCode != ErrorCodeFailFast {
+					t.Errorf("expected file %d to record %q, got %q", i, ErrorCodeFailFast, task.Files[i].ErrorCode)
+				}
+			}
+			if tt.expectedRequested != nil {
+				mu.Lock()
+				got := append([]string(nil), requested...)
+				mu.Unlock()
+				if len(got) != len(tt.expectedRequested) {
+					t.Fatalf("expected downloads of %v, got %v", tt.expectedRequested, got)
+				}
+				for i := range got {
+					if got[i] != tt.expectedRequested[i] {
+						t.Errorf("expected download %d of %s, got %s", i, tt.expectedRequested[i], got[i])
+					}
+				}
+			}
+
+			wp.Stop()
+			task, err = tm.RetryFailedFiles(task.ID)
+			if err != nil {
+				t.Fatalf("failed to retry: %v", err)
+			}
+			for i, status := range tt.expectedRetried {
+				if task.Files[i].Status != status {
+					t.Errorf("expected file %d to be %s after a retry, got %s", i, status, task.Files[i].Status)
+				}
+			}
+		})
+	}
+}
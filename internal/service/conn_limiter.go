@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// connLimiter bounds the number of outbound connections of the whole process.
+// Unlike a buffered channel, its limit can change while slots are held:
+// lowering it lets the holders finish and admits no one until the count drops
+// below the new limit.
+type connLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	active int
+	// wake is closed and replaced whenever a slot frees up or the limit changes
+	wake chan struct{}
+}
+
+// newConnLimiter returns a limiter allowing limit connections; 0 means unlimited
+func newConnLimiter(limit int) *connLimiter {
+	return &connLimiter{limit: limit, wake: make(chan struct{})}
+}
+
+// SetLimit changes the number of connections; 0 means unlimited
+func (l *connLimiter) SetLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.broadcast()
+}
+
+// Acquire blocks until a connection slot is free or ctx is done.
+// Every successful Acquire must be paired with a Release.
+func (l *connLimiter) Acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.limit <= 0 || l.active < l.limit {
+			l.active++
+			l.mu.Unlock()
+			return nil
+		}
+		wake := l.wake
+		l.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
+
+// Release frees a slot taken by Acquire
+func (l *connLimiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.broadcast()
+}
+
+// Active returns the number of connections currently held
+func (l *connLimiter) Active() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active
+}
+
+// broadcast wakes every waiter to check for a free slot again; l.mu is held
+func (l *connLimiter) broadcast() {
+	close(l.wake)
+	l.wake = make(chan struct{})
+}
+
+// SetMaxConnections caps the concurrent requests of all downloads, size
+// probes, streams and manifest fetches of the downloader together; 0 means
+// unlimited. A download holds its slot from its first request until its body
+// is written, so the requests of one download share a single slot; a stream
+// holds its slot until its body is closed. Waiting requests start in no
+// particular order once a slot frees up.
+func (d *Downloader) SetMaxConnections(limit int) {
+	d.conns.SetLimit(limit)
+}
+
+// ActiveConnections returns the number of requests currently holding a connection slot
+func (d *Downloader) ActiveConnections() int {
+	return d.conns.Active()
+}
+
+// acquireConn waits for a connection slot for a request to url and returns
+// the function that frees it
+func (d *Downloader) acquireConn(ctx context.Context, url string) (func(), error) {
+	if err := d.conns.Acquire(ctx); err != nil {
+		return nil, fmt.Errorf("failed to wait for a connection to %s: %w", url, err)
+	}
+	var once sync.Once
+	return func() { once.Do(d.conns.Release) }, nil
+}
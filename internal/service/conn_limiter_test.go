@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+)
+
+// TestConnLimiter tests waiting for connection slots and changing the limit while they are held
+func TestConnLimiter(t *testing.T) {
+	l := newConnLimiter(2)
+	for i := 0; i < 2; i++ {
+		if err := l.Acquire(context.Background()); err != nil {
+			t.Fatalf("failed to acquire slot %d: %v", i, err)
+		}
+	}
+
+	acquired := make(chan error, 1)
+	go func() { acquired <- l.Acquire(context.Background()) }()
+	select {
+	case <-acquired:
+		t.Fatal("expected the third acquire to wait for a free slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+	l.Release()
+	if err := <-acquired; err != nil {
+		t.Fatalf("expected the waiter to get the freed slot, got %v", err)
+	}
+
+	go func() { acquired <- l.Acquire(context.Background()) }()
+	l.SetLimit(3)
+	if err := <-acquired; err != nil {
+		t.Fatalf("expected a raised limit to admit the waiter, got %v", err)
+	}
+	if active := l.Active(); active != 3 {
+		t.Errorf("expected 3 active connections, got %d", active)
+	}
+
+	l.SetLimit(1)
+	l.Release()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(ErrFileCancelled)
+	if err := l.Acquire(ctx); !errors.Is(err, ErrFileCancelled) {
+		t.Errorf("expected a lowered limit to keep the waiter out until ctx is done, got %v", err)
+	}
+
+	l.SetLimit(0)
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Errorf("expected no limit to admit everyone, got %v", err)
+	}
+}
+
+// TestWorkerPoolMaxConnections tests that the connection cap bounds the requests of all workers together
+func TestWorkerPoolMaxConnections(t *testing.T) {
+	tests := []struct {
+		name        string
+		workers     int
+		maxConns    int
+		resize      int
+		expectedMax int
+	}{
+		{name: "one per worker by default", workers: 3, expectedMax: 3},
+		{name: "fewer connections than workers", workers: 4, maxConns: 2, expectedMax: 2},
+		{name: "default follows resize", workers: 2, resize: 4, expectedMax: 4},
+		{name: "explicit cap ignores resize", workers: 2, maxConns: 1, resize: 4, expectedMax: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inFlight, peak atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				for {
+					old := peak.Load()
+					if n <= old || peak.CompareAndSwap(old, n) {
+						break
+					}
+				}
+				time.Sleep(20 * time.Millisecond)
+				w.Write([]byte("content"))
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(tt.workers, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			wp.SetMaxConnections(tt.maxConns)
+			if tt.resize > 0 {
+				if err := wp.Resize(tt.resize); err != nil {
+					t.Fatalf("failed to resize: %v", err)
+				}
+			}
+			if limit := wp.downloader.conns.limit; limit != tt.expectedMax {
+				t.Errorf("expected a cap of %d connections, got %d", tt.expectedMax, limit)
+			}
+			wp.Start()
+			defer wp.Stop()
+
+			urls := make([]string, 8)
+			for i := range urls {
+				urls[i] = fmt.Sprintf("%s/file%d.txt", srv.URL, i)
+			}
+			task, err := tm.CreateTask(urls)
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			wp.ProcessFiles(task.ID, task.Files)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			task, err = wp.WaitForTask(ctx, task.ID)
+			if err != nil {
+				t.Fatalf("task did not finish: %v", err)
+			}
+			if task.Status != domain.StatusCompleted {
+				t.Errorf("expected the task to complete, got %s", task.Status)
+			}
+			if got := int(peak.Load()); got > tt.expectedMax {
+				t.Errorf("expected at most %d concurrent requests, got %d", tt.expectedMax, got)
+			}
+			if active := wp.downloader.ActiveConnections(); active != 0 {
+				t.Errorf("expected every connection slot to be released, got %d held", active)
+			}
+		})
+	}
+}
+
+// TestDownloaderOpenHoldsConnection tests that a stream holds its connection slot until its body is closed
+func TestDownloaderOpenHoldsConnection(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{name: "streamed body", status: http.StatusOK},
+		{name: "rejected response", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte("content"))
+			}))
+			defer srv.Close()
+
+			d := NewDownloader()
+			d.SetMaxConnections(1)
+			resp, err := d.Open(context.Background(), srv.URL+"/file.txt", DownloadOptions{})
+			if tt.status != http.StatusOK {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("expected the response to be rejected")
+				}
+				if active := d.ActiveConnections(); active != 0 {
+					t.Errorf("expected a rejected stream to free its slot, got %d held", active)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to open stream: %v", err)
+			}
+			if active := d.ActiveConnections(); active != 1 {
+				t.Errorf("expected an open stream to hold a slot, got %d held", active)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			if _, err := d.Open(ctx, srv.URL+"/other.txt", DownloadOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("expected a second stream to wait for the slot, got %v", err)
+			}
+
+			resp.Body.Close()
+			resp.Body.Close()
+			if active := d.ActiveConnections(); active != 0 {
+				t.Errorf("expected a closed stream to free its slot, got %d held", active)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	"filedownloader-20240926/internal/domain"
+)
+
+// splitCredentials returns rawURL without its userinfo together with the
+// userinfo it carried. URLs without credentials, or that do not parse, are
+// returned unchanged with nil.
+func splitCredentials(rawURL string) (string, *neturl.Userinfo) {
+	u, err := neturl.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL, nil
+	}
+	user := u.User
+	u.User = nil
+	return u.String(), user
+}
+
+// parseCredentials turns credentials stored as "user:password" back into userinfo
+func parseCredentials(s string) *neturl.Userinfo {
+	if s == "" {
+		return nil
+	}
+	_, user := splitCredentials("//" + s + "@localhost")
+	return user
+}
+
+// splitMirrorCredentials moves credentials embedded in the mirrors of file
+// into MirrorCredentials, aligned with the mirrors, so that the stored and
+// logged mirror URLs carry no password either
+func splitMirrorCredentials(file *domain.File) {
+	var creds []string
+	for i, mirror := range file.Mirrors {
+		stripped, user := splitCredentials(mirror)
+		if user == nil {
+			continue
+		}
+		if creds == nil {
+			creds = make([]string, len(file.Mirrors))
+			copy(creds, file.MirrorCredentials)
+			file.Mirrors = append([]string(nil), file.Mirrors...)
+		}
+		file.Mirrors[i] = stripped
+		creds[i] = user.String()
+	}
+	if creds != nil {
+		file.MirrorCredentials = creds
+	}
+}
+
+// sourceCredentials returns the credentials to send when downloading file
+// from source: the primary URL and each mirror have their own, split off
+// their URLs at creation
+func sourceCredentials(file domain.File, source string) *neturl.Userinfo {
+	if source == file.URL {
+		return parseCredentials(file.Credentials)
+	}
+	for i, mirror := range file.Mirrors {
+		if mirror == source && i < len(file.MirrorCredentials) {
+			return parseCredentials(file.MirrorCredentials[i])
+		}
+	}
+	return nil
+}
+
+// redactURL returns rawURL with any password replaced, for logging
+func redactURL(rawURL string) string {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
+
+// withCredentials moves credentials embedded in url into opts, so that they
+// are sent as basic auth and the returned URL, used in errors and logs,
+// carries no password. Credentials already in opts win.
+func withCredentials(url string, opts DownloadOptions) (string, DownloadOptions) {
+	stripped, user := splitCredentials(url)
+	if opts.Credentials == nil {
+		opts.Credentials = user
+	}
+	return stripped, opts
+}
+
+// setCredentials sends the credentials of opts with req as basic auth
+func setCredentials(req *http.Request, opts DownloadOptions) {
+	if opts.Credentials == nil {
+		return
+	}
+	password, _ := opts.Credentials.Password()
+	req.SetBasicAuth(opts.Credentials.Username(), password)
+}
+
+// sameHost reports whether both URLs point at the same host and port
+func sameHost(a, b string) bool {
+	ua, err := neturl.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := neturl.Parse(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(ua.Host, ub.Host)
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+	"filedownloader-20240926/pkg/logger"
+)
+
+// basicAuthServer serves a file to requests authenticated as alice:s3cret
+func basicAuthServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "alice" || password != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, "private")
+	}))
+}
+
+// TestDownloaderBasicAuth tests that credentials embedded in the URL are sent as basic auth and kept out of errors
+func TestDownloaderBasicAuth(t *testing.T) {
+	tests := []struct {
+		name        string
+		userinfo    string
+		expectError bool
+	}{
+		{
+			name:     "valid credentials",
+			userinfo: "alice:s3cret@",
+		},
+		{
+			name:        "wrong password",
+			userinfo:    "alice:wrong-pass@",
+			expectError: true,
+		},
+		{
+			name:        "no credentials",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := basicAuthServer()
+			defer srv.Close()
+			url := strings.Replace(srv.URL, "://", "://"+tt.userinfo, 1) + "/private.txt"
+
+			d := NewDownloader()
+			d.downloadsDir = t.TempDir()
+
+			size, sizeErr := d.GetFileSize(context.Background(), url)
+			_, err := d.DownloadFile(context.Background(), url, "private.txt")
+
+			if tt.expectError {
+				if err == nil {
+					t.Fatalf("expected error but got none")
+				}
+				if strings.Contains(err.Error(), "wrong-pass") {
+					t.Errorf("expected the password to be kept out of the error, got %v", err)
+				}
+				return
+			}
+			if sizeErr != nil || size != int64(len("private")) {
+				t.Errorf("expected size %d, got %d (%v)", len("private"), size, sizeErr)
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// TestWorkerPoolBasicAuth tests that a task stores the URL without its credentials and still downloads with them
+func TestWorkerPoolBasicAuth(t *testing.T) {
+	srv := basicAuthServer()
+	defer srv.Close()
+	url := strings.Replace(srv.URL, "://", "://alice:s3cret@", 1) + "/private.txt"
+
+	tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	wp := NewWorkerPool(1, tm)
+	wp.downloader.downloadsDir = t.TempDir()
+
+	task, err := tm.CreateTask([]string{url})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	if task.Files[0].URL != srv.URL+"/private.txt" || task.URLs[0] != srv.URL+"/private.txt" {
+		t.Errorf("expected the URL without credentials, got %s", task.Files[0].URL)
+	}
+	wp.processTask(0, DownloadTask{TaskID: task.ID, FileIndex: 0})
+
+	task, _ = tm.GetTask(task.ID)
+	if file := task.Files[0]; file.Status != domain.StatusCompleted {
+		t.Fatalf("expected status %s, got %s (%s)", domain.StatusCompleted, file.Status, file.Error)
+	}
+	if status := domain.NewFileStatuses(task.Files)[0]; status.Credentials != "" {
+		t.Errorf("expected the status to hide the credentials")
+	}
+}
+
+// TestWorkerPoolMirrorCredentials tests that credentials embedded in a mirror are split off, sent to it and kept out of logs
+func TestWorkerPoolMirrorCredentials(t *testing.T) {
+	primary := httptest.NewServer(http.NotFoundHandler())
+	defer primary.Close()
+	srv := basicAuthServer()
+	defer srv.Close()
+	mirror := strings.Replace(srv.URL, "://", "://alice:s3cret@", 1) + "/private.txt"
+
+	var buf bytes.Buffer
+	original := logger.Logger
+	logger.Logger = logger.NewJSONLogger(&buf, slog.LevelDebug)
+	defer func() { logger.Logger = original }()
+
+	tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	wp := NewWorkerPool(1, tm)
+	wp.downloader.downloadsDir = t.TempDir()
+
+	task, err := tm.CreateTaskWithOptions([]string{primary.URL + "/private.txt"}, TaskOptions{
+		Mirrors: [][]string{{mirror}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	if file := task.Files[0]; file.Mirrors[0] != srv.URL+"/private.txt" || len(file.MirrorCredentials) != 1 {
+		t.Errorf("expected the mirror without credentials, got %s", file.Mirrors[0])
+	}
+	wp.processTask(0, DownloadTask{TaskID: task.ID, FileIndex: 0})
+
+	task, _ = tm.GetTask(task.ID)
+	if file := task.Files[0]; file.Status != domain.StatusCompleted {
+		t.Fatalf("expected status %s, got %s (%s)", domain.StatusCompleted, file.Status, file.Error)
+	}
+	if status := domain.NewFileStatuses(task.Files)[0]; status.MirrorCredentials != nil {
+		t.Errorf("expected the status to hide the mirror credentials")
+	}
+	if strings.Contains(buf.String(), "s3cret") {
+		t.Errorf("expected the logs to hide the mirror credentials, got %s", buf.String())
+	}
+}
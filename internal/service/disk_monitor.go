@@ -0,0 +1,89 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"filedownloader-20240926/pkg/logger"
+)
+
+// DiskMonitor pauses the worker pool while free space on the downloads
+// volume is below a threshold and resumes it once the space is back
+type DiskMonitor struct {
+	wp        *WorkerPool
+	freeSpace func() (int64, error)
+	minFree   int64
+	interval  time.Duration
+	low       bool
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// NewDiskMonitor creates a monitor that checks the downloads volume of
+// downloader every interval and pauses wp below minFree bytes
+func NewDiskMonitor(wp *WorkerPool, downloader *Downloader, minFree int64, interval time.Duration) *DiskMonitor {
+	return &DiskMonitor{
+		wp:        wp,
+		freeSpace: downloader.FreeSpace,
+		minFree:   minFree,
+		interval:  interval,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start checks the free space right away and then in the background.
+// A zero threshold or interval disables the monitor.
+func (m *DiskMonitor) Start() {
+	if m.minFree <= 0 || m.interval <= 0 {
+		close(m.done)
+		return
+	}
+
+	logger.Logger.Info("Starting disk space monitor", "min_free_bytes", m.minFree, "interval", m.interval)
+	m.Check()
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.Check()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the monitor; a pause it caused stays in effect
+func (m *DiskMonitor) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
+	<-m.done
+}
+
+// Check measures the free space and pauses or resumes the pool on a
+// transition. When the space cannot be measured the pool is left as it is.
+func (m *DiskMonitor) Check() {
+	free, err := m.freeSpace()
+	if err != nil {
+		logger.Logger.Warn("Failed to check free disk space", "error", err)
+		return
+	}
+
+	low := free < m.minFree
+	if low == m.low {
+		return
+	}
+	m.low = low
+	if low {
+		logger.Logger.Warn("Free disk space below threshold, pausing worker pool",
+			"free_bytes", free, "min_free_bytes", m.minFree)
+	} else {
+		logger.Logger.Info("Free disk space recovered, resuming worker pool",
+			"free_bytes", free, "min_free_bytes", m.minFree)
+	}
+	m.wp.SetPaused(low)
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestDiskMonitorCheck tests that the pool is paused below the threshold and resumed above it
+func TestDiskMonitorCheck(t *testing.T) {
+	tests := []struct {
+		name           string
+		readings       []int64
+		failAt         int
+		expectedPaused []bool
+	}{
+		{
+			name:           "pauses and resumes",
+			readings:       []int64{200, 50, 80, 150},
+			failAt:         -1,
+			expectedPaused: []bool{false, true, true, false},
+		},
+		{
+			name:           "measurement error keeps state",
+			readings:       []int64{50, 0, 150},
+			failAt:         1,
+			expectedPaused: []bool{true, true, false},
+		},
+		{
+			name:           "threshold is inclusive",
+			readings:       []int64{100},
+			failAt:         -1,
+			expectedPaused: []bool{false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wp := NewWorkerPool(1, nil)
+			defer wp.Stop()
+
+			m := NewDiskMonitor(wp, NewDownloader(), 100, 0)
+			step := 0
+			m.freeSpace = func() (int64, error) {
+				if step == tt.failAt {
+					return 0, errors.New("statfs failed")
+				}
+				return tt.readings[step], nil
+			}
+
+			for i := range tt.readings {
+				step = i
+				m.Check()
+				if wp.Paused() != tt.expectedPaused[i] {
+					t.Errorf("check %d: expected paused=%v, got %v", i, tt.expectedPaused[i], wp.Paused())
+				}
+			}
+		})
+	}
+}
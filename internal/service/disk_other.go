@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package service
+
+import "errors"
+
+// freeDiskSpace is not supported on this platform, so the preflight check is skipped
+func freeDiskSpace(path string) (int64, error) {
+	return 0, errors.New("disk space check not supported")
+}
@@ -1,98 +1,621 @@
 package service
 
 import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"mime"
+	"net"
 	"net/http"
 	neturl "net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
+
+	"filedownloader-20240926/internal/config"
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/version"
+	"filedownloader-20240926/pkg/logger"
+)
+
+var (
+	// ErrContentTypeNotAllowed is returned when the response Content-Type is rejected by the filter
+	ErrContentTypeNotAllowed = errors.New("content type not allowed")
+	// ErrInsufficientDiskSpace is returned when the downloads volume cannot fit the file
+	ErrInsufficientDiskSpace = errors.New("insufficient disk space")
+	// ErrFileTooLarge is returned when the file exceeds the configured size limit
+	ErrFileTooLarge = errors.New("file size exceeds limit")
+	// ErrDownloadStalled is returned when no bytes arrive within the stall timeout
+	ErrDownloadStalled = errors.New("download stalled")
+	// ErrBadContentEncoding is returned when the body does not match the declared Content-Encoding
+	ErrBadContentEncoding = errors.New("content does not match declared encoding")
+	// ErrIncompleteBody is returned when fewer bytes arrive than the declared Content-Length
+	ErrIncompleteBody = errors.New("response body shorter than Content-Length")
+	// ErrNotModified is returned when a conditional request is answered with 304 Not Modified
+	ErrNotModified = errors.New("not modified")
 )
 
+// DownloadOptions holds per-task download settings
+type DownloadOptions struct {
+	// Decompress decodes gzip/deflate Content-Encoding before writing to disk
+	Decompress bool
+	// Transform names the DownloadTransform the body goes through before it
+	// is written to disk; empty or TransformNone writes it unchanged
+	Transform string
+	// TaskID places the file in the task's subdirectory under the per-task layout
+	TaskID string
+	// UserAgent overrides the downloader's User-Agent for this task
+	UserAgent string
+	// ETag and LastModified are the validators of a local copy of the file;
+	// when set, the request is conditional and a 304 yields ErrNotModified
+	ETag         string
+	LastModified string
+	// PartFile is the path the download is written to before it is renamed
+	// to its final name; when empty the final file is written directly
+	PartFile string
+	// Offset is the number of bytes already in PartFile; when positive the
+	// download continues from there with a range request
+	Offset int64
+	// PartETag is the ETag of the response PartFile was started from; a
+	// resumed response with a different ETag is not appended to it
+	PartETag string
+	// PartStarted, when set, is called with the ETag of the response once
+	// a download into PartFile starts from the beginning
+	PartStarted func(etag string)
+	// Progress, when set, is called periodically while the body is read
+	Progress ProgressFunc
+	// FollowNext follows rel="next" Link headers and joins the pages into one file
+	FollowNext bool
+	// Pieces, when set, verifies the file piece by piece while it downloads
+	// and fetches pieces that do not match again
+	Pieces *domain.PieceHashes
+	// Credentials are sent as basic auth; credentials embedded in the URL
+	// are moved here so that they never show up in errors or logs
+	Credentials *neturl.Userinfo
+	// OverwritePolicy decides what happens to an existing file of the same
+	// name; empty uses the downloader default
+	OverwritePolicy string
+	// Method is the method of download requests; empty means GET
+	Method string
+	// ProbeMethod is the method of size probes; empty means HEAD
+	ProbeMethod string
+	// Body is sent with the POST requests of the download. It may hold
+	// secrets, so it is never logged.
+	Body string
+	// Headers are added to every request of the download; the headers the
+	// downloader manages itself, such as Range, cannot be set
+	Headers map[string]string
+	// Logger receives the log lines of the download, e.g. one carrying the
+	// task and worker IDs; nil uses the global logger
+	Logger *slog.Logger
+}
+
+// log returns the logger of a download
+func (o DownloadOptions) log() *slog.Logger {
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return logger.Logger
+}
+
+// downloadResult describes a completed download
+type downloadResult struct {
+	Filename     string
+	ETag         string
+	LastModified string
+	// FinalURL is the URL that served the bytes after redirects, with any password redacted
+	FinalURL    string
+	ContentType string
+	// SHA256 is set when the file was linked to stored content instead of
+	// being downloaded, and is the digest of that content
+	SHA256 string
+	// Bytes counts the response bytes received by the download, not those
+	// resumed from a part file, and Duration is how long its requests took
+	Bytes    int64
+	Duration time.Duration
+	// StatusCode is the status of the last response, e.g. 206 for a resumed download
+	StatusCode int
+}
+
+const (
+	// LayoutPerTask stores files under downloads/<task_id>/
+	LayoutPerTask = "per_task"
+	// LayoutFlat stores the files of all tasks directly in downloads/
+	LayoutFlat = "flat"
+)
+
+// HTTPStatusError is returned when the server responds with an unexpected status code
+type HTTPStatusError struct {
+	StatusCode int
+	URL        string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("bad status code %d for %s", e.StatusCode, e.URL)
+}
+
+// Error codes recorded on failed files
+const (
+	ErrorCodeTimeout     = "timeout"
+	ErrorCodeStalled     = "stalled"
+	ErrorCodeSizeLimit   = "size_limit"
+	ErrorCodeContentType = "content_type"
+	ErrorCodeDiskSpace   = "disk_space"
+	ErrorCodeEncoding    = "bad_encoding"
+	ErrorCodeNetwork     = "network"
+	ErrorCodeBlocked     = "blocked_address"
+	ErrorCodeIncomplete  = "incomplete"
+	ErrorCodePanic       = "panic"
+	ErrorCodeUnknown     = "unknown"
+)
+
+// errorCode classifies a download error into a stable code for API clients
+func errorCode(err error) string {
+	var statusErr *HTTPStatusError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &statusErr):
+		return fmt.Sprintf("http_%d", statusErr.StatusCode)
+	case errors.Is(err, ErrDownloadStalled):
+		return ErrorCodeStalled
+	case errors.Is(err, ErrRequestTimeout), errors.Is(err, ErrTransferTimeout):
+		return ErrorCodeTimeout
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorCodeTimeout
+	case errors.Is(err, ErrFileTooLarge):
+		return ErrorCodeSizeLimit
+	case errors.Is(err, ErrContentTypeNotAllowed):
+		return ErrorCodeContentType
+	case errors.Is(err, ErrInsufficientDiskSpace):
+		return ErrorCodeDiskSpace
+	case errors.Is(err, ErrBadContentEncoding):
+		return ErrorCodeEncoding
+	case errors.Is(err, ErrTransformFailed):
+		return ErrorCodeTransform
+	case errors.Is(err, ErrBlockedAddress):
+		return ErrorCodeBlocked
+	case errors.Is(err, ErrIncompleteBody):
+		return ErrorCodeIncomplete
+	case errors.Is(err, ErrChecksumMismatch):
+		return ErrorCodeChecksum
+	case errors.Is(err, ErrResumeNotHonored):
+		return ErrorCodeResume
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorCodeTimeout
+	case errors.As(err, &netErr):
+		return ErrorCodeNetwork
+	}
+	return ErrorCodeUnknown
+}
+
+// retryable reports whether a download error may go away on another attempt:
+// timeouts, stalls, network errors, truncated bodies, partials the server would
+// not continue and 5xx or 429 responses.
+// Errors about the file itself, like its size or type, are permanent.
+func retryable(err error) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	switch errorCode(err) {
+	case ErrorCodeTimeout, ErrorCodeStalled, ErrorCodeNetwork, ErrorCodeIncomplete, ErrorCodeResume:
+		return true
+	}
+	return false
+}
+
 type Downloader struct {
-	downloadsDir string
-	timeout      time.Duration
-	maxFileSize  int64
-	userAgent    string
+	downloadsDir        string
+	requestTimeout      time.Duration
+	transferTimeout     time.Duration
+	minTransferRate     int64
+	maxFileSize         int64
+	userAgent           string
+	allowedContentTypes []string
+	blockedContentTypes []string
+	diskSpaceMargin     int64
+	stallMu             sync.RWMutex
+	stallTimeout        time.Duration
+	extensionPolicy     string
+	layout              string
+	conditionalRequests bool
+	preserveMtime       bool
+	resumeFallback      bool
+	overwrite           string
+	maxSpeed            int64
+	speedRamp           time.Duration
+	maxPages            int
+	dialTimeout         time.Duration
+	blobs               *blobStore
+	transport           *http.Transport
+	conns               *connLimiter
 }
 
 // NewDownloader creates a new downloader instance
 func NewDownloader() *Downloader {
 	return &Downloader{
-		downloadsDir: "downloads",
-		timeout:      60 * time.Second,
-		maxFileSize:  100 * 1024 * 1024, // 100MB
-		userAgent:    "FileDownloader/1.0",
+		downloadsDir:        "downloads",
+		requestTimeout:      60 * time.Second,
+		maxFileSize:         100 * 1024 * 1024, // 100MB
+		userAgent:           version.UserAgent(),
+		extensionPolicy:     ExtensionPolicyTrustURL,
+		layout:              LayoutPerTask,
+		conditionalRequests: true,
+		resumeFallback:      true,
+		overwrite:           OverwritePolicyRename,
+		maxPages:            DefaultMaxPages,
+		dialTimeout:         30 * time.Second,
+		transport:           http.DefaultTransport.(*http.Transport).Clone(),
+		conns:               newConnLimiter(0),
 	}
 }
 
-// DownloadFile downloads a file from URL and saves it to local directory
-func (d *Downloader) DownloadFile(url, filename string) (string, error) {
-	client := &http.Client{
-		Timeout: d.timeout,
+// NewDownloaderWithConfig creates a downloader configured from the download config section
+func NewDownloaderWithConfig(cfg config.DownloadConfig) *Downloader {
+	d := NewDownloader()
+	d.allowedContentTypes = cfg.AllowedContentTypes
+	d.blockedContentTypes = cfg.BlockedContentTypes
+	d.diskSpaceMargin = cfg.DiskSpaceMarginMB * 1024 * 1024
+	d.stallTimeout = time.Duration(cfg.StallTimeoutSeconds) * time.Second
+	d.requestTimeout = time.Duration(cfg.RequestTimeoutSeconds) * time.Second
+	d.transferTimeout = time.Duration(cfg.TransferTimeoutSeconds) * time.Second
+	d.minTransferRate = cfg.TransferMinKBps * 1024
+	if cfg.ExtensionPolicy != "" {
+		d.extensionPolicy = cfg.ExtensionPolicy
 	}
+	if cfg.Layout != "" {
+		d.layout = cfg.Layout
+	}
+	if cfg.UserAgent != "" {
+		d.userAgent = cfg.UserAgent
+	}
+	d.conditionalRequests = cfg.ConditionalRequests
+	d.preserveMtime = cfg.PreserveMtime
+	d.resumeFallback = cfg.ResumeFallback
+	if cfg.OverwritePolicy != "" {
+		d.overwrite = cfg.OverwritePolicy
+	}
+	d.maxSpeed = cfg.MaxSpeedKBps * 1024
+	d.speedRamp = time.Duration(cfg.SpeedRampSeconds) * time.Second
+	d.SetMaxPages(cfg.MaxPages)
+	if cfg.AllowLocalURLs {
+		d.EnableLocalURLs(cfg.FileRoot)
+	}
+	if proxy, err := proxyFunc(cfg.ProxyURL); err == nil {
+		d.transport.Proxy = proxy
+	} else {
+		logger.Logger.Warn("Ignoring invalid proxy URL", "error", err)
+	}
+	d.configureTransport(cfg.Transport)
+	d.SetDedup(cfg.Dedup)
+	return d
+}
 
-	req, err := http.NewRequest("GET", url, nil)
+// configureTransport bounds the header size and the connect, handshake and
+// header phases of every request, guarding against unreachable hosts and
+// servers that send huge headers or trickle them in to hold connections
+// open, and applies the protocol toggles. Left at their zero values, the
+// toggles keep Go's defaults.
+func (d *Downloader) configureTransport(cfg config.TransportConfig) {
+	d.dialTimeout = time.Duration(cfg.DialTimeoutSeconds) * time.Second
+	d.transport.DialContext = d.dialer().DialContext
+	d.transport.MaxResponseHeaderBytes = cfg.MaxResponseHeaderKB * 1024
+	d.transport.ResponseHeaderTimeout = time.Duration(cfg.ResponseHeaderTimeoutSeconds) * time.Second
+	d.transport.TLSHandshakeTimeout = time.Duration(cfg.TLSHandshakeTimeoutSeconds) * time.Second
+	d.transport.ExpectContinueTimeout = time.Duration(cfg.ExpectContinueTimeoutSeconds) * time.Second
+	if cfg.ForceHTTP1 {
+		// a non-nil empty map is the documented way to turn HTTP/2 off
+		d.transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		d.transport.ForceAttemptHTTP2 = false
+	}
+	d.transport.DisableKeepAlives = cfg.DisableKeepAlives
+	d.transport.DisableCompression = cfg.DisableCompression
+}
+
+// dialKeepAlive is the interval of TCP keep-alive probes on download connections
+const dialKeepAlive = 30 * time.Second
+
+// dialer returns the dialer of download connections, so that an unreachable
+// host fails within the dial timeout rather than the whole request timeout
+func (d *Downloader) dialer() *net.Dialer {
+	return &net.Dialer{Timeout: d.dialTimeout, KeepAlive: dialKeepAlive}
+}
+
+// SetStallTimeout changes the stall timeout of downloads that start afterwards; 0 disables it
+func (d *Downloader) SetStallTimeout(timeout time.Duration) {
+	d.stallMu.Lock()
+	defer d.stallMu.Unlock()
+	d.stallTimeout = timeout
+}
+
+// StallTimeout returns the current stall timeout
+func (d *Downloader) StallTimeout() time.Duration {
+	d.stallMu.RLock()
+	defer d.stallMu.RUnlock()
+	return d.stallTimeout
+}
+
+// client returns an HTTP client sharing the downloader transport, so HEAD probes
+// and downloads use the same proxy and connection pool. The request timeout
+// covers each request up to its response headers; bodies are bounded by the
+// transfer deadline instead.
+func (d *Downloader) client() *http.Client {
+	return &http.Client{
+		Transport: &countingTransport{rt: &requestTimeoutTransport{rt: d.transport, timeout: d.requestTimeout}},
+	}
+}
+
+// DownloadFile downloads a file from URL and saves it to local directory.
+// The transfer is aborted when ctx is done or when no bytes arrive within
+// the stall timeout.
+func (d *Downloader) DownloadFile(ctx context.Context, url, filename string) (string, error) {
+	return d.DownloadFileWithOptions(ctx, url, filename, DownloadOptions{})
+}
+
+// DownloadFileWithOptions downloads a file like DownloadFile using per-task options
+func (d *Downloader) DownloadFileWithOptions(ctx context.Context, url, filename string, opts DownloadOptions) (string, error) {
+	result, err := d.fetch(ctx, url, filename, opts)
 	if err != nil {
-		return "", fmt.Errorf("failed to create request for %s: %w", url, err)
+		return "", err
 	}
+	return result.Filename, nil
+}
 
-	req.Header.Set("User-Agent", d.userAgent)
+// fetch downloads a file and reports the saved name together with the
+// validators the server returned for it. The download holds a connection
+// slot from its first request until it is done.
+func (d *Downloader) fetch(ctx context.Context, url, filename string, opts DownloadOptions) (downloadResult, error) {
+	url, opts = withCredentials(url, opts)
+	release, err := d.acquireConn(ctx, url)
+	if err != nil {
+		return downloadResult{}, err
+	}
+	defer release()
 
-	resp, err := client.Do(req)
+	ctx, counter := withTransferCounter(ctx)
+	started := time.Now()
+	result, err := d.download(ctx, url, filename, opts)
 	if err != nil {
-		return "", fmt.Errorf("failed to get %s: %w", url, err)
+		return result, err
 	}
-	defer resp.Body.Close()
+	result.Bytes = counter.bytes.Load()
+	result.Duration = time.Since(started)
+	result.StatusCode = int(counter.statusCode.Load())
+	return result, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("bad status code %d for %s", resp.StatusCode, url)
+// download performs a fetch within its connection slot
+func (d *Downloader) download(ctx context.Context, url, filename string, opts DownloadOptions) (downloadResult, error) {
+	if opts.FollowNext {
+		return d.fetchPages(ctx, url, filename, opts)
 	}
+	if opts.Pieces != nil {
+		return d.fetchPieces(ctx, url, filename, opts)
+	}
+	client := d.client()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
 
-	if d.maxFileSize > 0 && resp.ContentLength > d.maxFileSize {
-		return "", fmt.Errorf("file size %d exceeds limit %d", resp.ContentLength, d.maxFileSize)
+	offset := partialSize(opts)
+
+	req, err := d.newRequest(ctx, opts.method(), url, opts)
+	if err != nil {
+		return downloadResult{}, fmt.Errorf("failed to create request for %s: %w", url, err)
 	}
 
-	finalName := filename
-	if cd := resp.Header.Get("Content-Disposition"); cd != "" {
-		if n := parseFilenameFromContentDisposition(cd); n != "" {
-			finalName = n
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if strongETag(opts.PartETag) {
+			// a changed file is sent whole instead of as a range of the new version
+			req.Header.Set("If-Range", opts.PartETag)
 		}
 	}
-	if ct := resp.Header.Get("Content-Type"); ct != "" {
-		hasValidExtension := false
-		if strings.Contains(finalName, ".") {
-			ext := strings.ToLower(filepath.Ext(finalName))
-			hasValidExtension = ext == ".html" || ext == ".htm" || ext == ".txt" || ext == ".pdf" || ext == ".png" || ext == ".jpg" || ext == ".jpeg" || ext == ".gif" || ext == ".css" || ext == ".js"
+	// an explicit header disables the transport's transparent gzip handling,
+	// so compressed bytes are stored as-is unless decompression is requested
+	if opts.Decompress {
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	} else {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+	conditional := d.conditionalRequests && (opts.ETag != "" || opts.LastModified != "")
+	if conditional {
+		if opts.ETag != "" {
+			req.Header.Set("If-None-Match", opts.ETag)
 		}
+		if opts.LastModified != "" {
+			req.Header.Set("If-Modified-Since", opts.LastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return downloadResult{}, fmt.Errorf("failed to get %s: %w", url, err)
+	}
+	defer resp.Body.Close()
 
-		if !hasValidExtension {
-			if exts, _ := mime.ExtensionsByType(ct); len(exts) > 0 {
-				finalName = finalName + exts[0]
-			} else if strings.Contains(strings.ToLower(ct), "text/html") {
-				finalName = finalName + ".html"
+	if conditional && resp.StatusCode == http.StatusNotModified {
+		return downloadResult{}, ErrNotModified
+	}
+	if offset > 0 {
+		// appending to a partial the response does not continue would corrupt
+		// the file, so the partial is discarded and the file downloaded whole
+		if reason := resumeRejection(resp, offset, opts.PartETag); reason != "" {
+			if !d.resumeFallback {
+				resp.Body.Close()
+				os.Remove(opts.PartFile)
+				return downloadResult{}, fmt.Errorf("%w for %s at offset %d: %s", ErrResumeNotHonored, url, offset, reason)
+			}
+			opts.log().Warn("Resume not honored, restarting", "url", url, "offset", offset, "reason", reason)
+			if resp.StatusCode != http.StatusOK {
+				resp.Body.Close()
+				os.Remove(opts.PartFile)
+				opts.Offset = 0
+				return d.download(ctx, url, filename, opts)
 			}
+			// the response already carries the whole file and overwrites the partial
+			offset = 0
 		}
 	}
+	if resp.StatusCode != http.StatusOK && (offset == 0 || resp.StatusCode != http.StatusPartialContent) {
+		return downloadResult{}, &HTTPStatusError{StatusCode: resp.StatusCode, URL: url}
+	}
+
+	if d.maxFileSize > 0 && offset+resp.ContentLength > d.maxFileSize {
+		return downloadResult{}, fmt.Errorf("%w: %d > %d", ErrFileTooLarge, offset+resp.ContentLength, d.maxFileSize)
+	}
+
+	if err := d.checkContentType(resp.Header.Get("Content-Type")); err != nil {
+		return downloadResult{}, fmt.Errorf("%w for %s", err, url)
+	}
+
+	finalName := d.resolveName(url, filename, resp.Header, opts.Decompress)
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	decode := opts.Decompress && (encoding == "gzip" || encoding == "deflate")
 
-	if err := os.MkdirAll(d.downloadsDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create downloads dir: %w", err)
+	if err := d.checkDiskSpace(resp.ContentLength); err != nil {
+		return downloadResult{}, err
+	}
+	defer d.startTransferDeadline(resp.ContentLength, cancel)()
+
+	// the raw bytes are counted before decoding, since Content-Length
+	// describes the bytes on the wire
+	received := &countingReader{r: resp.Body}
+	var body io.Reader = received
+	if stallTimeout := d.StallTimeout(); stallTimeout > 0 {
+		sr := newStallReader(received, stallTimeout, func() { cancel(ErrDownloadStalled) })
+		defer sr.Stop()
+		body = sr
+	}
+	body = newThrottledReader(ctx, body, d.throttle(), d.maxSpeed)
+	if opts.Progress != nil {
+		body = newProgressReader(body, offset, opts.Progress)
+	}
+	if decode {
+		decoded, err := newDecodingReader(body, encoding)
+		if err != nil {
+			return downloadResult{}, fmt.Errorf("failed to decode %s: %w", url, err)
+		}
+		defer decoded.Close()
+		body = decoded
+	}
+	if opts.transformed() {
+		transformed, err := applyTransform(opts.Transform, body)
+		if err != nil {
+			return downloadResult{}, fmt.Errorf("failed to transform %s: %w", url, err)
+		}
+		body = transformed
+	}
+	if d.extensionPolicy == ExtensionPolicySniff {
+		// the name has to be final before the file is created, so the head
+		// of the content is buffered and written out with the rest
+		buffered := bufio.NewReaderSize(body, sniffLen)
+		head := sniffHead(buffered, opts.PartFile, offset)
+		finalName = d.sniffName(url, d.responseName(filename, resp.Header, opts.Decompress), finalName, head)
+		body = buffered
+	}
+	if d.maxFileSize > 0 {
+		body = io.LimitReader(body, d.maxFileSize-offset+1)
+	}
+
+	dir := d.TaskDir(opts.TaskID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return downloadResult{}, fmt.Errorf("failed to create downloads dir: %w", err)
+	}
+	var file *os.File
+	if opts.PartFile != "" {
+		file, err = openPartFile(opts.PartFile, offset)
+		if err == nil && offset == 0 && opts.PartStarted != nil {
+			opts.PartStarted(resp.Header.Get("ETag"))
+		}
+	} else {
+		file, finalName, err = createFile(dir, finalName, d.replacesExisting(opts))
 	}
-	filePath := filepath.Join(d.downloadsDir, finalName)
-	file, err := os.Create(filePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to create file %s: %w", filePath, err)
+		return downloadResult{}, fmt.Errorf("failed to create file %s: %w", filepath.Join(dir, finalName), err)
 	}
 	defer file.Close()
-	_, err = io.Copy(file, resp.Body)
+	filePath := file.Name()
+
+	written, err := io.Copy(file, body)
 	if err != nil {
-		return "", fmt.Errorf("failed to write file %s: %w", filePath, err)
+		file.Close()
+		cause := context.Cause(ctx)
+		if opts.PartFile == "" || !errors.Is(cause, context.Canceled) {
+			// a partial interrupted by shutdown is kept so that it can be resumed
+			os.Remove(filePath)
+		}
+		if cause != nil {
+			return downloadResult{}, fmt.Errorf("failed to write file %s: %w", filePath, cause)
+		}
+		if errors.Is(err, ErrTransformFailed) {
+			// a transform may stop reading early, so the body is not short
+			return downloadResult{}, fmt.Errorf("failed to transform %s: %w", url, err)
+		}
+		if resp.ContentLength >= 0 && received.n < resp.ContentLength {
+			return downloadResult{}, fmt.Errorf("%w: got %d of %d bytes from %s: %v",
+				ErrIncompleteBody, received.n, resp.ContentLength, url, err)
+		}
+		return downloadResult{}, fmt.Errorf("failed to write file %s: %w", filePath, err)
+	}
+	if resp.ContentLength >= 0 && received.n < resp.ContentLength {
+		// the transport normally reports a short body itself; this catches
+		// transports and readers that end it with a clean EOF
+		file.Close()
+		os.Remove(filePath)
+		return downloadResult{}, fmt.Errorf("%w: got %d of %d bytes from %s",
+			ErrIncompleteBody, received.n, resp.ContentLength, url)
+	}
+	if d.maxFileSize > 0 && offset+written > d.maxFileSize {
+		file.Close()
+		os.Remove(filePath)
+		return downloadResult{}, fmt.Errorf("%w: more than %d bytes streamed", ErrFileTooLarge, d.maxFileSize)
 	}
 
-	return finalName, nil
+	if opts.PartFile != "" {
+		if finalName, err = promotePartFile(file, dir, finalName, d.replacesExisting(opts)); err != nil {
+			os.Remove(filePath)
+			return downloadResult{}, fmt.Errorf("failed to save file %s: %w", filepath.Join(dir, finalName), err)
+		}
+	}
+	d.applyLastModified(filepath.Join(dir, finalName), resp.Header.Get("Last-Modified"))
+
+	return downloadResult{
+		Filename:     finalName,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FinalURL:     resp.Request.URL.Redacted(),
+		ContentType:  resp.Header.Get("Content-Type"),
+	}, nil
+}
+
+// applyLastModified sets the mtime of a saved file to the Last-Modified time
+// of its response when preserve_mtime is enabled. A missing or unparseable
+// header leaves the download time in place.
+func (d *Downloader) applyLastModified(path, lastModified string) {
+	if !d.preserveMtime || lastModified == "" {
+		return
+	}
+	mtime, err := http.ParseTime(lastModified)
+	if err != nil {
+		logger.Logger.Debug("Ignoring unparseable Last-Modified", "path", path, "last_modified", lastModified)
+		return
+	}
+	if err := os.Chtimes(path, time.Time{}, mtime); err != nil {
+		logger.Logger.Warn("Failed to set file mtime", "path", path, "error", err)
+	}
 }
 
 // ExtractFilename extracts filename from URL
@@ -101,64 +624,513 @@ func (d *Downloader) ExtractFilename(u string) string {
 	if err != nil {
 		return fmt.Sprintf("file_%d", len(u))
 	}
-	p := parsed.Path
-	if p == "" || p == "/" {
-		return fmt.Sprintf("file_%d", len(u))
+	if parsed.Scheme == "data" {
+		// the extension is added from the media type
+		return "data"
+	}
+	// the segment is split off the escaped path, so an encoded slash stays
+	// part of the name instead of starting a new segment
+	segs := strings.Split(parsed.EscapedPath(), "/")
+	raw := segs[len(segs)-1]
+	name, err := neturl.PathUnescape(raw)
+	if err != nil {
+		name = raw
 	}
-	segs := strings.Split(p, "/")
-	name := segs[len(segs)-1]
+	name = sanitizeFilename(name)
 	if name == "" {
 		return fmt.Sprintf("file_%d", len(u))
 	}
 	return name
 }
 
-// GetFileSize returns file size by URL using HEAD request
-func (d *Downloader) GetFileSize(url string) (int64, error) {
-	client := &http.Client{
-		Timeout: d.timeout,
+// sanitizeFilename makes a decoded path segment safe to use as a file name:
+// path separators and characters reserved on common filesystems become
+// underscores, control characters are dropped and "." or ".." yield ""
+func sanitizeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			return '_'
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// maxNameCollisions bounds the numeric suffixes tried by createUniqueFile
+const maxNameCollisions = 1000
+
+// createUniqueFile creates name in dir without overwriting an existing file. On a
+// collision it appends a numeric suffix before the extension ("report (1).txt").
+// O_EXCL makes the check atomic, so concurrent workers never pick the same name.
+func createUniqueFile(dir, name string) (*os.File, string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	candidate := name
+	for i := 1; ; i++ {
+		file, err := os.OpenFile(filepath.Join(dir, candidate), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			return file, candidate, nil
+		}
+		if !errors.Is(err, os.ErrExist) || i > maxNameCollisions {
+			return nil, name, err
+		}
+		candidate = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
+
+// partialSize returns the number of bytes to resume from. The offset is only
+// trusted when the part file on disk still has exactly that size, and decoded
+// or transformed downloads always start over because the range applies to the
+// bytes on the wire.
+func partialSize(opts DownloadOptions) int64 {
+	if opts.PartFile == "" || opts.Offset <= 0 || opts.Decompress || opts.transformed() {
+		return 0
+	}
+	info, err := os.Stat(opts.PartFile)
+	if err != nil || info.Size() != opts.Offset {
+		return 0
+	}
+	return opts.Offset
+}
+
+// openPartFile opens the part file for appending at offset, truncating it when
+// the download starts from the beginning
+func openPartFile(path string, offset int64) (*os.File, error) {
+	if offset > 0 {
+		return os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
 	}
+	return os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+}
 
-	req, err := http.NewRequest("HEAD", url, nil)
+// promotePartFile closes a finished part file and renames it to a unique name
+// in dir. The placeholder created by createUniqueFile reserves the name, and
+// the rename atomically replaces it. With replace set, the part file takes
+// the place of an existing file of that name instead.
+func promotePartFile(part *os.File, dir, name string, replace bool) (string, error) {
+	if err := part.Close(); err != nil {
+		return name, err
+	}
+	if replace {
+		return name, os.Rename(part.Name(), filepath.Join(dir, name))
+	}
+	placeholder, name, err := createUniqueFile(dir, name)
 	if err != nil {
-		return 0, fmt.Errorf("failed to create HEAD request for %s: %w", url, err)
+		return name, err
+	}
+	placeholder.Close()
+	if err := os.Rename(part.Name(), placeholder.Name()); err != nil {
+		os.Remove(placeholder.Name())
+		return name, err
 	}
+	return name, nil
+}
 
-	req.Header.Set("User-Agent", d.userAgent)
+// PartPath returns the path of the partial download of a file of a task. The
+// name is derived from the task ID and file index, so it can be found again
+// after a restart.
+func (d *Downloader) PartPath(taskID string, fileIndex int) string {
+	return filepath.Join(d.TaskDir(taskID), fmt.Sprintf(".%s.%d.part", filepath.Base(taskID), fileIndex))
+}
+
+// TaskDir returns the directory holding the files of a task. Under the flat
+// layout, or without a task ID, this is the downloads directory itself.
+func (d *Downloader) TaskDir(taskID string) string {
+	if d.layout == LayoutFlat || taskID == "" {
+		return d.downloadsDir
+	}
+	return filepath.Join(d.downloadsDir, filepath.Base(taskID))
+}
+
+// GetFileSize returns file size by URL using HEAD request, aborting when ctx is done.
+func (d *Downloader) GetFileSize(ctx context.Context, url string) (int64, error) {
+	return d.GetFileSizeWithOptions(ctx, url, DownloadOptions{})
+}
+
+// GetFileSizeWithOptions returns file size like GetFileSize using per-task options.
+// When the server rejects HEAD, the size is taken from the Content-Range of a
+// one-byte ranged GET instead. 0 is returned when the server does not report a
+// size at all, e.g. for chunked responses.
+func (d *Downloader) GetFileSizeWithOptions(ctx context.Context, url string, opts DownloadOptions) (int64, error) {
+	url, opts = withCredentials(url, opts)
+	_, size, err := d.probeHeaders(ctx, url, opts)
+	return size, err
+}
+
+// Probe resolves the size and the name a download of url would be saved under
+// without transferring the body. Content-Type filtering is applied, so a file
+// that the download would reject fails the probe as well.
+func (d *Downloader) Probe(ctx context.Context, url, filename string, opts DownloadOptions) (int64, string, error) {
+	url, opts = withCredentials(url, opts)
+	header, size, err := d.probeHeaders(ctx, url, opts)
+	if err != nil {
+		return 0, "", err
+	}
+	if d.maxFileSize > 0 && size > d.maxFileSize {
+		return 0, "", fmt.Errorf("%w: %d > %d", ErrFileTooLarge, size, d.maxFileSize)
+	}
+	if err := d.checkContentType(header.Get("Content-Type")); err != nil {
+		return 0, "", fmt.Errorf("%w for %s", err, url)
+	}
+	return size, d.resolveName(url, filename, header, opts.Decompress), nil
+}
+
+// probeHeaders fetches the response headers of url with HEAD, falling back to
+// a one-byte ranged request with the download method, and returns them with
+// the reported file size. A task probing with another method sends the
+// ranged request right away.
+func (d *Downloader) probeHeaders(ctx context.Context, url string, opts DownloadOptions) (http.Header, int64, error) {
+	release, err := d.acquireConn(ctx, url)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer release()
+
+	client := d.client()
+	if method := opts.probeMethod(); method != http.MethodHead {
+		return d.getFileSizeByRange(ctx, client, method, url, opts)
+	}
+
+	req, err := d.newRequest(ctx, http.MethodHead, url, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create HEAD request for %s: %w", url, err)
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get file size: %w", err)
+		return nil, 0, fmt.Errorf("failed to get file size: %w", err)
 	}
-	defer resp.Body.Close()
+	resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("bad status code %d", resp.StatusCode)
+		return d.getFileSizeByRange(ctx, client, opts.method(), url, opts)
+	}
+
+	return resp.Header, knownSize(resp.ContentLength), nil
+}
+
+// resolveName returns the name a response is saved under: the
+// Content-Disposition name wins over filename, a .gz suffix is dropped when
+// the body gets decompressed and the extension policy is applied last
+func (d *Downloader) resolveName(url, filename string, header http.Header, decompress bool) string {
+	name := d.responseName(filename, header, decompress)
+	if ct := header.Get("Content-Type"); ct != "" {
+		policy := d.extensionPolicy
+		if policy == ExtensionPolicySniff {
+			// until the content is seen the header is all there is
+			policy = ExtensionPolicyTrustURL
+		}
+		resolved, mismatch := resolveExtension(name, ct, policy)
+		if mismatch {
+			logger.Logger.Warn("File extension does not match Content-Type",
+				"url", url, "filename", name, "content_type", ct, "policy", d.extensionPolicy, "saved_as", resolved)
+		}
+		name = resolved
 	}
+	return name
+}
 
-	return resp.ContentLength, nil
+// responseName returns the name of a response before any extension is added:
+// the Content-Disposition name or filename, without a .gz suffix when the
+// body gets decompressed
+func (d *Downloader) responseName(filename string, header http.Header, decompress bool) string {
+	name := filename
+	if cd := header.Get("Content-Disposition"); cd != "" {
+		if n := parseFilenameFromContentDisposition(cd); n != "" {
+			name = n
+		}
+	}
+	encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding")))
+	if decompress && encoding == "gzip" {
+		if trimmed := strings.TrimSuffix(name, ".gz"); trimmed != "" {
+			name = trimmed
+		}
+	}
+	return name
+}
+
+// sniffName returns the name for content starting with head under
+// ExtensionPolicySniff. A base name without a recognised extension gets the
+// extension of the detected type instead of the one derived from the header;
+// when the type cannot be told from the content, resolved is kept.
+func (d *Downloader) sniffName(url, base, resolved string, head []byte) string {
+	if knownExtension(strings.ToLower(filepath.Ext(base))) {
+		return resolved
+	}
+	detected, ok := detectContentType(head)
+	if !ok {
+		return resolved
+	}
+	name := base + extensionForType(detected)
+	if name != resolved {
+		logger.Logger.Info("Named file by its content",
+			"url", url, "detected_type", detected, "saved_as", name)
+	}
+	return name
+}
+
+// userAgentFor returns the User-Agent for a request: the task override wins
+// over the configured or default one
+func (d *Downloader) userAgentFor(opts DownloadOptions) string {
+	if opts.UserAgent != "" {
+		return opts.UserAgent
+	}
+	return d.userAgent
+}
+
+// getFileSizeByRange requests the first byte of the file and reads the total size from Content-Range
+func (d *Downloader) getFileSizeByRange(ctx context.Context, client *http.Client, method, url string, opts DownloadOptions) (http.Header, int64, error) {
+	req, err := d.newRequest(ctx, method, url, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create range request for %s: %w", url, err)
+	}
+
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get file size: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Header, parseContentRangeTotal(resp.Header.Get("Content-Range")), nil
+	case http.StatusOK:
+		// the server ignored the range; the body is not read, only its declared length
+		return resp.Header, knownSize(resp.ContentLength), nil
+	default:
+		return nil, 0, &HTTPStatusError{StatusCode: resp.StatusCode, URL: url}
+	}
+}
+
+// parseContentRangeTotal returns the total size from a "bytes 0-0/1234" header, or 0 when unknown
+func parseContentRangeTotal(header string) int64 {
+	slash := strings.LastIndex(header, "/")
+	if slash < 0 {
+		return 0
+	}
+	total, err := strconv.ParseInt(strings.TrimSpace(header[slash+1:]), 10, 64)
+	if err != nil || total < 0 {
+		return 0
+	}
+	return total
+}
+
+// parseContentRangeStart returns the first byte position from a "bytes 100-199/200" header, or -1
+func parseContentRangeStart(header string) int64 {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes ")
+	if !ok {
+		return -1
+	}
+	dash := strings.Index(spec, "-")
+	if dash < 0 {
+		return -1
+	}
+	start, err := strconv.ParseInt(strings.TrimSpace(spec[:dash]), 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
 }
 
-// parseFilenameFromContentDisposition extracts filename from Content-Disposition header
+// knownSize maps the -1 Content-Length of responses without a declared size to 0
+func knownSize(contentLength int64) int64 {
+	if contentLength < 0 {
+		return 0
+	}
+	return contentLength
+}
+
+// decodingReader decompresses a body and reports corrupt data as ErrBadContentEncoding
+type decodingReader struct {
+	r io.ReadCloser
+}
+
+// newDecodingReader creates a gzip or deflate (zlib) decoder for the body
+func newDecodingReader(body io.Reader, encoding string) (*decodingReader, error) {
+	var r io.ReadCloser
+	var err error
+	if encoding == "gzip" {
+		r, err = gzip.NewReader(body)
+	} else {
+		r, err = zlib.NewReader(body)
+	}
+	if err != nil {
+		return nil, encodingError(err)
+	}
+	return &decodingReader{r: r}, nil
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = encodingError(err)
+	}
+	return n, err
+}
+
+func (d *decodingReader) Close() error {
+	return d.r.Close()
+}
+
+// encodingError wraps decoder format errors with ErrBadContentEncoding
+func encodingError(err error) error {
+	var corrupt flate.CorruptInputError
+	if errors.Is(err, gzip.ErrHeader) || errors.Is(err, gzip.ErrChecksum) ||
+		errors.Is(err, zlib.ErrHeader) || errors.Is(err, zlib.ErrChecksum) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &corrupt) {
+		return fmt.Errorf("%w: %v", ErrBadContentEncoding, err)
+	}
+	return err
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// stallReader cancels the download when no bytes are read within the timeout
+type stallReader struct {
+	r     io.Reader
+	d     time.Duration
+	timer *time.Timer
+}
+
+// newStallReader wraps r and calls onStall if no data arrives for d
+func newStallReader(r io.Reader, d time.Duration, onStall func()) *stallReader {
+	return &stallReader{r: r, d: d, timer: time.AfterFunc(d, onStall)}
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.timer.Reset(s.d)
+	}
+	return n, err
+}
+
+// Stop stops the stall timer
+func (s *stallReader) Stop() {
+	s.timer.Stop()
+}
+
+// checkDiskSpace verifies there is room for a file of the given size plus the safety margin.
+// Responses with unknown length skip the check and rely on the streaming size limit.
+func (d *Downloader) checkDiskSpace(size int64) error {
+	if size <= 0 {
+		return nil
+	}
+
+	free, err := d.FreeSpace()
+	if err != nil {
+		return nil
+	}
+
+	if required := size + d.diskSpaceMargin; free < required {
+		return fmt.Errorf("%w: need %d bytes, %d available", ErrInsufficientDiskSpace, required, free)
+	}
+
+	return nil
+}
+
+// FreeSpace returns the bytes available on the volume of the downloads
+// directory, measured at its closest existing ancestor if it is not created yet
+func (d *Downloader) FreeSpace() (int64, error) {
+	dir := d.downloadsDir
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	return freeDiskSpace(dir)
+}
+
+// checkContentType verifies the Content-Type against the allow/block lists.
+// Blocked patterns take precedence over allowed ones. A missing Content-Type
+// is accepted only when no allow list is configured.
+func (d *Downloader) checkContentType(contentType string) error {
+	if len(d.allowedContentTypes) == 0 && len(d.blockedContentTypes) == 0 {
+		return nil
+	}
+
+	mediaType := strings.ToLower(strings.TrimSpace(contentType))
+	if parsed, _, err := mime.ParseMediaType(contentType); err == nil {
+		mediaType = parsed
+	}
+
+	if mediaType == "" {
+		if len(d.allowedContentTypes) > 0 {
+			return fmt.Errorf("%w: missing Content-Type", ErrContentTypeNotAllowed)
+		}
+		return nil
+	}
+
+	if matchContentType(d.blockedContentTypes, mediaType) {
+		return fmt.Errorf("%w: %s is blocked", ErrContentTypeNotAllowed, mediaType)
+	}
+
+	if len(d.allowedContentTypes) > 0 && !matchContentType(d.allowedContentTypes, mediaType) {
+		return fmt.Errorf("%w: %s is not in the allowed list", ErrContentTypeNotAllowed, mediaType)
+	}
+
+	return nil
+}
+
+// matchContentType reports whether the media type matches any of the glob patterns
+func matchContentType(patterns []string, mediaType string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(strings.ToLower(pattern), mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFilenameFromContentDisposition extracts filename from Content-Disposition
+// header. The percent-encoded filename* form wins over filename, as RFC 6266
+// asks, and the name is sanitized like one taken from a URL, so that a server
+// cannot name a file outside the download directory.
 func parseFilenameFromContentDisposition(cd string) string {
-	cd = strings.TrimSpace(cd)
-	parts := strings.Split(cd, ";")
-	for _, p := range parts {
+	var plain, extended string
+	for _, p := range strings.Split(cd, ";") {
 		p = strings.TrimSpace(p)
-		if strings.HasPrefix(strings.ToLower(p), "filename*=") {
-			v := strings.TrimPrefix(p, "filename*=")
-			idx := strings.Index(v, "''")
-			if idx >= 0 && idx+2 < len(v) {
-				return v[idx+2:]
+		lower := strings.ToLower(p)
+		switch {
+		case strings.HasPrefix(lower, "filename*="):
+			// charset'language'value, with the value percent-encoded
+			parts := strings.SplitN(p[len("filename*="):], "'", 3)
+			if len(parts) != 3 {
+				continue
 			}
-		}
-		if strings.HasPrefix(strings.ToLower(p), "filename=") {
-			v := strings.TrimPrefix(p, "filename=")
-			v = strings.Trim(v, "\"")
-			if v != "" {
-				return v
+			if v, err := neturl.PathUnescape(strings.Trim(parts[2], `"`)); err == nil {
+				extended = v
 			}
+		case strings.HasPrefix(lower, "filename="):
+			plain = strings.Trim(p[len("filename="):], `"`)
 		}
 	}
-	return ""
+	if name := sanitizeFilename(extended); name != "" {
+		return name
+	}
+	return sanitizeFilename(plain)
 }
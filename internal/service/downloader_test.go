@@ -1,12 +1,25 @@
 package service
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"filedownloader-20240926/internal/config"
+	"filedownloader-20240926/internal/version"
 )
 
 // TestDownloaderExtractFilename tests filename extraction from various URLs
@@ -51,6 +64,46 @@ func TestDownloaderExtractFilename(t *testing.T) {
 			url:      "http://x/y/z.txt",
 			expected: "z.txt",
 		},
+		{
+			name:     "encoded spaces",
+			url:      "http://example.com/docs/My%20Report%20(final).pdf",
+			expected: "My Report (final).pdf",
+		},
+		{
+			name:     "encoded unicode",
+			url:      "http://example.com/%D0%BE%D1%82%D1%87%D0%B5%D1%82.pdf",
+			expected: "отчет.pdf",
+		},
+		{
+			name:     "raw unicode",
+			url:      "http://example.com/データ.csv",
+			expected: "データ.csv",
+		},
+		{
+			name:     "encoded slash stays in the name",
+			url:      "http://example.com/a%2Fb.txt",
+			expected: "a_b.txt",
+		},
+		{
+			name:     "reserved characters",
+			url:      "http://example.com/what%3F%20a%3Ab%2A%22c%22.txt",
+			expected: "what_ a_b__c_.txt",
+		},
+		{
+			name:     "encoded backslash and control characters",
+			url:      "http://example.com/..%5Cevil%00%0A.txt",
+			expected: ".._evil.txt",
+		},
+		{
+			name:     "encoded dot segment",
+			url:      "http://example.com/%2E%2E",
+			expected: "file_25",
+		},
+		{
+			name:     "plus is not a space",
+			url:      "http://example.com/a+b.txt",
+			expected: "a+b.txt",
+		},
 	}
 
 	for _, tt := range tests {
@@ -96,7 +149,7 @@ func TestDownloaderGetFileSize(t *testing.T) {
 			defer srv.Close()
 
 			d := NewDownloader()
-			size, err := d.GetFileSize(srv.URL)
+			size, err := d.GetFileSize(context.Background(), srv.URL)
 
 			if tt.expectError {
 				if err == nil {
@@ -111,10 +164,6 @@ func TestDownloaderGetFileSize(t *testing.T) {
 			}
 
 			expectedSize := int64(len(tt.content))
-			if tt.content == "" && size == -1 {
-				// This is acceptable for empty files
-				return
-			}
 			if size != expectedSize {
 				t.Errorf("expected size %d, got %d", expectedSize, size)
 			}
@@ -161,7 +210,7 @@ func TestDownloaderDownloadFile(t *testing.T) {
 			tmpDir := t.TempDir()
 			d.downloadsDir = tmpDir
 
-			filename, err := d.DownloadFile(srv.URL, tt.filename)
+			filename, err := d.DownloadFile(context.Background(), srv.URL, tt.filename)
 
 			if tt.expectError {
 				if err == nil {
@@ -207,6 +256,61 @@ func TestDownloaderDownloadFile(t *testing.T) {
 	}
 }
 
+// TestParseFilenameFromContentDisposition tests decoding and sanitizing the name a server suggests
+func TestParseFilenameFromContentDisposition(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{name: "quoted", header: `attachment; filename="report.pdf"`, expected: "report.pdf"},
+		{name: "unquoted", header: `attachment; filename=report.pdf`, expected: "report.pdf"},
+		{name: "percent-encoded", header: `attachment; filename*=UTF-8''%D0%BE%D1%82%D1%87%D0%B5%D1%82%20v2.pdf`, expected: "отчет v2.pdf"},
+		{name: "with language", header: `attachment; filename*=UTF-8'en'annual%20report.pdf`, expected: "annual report.pdf"},
+		{name: "extended form wins", header: `attachment; filename="plain.pdf"; filename*=UTF-8''fancy.pdf`, expected: "fancy.pdf"},
+		{name: "bad encoding falls back", header: `attachment; filename="plain.pdf"; filename*=UTF-8''%zz.pdf`, expected: "plain.pdf"},
+		{name: "parent directory", header: `attachment; filename="../../x"`, expected: ".._.._x"},
+		{name: "encoded traversal", header: `attachment; filename*=UTF-8''..%2F..%2Fetc%2Fpasswd`, expected: ".._.._etc_passwd"},
+		{name: "dot dot", header: `attachment; filename=".."`, expected: ""},
+		{name: "no name", header: `inline`, expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseFilenameFromContentDisposition(tt.header); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestDownloaderContentDispositionTraversal tests that a Content-Disposition name cannot leave the download directory
+func TestDownloaderContentDispositionTraversal(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="../../escaped.txt"`)
+		io.WriteString(w, "content")
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	d := NewDownloader()
+	d.downloadsDir = filepath.Join(root, "downloads", "task")
+
+	filename, err := d.DownloadFile(context.Background(), srv.URL+"/file.txt", "file.txt")
+	if err != nil {
+		t.Fatalf("download failed: %v", err)
+	}
+	if filename != filepath.Base(filename) {
+		t.Errorf("expected a bare file name, got %q", filename)
+	}
+	if _, err := os.Stat(filepath.Join(d.downloadsDir, filename)); err != nil {
+		t.Errorf("expected the file inside the download directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escaped.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be written outside the download directory, got %v", err)
+	}
+}
+
 // TestDownloaderIntegration tests the complete download workflow
 func TestDownloaderIntegration(t *testing.T) {
 	tests := []struct {
@@ -237,7 +341,7 @@ func TestDownloaderIntegration(t *testing.T) {
 				t.Fatalf("empty filename")
 			}
 
-			size, err := d.GetFileSize(srv.URL)
+			size, err := d.GetFileSize(context.Background(), srv.URL)
 			if err != nil {
 				t.Fatalf("GetFileSize error: %v", err)
 			}
@@ -245,7 +349,7 @@ func TestDownloaderIntegration(t *testing.T) {
 				t.Fatalf("unexpected size: %d", size)
 			}
 
-			downloadedFilename, err := d.DownloadFile(srv.URL, filename)
+			downloadedFilename, err := d.DownloadFile(context.Background(), srv.URL, filename)
 			if err != nil {
 				t.Fatalf("DownloadFile error: %v", err)
 			}
@@ -261,3 +365,1081 @@ func TestDownloaderIntegration(t *testing.T) {
 		})
 	}
 }
+
+// TestDownloaderContentTypeFilter tests allow/block filtering by Content-Type
+func TestDownloaderContentTypeFilter(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		allowed     []string
+		blocked     []string
+		expectError bool
+	}{
+		{
+			name:        "no filters",
+			contentType: "text/plain",
+			expectError: false,
+		},
+		{
+			name:        "allowed by wildcard",
+			contentType: "image/png",
+			allowed:     []string{"image/*"},
+			expectError: false,
+		},
+		{
+			name:        "allowed with parameters",
+			contentType: "image/svg+xml; charset=utf-8",
+			allowed:     []string{"image/*"},
+			expectError: false,
+		},
+		{
+			name:        "not in allowed list",
+			contentType: "text/html",
+			allowed:     []string{"image/*"},
+			expectError: true,
+		},
+		{
+			name:        "explicitly blocked",
+			contentType: "image/gif",
+			allowed:     []string{"image/*"},
+			blocked:     []string{"image/gif"},
+			expectError: true,
+		},
+		{
+			name:        "missing with allow list",
+			contentType: "",
+			allowed:     []string{"image/*"},
+			expectError: true,
+		},
+		{
+			name:        "missing with block list only",
+			contentType: "",
+			blocked:     []string{"text/html"},
+			expectError: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header()["Content-Type"] = []string{tt.contentType}
+				io.WriteString(w, "content")
+			}))
+			defer srv.Close()
+
+			d := NewDownloaderWithConfig(config.DownloadConfig{
+				AllowedContentTypes: tt.allowed,
+				BlockedContentTypes: tt.blocked,
+			})
+			tmpDir := t.TempDir()
+			d.downloadsDir = tmpDir
+
+			_, err := d.DownloadFile(context.Background(), srv.URL, "file")
+
+			if tt.expectError {
+				if !errors.Is(err, ErrContentTypeNotAllowed) {
+					t.Errorf("expected ErrContentTypeNotAllowed, got %v", err)
+				}
+				entries, _ := os.ReadDir(tmpDir)
+				if len(entries) != 0 {
+					t.Errorf("expected no files written, got %d", len(entries))
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// TestDownloaderSizeGuards tests the disk space preflight and streaming size limit
+func TestDownloaderSizeGuards(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		chunked     bool
+		maxFileSize int64
+		margin      int64
+		expectedErr error
+	}{
+		{
+			name:        "enough space",
+			content:     "hello world",
+			maxFileSize: 1024,
+			expectedErr: nil,
+		},
+		{
+			name:        "insufficient space",
+			content:     "hello world",
+			maxFileSize: 1024,
+			margin:      1 << 62,
+			expectedErr: ErrInsufficientDiskSpace,
+		},
+		{
+			name:        "unknown length skips preflight",
+			content:     "hello world",
+			chunked:     true,
+			maxFileSize: 1024,
+			margin:      1 << 62,
+			expectedErr: nil,
+		},
+		{
+			name:        "unknown length exceeds streaming limit",
+			content:     "hello world",
+			chunked:     true,
+			maxFileSize: 5,
+			expectedErr: ErrFileTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, tt.content)
+				if tt.chunked {
+					w.(http.Flusher).Flush()
+				}
+			}))
+			defer srv.Close()
+
+			d := NewDownloader()
+			tmpDir := t.TempDir()
+			d.downloadsDir = tmpDir
+			d.maxFileSize = tt.maxFileSize
+			d.diskSpaceMargin = tt.margin
+
+			_, err := d.DownloadFile(context.Background(), srv.URL, "file.txt")
+
+			if tt.expectedErr == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expected %v, got %v", tt.expectedErr, err)
+			}
+			entries, _ := os.ReadDir(tmpDir)
+			if len(entries) != 0 {
+				t.Errorf("expected no files left behind, got %d", len(entries))
+			}
+		})
+	}
+}
+
+// TestDownloaderShortBody tests that a body shorter than its Content-Length fails the download
+func TestDownloaderShortBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		declared    int
+		sent        int
+		partFile    bool
+		expectedErr error
+	}{
+		{
+			name:     "complete body",
+			declared: 4096,
+			sent:     4096,
+		},
+		{
+			name:        "truncated body",
+			declared:    5000,
+			sent:        4000,
+			expectedErr: ErrIncompleteBody,
+		},
+		{
+			name:        "truncated body into part file",
+			declared:    5000,
+			sent:        4000,
+			partFile:    true,
+			expectedErr: ErrIncompleteBody,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				conn, buf, err := w.(http.Hijacker).Hijack()
+				if err != nil {
+					t.Errorf("failed to hijack connection: %v", err)
+					return
+				}
+				defer conn.Close()
+				fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n", tt.declared)
+				buf.Write(bytes.Repeat([]byte("x"), tt.sent))
+				buf.Flush()
+			}))
+			defer srv.Close()
+
+			d := NewDownloader()
+			tmpDir := t.TempDir()
+			d.downloadsDir = tmpDir
+			opts := DownloadOptions{}
+			if tt.partFile {
+				opts.PartFile = filepath.Join(tmpDir, ".task.0.part")
+			}
+
+			_, err := d.DownloadFileWithOptions(context.Background(), srv.URL, "file.bin", opts)
+
+			if tt.expectedErr == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected %v, got %v", tt.expectedErr, err)
+			}
+			if code := errorCode(err); code != ErrorCodeIncomplete {
+				t.Errorf("expected error code %s, got %s", ErrorCodeIncomplete, code)
+			}
+			entries, _ := os.ReadDir(tmpDir)
+			if len(entries) != 0 {
+				t.Errorf("expected no files left behind, got %d", len(entries))
+			}
+		})
+	}
+}
+
+// TestDownloaderStallDetection tests that a download fails when the server stops sending bytes
+func TestDownloaderStallDetection(t *testing.T) {
+	tests := []struct {
+		name         string
+		pause        time.Duration
+		stallTimeout time.Duration
+		expectedErr  error
+	}{
+		{
+			name:         "steady stream",
+			pause:        0,
+			stallTimeout: 200 * time.Millisecond,
+			expectedErr:  nil,
+		},
+		{
+			name:         "stalled stream",
+			pause:        2 * time.Second,
+			stallTimeout: 100 * time.Millisecond,
+			expectedErr:  ErrDownloadStalled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "first chunk")
+				w.(http.Flusher).Flush()
+				select {
+				case <-time.After(tt.pause):
+				case <-r.Context().Done():
+					return
+				}
+				io.WriteString(w, "second chunk")
+			}))
+			defer srv.Close()
+
+			d := NewDownloader()
+			tmpDir := t.TempDir()
+			d.downloadsDir = tmpDir
+			d.stallTimeout = tt.stallTimeout
+
+			start := time.Now()
+			_, err := d.DownloadFile(context.Background(), srv.URL, "file.txt")
+
+			if tt.expectedErr == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expected %v, got %v", tt.expectedErr, err)
+			}
+			if elapsed := time.Since(start); elapsed >= tt.pause {
+				t.Errorf("expected stall to be detected before %v, took %v", tt.pause, elapsed)
+			}
+		})
+	}
+}
+
+// TestDownloaderTransportLimits tests that slow and oversized response headers are rejected fast
+func TestDownloaderTransportLimits(t *testing.T) {
+	tests := []struct {
+		name         string
+		headerDelay  bool
+		headerBytes  int
+		expectedCode string
+	}{
+		{
+			name:         "normal response",
+			headerBytes:  1024,
+			expectedCode: "",
+		},
+		{
+			name:         "headers never sent",
+			headerDelay:  true,
+			expectedCode: ErrorCodeTimeout,
+		},
+		{
+			name:         "oversized headers",
+			headerBytes:  128 * 1024,
+			expectedCode: ErrorCodeNetwork,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			release := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.headerDelay {
+					select {
+					case <-release:
+					case <-r.Context().Done():
+					}
+					return
+				}
+				w.Header().Set("X-Padding", strings.Repeat("a", tt.headerBytes))
+				io.WriteString(w, "content")
+			}))
+			defer srv.Close()
+			defer close(release)
+
+			d := NewDownloaderWithConfig(config.DownloadConfig{
+				Transport: config.TransportConfig{
+					MaxResponseHeaderKB:          64,
+					ResponseHeaderTimeoutSeconds: 1,
+				},
+			})
+			d.downloadsDir = t.TempDir()
+
+			start := time.Now()
+			_, err := d.DownloadFile(context.Background(), srv.URL+"/file.txt", "file.txt")
+			if tt.expectedCode == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error, got nil")
+			}
+			if code := errorCode(err); code != tt.expectedCode {
+				t.Errorf("expected error code %s, got %s (%v)", tt.expectedCode, code, err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("expected the download to fail within the header timeout, took %v", elapsed)
+			}
+		})
+	}
+}
+
+// TestDownloaderDialTimeout tests that an unreachable host fails within the dial timeout instead of the request timeout
+func TestDownloaderDialTimeout(t *testing.T) {
+	d := NewDownloaderWithConfig(config.DownloadConfig{
+		RequestTimeoutSeconds: 30,
+		Transport:             config.TransportConfig{DialTimeoutSeconds: 1},
+	})
+	d.downloadsDir = t.TempDir()
+	// a proxy from the environment would accept the connection in its place
+	d.transport.Proxy = nil
+	if dialer := d.dialer(); dialer.Timeout != time.Second || dialer.KeepAlive != dialKeepAlive {
+		t.Errorf("expected a dialer with a 1s timeout and keep-alive, got %v and %v", dialer.Timeout, dialer.KeepAlive)
+	}
+
+	// a blackhole address from TEST-NET-1: connection attempts go unanswered,
+	// unless the network of the test refuses or routes them right away
+	const addr = "192.0.2.1:80"
+	if conn, err := net.DialTimeout("tcp", addr, 100*time.Millisecond); err == nil {
+		conn.Close()
+		t.Skip("the unroutable address is reachable here")
+	} else if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+		t.Skipf("the unroutable address fails at once here: %v", err)
+	}
+
+	start := time.Now()
+	_, err := d.DownloadFile(context.Background(), "http://"+addr+"/file.txt", "file.txt")
+	if err == nil {
+		t.Fatal("expected an error, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected the download to fail within the dial timeout, took %v (%v)", elapsed, err)
+	}
+	if code := errorCode(err); code != ErrorCodeTimeout {
+		t.Errorf("expected error code %s, got %s (%v)", ErrorCodeTimeout, code, err)
+	}
+}
+
+// TestDownloaderTransportToggles tests that the protocol toggles are applied to the shared transport
+func TestDownloaderTransportToggles(t *testing.T) {
+	tests := []struct {
+		name                string
+		cfg                 config.TransportConfig
+		expectedHTTP2       bool
+		expectedNoKeepAlive bool
+		expectedNoCompress  bool
+	}{
+		{
+			name:          "defaults",
+			expectedHTTP2: true,
+		},
+		{
+			name:          "force http1",
+			cfg:           config.TransportConfig{ForceHTTP1: true},
+			expectedHTTP2: false,
+		},
+		{
+			name:                "disable keep-alives",
+			cfg:                 config.TransportConfig{DisableKeepAlives: true},
+			expectedHTTP2:       true,
+			expectedNoKeepAlive: true,
+		},
+		{
+			name:               "disable compression",
+			cfg:                config.TransportConfig{DisableCompression: true},
+			expectedHTTP2:      true,
+			expectedNoCompress: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDownloaderWithConfig(config.DownloadConfig{Transport: tt.cfg})
+			tr := d.transport
+
+			// HTTP/2 is off exactly when TLSNextProto is a non-nil map without "h2"
+			http2 := tr.TLSNextProto == nil || tr.TLSNextProto["h2"] != nil
+			if http2 != tt.expectedHTTP2 {
+				t.Errorf("expected HTTP/2 enabled %v, got TLSNextProto %v", tt.expectedHTTP2, tr.TLSNextProto)
+			}
+			if !tt.expectedHTTP2 && tr.ForceAttemptHTTP2 {
+				t.Error("expected ForceAttemptHTTP2 to be off")
+			}
+			if tr.DisableKeepAlives != tt.expectedNoKeepAlive {
+				t.Errorf("expected DisableKeepAlives %v, got %v", tt.expectedNoKeepAlive, tr.DisableKeepAlives)
+			}
+			if tr.DisableCompression != tt.expectedNoCompress {
+				t.Errorf("expected DisableCompression %v, got %v", tt.expectedNoCompress, tr.DisableCompression)
+			}
+			if d.client().Transport.(*countingTransport).rt.(*requestTimeoutTransport).rt != tr {
+				t.Error("expected the client to use the shared transport")
+			}
+		})
+	}
+}
+
+// TestDownloaderCancellation tests that cancelling the context aborts an in-flight transfer
+func TestDownloaderCancellation(t *testing.T) {
+	tests := []struct {
+		name        string
+		ctx         func() (context.Context, context.CancelFunc)
+		cancelAfter time.Duration
+		expectedErr error
+	}{
+		{
+			name: "cancelled",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+			cancelAfter: 50 * time.Millisecond,
+			expectedErr: context.Canceled,
+		},
+		{
+			name: "deadline exceeded",
+			ctx: func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 50*time.Millisecond)
+			},
+			expectedErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// stream forever until the client goes away
+				for {
+					if _, err := io.WriteString(w, "chunk"); err != nil {
+						return
+					}
+					w.(http.Flusher).Flush()
+					select {
+					case <-time.After(10 * time.Millisecond):
+					case <-r.Context().Done():
+						return
+					}
+				}
+			}))
+			defer srv.Close()
+
+			d := NewDownloader()
+			tmpDir := t.TempDir()
+			d.downloadsDir = tmpDir
+
+			ctx, cancel := tt.ctx()
+			defer cancel()
+			if tt.cancelAfter > 0 {
+				time.AfterFunc(tt.cancelAfter, cancel)
+			}
+
+			start := time.Now()
+			_, err := d.DownloadFile(ctx, srv.URL, "file.txt")
+
+			if !errors.Is(err, tt.expectedErr) {
+				t.Errorf("expected %v, got %v", tt.expectedErr, err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected the transfer to abort promptly, took %v", elapsed)
+			}
+			if entries, _ := os.ReadDir(tmpDir); len(entries) != 0 {
+				t.Errorf("expected partial file to be removed, found %d entries", len(entries))
+			}
+		})
+	}
+}
+
+// TestDownloaderUserAgent tests User-Agent precedence: task over config over default
+func TestDownloaderUserAgent(t *testing.T) {
+	tests := []struct {
+		name       string
+		configUA   string
+		taskUA     string
+		expectedUA string
+	}{
+		{
+			name:       "default",
+			expectedUA: version.UserAgent(),
+		},
+		{
+			name:       "config override",
+			configUA:   "Mozilla/5.0 (compatible; Mirror/2.0)",
+			expectedUA: "Mozilla/5.0 (compatible; Mirror/2.0)",
+		},
+		{
+			name:       "task override wins",
+			configUA:   "Mozilla/5.0 (compatible; Mirror/2.0)",
+			taskUA:     "CDN-Friendly/1.0",
+			expectedUA: "CDN-Friendly/1.0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			seen := make(map[string]string)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				key := r.Method
+				if r.Header.Get("Range") != "" {
+					key = "RANGE"
+				}
+				mu.Lock()
+				seen[key] = r.UserAgent()
+				mu.Unlock()
+				if r.Method == "HEAD" {
+					// force the ranged GET fallback so all three requests are covered
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				io.WriteString(w, "data")
+			}))
+			defer srv.Close()
+
+			d := NewDownloaderWithConfig(config.DownloadConfig{UserAgent: tt.configUA})
+			d.downloadsDir = t.TempDir()
+			opts := DownloadOptions{UserAgent: tt.taskUA}
+
+			if _, err := d.GetFileSizeWithOptions(context.Background(), srv.URL+"/file.txt", opts); err != nil {
+				t.Fatalf("GetFileSize error: %v", err)
+			}
+			if _, err := d.DownloadFileWithOptions(context.Background(), srv.URL+"/file.txt", "file.txt", opts); err != nil {
+				t.Fatalf("DownloadFile error: %v", err)
+			}
+
+			for _, key := range []string{"HEAD", "RANGE", "GET"} {
+				if seen[key] != tt.expectedUA {
+					t.Errorf("%s: expected User-Agent %q, got %q", key, tt.expectedUA, seen[key])
+				}
+			}
+		})
+	}
+}
+
+// TestDownloaderDecompress tests opt-in gzip/deflate decoding
+func TestDownloaderDecompress(t *testing.T) {
+	content := "hello compressed world"
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write([]byte(content))
+	gw.Close()
+
+	var zl bytes.Buffer
+	zw := zlib.NewWriter(&zl)
+	zw.Write([]byte(content))
+	zw.Close()
+
+	tests := []struct {
+		name             string
+		encoding         string
+		body             []byte
+		decompress       bool
+		expectedFilename string
+		expectedContent  []byte
+		expectedErr      error
+	}{
+		{
+			name:             "gzip decoded",
+			encoding:         "gzip",
+			body:             gz.Bytes(),
+			decompress:       true,
+			expectedFilename: "data.txt",
+			expectedContent:  []byte(content),
+		},
+		{
+			name:             "deflate decoded",
+			encoding:         "deflate",
+			body:             zl.Bytes(),
+			decompress:       true,
+			expectedFilename: "data.txt.gz",
+			expectedContent:  []byte(content),
+		},
+		{
+			name:             "gzip kept raw",
+			encoding:         "gzip",
+			body:             gz.Bytes(),
+			decompress:       false,
+			expectedFilename: "data.txt.gz",
+			expectedContent:  gz.Bytes(),
+		},
+		{
+			name:        "declared gzip but plain bytes",
+			encoding:    "gzip",
+			body:        []byte(content),
+			decompress:  true,
+			expectedErr: ErrBadContentEncoding,
+		},
+		{
+			name:        "truncated gzip",
+			encoding:    "gzip",
+			body:        gz.Bytes()[:gz.Len()-6],
+			decompress:  true,
+			expectedErr: ErrBadContentEncoding,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Encoding", tt.encoding)
+				w.Write(tt.body)
+			}))
+			defer srv.Close()
+
+			d := NewDownloader()
+			tmpDir := t.TempDir()
+			d.downloadsDir = tmpDir
+
+			name, err := d.DownloadFileWithOptions(context.Background(), srv.URL, "data.txt.gz", DownloadOptions{Decompress: tt.decompress})
+
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected %v, got %v", tt.expectedErr, err)
+				}
+				if entries, _ := os.ReadDir(tmpDir); len(entries) != 0 {
+					t.Errorf("expected partial file to be removed, found %d entries", len(entries))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if name != tt.expectedFilename {
+				t.Errorf("expected filename %s, got %s", tt.expectedFilename, name)
+			}
+			got, err := os.ReadFile(filepath.Join(tmpDir, name))
+			if err != nil {
+				t.Fatalf("failed to read downloaded file: %v", err)
+			}
+			if !bytes.Equal(got, tt.expectedContent) {
+				t.Errorf("unexpected content: %q", got)
+			}
+		})
+	}
+}
+
+// TestDownloaderExtensionPolicy tests reconciling the URL extension with the Content-Type
+func TestDownloaderExtensionPolicy(t *testing.T) {
+	tests := []struct {
+		name             string
+		filename         string
+		contentType      string
+		policy           string
+		expectedFilename string
+	}{
+		{
+			name:             "txt url returning zip trusts url",
+			filename:         "archive.txt",
+			contentType:      "application/zip",
+			policy:           ExtensionPolicyTrustURL,
+			expectedFilename: "archive.txt",
+		},
+		{
+			name:             "txt url returning zip trusts server",
+			filename:         "archive.txt",
+			contentType:      "application/zip",
+			policy:           ExtensionPolicyTrustServer,
+			expectedFilename: "archive.zip",
+		},
+		{
+			name:             "matching extension is kept",
+			filename:         "movie.mp4",
+			contentType:      "video/mp4",
+			policy:           ExtensionPolicyTrustServer,
+			expectedFilename: "movie.mp4",
+		},
+		{
+			name:             "alias extension is kept",
+			filename:         "photo.jpeg",
+			contentType:      "image/jpeg",
+			policy:           ExtensionPolicyTrustServer,
+			expectedFilename: "photo.jpeg",
+		},
+		{
+			name:             "missing extension is added",
+			filename:         "download",
+			contentType:      "application/x-tar",
+			policy:           ExtensionPolicyTrustURL,
+			expectedFilename: "download.tar",
+		},
+		{
+			name:             "html with parameters",
+			filename:         "index",
+			contentType:      "text/html; charset=utf-8",
+			policy:           ExtensionPolicyTrustURL,
+			expectedFilename: "index.html",
+		},
+		{
+			name:             "generic type leaves name alone",
+			filename:         "data.zip",
+			contentType:      "application/octet-stream",
+			policy:           ExtensionPolicyTrustServer,
+			expectedFilename: "data.zip",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				io.WriteString(w, "content")
+			}))
+			defer srv.Close()
+
+			d := NewDownloaderWithConfig(config.DownloadConfig{ExtensionPolicy: tt.policy})
+			tmpDir := t.TempDir()
+			d.downloadsDir = tmpDir
+
+			filename, err := d.DownloadFile(context.Background(), srv.URL, tt.filename)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if filename != tt.expectedFilename {
+				t.Errorf("expected filename %s, got %s", tt.expectedFilename, filename)
+			}
+			if _, err := os.Stat(filepath.Join(tmpDir, filename)); err != nil {
+				t.Errorf("file not found: %v", err)
+			}
+		})
+	}
+}
+
+// TestDownloaderSniffContentType tests naming files by their first bytes under the sniff policy
+func TestDownloaderSniffContentType(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 1000)...)
+
+	tests := []struct {
+		name             string
+		filename         string
+		contentType      string
+		content          []byte
+		resumeAt         int
+		expectedFilename string
+	}{
+		{
+			name:             "png served as octet-stream",
+			filename:         "image",
+			contentType:      "application/octet-stream",
+			content:          png,
+			expectedFilename: "image.png",
+		},
+		{
+			name:             "content wins over a wrong header",
+			filename:         "image",
+			contentType:      "text/html",
+			content:          png,
+			expectedFilename: "image.png",
+		},
+		{
+			name:             "known extension is kept",
+			filename:         "photo.jpg",
+			contentType:      "application/octet-stream",
+			content:          png,
+			expectedFilename: "photo.jpg",
+		},
+		{
+			name:             "plain text falls back to the header",
+			filename:         "export",
+			contentType:      "text/csv",
+			content:          []byte("a,b\n1,2\n"),
+			expectedFilename: "export.csv",
+		},
+		{
+			name:             "empty file falls back to the header",
+			filename:         "empty",
+			contentType:      "application/pdf",
+			content:          []byte{},
+			expectedFilename: "empty.pdf",
+		},
+		{
+			name:             "resumed download sniffs the part file",
+			filename:         "image",
+			contentType:      "application/octet-stream",
+			content:          png,
+			resumeAt:         4,
+			expectedFilename: "image.png",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				body := tt.content
+				var start int
+				if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start); err == nil {
+					w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(body)-1, len(body)))
+					w.WriteHeader(http.StatusPartialContent)
+					body = body[start:]
+				}
+				w.Write(body)
+			}))
+			defer srv.Close()
+
+			d := NewDownloaderWithConfig(config.DownloadConfig{ExtensionPolicy: ExtensionPolicySniff})
+			tmpDir := t.TempDir()
+			d.downloadsDir = tmpDir
+			opts := DownloadOptions{}
+			if tt.resumeAt > 0 {
+				opts.PartFile = filepath.Join(tmpDir, ".task.0.part")
+				opts.Offset = int64(tt.resumeAt)
+				if err := os.WriteFile(opts.PartFile, tt.content[:tt.resumeAt], 0644); err != nil {
+					t.Fatalf("failed to write part file: %v", err)
+				}
+			}
+
+			filename, err := d.DownloadFileWithOptions(context.Background(), srv.URL, tt.filename, opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if filename != tt.expectedFilename {
+				t.Errorf("expected filename %s, got %s", tt.expectedFilename, filename)
+			}
+			got, err := os.ReadFile(filepath.Join(tmpDir, filename))
+			if err != nil {
+				t.Fatalf("file not found: %v", err)
+			}
+			if !bytes.Equal(got, tt.content) {
+				t.Errorf("expected %d bytes of content, got %d", len(tt.content), len(got))
+			}
+		})
+	}
+}
+
+// TestDownloaderLayout tests flat and per-task download directories
+func TestDownloaderLayout(t *testing.T) {
+	tests := []struct {
+		name          string
+		layout        string
+		expectedFiles []string
+	}{
+		{
+			name:          "per task",
+			layout:        LayoutPerTask,
+			expectedFiles: []string{filepath.Join("task_a", "report.txt"), filepath.Join("task_b", "report.txt")},
+		},
+		{
+			name:          "flat",
+			layout:        LayoutFlat,
+			expectedFiles: []string{"report.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				io.WriteString(w, r.URL.Query().Get("task"))
+			}))
+			defer srv.Close()
+
+			d := NewDownloaderWithConfig(config.DownloadConfig{Layout: tt.layout})
+			tmpDir := t.TempDir()
+			d.downloadsDir = tmpDir
+
+			for _, taskID := range []string{"task_a", "task_b"} {
+				if _, err := d.DownloadFileWithOptions(context.Background(), srv.URL+"?task="+taskID, "report.txt", DownloadOptions{TaskID: taskID}); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			for _, name := range tt.expectedFiles {
+				if _, err := os.Stat(filepath.Join(tmpDir, name)); err != nil {
+					t.Errorf("expected file %s: %v", name, err)
+				}
+			}
+			if tt.layout == LayoutPerTask {
+				got, _ := os.ReadFile(filepath.Join(tmpDir, "task_a", "report.txt"))
+				if string(got) != "task_a" {
+					t.Errorf("expected task_a content to survive, got %q", got)
+				}
+			}
+		})
+	}
+}
+
+// TestDownloaderGetFileSizeFallback tests the ranged GET fallback when HEAD is rejected
+func TestDownloaderGetFileSizeFallback(t *testing.T) {
+	tests := []struct {
+		name         string
+		contentRange string
+		rangeStatus  int
+		expectedSize int64
+		expectError  bool
+	}{
+		{
+			name:         "size from content range",
+			contentRange: "bytes 0-0/1234",
+			rangeStatus:  http.StatusPartialContent,
+			expectedSize: 1234,
+		},
+		{
+			name:         "unknown total",
+			contentRange: "bytes 0-0/*",
+			rangeStatus:  http.StatusPartialContent,
+			expectedSize: 0,
+		},
+		{
+			name:        "range request fails",
+			rangeStatus: http.StatusNotFound,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "HEAD" {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				if r.Header.Get("Range") != "bytes=0-0" {
+					t.Errorf("expected Range header, got %q", r.Header.Get("Range"))
+				}
+				if tt.contentRange != "" {
+					w.Header().Set("Content-Range", tt.contentRange)
+				}
+				w.WriteHeader(tt.rangeStatus)
+				io.WriteString(w, "x")
+			}))
+			defer srv.Close()
+
+			d := NewDownloader()
+			size, err := d.GetFileSize(context.Background(), srv.URL)
+
+			if tt.expectError {
+				var statusErr *HTTPStatusError
+				if !errors.As(err, &statusErr) {
+					t.Errorf("expected HTTPStatusError, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if size != tt.expectedSize {
+				t.Errorf("expected size %d, got %d", tt.expectedSize, size)
+			}
+		})
+	}
+}
+
+// TestDownloaderPreserveMtime tests that saved files take their mtime from Last-Modified when enabled
+func TestDownloaderPreserveMtime(t *testing.T) {
+	lastModified := time.Date(2020, time.March, 4, 5, 6, 7, 0, time.UTC)
+	tests := []struct {
+		name           string
+		preserve       bool
+		header         string
+		expectPreserve bool
+	}{
+		{
+			name:           "valid header",
+			preserve:       true,
+			header:         lastModified.Format(http.TimeFormat),
+			expectPreserve: true,
+		},
+		{
+			name:     "disabled",
+			preserve: false,
+			header:   lastModified.Format(http.TimeFormat),
+		},
+		{
+			name:     "missing header",
+			preserve: true,
+		},
+		{
+			name:     "unparseable header",
+			preserve: true,
+			header:   "yesterday",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.header != "" {
+					w.Header().Set("Last-Modified", tt.header)
+				}
+				io.WriteString(w, "archived content")
+			}))
+			defer srv.Close()
+
+			d := NewDownloader()
+			tmpDir := t.TempDir()
+			d.downloadsDir = tmpDir
+			d.preserveMtime = tt.preserve
+
+			start := time.Now()
+			filename, err := d.DownloadFile(context.Background(), srv.URL+"/file.txt", "file.txt")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			info, err := os.Stat(filepath.Join(tmpDir, filename))
+			if err != nil {
+				t.Fatalf("file not found: %v", err)
+			}
+
+			if tt.expectPreserve {
+				if diff := info.ModTime().Sub(lastModified).Abs(); diff > time.Second {
+					t.Errorf("expected mtime %v, got %v", lastModified, info.ModTime())
+				}
+				return
+			}
+			if info.ModTime().Before(start.Add(-time.Second)) {
+				t.Errorf("expected the download time as mtime, got %v", info.ModTime())
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+
+	"filedownloader-20240926/internal/config"
+)
+
+// ErrBlockedAddress is returned when the egress guard refuses to connect to an address
+var ErrBlockedAddress = errors.New("destination address is blocked")
+
+// defaultBlockedRanges are refused by the egress guard unless allowlisted:
+// loopback, link-local (including cloud metadata endpoints), private and
+// unspecified addresses
+var defaultBlockedRanges = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("fe80::/10"),
+	netip.MustParsePrefix("fc00::/7"),
+}
+
+// egressGuard decides which addresses downloads may connect to
+type egressGuard struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// SetEgressGuard makes the downloader refuse connections to loopback,
+// link-local and private addresses, plus the configured deny list, unless
+// they are allowlisted. The check runs on the resolved address of every
+// connection, so it also covers redirects and DNS names pointing inward.
+// With a proxy, the connection checked is the one to the proxy.
+func (d *Downloader) SetEgressGuard(cfg config.EgressGuardConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	guard, err := newEgressGuard(cfg.Allow, cfg.Deny)
+	if err != nil {
+		return err
+	}
+	dialer := d.dialer()
+	dialer.Control = guard.control
+	d.transport.DialContext = dialer.DialContext
+	return nil
+}
+
+// SetEgressGuard applies the egress rules of downloads to callbacks, so that
+// a callback URL cannot reach internal services either. Redirects of a
+// callback open new connections and are checked the same way.
+func (n *Notifier) SetEgressGuard(cfg config.EgressGuardConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+	guard, err := newEgressGuard(cfg.Allow, cfg.Deny)
+	if err != nil {
+		return err
+	}
+	dialer := &net.Dialer{Timeout: callbackTimeout, Control: guard.control}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	n.client.Transport = transport
+	return nil
+}
+
+// SetCallbackEgressGuard applies the egress rules to the task callbacks of the pool
+func (wp *WorkerPool) SetCallbackEgressGuard(cfg config.EgressGuardConfig) error {
+	return wp.notifier.SetEgressGuard(cfg)
+}
+
+// newEgressGuard parses the allow and deny lists; entries are CIDR prefixes or single addresses
+func newEgressGuard(allow, deny []string) (*egressGuard, error) {
+	g := &egressGuard{deny: append([]netip.Prefix(nil), defaultBlockedRanges...)}
+	for _, entry := range allow {
+		p, err := parsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid egress allow entry: %w", err)
+		}
+		g.allow = append(g.allow, p)
+	}
+	for _, entry := range deny {
+		p, err := parsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid egress deny entry: %w", err)
+		}
+		g.deny = append(g.deny, p)
+	}
+	return g, nil
+}
+
+// parsePrefix parses a CIDR prefix, treating a bare address as a single-host prefix
+func parsePrefix(entry string) (netip.Prefix, error) {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "/") {
+		p, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// check returns ErrBlockedAddress when addr is denied and not allowlisted
+func (g *egressGuard) check(addr netip.Addr) error {
+	// zoned addresses never match a prefix, so the zone is dropped first
+	addr = addr.Unmap().WithZone("")
+	for _, p := range g.allow {
+		if p.Contains(addr) {
+			return nil
+		}
+	}
+	for _, p := range g.deny {
+		if p.Contains(addr) {
+			return fmt.Errorf("%w: %s", ErrBlockedAddress, addr)
+		}
+	}
+	return nil
+}
+
+// control is a net.Dialer hook run after name resolution, right before connecting
+func (g *egressGuard) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	return g.check(addr)
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"filedownloader-20240926/internal/config"
+)
+
+// TestEgressGuardCheck tests which addresses the egress guard blocks
+func TestEgressGuardCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    string
+		allow   []string
+		deny    []string
+		blocked bool
+	}{
+		{name: "public IPv4", addr: "93.184.216.34", blocked: false},
+		{name: "loopback", addr: "127.0.0.1", blocked: true},
+		{name: "metadata endpoint", addr: "169.254.169.254", blocked: true},
+		{name: "RFC1918 10/8", addr: "10.1.2.3", blocked: true},
+		{name: "RFC1918 172.16/12", addr: "172.31.255.1", blocked: true},
+		{name: "outside 172.16/12", addr: "172.32.0.1", blocked: false},
+		{name: "RFC1918 192.168/16", addr: "192.168.1.1", blocked: true},
+		{name: "unspecified", addr: "0.0.0.0", blocked: true},
+		{name: "IPv6 loopback", addr: "::1", blocked: true},
+		{name: "IPv6 link-local with zone", addr: "fe80::1%eth0", blocked: true},
+		{name: "IPv6 unique local", addr: "fd00::1", blocked: true},
+		{name: "IPv4-mapped loopback", addr: "::ffff:127.0.0.1", blocked: true},
+		{name: "public IPv6", addr: "2606:4700::1111", blocked: false},
+		{name: "allowlisted range", addr: "10.1.2.3", allow: []string{"10.1.0.0/16"}, blocked: false},
+		{name: "allowlisted address", addr: "127.0.0.1", allow: []string{"127.0.0.1"}, blocked: false},
+		{name: "denylisted public", addr: "93.184.216.34", deny: []string{"93.184.216.0/24"}, blocked: true},
+		{name: "allow wins over deny", addr: "93.184.216.34", allow: []string{"93.184.216.34"}, deny: []string{"93.184.216.0/24"}, blocked: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := newEgressGuard(tt.allow, tt.deny)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			err = g.check(netip.MustParseAddr(tt.addr))
+			if blocked := errors.Is(err, ErrBlockedAddress); blocked != tt.blocked {
+				t.Errorf("expected blocked=%v, got %v (%v)", tt.blocked, blocked, err)
+			}
+		})
+	}
+}
+
+// TestDownloaderEgressGuard tests that the guard covers size probes, downloads and redirects
+func TestDownloaderEgressGuard(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "content")
+	}))
+	defer target.Close()
+
+	// the redirecting server listens on another loopback address, so only it can be allowlisted
+	listener, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("cannot listen on 127.0.0.2: %v", err)
+	}
+	redirect := httptest.NewUnstartedServer(http.RedirectHandler(target.URL+"/file.txt", http.StatusFound))
+	redirect.Listener.Close()
+	redirect.Listener = listener
+	redirect.Start()
+	defer redirect.Close()
+
+	tests := []struct {
+		name        string
+		guard       config.EgressGuardConfig
+		url         string
+		expectBlock bool
+	}{
+		{
+			name:        "loopback blocked",
+			guard:       config.EgressGuardConfig{Enabled: true},
+			url:         target.URL + "/file.txt",
+			expectBlock: true,
+		},
+		{
+			name:        "redirect to blocked address",
+			guard:       config.EgressGuardConfig{Enabled: true, Allow: []string{"127.0.0.2"}},
+			url:         redirect.URL + "/file.txt",
+			expectBlock: true,
+		},
+		{
+			name:  "allowlisted",
+			guard: config.EgressGuardConfig{Enabled: true, Allow: []string{"127.0.0.0/8"}},
+			url:   redirect.URL + "/file.txt",
+		},
+		{
+			name:  "disabled",
+			guard: config.EgressGuardConfig{Enabled: false},
+			url:   target.URL + "/file.txt",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDownloader()
+			d.downloadsDir = t.TempDir()
+			if err := d.SetEgressGuard(tt.guard); err != nil {
+				t.Fatalf("failed to set egress guard: %v", err)
+			}
+
+			_, sizeErr := d.GetFileSize(context.Background(), tt.url)
+			_, downloadErr := d.DownloadFile(context.Background(), tt.url, "file.txt")
+			for _, err := range []error{sizeErr, downloadErr} {
+				if blocked := errors.Is(err, ErrBlockedAddress); blocked != tt.expectBlock {
+					t.Errorf("expected blocked=%v, got %v", tt.expectBlock, err)
+				}
+			}
+			if tt.expectBlock && errorCode(downloadErr) != ErrorCodeBlocked {
+				t.Errorf("expected error code %s, got %s", ErrorCodeBlocked, errorCode(downloadErr))
+			}
+		})
+	}
+}
+
+// TestNewEgressGuardInvalidEntry tests that malformed list entries are rejected
+func TestNewEgressGuardInvalidEntry(t *testing.T) {
+	if _, err := newEgressGuard([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Errorf("expected error for invalid allow entry")
+	}
+	if _, err := newEgressGuard(nil, []string{"not-an-ip"}); err == nil {
+		t.Errorf("expected error for invalid deny entry")
+	}
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ExtensionPolicyTrustURL keeps the extension from the URL when it contradicts the Content-Type
+	ExtensionPolicyTrustURL = "trust_url"
+	// ExtensionPolicyTrustServer replaces a contradicting extension with one matching the Content-Type
+	ExtensionPolicyTrustServer = "trust_server"
+	// ExtensionPolicySniff derives a missing extension from the first bytes of the
+	// content instead of the Content-Type
+	ExtensionPolicySniff = "sniff"
+)
+
+// preferredExtensions maps media types to their canonical extension. The
+// system mime table is only consulted for types missing here, since it lists
+// extensions alphabetically (e.g. ".jfif" before ".jpg").
+var preferredExtensions = map[string]string{
+	"text/html":                    ".html",
+	"text/plain":                   ".txt",
+	"text/css":                     ".css",
+	"text/csv":                     ".csv",
+	"text/xml":                     ".xml",
+	"text/javascript":              ".js",
+	"application/javascript":       ".js",
+	"application/json":             ".json",
+	"application/xml":              ".xml",
+	"application/pdf":              ".pdf",
+	"application/zip":              ".zip",
+	"application/x-tar":            ".tar",
+	"application/gzip":             ".gz",
+	"application/x-gzip":           ".gz",
+	"application/x-bzip2":          ".bz2",
+	"application/x-xz":             ".xz",
+	"application/x-7z-compressed":  ".7z",
+	"application/vnd.rar":          ".rar",
+	"application/x-rar-compressed": ".rar",
+	"image/png":                    ".png",
+	"image/jpeg":                   ".jpg",
+	"image/gif":                    ".gif",
+	"image/webp":                   ".webp",
+	"image/svg+xml":                ".svg",
+	"image/x-icon":                 ".ico",
+	"video/mp4":                    ".mp4",
+	"video/webm":                   ".webm",
+	"video/quicktime":              ".mov",
+	"audio/mpeg":                   ".mp3",
+	"audio/ogg":                    ".ogg",
+	"audio/wav":                    ".wav",
+}
+
+// extensionAliases lists extensions that are equivalent to the preferred one
+var extensionAliases = map[string][]string{
+	".html": {".htm"},
+	".jpg":  {".jpeg", ".jpe"},
+	".txt":  {".text", ".log"},
+	".gz":   {".tgz"},
+}
+
+// mediaTypeOf returns the lowercased media type of a Content-Type header without parameters
+func mediaTypeOf(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	}
+	return strings.ToLower(mediaType)
+}
+
+// extensionForType returns the canonical extension for a media type, or "" when unknown
+func extensionForType(mediaType string) string {
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		return exts[0]
+	}
+	return ""
+}
+
+// knownExtension reports whether ext is a recognised file extension rather than
+// an arbitrary suffix such as a version number
+func knownExtension(ext string) bool {
+	if ext == "" {
+		return false
+	}
+	for _, preferred := range preferredExtensions {
+		if ext == preferred {
+			return true
+		}
+	}
+	for _, aliases := range extensionAliases {
+		for _, alias := range aliases {
+			if ext == alias {
+				return true
+			}
+		}
+	}
+	return mime.TypeByExtension(ext) != ""
+}
+
+// extensionMatchesType reports whether ext is a valid extension for mediaType
+func extensionMatchesType(ext, mediaType string) bool {
+	preferred := extensionForType(mediaType)
+	if ext == preferred {
+		return true
+	}
+	for _, alias := range extensionAliases[preferred] {
+		if ext == alias {
+			return true
+		}
+	}
+	if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+		for _, e := range exts {
+			if ext == e {
+				return true
+			}
+		}
+	}
+	return mediaTypeOf(mime.TypeByExtension(ext)) == mediaType
+}
+
+// genericMediaType reports whether a media type carries no information about the file format
+func genericMediaType(mediaType string) bool {
+	return mediaType == "" || mediaType == "application/octet-stream" || mediaType == "binary/octet-stream"
+}
+
+// resolveExtension reconciles the extension of name with the server Content-Type.
+// A name without a recognised extension gets one derived from the type. When the
+// extension contradicts the type, mismatch is true and the name is only rewritten
+// under ExtensionPolicyTrustServer.
+func resolveExtension(name, contentType, policy string) (resolved string, mismatch bool) {
+	mediaType := mediaTypeOf(contentType)
+	if genericMediaType(mediaType) {
+		return name, false
+	}
+
+	serverExt := extensionForType(mediaType)
+	ext := strings.ToLower(filepath.Ext(name))
+
+	if !knownExtension(ext) {
+		return name + serverExt, false
+	}
+	if serverExt == "" || extensionMatchesType(ext, mediaType) {
+		return name, false
+	}
+
+	if policy == ExtensionPolicyTrustServer {
+		return strings.TrimSuffix(name, filepath.Ext(name)) + serverExt, true
+	}
+	return name, true
+}
@@ -0,0 +1,227 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filedownloader-20240926/internal/config"
+	"filedownloader-20240926/internal/domain"
+)
+
+var (
+	// ErrUnsafeArchive is returned for archive entries that would land outside the extraction directory
+	ErrUnsafeArchive = errors.New("unsafe archive entry")
+	// ErrArchiveTooLarge is returned when an archive holds more data or files than extraction allows
+	ErrArchiveTooLarge = errors.New("archive exceeds extraction limits")
+)
+
+// SetExtract configures extracting downloaded archives. Tasks created with
+// extract are extracted even when it is not enabled. Call it before Start.
+func (wp *WorkerPool) SetExtract(cfg config.ExtractConfig) {
+	wp.extract = cfg
+}
+
+// archiveBase returns the name of a supported archive without its extension,
+// or "" when the file is not one
+func archiveBase(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return ""
+}
+
+// extractDownload unpacks a saved archive into a new directory next to it and
+// records the outcome on the file. A file that is not an archive is left
+// alone; a failed extraction leaves no directory behind and keeps the archive.
+func (wp *WorkerPool) extractDownload(log *slog.Logger, taskID, savedName string, file *domain.File) {
+	base := archiveBase(savedName)
+	if base == "" {
+		return
+	}
+	dir := wp.downloader.TaskDir(taskID)
+	archive := filepath.Join(dir, savedName)
+
+	dest, destName, err := createUniqueDir(dir, base)
+	if err == nil {
+		file.ExtractedFiles, err = extractArchive(archive, dest, wp.extract.MaxSizeMB<<20, wp.extract.MaxFiles)
+		if err != nil {
+			os.RemoveAll(dest)
+		}
+	}
+	if err != nil {
+		log.Warn("Failed to extract archive", "filename", savedName, "error", err)
+		file.ExtractedFiles = 0
+		file.ExtractError = err.Error()
+		return
+	}
+
+	file.ExtractedTo = destName
+	file.ExtractError = ""
+	log.Info("Archive extracted", "filename", savedName, "directory", destName, "files", file.ExtractedFiles)
+	if !wp.extract.KeepArchive {
+		if err := os.Remove(archive); err != nil {
+			log.Warn("Failed to delete extracted archive", "filename", savedName, "error", err)
+		}
+	}
+}
+
+// createUniqueDir creates a new directory named name in parent, adding a
+// numeric suffix when the name is taken
+func createUniqueDir(parent, name string) (string, string, error) {
+	candidate := name
+	for i := 1; ; i++ {
+		path := filepath.Join(parent, candidate)
+		err := os.Mkdir(path, 0755)
+		if err == nil {
+			return path, candidate, nil
+		}
+		if !errors.Is(err, os.ErrExist) || i > maxNameCollisions {
+			return "", name, fmt.Errorf("failed to create directory %s: %w", path, err)
+		}
+		candidate = fmt.Sprintf("%s (%d)", name, i)
+	}
+}
+
+// extractArchive unpacks a .zip or .tar.gz archive into dest and returns the
+// number of files written. Entries that would escape dest are rejected, links
+// and special files are skipped, and extraction stops once maxBytes of data
+// or maxFiles files are exceeded.
+func extractArchive(archive, dest string, maxBytes int64, maxFiles int) (int, error) {
+	w := &archiveWriter{dest: dest, maxBytes: maxBytes, remaining: maxBytes, maxFiles: maxFiles}
+	var err error
+	if strings.HasSuffix(strings.ToLower(archive), ".zip") {
+		err = w.extractZip(archive)
+	} else {
+		err = w.extractTarGz(archive)
+	}
+	return w.files, err
+}
+
+// archiveWriter writes archive entries below dest within the extraction limits
+type archiveWriter struct {
+	dest      string
+	maxBytes  int64
+	remaining int64
+	maxFiles  int
+	files     int
+}
+
+func (w *archiveWriter) extractZip(archive string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return fmt.Errorf("failed to open zip archive: %w", err)
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		mode := f.Mode()
+		switch {
+		case mode.IsDir():
+			if err := w.mkdir(f.Name); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			rc, err := f.Open()
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", f.Name, err)
+			}
+			err = w.writeFile(f.Name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *archiveWriter) extractTarGz(archive string) error {
+	f, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("failed to open gzip stream: %w", err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar archive: %w", err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := w.mkdir(hdr.Name); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := w.writeFile(hdr.Name, tr); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// target returns where an entry goes, refusing names that are absolute or
+// climb out of dest
+func (w *archiveWriter) target(name string) (string, error) {
+	clean := filepath.FromSlash(strings.TrimSuffix(name, "/"))
+	if !filepath.IsLocal(clean) {
+		return "", fmt.Errorf("%w: %q", ErrUnsafeArchive, name)
+	}
+	return filepath.Join(w.dest, clean), nil
+}
+
+func (w *archiveWriter) mkdir(name string) error {
+	path, err := w.target(name)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(path, 0755)
+}
+
+func (w *archiveWriter) writeFile(name string, r io.Reader) error {
+	path, err := w.target(name)
+	if err != nil {
+		return err
+	}
+	if w.files++; w.files > w.maxFiles {
+		return fmt.Errorf("%w: more than %d files", ErrArchiveTooLarge, w.maxFiles)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	defer out.Close()
+	n, err := io.Copy(out, io.LimitReader(r, w.remaining+1))
+	w.remaining -= n
+	if err != nil {
+		return fmt.Errorf("failed to extract %s: %w", name, err)
+	}
+	if w.remaining < 0 {
+		return fmt.Errorf("%w: more than %d bytes uncompressed", ErrArchiveTooLarge, w.maxBytes)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+package service
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filedownloader-20240926/internal/config"
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+)
+
+type archiveEntry struct {
+	name string
+	body string
+	link string
+}
+
+func buildZip(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatalf("failed to add %s: %v", e.name, err)
+		}
+		w.Write([]byte(e.body))
+	}
+	zw.Close()
+	return buf.Bytes()
+}
+
+func buildTarGz(t *testing.T, entries []archiveEntry) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.body)), Typeflag: tar.TypeReg}
+		if e.link != "" {
+			hdr = &tar.Header{Name: e.name, Linkname: e.link, Typeflag: tar.TypeSymlink}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("failed to add %s: %v", e.name, err)
+		}
+		tw.Write([]byte(e.body))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// TestExtractArchive tests unpacking archives within the limits and rejecting path traversal
+func TestExtractArchive(t *testing.T) {
+	tests := []struct {
+		name          string
+		archive       string
+		entries       []archiveEntry
+		maxBytes      int64
+		maxFiles      int
+		expectedFiles []string
+		expectedErr   error
+	}{
+		{
+			name:          "zip with nested files",
+			archive:       "pkg.zip",
+			entries:       []archiveEntry{{name: "a.txt", body: "a"}, {name: "dir/b.txt", body: "bb"}},
+			maxBytes:      1024,
+			maxFiles:      10,
+			expectedFiles: []string{"a.txt", "dir/b.txt"},
+		},
+		{
+			name:          "tar.gz skips links",
+			archive:       "pkg.tar.gz",
+			entries:       []archiveEntry{{name: "a.txt", body: "a"}, {name: "passwd", link: "/etc/passwd"}},
+			maxBytes:      1024,
+			maxFiles:      10,
+			expectedFiles: []string{"a.txt"},
+		},
+		{
+			name:        "zip slip",
+			archive:     "evil.zip",
+			entries:     []archiveEntry{{name: "../evil.txt", body: "x"}},
+			maxBytes:    1024,
+			maxFiles:    10,
+			expectedErr: ErrUnsafeArchive,
+		},
+		{
+			name:        "absolute tar path",
+			archive:     "evil.tgz",
+			entries:     []archiveEntry{{name: "/tmp/evil.txt", body: "x"}},
+			maxBytes:    1024,
+			maxFiles:    10,
+			expectedErr: ErrUnsafeArchive,
+		},
+		{
+			name:        "too many files",
+			archive:     "many.zip",
+			entries:     []archiveEntry{{name: "a", body: "a"}, {name: "b", body: "b"}, {name: "c", body: "c"}},
+			maxBytes:    1024,
+			maxFiles:    2,
+			expectedErr: ErrArchiveTooLarge,
+		},
+		{
+			name:        "too much data",
+			archive:     "big.tar.gz",
+			entries:     []archiveEntry{{name: "a", body: "0123456789"}, {name: "b", body: "0123456789"}},
+			maxBytes:    15,
+			maxFiles:    10,
+			expectedErr: ErrArchiveTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			data := buildTarGz(t, tt.entries)
+			if filepath.Ext(tt.archive) == ".zip" {
+				data = buildZip(t, tt.entries)
+			}
+			archive := filepath.Join(dir, tt.archive)
+			if err := os.WriteFile(archive, data, 0644); err != nil {
+				t.Fatalf("failed to write archive: %v", err)
+			}
+			dest := filepath.Join(dir, "out", "pkg")
+			os.MkdirAll(dest, 0755)
+
+			files, err := extractArchive(archive, dest, tt.maxBytes, tt.maxFiles)
+
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "out", "evil.txt")); err == nil {
+				t.Errorf("expected nothing written outside the destination")
+			}
+			if tt.expectedErr != nil {
+				return
+			}
+			if files != len(tt.expectedFiles) {
+				t.Errorf("expected %d files, got %d", len(tt.expectedFiles), files)
+			}
+			for _, name := range tt.expectedFiles {
+				if _, err := os.Stat(filepath.Join(dest, name)); err != nil {
+					t.Errorf("expected %s to be extracted: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+// TestWorkerPoolExtract tests that downloaded archives are extracted and recorded on the file
+func TestWorkerPoolExtract(t *testing.T) {
+	tests := []struct {
+		name          string
+		path          string
+		body          []byte
+		keepArchive   bool
+		expectedDir   string
+		expectedError bool
+	}{
+		{
+			name:        "zip kept",
+			path:        "/pkg.zip",
+			body:        buildZip(t, []archiveEntry{{name: "readme.txt", body: "hello"}}),
+			keepArchive: true,
+			expectedDir: "pkg",
+		},
+		{
+			name:        "tar.gz deleted after extraction",
+			path:        "/pkg.tar.gz",
+			body:        buildTarGz(t, []archiveEntry{{name: "readme.txt", body: "hello"}}),
+			expectedDir: "pkg",
+		},
+		{
+			name:          "corrupt archive",
+			path:          "/broken.zip",
+			body:          []byte("not a zip"),
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(tt.body)
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(1, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			wp.SetExtract(config.ExtractConfig{MaxSizeMB: 1, MaxFiles: 10, KeepArchive: tt.keepArchive})
+			wp.Start()
+			defer wp.Stop()
+
+			task, err := tm.CreateTaskWithOptions([]string{srv.URL + tt.path}, TaskOptions{Extract: true})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			wp.ProcessFiles(task.ID, task.Files)
+
+			var file domain.File
+			deadline := time.Now().Add(3 * time.Second)
+			for time.Now().Before(deadline) {
+				current, _ := tm.GetTask(task.ID)
+				if file = current.Files[0]; fileTerminal(file.Status) {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if file.Status != domain.StatusCompleted {
+				t.Fatalf("expected the download to complete, got %s (%s)", file.Status, file.Error)
+			}
+
+			dir := wp.downloader.TaskDir(task.ID)
+			_, archiveErr := os.Stat(filepath.Join(dir, file.Filename))
+			if tt.expectedError {
+				if file.ExtractError == "" || file.ExtractedTo != "" {
+					t.Errorf("expected an extraction error, got %+v", file)
+				}
+				if archiveErr != nil {
+					t.Errorf("expected the archive to stay after a failed extraction")
+				}
+				return
+			}
+			if file.ExtractedTo != tt.expectedDir || file.ExtractedFiles != 1 {
+				t.Fatalf("expected 1 file extracted to %s, got %d to %q (%s)", tt.expectedDir, file.ExtractedFiles, file.ExtractedTo, file.ExtractError)
+			}
+			if got, err := os.ReadFile(filepath.Join(dir, file.ExtractedTo, "readme.txt")); err != nil || string(got) != "hello" {
+				t.Errorf("expected the extracted file, got %q, %v", got, err)
+			}
+			if kept := archiveErr == nil; kept != tt.keepArchive {
+				t.Errorf("expected archive kept %v, got %v", tt.keepArchive, kept)
+			}
+		})
+	}
+}
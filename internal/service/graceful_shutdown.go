@@ -2,19 +2,24 @@ package service
 
 import (
 	"context"
-	"log"
+	"errors"
 	"net/http"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 	"time"
+
+	"filedownloader-20240926/pkg/logger"
 )
 
 type GracefulShutdown struct {
 	server      *http.Server
 	workerPool  *WorkerPool
 	taskManager *TaskManager
+	sweeper     *Sweeper
+	diskMonitor *DiskMonitor
+	reload      func()
 	ctx         context.Context
 	cancel      context.CancelFunc
 	wg          sync.WaitGroup
@@ -32,6 +37,21 @@ func NewGracefulShutdown(server *http.Server, workerPool *WorkerPool, taskManage
 	}
 }
 
+// SetSweeper registers the task sweeper to stop on shutdown
+func (gs *GracefulShutdown) SetSweeper(s *Sweeper) {
+	gs.sweeper = s
+}
+
+// SetDiskMonitor registers the disk space monitor to stop on shutdown
+func (gs *GracefulShutdown) SetDiskMonitor(m *DiskMonitor) {
+	gs.diskMonitor = m
+}
+
+// SetReload registers a function run on SIGHUP; the service keeps running afterwards
+func (gs *GracefulShutdown) SetReload(reload func()) {
+	gs.reload = reload
+}
+
 // Start starts server with graceful shutdown
 func (gs *GracefulShutdown) Start() error {
 	gs.workerPool.Start()
@@ -40,7 +60,7 @@ func (gs *GracefulShutdown) Start() error {
 	go func() {
 		defer gs.wg.Done()
 		if err := gs.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("Server error: %v", err)
+			logger.Logger.Error("Server error", "error", err)
 		}
 	}()
 
@@ -51,47 +71,74 @@ func (gs *GracefulShutdown) Start() error {
 	return nil
 }
 
-// waitForSignals waits for signals for graceful shutdown
+// waitForSignals waits for signals for graceful shutdown, running the reload
+// function on every SIGHUP in between
 func (gs *GracefulShutdown) waitForSignals() {
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-
-	sig := <-sigChan
-	log.Printf("Received signal: %v", sig)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigChan)
+
+	for sig := range sigChan {
+		if sig == syscall.SIGHUP {
+			if gs.reload != nil {
+				logger.Logger.Info("Received signal, reloading configuration", "signal", sig.String())
+				gs.reload()
+			}
+			continue
+		}
+		logger.Logger.Info("Received signal", "signal", sig.String())
+		return
+	}
 }
 
 // shutdown performs graceful shutdown
 func (gs *GracefulShutdown) shutdown() {
-	log.Println("Starting graceful shutdown...")
+	logger.Logger.Info("Starting graceful shutdown")
+	// reject new work first, the server keeps serving for a moment while it shuts down
+	gs.workerPool.StartDraining()
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := gs.server.Shutdown(ctx); err != nil {
-		log.Printf("Server shutdown error: %v", err)
+		logger.Logger.Error("Server shutdown error", "error", err)
+	}
+	if gs.sweeper != nil {
+		gs.sweeper.Stop()
+	}
+	if gs.diskMonitor != nil {
+		gs.diskMonitor.Stop()
 	}
 	gs.workerPool.Stop()
 	gs.saveAllTasks()
 	gs.wg.Wait()
 
-	log.Println("Graceful shutdown completed")
+	logger.Logger.Info("Graceful shutdown completed")
 }
 
 // saveAllTasks saves state of all tasks
 func (gs *GracefulShutdown) saveAllTasks() {
-	log.Println("Saving all tasks state...")
+	logger.Logger.Info("Saving all tasks state")
 
 	tasks := gs.taskManager.GetAllTasks()
 	saved := 0
 
 	for _, task := range tasks {
-		if err := gs.taskManager.UpdateTask(task); err != nil {
-			log.Printf("Failed to save task %s: %v", task.ID, err)
+		err := gs.taskManager.UpdateTask(task)
+		if errors.Is(err, ErrStaleTask) {
+			// a newer state was stored after the snapshot and is flushed below
+			err = nil
+		}
+		if err != nil {
+			logger.Logger.Error("Failed to save task", "task_id", task.ID, "error", err)
 		} else {
 			saved++
 		}
 	}
+	if err := gs.taskManager.Flush(); err != nil {
+		logger.Logger.Error("Failed to flush task updates", "error", err)
+	}
 
-	log.Printf("Saved %d tasks", saved)
+	logger.Logger.Info("Saved tasks", "saved", saved, "total", len(tasks))
 }
 
 // GetContext returns context for use in other components
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	neturl "net/url"
+	"strings"
+	"sync"
+)
+
+// hostLimiter bounds the number of concurrent downloads per host. Semaphores
+// are created on first use and dropped once no worker holds or waits for them.
+type hostLimiter struct {
+	limit int
+	mu    sync.Mutex
+	hosts map[string]*hostSemaphore
+}
+
+type hostSemaphore struct {
+	slots chan struct{}
+	refs  int
+}
+
+// newHostLimiter returns a limiter allowing limit downloads per host; 0 means unlimited
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{
+		limit: limit,
+		hosts: make(map[string]*hostSemaphore),
+	}
+}
+
+// Acquire blocks until a download slot for host is free or ctx is done.
+// Every successful Acquire must be paired with a Release.
+func (l *hostLimiter) Acquire(ctx context.Context, host string) error {
+	if l.limit <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	sem, ok := l.hosts[host]
+	if !ok {
+		sem = &hostSemaphore{slots: make(chan struct{}, l.limit)}
+		l.hosts[host] = sem
+	}
+	sem.refs++
+	l.mu.Unlock()
+
+	select {
+	case sem.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		l.unref(host, sem)
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot taken by Acquire
+func (l *hostLimiter) Release(host string) {
+	if l.limit <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	sem := l.hosts[host]
+	l.mu.Unlock()
+
+	<-sem.slots
+	l.unref(host, sem)
+}
+
+// unref drops a reference to the semaphore of host, forgetting it when unused
+func (l *hostLimiter) unref(host string, sem *hostSemaphore) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sem.refs--
+	if sem.refs == 0 {
+		delete(l.hosts, host)
+	}
+}
+
+// hostOf returns the lowercased host name of rawURL without the port
+func hostOf(rawURL string) string {
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestHostLimiter tests that concurrent holders per host never exceed the limit
+func TestHostLimiter(t *testing.T) {
+	tests := []struct {
+		name        string
+		limit       int
+		hosts       []string
+		expectedMax int32
+	}{
+		{
+			name:        "single host limited",
+			limit:       2,
+			hosts:       []string{"a", "a", "a", "a", "a", "a"},
+			expectedMax: 2,
+		},
+		{
+			name:        "hosts limited independently",
+			limit:       1,
+			hosts:       []string{"a", "b", "a", "b", "a", "b"},
+			expectedMax: 1,
+		},
+		{
+			name:        "unlimited",
+			limit:       0,
+			hosts:       []string{"a", "a", "a", "a"},
+			expectedMax: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newHostLimiter(tt.limit)
+			var mu sync.Mutex
+			active := make(map[string]int32)
+			var maxActive atomic.Int32
+
+			var wg sync.WaitGroup
+			release := make(chan struct{})
+			for _, host := range tt.hosts {
+				wg.Add(1)
+				go func(host string) {
+					defer wg.Done()
+					if err := l.Acquire(context.Background(), host); err != nil {
+						t.Errorf("unexpected error: %v", err)
+						return
+					}
+					mu.Lock()
+					active[host]++
+					if active[host] > maxActive.Load() {
+						maxActive.Store(active[host])
+					}
+					mu.Unlock()
+
+					<-release
+					time.Sleep(time.Millisecond)
+
+					mu.Lock()
+					active[host]--
+					mu.Unlock()
+					l.Release(host)
+				}(host)
+			}
+
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			if maxActive.Load() != tt.expectedMax {
+				t.Errorf("expected at most %d concurrent per host, got %d", tt.expectedMax, maxActive.Load())
+			}
+			if len(l.hosts) != 0 {
+				t.Errorf("expected unused hosts to be forgotten, got %d", len(l.hosts))
+			}
+		})
+	}
+}
+
+// TestHostLimiterCancel tests that a waiting Acquire returns when its context is done
+func TestHostLimiterCancel(t *testing.T) {
+	l := newHostLimiter(1)
+	if err := l.Acquire(context.Background(), "a"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.Acquire(ctx, "a"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+
+	l.Release("a")
+	if len(l.hosts) != 0 {
+		t.Errorf("expected unused hosts to be forgotten, got %d", len(l.hosts))
+	}
+}
+
+// TestHostOf tests host extraction from download URLs
+func TestHostOf(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{url: "http://example.com/a.txt", expected: "example.com"},
+		{url: "https://Example.COM:8443/a.txt", expected: "example.com"},
+		{url: "http://[::1]:8080/a.txt", expected: "::1"},
+		{url: "://bad", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if got := hostOf(tt.url); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
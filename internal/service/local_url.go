@@ -0,0 +1,179 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrOutsideFileRoot is returned for file: URLs that resolve outside the allowed root
+	ErrOutsideFileRoot = errors.New("path is outside the allowed file root")
+	// ErrBadDataURL is returned for malformed data: URLs
+	ErrBadDataURL = errors.New("malformed data URL")
+)
+
+// EnableLocalURLs lets the downloader serve file: URLs below fileRoot and
+// inline data: URLs. Without a root only data: URLs are accepted. Local URLs
+// stay rejected by the transport as an unsupported protocol unless enabled,
+// since they give API clients access to the host.
+func (d *Downloader) EnableLocalURLs(fileRoot string) {
+	lt := &localTransport{fileRoot: fileRoot}
+	d.transport.RegisterProtocol("file", lt)
+	d.transport.RegisterProtocol("data", lt)
+}
+
+// localTransport answers file: and data: requests like an HTTP server would,
+// so that the regular download path handles size limits, content type checks
+// and naming for them as well
+type localTransport struct {
+	fileRoot string
+}
+
+func (t *localTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		body        io.ReadCloser
+		size        int64
+		contentType string
+		err         error
+	)
+	switch req.URL.Scheme {
+	case "file":
+		body, size, contentType, err = t.openFile(req.URL)
+	case "data":
+		body, size, contentType, err = openDataURL(req.URL)
+	default:
+		return nil, fmt.Errorf("unsupported protocol scheme %q", req.URL.Scheme)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return localResponse(req, http.StatusNotFound, http.Header{}, http.NoBody, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", contentType)
+	header.Set("Content-Length", strconv.FormatInt(size, 10))
+	if req.Method == http.MethodHead {
+		body.Close()
+		body = http.NoBody
+	}
+	return localResponse(req, http.StatusOK, header, body, size), nil
+}
+
+// localResponse builds the response to a local request
+func localResponse(req *http.Request, status int, header http.Header, body io.ReadCloser, size int64) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          body,
+		ContentLength: size,
+		Request:       req,
+	}
+}
+
+// openFile opens the regular file named by a file: URL. Symlinks are resolved
+// before the root check, so a link inside the root cannot point outside it.
+func (t *localTransport) openFile(u *neturl.URL) (io.ReadCloser, int64, string, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		return nil, 0, "", fmt.Errorf("file URL host %q is not local", u.Host)
+	}
+	if t.fileRoot == "" {
+		return nil, 0, "", fmt.Errorf("%w: no file root configured", ErrOutsideFileRoot)
+	}
+	if !filepath.IsAbs(u.Path) {
+		return nil, 0, "", fmt.Errorf("file URL path %q is not absolute", u.Path)
+	}
+
+	root, err := filepath.EvalSymlinks(t.fileRoot)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to resolve file root: %w", err)
+	}
+	root, err = filepath.Abs(root)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to resolve file root: %w", err)
+	}
+	path, err := filepath.EvalSymlinks(filepath.Clean(u.Path))
+	if err != nil {
+		return nil, 0, "", err
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, 0, "", fmt.Errorf("%w: %s", ErrOutsideFileRoot, u.Path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, "", err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, 0, "", fmt.Errorf("%s is not a regular file", u.Path)
+	}
+
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return file, info.Size(), contentType, nil
+}
+
+// openDataURL decodes the payload of a data URL as described in RFC 2397
+func openDataURL(u *neturl.URL) (io.ReadCloser, int64, string, error) {
+	raw := u.Opaque
+	if u.RawQuery != "" {
+		raw += "?" + u.RawQuery
+	}
+	meta, payload, ok := strings.Cut(raw, ",")
+	if !ok {
+		return nil, 0, "", fmt.Errorf("%w: missing comma", ErrBadDataURL)
+	}
+
+	encoded := false
+	if strings.HasSuffix(strings.ToLower(meta), ";base64") {
+		encoded = true
+		meta = meta[:len(meta)-len(";base64")]
+	}
+	contentType := meta
+	if contentType == "" || strings.HasPrefix(contentType, ";") {
+		contentType = "text/plain" + contentType
+	}
+	if contentType == "text/plain" {
+		contentType = "text/plain;charset=US-ASCII"
+	}
+
+	data, err := neturl.PathUnescape(payload)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("%w: %v", ErrBadDataURL, err)
+	}
+	decoded := []byte(data)
+	if encoded {
+		decoded, err = base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			decoded, err = base64.RawStdEncoding.DecodeString(data)
+		}
+		if err != nil {
+			return nil, 0, "", fmt.Errorf("%w: %v", ErrBadDataURL, err)
+		}
+	}
+	return io.NopCloser(bytes.NewReader(decoded)), int64(len(decoded)), contentType, nil
+}
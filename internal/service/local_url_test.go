@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDownloaderLocalURLs tests downloading file: and data: URLs when enabled
+func TestDownloaderLocalURLs(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "report.txt"), []byte("local content"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		url             string
+		disabled        bool
+		expectedName    string
+		expectedContent string
+		expectedErr     error
+		expectError     bool
+	}{
+		{
+			name:            "file inside root",
+			url:             "file://" + filepath.Join(root, "report.txt"),
+			expectedName:    "report.txt",
+			expectedContent: "local content",
+		},
+		{
+			name:        "file outside root",
+			url:         "file://" + filepath.Join(outside, "secret.txt"),
+			expectedErr: ErrOutsideFileRoot,
+		},
+		{
+			name:        "traversal out of root",
+			url:         "file://" + root + "/../" + filepath.Base(outside) + "/secret.txt",
+			expectedErr: ErrOutsideFileRoot,
+		},
+		{
+			name:        "symlink out of root",
+			url:         "file://" + filepath.Join(root, "link.txt"),
+			expectedErr: ErrOutsideFileRoot,
+		},
+		{
+			name:        "missing file",
+			url:         "file://" + filepath.Join(root, "missing.txt"),
+			expectError: true,
+		},
+		{
+			name:            "plain data URL",
+			url:             "data:,hello%20world",
+			expectedName:    "data.txt",
+			expectedContent: "hello world",
+		},
+		{
+			name:            "base64 data URL",
+			url:             "data:application/json;base64,eyJvayI6dHJ1ZX0=",
+			expectedName:    "data.json",
+			expectedContent: `{"ok":true}`,
+		},
+		{
+			name:        "malformed data URL",
+			url:         "data:text/plain;base64",
+			expectedErr: ErrBadDataURL,
+		},
+		{
+			name:        "disabled",
+			url:         "data:,hello",
+			disabled:    true,
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDownloader()
+			d.downloadsDir = t.TempDir()
+			if !tt.disabled {
+				d.EnableLocalURLs(root)
+			}
+
+			name, err := d.DownloadFile(context.Background(), tt.url, d.ExtractFilename(tt.url))
+			if tt.expectedErr != nil || tt.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got file %s", name)
+				}
+				if tt.expectedErr != nil && !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected %v, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if name != tt.expectedName {
+				t.Errorf("expected name %s, got %s", tt.expectedName, name)
+			}
+			content, err := os.ReadFile(filepath.Join(d.downloadsDir, name))
+			if err != nil {
+				t.Fatalf("failed to read downloaded file: %v", err)
+			}
+			if string(content) != tt.expectedContent {
+				t.Errorf("expected content %q, got %q", tt.expectedContent, content)
+			}
+
+			size, err := d.GetFileSize(context.Background(), tt.url)
+			if err != nil || size != int64(len(tt.expectedContent)) {
+				t.Errorf("expected size %d, got %d (%v)", len(tt.expectedContent), size, err)
+			}
+		})
+	}
+}
+
+// TestDownloaderLocalURLsWithoutRoot tests that file: URLs are refused when no root is configured
+func TestDownloaderLocalURLsWithoutRoot(t *testing.T) {
+	d := NewDownloader()
+	d.downloadsDir = t.TempDir()
+	d.EnableLocalURLs("")
+
+	path := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+
+	_, err := d.DownloadFile(context.Background(), "file://"+path, "a.txt")
+	if !errors.Is(err, ErrOutsideFileRoot) || !strings.Contains(err.Error(), "no file root") {
+		t.Errorf("expected missing root error, got %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"filedownloader-20240926/internal/domain"
+)
+
+// maxManifestSize bounds the manifest document read by FetchManifest
+const maxManifestSize = 10 * 1024 * 1024
+
+// ErrBadManifest is returned when a manifest cannot be parsed or describes no valid files
+var ErrBadManifest = errors.New("malformed manifest")
+
+// manifest is the document behind a manifest_url: the files to download with
+// the SHA-256 and size each must have
+type manifest struct {
+	Files []struct {
+		URL    string `json:"url"`
+		SHA256 string `json:"sha256"`
+		Size   int64  `json:"size"`
+	} `json:"files"`
+}
+
+// FetchManifest downloads a manifest and returns its entries as file
+// requests. Every entry needs a URL and a SHA-256; the size is optional.
+// A manifest that cannot be parsed yields ErrBadManifest, a failed request
+// an HTTP or network error.
+func (d *Downloader) FetchManifest(ctx context.Context, url string) ([]domain.FileRequest, error) {
+	url, opts := withCredentials(url, DownloadOptions{})
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", url, err)
+	}
+	req.Header.Set("User-Agent", d.userAgent)
+	setCredentials(req, opts)
+	req.Header.Set("Accept", "application/json")
+
+	release, err := d.acquireConn(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPStatusError{StatusCode: resp.StatusCode, URL: url}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxManifestSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", url, err)
+	}
+	if len(body) > maxManifestSize {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrBadManifest, maxManifestSize)
+	}
+
+	var m manifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadManifest, err)
+	}
+	if len(m.Files) == 0 {
+		return nil, fmt.Errorf("%w: no files listed", ErrBadManifest)
+	}
+
+	files := make([]domain.FileRequest, 0, len(m.Files))
+	for i, f := range m.Files {
+		switch {
+		case f.URL == "":
+			return nil, fmt.Errorf("%w: files[%d] has no url", ErrBadManifest, i)
+		case !ValidSHA256(f.SHA256):
+			return nil, fmt.Errorf("%w: files[%d] has no valid sha256", ErrBadManifest, i)
+		case f.Size < 0:
+			return nil, fmt.Errorf("%w: files[%d] has a negative size", ErrBadManifest, i)
+		}
+		files = append(files, domain.FileRequest{URL: f.URL, SHA256: f.SHA256, Size: f.Size})
+	}
+	return files, nil
+}
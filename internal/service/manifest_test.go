@@ -0,0 +1,63 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestDownloaderFetchManifest tests parsing and validating manifests
+func TestDownloaderFetchManifest(t *testing.T) {
+	const sum = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	tests := []struct {
+		name          string
+		body          string
+		expectedFiles int
+		expectedErr   error
+	}{
+		{
+			name:          "valid manifest",
+			body:          `{"files": [{"url": "http://example.com/a", "sha256": "` + sum + `", "size": 5}, {"url": "http://example.com/b", "sha256": "` + sum + `"}]}`,
+			expectedFiles: 2,
+		},
+		{
+			name:        "not JSON",
+			body:        `<html></html>`,
+			expectedErr: ErrBadManifest,
+		},
+		{
+			name:        "no files",
+			body:        `{"files": []}`,
+			expectedErr: ErrBadManifest,
+		},
+		{
+			name:        "bad checksum",
+			body:        `{"files": [{"url": "http://example.com/a", "sha256": "xyz"}]}`,
+			expectedErr: ErrBadManifest,
+		},
+		{
+			name:        "missing url",
+			body:        `{"files": [{"sha256": "` + sum + `"}]}`,
+			expectedErr: ErrBadManifest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			files, err := NewDownloader().FetchManifest(context.Background(), srv.URL)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if len(files) != tt.expectedFiles {
+				t.Errorf("expected %d files, got %d", tt.expectedFiles, len(files))
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/pkg/logger"
+)
+
+const (
+	callbackTimeout  = 5 * time.Second
+	callbackAttempts = 3
+	callbackBackoff  = time.Second
+)
+
+// Notifier delivers task status callbacks in the background so that slow
+// callback endpoints never block the workers
+type Notifier struct {
+	client   *http.Client
+	attempts int
+	backoff  time.Duration
+	wg       sync.WaitGroup
+}
+
+// NewNotifier creates a notifier with the default timeout and retry policy
+func NewNotifier() *Notifier {
+	return &Notifier{
+		client:   &http.Client{Timeout: callbackTimeout},
+		attempts: callbackAttempts,
+		backoff:  callbackBackoff,
+	}
+}
+
+// Notify posts the task status to its callback URL, if any. Delivery runs in a
+// separate goroutine; failures are logged and never affect the task.
+func (n *Notifier) Notify(task *domain.Task) {
+	if task.CallbackURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(domain.NewTaskStatusResponse(task))
+	if err != nil {
+		logger.Logger.Error("Failed to marshal callback payload", "task_id", task.ID, "error", err)
+		return
+	}
+
+	n.wg.Add(1)
+	go func() {
+		defer n.wg.Done()
+		n.deliver(task.ID, task.RequestID, task.CallbackURL, body)
+	}()
+}
+
+// Wait blocks until all in-flight callbacks have been delivered or given up on
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+// deliver posts the payload, retrying network errors and 5xx/429 responses with exponential backoff
+func (n *Notifier) deliver(taskID, requestID, url string, body []byte) {
+	log := logger.Logger.With("task_id", taskID, "callback_url", url)
+	backoff := n.backoff
+
+	for attempt := 1; attempt <= n.attempts; attempt++ {
+		retry, err := n.post(requestID, url, body)
+		if err == nil {
+			log.Info("Callback delivered", "attempt", attempt)
+			return
+		}
+		if !retry || attempt == n.attempts {
+			log.Error("Callback delivery failed", "attempt", attempt, "error", err)
+			return
+		}
+
+		log.Warn("Callback delivery failed, retrying", "attempt", attempt, "error", err, "backoff", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends a single callback request and reports whether a failure is worth retrying
+func (n *Notifier) post(requestID, url string, body []byte) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.client.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// a blocked address stays blocked on the next attempt
+		return !errors.Is(err, ErrBlockedAddress), err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("callback returned status %d", resp.StatusCode)
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"filedownloader-20240926/internal/config"
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+)
+
+// TestNotifierDelivery tests callback delivery with retries
+func TestNotifierDelivery(t *testing.T) {
+	tests := []struct {
+		name             string
+		statuses         []int
+		expectedAttempts int32
+	}{
+		{
+			name:             "delivered first time",
+			statuses:         []int{http.StatusOK},
+			expectedAttempts: 1,
+		},
+		{
+			name:             "retried after server errors",
+			statuses:         []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusNoContent},
+			expectedAttempts: 3,
+		},
+		{
+			name:             "client error is not retried",
+			statuses:         []int{http.StatusBadRequest, http.StatusOK},
+			expectedAttempts: 1,
+		},
+		{
+			name:             "gives up after all attempts",
+			statuses:         []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway, http.StatusOK},
+			expectedAttempts: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			var received domain.TaskStatusResponse
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := attempts.Add(1)
+				json.NewDecoder(r.Body).Decode(&received)
+				w.WriteHeader(tt.statuses[n-1])
+			}))
+			defer srv.Close()
+
+			n := NewNotifier()
+			n.backoff = time.Millisecond
+			n.Notify(&domain.Task{ID: "task-1", Status: domain.StatusCompleted, CallbackURL: srv.URL})
+			n.Wait()
+
+			if got := attempts.Load(); got != tt.expectedAttempts {
+				t.Errorf("expected %d attempts, got %d", tt.expectedAttempts, got)
+			}
+			if received.ID != "task-1" || received.Status != string(domain.StatusCompleted) {
+				t.Errorf("unexpected payload: %+v", received)
+			}
+		})
+	}
+}
+
+// TestNotifierEgressGuard tests that callbacks to blocked addresses are refused without retries
+func TestNotifierEgressGuard(t *testing.T) {
+	tests := []struct {
+		name             string
+		guard            config.EgressGuardConfig
+		redirect         bool
+		expectedAttempts int32
+		expectBlock      bool
+	}{
+		{
+			name:        "loopback callback",
+			guard:       config.EgressGuardConfig{Enabled: true},
+			expectBlock: true,
+		},
+		{
+			name:             "allowlisted",
+			guard:            config.EgressGuardConfig{Enabled: true, Allow: []string{"127.0.0.0/8"}},
+			expectedAttempts: 1,
+		},
+		{
+			name:             "redirect to a blocked address",
+			guard:            config.EgressGuardConfig{Enabled: true, Allow: []string{"127.0.0.1"}},
+			redirect:         true,
+			expectedAttempts: 1,
+			expectBlock:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts.Add(1)
+				if tt.redirect {
+					_, port, _ := net.SplitHostPort(r.Host)
+					http.Redirect(w, r, "http://127.0.0.2:"+port+"/callback", http.StatusTemporaryRedirect)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer srv.Close()
+
+			n := NewNotifier()
+			n.backoff = time.Millisecond
+			if err := n.SetEgressGuard(tt.guard); err != nil {
+				t.Fatalf("failed to set egress guard: %v", err)
+			}
+			retry, err := n.post("", srv.URL+"/callback", []byte("{}"))
+			if blocked := errors.Is(err, ErrBlockedAddress); blocked != tt.expectBlock {
+				t.Errorf("expected blocked=%v, got %v", tt.expectBlock, err)
+			}
+			if tt.expectBlock && retry {
+				t.Errorf("expected a blocked callback not to be retried")
+			}
+			if got := attempts.Load(); got != tt.expectedAttempts {
+				t.Errorf("expected %d requests to reach the server, got %d", tt.expectedAttempts, got)
+			}
+		})
+	}
+}
+
+// TestWorkerPoolCallbackOnCompletion tests that a finished task triggers exactly one callback
+func TestWorkerPoolCallbackOnCompletion(t *testing.T) {
+	tests := []struct {
+		name           string
+		fileStatus     int
+		expectedStatus domain.Status
+	}{
+		{
+			name:           "completed task",
+			fileStatus:     http.StatusOK,
+			expectedStatus: domain.StatusCompleted,
+		},
+		{
+			name:           "failed task",
+			fileStatus:     http.StatusNotFound,
+			expectedStatus: domain.StatusFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.fileStatus)
+			}))
+			defer files.Close()
+
+			var calls atomic.Int32
+			statuses := make(chan string, 4)
+			callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var resp domain.TaskStatusResponse
+				json.NewDecoder(r.Body).Decode(&resp)
+				calls.Add(1)
+				statuses <- resp.Status
+			}))
+			defer callback.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(2, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			wp.Start()
+
+			task, err := tm.CreateTaskWithOptions(
+				[]string{files.URL + "/a.txt", files.URL + "/b.txt"},
+				TaskOptions{CallbackURL: callback.URL},
+			)
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			wp.ProcessFiles(task.ID, task.Files)
+
+			select {
+			case status := <-statuses:
+				if status != string(tt.expectedStatus) {
+					t.Errorf("expected callback status %s, got %s", tt.expectedStatus, status)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatal("callback was not delivered")
+			}
+
+			wp.Stop()
+			if got := calls.Load(); got != 1 {
+				t.Errorf("expected exactly one callback, got %d", got)
+			}
+		})
+	}
+}
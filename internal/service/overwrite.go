@@ -0,0 +1,76 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+)
+
+const (
+	// OverwritePolicyRename saves a download alongside an existing file of the
+	// same name with a numeric suffix ("report (1).txt")
+	OverwritePolicyRename = "rename"
+	// OverwritePolicyOverwrite replaces an existing file of the same name
+	OverwritePolicyOverwrite = "overwrite"
+	// OverwritePolicySkip keeps an existing file of the same name that matches
+	// the remote size, and the SHA-256 when one is expected, without downloading
+	// it again; a file that does not match is replaced
+	OverwritePolicySkip = "skip"
+)
+
+// ValidOverwritePolicy reports whether policy is one of the overwrite policies
+func ValidOverwritePolicy(policy string) bool {
+	switch policy {
+	case OverwritePolicyRename, OverwritePolicyOverwrite, OverwritePolicySkip:
+		return true
+	}
+	return false
+}
+
+// overwritePolicy returns the policy of a download: the per-task one when
+// set, otherwise the configured default
+func (d *Downloader) overwritePolicy(opts DownloadOptions) string {
+	if opts.OverwritePolicy != "" {
+		return opts.OverwritePolicy
+	}
+	return d.overwrite
+}
+
+// replacesExisting reports whether a download takes the place of an existing
+// file of the same name instead of being saved next to it
+func (d *Downloader) replacesExisting(opts DownloadOptions) bool {
+	return d.overwritePolicy(opts) != OverwritePolicyRename
+}
+
+// createFile creates name in dir, replacing an existing file when replace is
+// set and picking a unique name next to it otherwise. An existing file is
+// unlinked rather than truncated, so that content it shares with other files
+// through a hardlink, such as a deduplicated blob, is left intact.
+func createFile(dir, name string, replace bool) (*os.File, string, error) {
+	if !replace {
+		return createUniqueFile(dir, name)
+	}
+	path := filepath.Join(dir, name)
+	if info, err := os.Lstat(path); err == nil && !info.IsDir() {
+		os.Remove(path)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	return file, name, err
+}
+
+// sameFile reports whether the regular file at path can stand in for a
+// download of size bytes: its size has to match, and its SHA-256 too when one
+// is expected. Without a known size or digest nothing identifies the file,
+// so it is never considered the same.
+func sameFile(path string, size int64, expected ExpectedFile) bool {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	if size > 0 {
+		expected.Size = size
+	}
+	if expected.Size <= 0 && expected.SHA256 == "" {
+		return false
+	}
+	return verifyFile(path, expected) == nil
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+)
+
+// TestWorkerPoolOverwritePolicy tests how each overwrite policy treats a file that already exists on disk
+func TestWorkerPoolOverwritePolicy(t *testing.T) {
+	const remote = "remote content"
+	sum := sha256.Sum256([]byte(remote))
+	tests := []struct {
+		name             string
+		defaultPolicy    string
+		taskPolicy       string
+		existing         string
+		sha256           string
+		expectedFilename string
+		expectedContent  string
+		expectedGets     int32
+		expectedOriginal bool
+	}{
+		{
+			name:             "rename keeps the existing file",
+			defaultPolicy:    OverwritePolicyRename,
+			existing:         "local content!",
+			expectedFilename: "file (1).txt",
+			expectedContent:  remote,
+			expectedGets:     1,
+			expectedOriginal: true,
+		},
+		{
+			name:             "overwrite replaces the existing file",
+			defaultPolicy:    OverwritePolicyOverwrite,
+			existing:         "local content!",
+			expectedFilename: "file.txt",
+			expectedContent:  remote,
+			expectedGets:     1,
+		},
+		{
+			name:             "skip keeps a file of the same size",
+			defaultPolicy:    OverwritePolicySkip,
+			existing:         "local content!",
+			expectedFilename: "file.txt",
+			expectedContent:  "local content!",
+		},
+		{
+			name:             "skip replaces a file of a different size",
+			defaultPolicy:    OverwritePolicySkip,
+			existing:         "old",
+			expectedFilename: "file.txt",
+			expectedContent:  remote,
+			expectedGets:     1,
+		},
+		{
+			name:             "skip keeps a file matching the checksum",
+			defaultPolicy:    OverwritePolicySkip,
+			existing:         remote,
+			sha256:           hex.EncodeToString(sum[:]),
+			expectedFilename: "file.txt",
+			expectedContent:  remote,
+		},
+		{
+			name:             "skip replaces a file of the same size with another checksum",
+			defaultPolicy:    OverwritePolicySkip,
+			existing:         "local content!",
+			sha256:           hex.EncodeToString(sum[:]),
+			expectedFilename: "file.txt",
+			expectedContent:  remote,
+			expectedGets:     1,
+		},
+		{
+			name:             "task policy overrides the default",
+			defaultPolicy:    OverwritePolicyRename,
+			taskPolicy:       OverwritePolicySkip,
+			existing:         "local content!",
+			expectedFilename: "file.txt",
+			expectedContent:  "local content!",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gets atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodGet {
+					gets.Add(1)
+				}
+				io.WriteString(w, remote)
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(1, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			wp.downloader.overwrite = tt.defaultPolicy
+
+			task, err := tm.CreateTaskWithOptions([]string{srv.URL + "/file.txt"}, TaskOptions{
+				OverwritePolicy: tt.taskPolicy,
+				Expected:        []ExpectedFile{{SHA256: tt.sha256}},
+			})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			dir := wp.downloader.TaskDir(task.ID)
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatalf("failed to create task dir: %v", err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte(tt.existing), 0644); err != nil {
+				t.Fatalf("failed to write existing file: %v", err)
+			}
+
+			wp.processTask(0, DownloadTask{TaskID: task.ID, FileIndex: 0})
+
+			task, _ = tm.GetTask(task.ID)
+			file := task.Files[0]
+			if file.Status != domain.StatusCompleted {
+				t.Fatalf("expected status %s, got %s (%s)", domain.StatusCompleted, file.Status, file.Error)
+			}
+			if file.Filename != tt.expectedFilename {
+				t.Errorf("expected filename %q, got %q", tt.expectedFilename, file.Filename)
+			}
+			if n := gets.Load(); n != tt.expectedGets {
+				t.Errorf("expected %d downloads, got %d", tt.expectedGets, n)
+			}
+			saved, err := os.ReadFile(filepath.Join(dir, file.Filename))
+			if err != nil {
+				t.Fatalf("failed to read saved file: %v", err)
+			}
+			if string(saved) != tt.expectedContent {
+				t.Errorf("expected content %q, got %q", tt.expectedContent, saved)
+			}
+			if file.Size != int64(len(saved)) {
+				t.Errorf("expected size %d, got %d", len(saved), file.Size)
+			}
+			original, err := os.ReadFile(filepath.Join(dir, "file.txt"))
+			if tt.expectedOriginal && (err != nil || string(original) != tt.existing) {
+				t.Errorf("expected the existing file to be kept, got %q (%v)", original, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultMaxPages is the default cap on the pages joined into one file
+const DefaultMaxPages = 100
+
+// ErrBadNextLink is returned when a page links to a next page that cannot be followed
+var ErrBadNextLink = errors.New("unsupported next page link")
+
+// SetMaxPages caps how many pages a FollowNext download joins; values below 1 are ignored
+func (d *Downloader) SetMaxPages(pages int) {
+	if pages > 0 {
+		d.maxPages = pages
+	}
+}
+
+// fetchPages downloads a paginated resource into a single file: starting at
+// url it follows the rel="next" Link header of every response and appends
+// the body of each page. It stops at the page without a next link, at a link
+// seen before or once maxPages pages are written. A page that fails fails the
+// whole file, since the pages after it cannot be joined without it; the next
+// attempt starts over from the first page.
+func (d *Downloader) fetchPages(ctx context.Context, url, filename string, opts DownloadOptions) (downloadResult, error) {
+	client := d.client()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	dir := d.TaskDir(opts.TaskID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return downloadResult{}, fmt.Errorf("failed to create downloads dir: %w", err)
+	}
+
+	var (
+		file    *os.File
+		result  downloadResult
+		written int64
+	)
+	fail := func(page int, pageURL string, err error) (downloadResult, error) {
+		if file != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+		if cause := context.Cause(ctx); cause != nil && !errors.Is(err, cause) {
+			err = fmt.Errorf("%w: %v", cause, err)
+		}
+		return downloadResult{}, fmt.Errorf("page %d of %s (%s) failed after %d bytes: %w", page, url, pageURL, written, err)
+	}
+
+	seen := make(map[string]bool)
+	next := url
+	for page := 1; ; page++ {
+		seen[next] = true
+		pageOpts := opts
+		if !sameHost(next, url) {
+			// credentials are only sent to the host they were given for
+			pageOpts.Credentials = nil
+		}
+		resp, err := d.getPage(ctx, client, next, pageOpts)
+		if err != nil {
+			return fail(page, next, err)
+		}
+
+		if file == nil {
+			finalName := d.resolveName(url, filename, resp.Header, opts.Decompress)
+			if opts.PartFile != "" {
+				file, err = openPartFile(opts.PartFile, 0)
+			} else {
+				file, finalName, err = createFile(dir, finalName, d.replacesExisting(opts))
+			}
+			if err != nil {
+				resp.Body.Close()
+				return downloadResult{}, fmt.Errorf("failed to create file %s: %w", filepath.Join(dir, finalName), err)
+			}
+			defer file.Close()
+			// the validators of the first page do not describe the joined
+			// file, so none are recorded
+			result = downloadResult{
+				Filename:    finalName,
+				FinalURL:    resp.Request.URL.Redacted(),
+				ContentType: resp.Header.Get("Content-Type"),
+			}
+		}
+
+		n, err := d.copyPage(ctx, cancel, file, resp, opts, written)
+		resp.Body.Close()
+		written += n
+		if err != nil {
+			return fail(page, next, err)
+		}
+
+		link, err := nextLink(resp.Header, resp.Request.URL)
+		if err != nil {
+			return fail(page, next, err)
+		}
+		if link == "" {
+			break
+		}
+		if seen[link] {
+			opts.log().Warn("Next page link repeats an earlier page, stopping", "url", url, "pages", page, "next", link)
+			break
+		}
+		if page >= d.maxPages {
+			opts.log().Warn("Page limit reached, stopping", "url", url, "pages", page, "next", link)
+			break
+		}
+		next = link
+	}
+
+	if opts.PartFile != "" {
+		name, err := promotePartFile(file, dir, result.Filename, d.replacesExisting(opts))
+		if err != nil {
+			os.Remove(file.Name())
+			return downloadResult{}, fmt.Errorf("failed to save file %s: %w", filepath.Join(dir, name), err)
+		}
+		result.Filename = name
+	}
+	return result, nil
+}
+
+// getPage requests a single page and checks its status and Content-Type
+func (d *Downloader) getPage(ctx context.Context, client *http.Client, url string, opts DownloadOptions) (*http.Response, error) {
+	req, err := d.newRequest(ctx, opts.method(), url, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for %s: %w", url, err)
+	}
+	if opts.Decompress {
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	} else {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, &HTTPStatusError{StatusCode: resp.StatusCode, URL: url}
+	}
+	if err := d.checkContentType(resp.Header.Get("Content-Type")); err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w for %s", err, url)
+	}
+	return resp, nil
+}
+
+// copyPage appends the body of a page to file, where offset bytes of earlier
+// pages were written before. It returns the number of bytes written.
+func (d *Downloader) copyPage(ctx context.Context, cancel context.CancelCauseFunc, file *os.File, resp *http.Response, opts DownloadOptions, offset int64) (int64, error) {
+	if d.maxFileSize > 0 && offset+resp.ContentLength > d.maxFileSize {
+		return 0, fmt.Errorf("%w: %d > %d", ErrFileTooLarge, offset+resp.ContentLength, d.maxFileSize)
+	}
+
+	defer d.startTransferDeadline(resp.ContentLength, cancel)()
+
+	received := &countingReader{r: resp.Body}
+	var body io.Reader = received
+	if stallTimeout := d.StallTimeout(); stallTimeout > 0 {
+		sr := newStallReader(received, stallTimeout, func() { cancel(ErrDownloadStalled) })
+		defer sr.Stop()
+		body = sr
+	}
+	body = newThrottledReader(ctx, body, d.throttle(), d.maxSpeed)
+	if opts.Progress != nil {
+		body = newProgressReader(body, offset, opts.Progress)
+	}
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if opts.Decompress && (encoding == "gzip" || encoding == "deflate") {
+		decoded, err := newDecodingReader(body, encoding)
+		if err != nil {
+			return 0, fmt.Errorf("failed to decode %s: %w", resp.Request.URL.Redacted(), err)
+		}
+		defer decoded.Close()
+		body = decoded
+	}
+	if d.maxFileSize > 0 {
+		body = io.LimitReader(body, d.maxFileSize-offset+1)
+	}
+
+	written, err := io.Copy(file, body)
+	if err != nil {
+		if resp.ContentLength >= 0 && received.n < resp.ContentLength {
+			return written, fmt.Errorf("%w: got %d of %d bytes: %v", ErrIncompleteBody, received.n, resp.ContentLength, err)
+		}
+		return written, fmt.Errorf("failed to write page: %w", err)
+	}
+	if resp.ContentLength >= 0 && received.n < resp.ContentLength {
+		return written, fmt.Errorf("%w: got %d of %d bytes", ErrIncompleteBody, received.n, resp.ContentLength)
+	}
+	if d.maxFileSize > 0 && offset+written > d.maxFileSize {
+		return written, fmt.Errorf("%w: more than %d bytes streamed", ErrFileTooLarge, d.maxFileSize)
+	}
+	return written, nil
+}
+
+// nextLink returns the target of the rel="next" link in the RFC 8288 Link
+// headers, resolved against base, or "" when there is none. Only http and
+// https targets are followed, so a page cannot point the download at a local
+// file.
+func nextLink(header http.Header, base *neturl.URL) (string, error) {
+	for _, value := range header.Values("Link") {
+		for value != "" {
+			start := strings.IndexByte(value, '<')
+			if start < 0 {
+				break
+			}
+			end := strings.IndexByte(value[start:], '>')
+			if end < 0 {
+				break
+			}
+			target := value[start+1 : start+end]
+			value = value[start+end+1:]
+			// the parameters of a link run up to the start of the next one
+			params := value
+			if next := strings.IndexByte(value, '<'); next >= 0 {
+				params, value = value[:next], value[next:]
+			} else {
+				value = ""
+			}
+			if !hasRel(params, "next") {
+				continue
+			}
+
+			ref, err := neturl.Parse(strings.TrimSpace(target))
+			if err != nil {
+				return "", fmt.Errorf("%w: %q: %v", ErrBadNextLink, target, err)
+			}
+			resolved := base.ResolveReference(ref)
+			if resolved.Scheme != "http" && resolved.Scheme != "https" {
+				return "", fmt.Errorf("%w: %s", ErrBadNextLink, resolved.Redacted())
+			}
+			return resolved.String(), nil
+		}
+	}
+	return "", nil
+}
+
+// hasRel reports whether the link parameters carry the relation type rel;
+// a rel parameter may list several space-separated types
+func hasRel(params, rel string) bool {
+	for _, param := range strings.Split(params, ";") {
+		key, val, ok := strings.Cut(param, "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(key), "rel") {
+			continue
+		}
+		val = strings.Trim(strings.TrimSpace(strings.TrimRight(strings.TrimSpace(val), ",")), `"`)
+		for _, r := range strings.Fields(val) {
+			if strings.EqualFold(r, rel) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"filedownloader-20240926/internal/config"
+)
+
+// TestNextLink tests finding the rel="next" target in Link headers
+func TestNextLink(t *testing.T) {
+	tests := []struct {
+		name         string
+		links        []string
+		expectedNext string
+		expectedErr  error
+	}{
+		{
+			name:         "no link header",
+			expectedNext: "",
+		},
+		{
+			name:         "absolute next link",
+			links:        []string{`<https://api.example.com/items?page=2>; rel="next"`},
+			expectedNext: "https://api.example.com/items?page=2",
+		},
+		{
+			name:         "relative link among others",
+			links:        []string{`</items?page=1>; rel="prev", </items?page=3>; rel="next", </items?page=9>; rel="last"`},
+			expectedNext: "https://api.example.com/items?page=3",
+		},
+		{
+			name:         "unquoted and mixed case relation list",
+			links:        []string{`</first>; rel=first`, `</next>; title="more, please"; REL="Next prefetch"`},
+			expectedNext: "https://api.example.com/next",
+		},
+		{
+			name:         "only other relations",
+			links:        []string{`</items?page=1>; rel="prev"`},
+			expectedNext: "",
+		},
+		{
+			name:        "local file target",
+			links:       []string{`<file:///etc/passwd>; rel="next"`},
+			expectedErr: ErrBadNextLink,
+		},
+	}
+
+	base, _ := neturl.Parse("https://api.example.com/items?page=2")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for _, link := range tt.links {
+				header.Add("Link", link)
+			}
+			next, err := nextLink(header, base)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if next != tt.expectedNext {
+				t.Errorf("expected next %q, got %q", tt.expectedNext, next)
+			}
+		})
+	}
+}
+
+// TestDownloaderFollowNext tests joining paginated responses into one file
+func TestDownloaderFollowNext(t *testing.T) {
+	tests := []struct {
+		name            string
+		pages           int
+		maxPages        int
+		failPage        int
+		loop            bool
+		expectedContent string
+		expectedErr     bool
+	}{
+		{
+			name:            "all pages",
+			pages:           3,
+			maxPages:        10,
+			expectedContent: "page1\npage2\npage3\n",
+		},
+		{
+			name:            "stops at the page limit",
+			pages:           5,
+			maxPages:        2,
+			expectedContent: "page1\npage2\n",
+		},
+		{
+			name:            "stops at a repeated link",
+			pages:           3,
+			maxPages:        10,
+			loop:            true,
+			expectedContent: "page1\npage2\npage3\n",
+		},
+		{
+			name:        "page failing mid-sequence fails the file",
+			pages:       3,
+			maxPages:    10,
+			failPage:    2,
+			expectedErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+				if page == 0 {
+					page = 1
+				}
+				if page == tt.failPage {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				switch {
+				case page < tt.pages:
+					w.Header().Set("Link", fmt.Sprintf(`</items?page=%d>; rel="next"`, page+1))
+				case tt.loop:
+					w.Header().Set("Link", `</items?page=2>; rel="next"`)
+				}
+				fmt.Fprintf(w, "page%d\n", page)
+			}))
+			defer srv.Close()
+
+			d := NewDownloaderWithConfig(config.DownloadConfig{MaxPages: tt.maxPages})
+			tmpDir := t.TempDir()
+			d.downloadsDir = tmpDir
+			opts := DownloadOptions{FollowNext: true, PartFile: filepath.Join(tmpDir, ".task.0.part")}
+
+			filename, err := d.DownloadFileWithOptions(context.Background(), srv.URL+"/items", "items.txt", opts)
+
+			if tt.expectedErr {
+				var statusErr *HTTPStatusError
+				if !errors.As(err, &statusErr) || !strings.Contains(err.Error(), fmt.Sprintf("page %d of", tt.failPage)) {
+					t.Fatalf("expected an HTTP error naming page %d, got %v", tt.failPage, err)
+				}
+				if entries, _ := os.ReadDir(tmpDir); len(entries) != 0 {
+					t.Errorf("expected no files left behind, got %d", len(entries))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got, err := os.ReadFile(filepath.Join(tmpDir, filename))
+			if err != nil {
+				t.Fatalf("file not found: %v", err)
+			}
+			if string(got) != tt.expectedContent {
+				t.Errorf("expected content %q, got %q", tt.expectedContent, got)
+			}
+		})
+	}
+}
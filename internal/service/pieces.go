@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filedownloader-20240926/internal/domain"
+)
+
+// Limits of the piece hashes a file may be verified with
+const (
+	MinPieceSize = 16 * 1024
+	MaxPieceSize = 64 * 1024 * 1024
+	MaxPieces    = 100000
+)
+
+// pieceAttempts is how many times a piece that fails its checksum is fetched again
+const pieceAttempts = 3
+
+// ValidatePieces checks the piece hashes of a file. size is the expected size
+// of the file, 0 when unknown; a known size must take exactly as many pieces
+// as there are hashes.
+func ValidatePieces(pieces domain.PieceHashes, size int64) error {
+	if pieces.Size < MinPieceSize || pieces.Size > MaxPieceSize {
+		return fmt.Errorf("size must be between %d and %d bytes", MinPieceSize, MaxPieceSize)
+	}
+	n := len(pieces.SHA256)
+	if n == 0 || n > MaxPieces {
+		return fmt.Errorf("sha256 must have 1 to %d entries", MaxPieces)
+	}
+	for i, sum := range pieces.SHA256 {
+		if !ValidSHA256(sum) {
+			return fmt.Errorf("sha256[%d] must be a hex-encoded SHA-256 digest", i)
+		}
+	}
+	if size > 0 && (size+pieces.Size-1)/pieces.Size != int64(n) {
+		return fmt.Errorf("sha256 must have %d entries for a file of %d bytes", (size+pieces.Size-1)/pieces.Size, size)
+	}
+	return nil
+}
+
+// fetchPieces downloads a file verified piece by piece against opts.Pieces.
+// The file is streamed into the part file as usual and every piece is hashed
+// as it arrives; pieces that do not match are fetched again on their own with
+// range requests, so one bad byte costs a piece rather than the whole file.
+// Pieces already in the part file from an earlier attempt are checked and
+// kept when they match. The part file is kept on failure, since its good
+// pieces spare the next attempt; the file fails with ErrChecksumMismatch
+// only when a piece still does not match after pieceAttempts fetches.
+func (d *Downloader) fetchPieces(ctx context.Context, url, filename string, opts DownloadOptions) (result downloadResult, err error) {
+	pieces := *opts.Pieces
+	n := int64(len(pieces.SHA256))
+	if d.maxFileSize > 0 && (n-1)*pieces.Size >= d.maxFileSize {
+		return downloadResult{}, fmt.Errorf("%w: %d pieces of %d bytes > %d", ErrFileTooLarge, n, pieces.Size, d.maxFileSize)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	dir := d.TaskDir(opts.TaskID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return downloadResult{}, fmt.Errorf("failed to create downloads dir: %w", err)
+	}
+	var file *os.File
+	if opts.PartFile != "" {
+		file, err = os.OpenFile(opts.PartFile, os.O_RDWR|os.O_CREATE, 0644)
+	} else {
+		file, err = os.CreateTemp(dir, ".pieces-*.part")
+	}
+	if err != nil {
+		return downloadResult{}, fmt.Errorf("failed to create file in %s: %w", dir, err)
+	}
+	defer file.Close()
+	if opts.PartFile == "" {
+		// without a part file to resume from, nothing is worth keeping
+		defer func() {
+			if err != nil {
+				os.Remove(file.Name())
+			}
+		}()
+	}
+
+	next, total, bad, err := verifyPartPieces(file, pieces)
+	if err != nil {
+		return downloadResult{}, err
+	}
+	if next > 0 {
+		opts.log().Info("Verified pieces of partial download", "url", url, "pieces", next, "bad", len(bad))
+	}
+
+	var resp *http.Response
+	if next < n {
+		if resp, total, err = d.streamPieces(ctx, cancel, url, file, next, &bad, opts); err != nil {
+			return downloadResult{}, err
+		}
+	}
+	for _, i := range bad {
+		opts.log().Warn("Piece does not match its checksum, fetching it again", "url", url, "piece", i)
+		var pieceResp *http.Response
+		if pieceResp, err = d.refetchPiece(ctx, url, file, i, total, opts); err != nil {
+			return downloadResult{}, err
+		}
+		if resp == nil {
+			resp = pieceResp
+		}
+	}
+
+	var header http.Header
+	if resp != nil {
+		header = resp.Header
+		result.ETag = header.Get("ETag")
+		result.LastModified = header.Get("Last-Modified")
+		result.FinalURL = resp.Request.URL.Redacted()
+		result.ContentType = header.Get("Content-Type")
+	}
+	finalName := d.resolveName(url, filename, header, false)
+	if finalName, err = promotePartFile(file, dir, finalName, d.replacesExisting(opts)); err != nil {
+		return downloadResult{}, fmt.Errorf("failed to save file %s: %w", filepath.Join(dir, finalName), err)
+	}
+	d.applyLastModified(filepath.Join(dir, finalName), result.LastModified)
+	result.Filename = finalName
+	return result, nil
+}
+
+// verifyPartPieces checks the pieces an earlier attempt left in the part
+// file. It returns the number of pieces on disk, the size of the file when
+// all of them are, -1 otherwise, and the pieces that do not match. A trailing
+// partial piece is cut off; so is a short last piece that does not match,
+// since the file may have been interrupted within it.
+func verifyPartPieces(file *os.File, pieces domain.PieceHashes) (int64, int64, []int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("failed to stat %s: %w", file.Name(), err)
+	}
+	n := int64(len(pieces.SHA256))
+	have := info.Size()
+	if have > n*pieces.Size {
+		have = 0
+	}
+
+	full := have / pieces.Size
+	var bad []int64
+	for i := int64(0); i < full; i++ {
+		sum, err := hashSection(file, i*pieces.Size, pieces.Size)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if !strings.EqualFold(sum, pieces.SHA256[i]) {
+			bad = append(bad, i)
+		}
+	}
+	if full == n {
+		return n, have, bad, nil
+	}
+	if start := full * pieces.Size; full == n-1 && have > start {
+		sum, err := hashSection(file, start, have-start)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if strings.EqualFold(sum, pieces.SHA256[full]) {
+			return n, have, bad, nil
+		}
+	}
+	if err := file.Truncate(full * pieces.Size); err != nil {
+		return 0, 0, nil, fmt.Errorf("failed to truncate %s: %w", file.Name(), err)
+	}
+	return full, -1, bad, nil
+}
+
+// streamPieces downloads the file from the piece next on into file, hashing
+// each piece as it arrives and adding those that do not match to bad. It
+// returns the response and the size of the file.
+func (d *Downloader) streamPieces(ctx context.Context, cancel context.CancelCauseFunc, url string, file *os.File, next int64, bad *[]int64, opts DownloadOptions) (*http.Response, int64, error) {
+	pieces := *opts.Pieces
+	n := int64(len(pieces.SHA256))
+	offset := next * pieces.Size
+
+	req, err := d.newRequest(ctx, opts.method(), url, opts)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create request for %s: %w", url, err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	// the hashes describe the stored bytes, so the body must arrive as-is
+	req.Header.Set("Accept-Encoding", "identity")
+
+	resp, err := d.client().Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	total := int64(-1)
+	skip := int64(0)
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		if start := parseContentRangeStart(resp.Header.Get("Content-Range")); start != offset {
+			return nil, 0, fmt.Errorf("%w for %s at offset %d: range starts at %d", ErrResumeNotHonored, url, offset, start)
+		}
+		if size := parseContentRangeTotal(resp.Header.Get("Content-Range")); size > 0 {
+			total = size
+		}
+	case resp.StatusCode == http.StatusOK:
+		// a server ignoring the range sends the pieces on disk again
+		skip = offset
+		if resp.ContentLength >= 0 {
+			total = resp.ContentLength
+		}
+	default:
+		return nil, 0, &HTTPStatusError{StatusCode: resp.StatusCode, URL: url}
+	}
+	if total >= 0 && (total <= (n-1)*pieces.Size || total > n*pieces.Size) {
+		return nil, 0, fmt.Errorf("%w: size %d does not fit %d pieces of %d bytes", ErrChecksumMismatch, total, n, pieces.Size)
+	}
+	if err := d.checkContentType(resp.Header.Get("Content-Type")); err != nil {
+		return nil, 0, fmt.Errorf("%w for %s", err, url)
+	}
+	if err := d.checkDiskSpace(resp.ContentLength - skip); err != nil {
+		return nil, 0, err
+	}
+	defer d.startTransferDeadline(resp.ContentLength-skip, cancel)()
+
+	var body io.Reader = resp.Body
+	if stallTimeout := d.StallTimeout(); stallTimeout > 0 {
+		sr := newStallReader(body, stallTimeout, func() { cancel(ErrDownloadStalled) })
+		defer sr.Stop()
+		body = sr
+	}
+	body = newThrottledReader(ctx, body, d.throttle(), d.maxSpeed)
+	if skip > 0 {
+		if _, err := io.CopyN(io.Discard, body, skip); err != nil {
+			return nil, 0, transferError(ctx, url, err)
+		}
+	}
+	if opts.Progress != nil {
+		body = newProgressReader(body, offset, opts.Progress)
+	}
+
+	for i := next; i < n; i++ {
+		start := i * pieces.Size
+		sum, written, err := copyPiece(file, body, start, pieces.Size)
+		if err == io.EOF && i == n-1 && written > 0 {
+			// the last piece may be short
+			total, err = start+written, nil
+		}
+		if err == io.EOF {
+			return nil, 0, fmt.Errorf("%w: %s ended after %d bytes, within piece %d of %d",
+				ErrIncompleteBody, url, start+written, i, n)
+		}
+		if err != nil {
+			return nil, 0, transferError(ctx, url, err)
+		}
+		if !strings.EqualFold(sum, pieces.SHA256[i]) {
+			*bad = append(*bad, i)
+		}
+	}
+	if total < 0 {
+		total = n * pieces.Size
+	}
+	if extra, _ := io.CopyN(io.Discard, body, 1); extra > 0 {
+		return nil, 0, fmt.Errorf("%w: %s is longer than %d pieces of %d bytes", ErrChecksumMismatch, url, n, pieces.Size)
+	}
+	return resp, total, nil
+}
+
+// refetchPiece fetches piece i of a file of the given size again with range
+// requests until it matches its checksum, at most pieceAttempts times
+func (d *Downloader) refetchPiece(ctx context.Context, url string, file *os.File, i, total int64, opts DownloadOptions) (*http.Response, error) {
+	pieces := *opts.Pieces
+	start := i * pieces.Size
+	length := min(pieces.Size, total-start)
+	for attempt := 1; attempt <= pieceAttempts; attempt++ {
+		req, err := d.newRequest(ctx, opts.method(), url, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request for %s: %w", url, err)
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, start+length-1))
+		req.Header.Set("Accept-Encoding", "identity")
+
+		resp, err := d.client().Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get piece %d of %s: %w", i, url, err)
+		}
+		if resp.StatusCode != http.StatusPartialContent || parseContentRangeStart(resp.Header.Get("Content-Range")) != start {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%w: piece %d of %s failed its checksum and the server does not serve it as a range",
+				ErrChecksumMismatch, i, url)
+		}
+		sum, _, err := copyPiece(file, resp.Body, start, length)
+		resp.Body.Close()
+		if err != nil {
+			return nil, transferError(ctx, url, err)
+		}
+		if strings.EqualFold(sum, pieces.SHA256[i]) {
+			opts.log().Info("Piece recovered", "url", url, "piece", i, "attempt", attempt)
+			return resp, nil
+		}
+		opts.log().Warn("Fetched piece does not match its checksum", "url", url, "piece", i, "attempt", attempt)
+	}
+	return nil, fmt.Errorf("%w: piece %d of %s did not match after %d attempts", ErrChecksumMismatch, i, url, pieceAttempts)
+}
+
+// copyPiece writes up to length bytes of body to file at start and returns
+// their hex-encoded SHA-256 digest and count. io.EOF is returned when body
+// ends before length bytes.
+func copyPiece(file *os.File, body io.Reader, start, length int64) (string, int64, error) {
+	h := sha256.New()
+	written, err := io.CopyN(io.MultiWriter(io.NewOffsetWriter(file, start), h), body, length)
+	return hex.EncodeToString(h.Sum(nil)), written, err
+}
+
+// hashSection returns the hex-encoded SHA-256 digest of length bytes of file from start
+func hashSection(file *os.File, start, length int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(file, start, length)); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", file.Name(), err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// transferError describes an interrupted transfer by the cause of the
+// cancellation when there is one, so that stalls and deadlines are reported
+// as such
+func transferError(ctx context.Context, url string, err error) error {
+	if cause := context.Cause(ctx); cause != nil {
+		return fmt.Errorf("failed to download %s: %w", url, cause)
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: %s: %v", ErrIncompleteBody, url, err)
+	}
+	return fmt.Errorf("failed to download %s: %w", url, err)
+}
@@ -0,0 +1,259 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+)
+
+// TestDownloaderFetchPieces tests piece verification, fetching bad pieces again and resuming from verified pieces
+func TestDownloaderFetchPieces(t *testing.T) {
+	const pieceSize = MinPieceSize
+	content := make([]byte, 3*pieceSize+pieceSize/2)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	corrupt := bytes.Clone(content)
+	corrupt[pieceSize+10] ^= 0xff
+	pieces := domain.PieceHashes{Size: pieceSize}
+	for start := 0; start < len(content); start += pieceSize {
+		sum := sha256.Sum256(content[start:min(start+pieceSize, len(content))])
+		pieces.SHA256 = append(pieces.SHA256, hex.EncodeToString(sum[:]))
+	}
+	good := func(int) []byte { return content }
+	piece1 := "bytes=16384-32767"
+
+	tests := []struct {
+		name           string
+		part           []byte
+		serve          func(request int) []byte
+		noRanges       bool
+		expectedErr    error
+		expectedRanges []string
+	}{
+		{
+			name:           "all pieces match",
+			serve:          good,
+			expectedRanges: []string{""},
+		},
+		{
+			name: "bad piece is fetched again",
+			serve: func(request int) []byte {
+				if request == 0 {
+					return corrupt
+				}
+				return content
+			},
+			expectedRanges: []string{"", piece1},
+		},
+		{
+			name:           "piece that never matches",
+			serve:          func(int) []byte { return corrupt },
+			expectedErr:    ErrChecksumMismatch,
+			expectedRanges: []string{"", piece1, piece1, piece1},
+		},
+		{
+			name:           "resumes after the verified pieces",
+			part:           content[:2*pieceSize+100],
+			serve:          good,
+			expectedRanges: []string{"bytes=32768-"},
+		},
+		{
+			name:           "bad piece in the part file",
+			part:           corrupt[:2*pieceSize],
+			serve:          good,
+			expectedRanges: []string{"bytes=32768-", piece1},
+		},
+		{
+			name:           "complete part file",
+			part:           content,
+			serve:          good,
+			expectedRanges: nil,
+		},
+		{
+			name:           "server ignores ranges",
+			part:           content[:2*pieceSize],
+			serve:          good,
+			noRanges:       true,
+			expectedRanges: []string{"bytes=32768-"},
+		},
+		{
+			name:           "file longer than its pieces",
+			serve:          func(int) []byte { return append(bytes.Clone(content), make([]byte, pieceSize)...) },
+			expectedErr:    ErrChecksumMismatch,
+			expectedRanges: []string{""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				ranges []string
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				request := len(ranges)
+				ranges = append(ranges, r.Header.Get("Range"))
+				mu.Unlock()
+				data := tt.serve(request)
+				if tt.noRanges {
+					w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+					w.Write(data)
+					return
+				}
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+			}))
+			defer srv.Close()
+
+			d := NewDownloader()
+			d.downloadsDir = t.TempDir()
+			part := filepath.Join(d.downloadsDir, ".file.part")
+			if tt.part != nil {
+				if err := os.WriteFile(part, tt.part, 0644); err != nil {
+					t.Fatalf("failed to write part file: %v", err)
+				}
+			}
+
+			result, err := d.fetch(context.Background(), srv.URL+"/file.bin", "file.bin", DownloadOptions{PartFile: part, Pieces: &pieces})
+			if len(ranges) != len(tt.expectedRanges) {
+				t.Fatalf("expected requests with ranges %q, got %q", tt.expectedRanges, ranges)
+			}
+			for i := range ranges {
+				if ranges[i] != tt.expectedRanges[i] {
+					t.Errorf("expected request %d with range %q, got %q", i, tt.expectedRanges[i], ranges[i])
+				}
+			}
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Fatalf("expected %v, got %v", tt.expectedErr, err)
+				}
+				if _, err := os.Stat(part); err != nil {
+					t.Errorf("expected the part file to be kept for the next attempt: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("download failed: %v", err)
+			}
+			saved, err := os.ReadFile(filepath.Join(d.downloadsDir, result.Filename))
+			if err != nil || !bytes.Equal(saved, content) {
+				t.Errorf("expected the saved file to match the content, got %d bytes (%v)", len(saved), err)
+			}
+			if _, err := os.Stat(part); !os.IsNotExist(err) {
+				t.Errorf("expected the part file to be renamed, got %v", err)
+			}
+		})
+	}
+}
+
+// TestWorkerPoolPieces tests that a file with piece hashes completes past a bad piece and fails when a piece cannot be recovered
+func TestWorkerPoolPieces(t *testing.T) {
+	content := bytes.Repeat([]byte("piece"), MinPieceSize)
+	corrupt := bytes.Clone(content)
+	corrupt[MinPieceSize*2] ^= 0xff
+	pieces := domain.PieceHashes{Size: MinPieceSize}
+	for start := 0; start < len(content); start += MinPieceSize {
+		sum := sha256.Sum256(content[start : start+MinPieceSize])
+		pieces.SHA256 = append(pieces.SHA256, hex.EncodeToString(sum[:]))
+	}
+
+	tests := []struct {
+		name           string
+		corruptAlways  bool
+		expectedStatus domain.Status
+		expectedCode   string
+	}{
+		{
+			name:           "bad piece recovered",
+			expectedStatus: domain.StatusCompleted,
+		},
+		{
+			name:           "piece cannot be recovered",
+			corruptAlways:  true,
+			expectedStatus: domain.StatusFailed,
+			expectedCode:   ErrorCodeChecksum,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data := content
+				if requests.Add(1) == 1 || tt.corruptAlways {
+					data = corrupt
+				}
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(1, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			task, err := tm.CreateTaskWithOptions([]string{srv.URL + "/file.bin"}, TaskOptions{
+				Expected: []ExpectedFile{{Pieces: &pieces}},
+			})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			wp.processTask(0, DownloadTask{TaskID: task.ID, FileIndex: 0})
+
+			task, _ = tm.GetTask(task.ID)
+			file := task.Files[0]
+			if file.Status != tt.expectedStatus || file.ErrorCode != tt.expectedCode {
+				t.Fatalf("expected %s (%q), got %s (%q): %s", tt.expectedStatus, tt.expectedCode, file.Status, file.ErrorCode, file.Error)
+			}
+			if _, err := os.Stat(wp.downloader.PartPath(task.ID, 0)); !os.IsNotExist(err) {
+				t.Errorf("expected no part file to be left, got %v", err)
+			}
+			if tt.expectedStatus != domain.StatusCompleted {
+				return
+			}
+			saved, err := os.ReadFile(filepath.Join(wp.downloader.TaskDir(task.ID), file.Filename))
+			if err != nil || !bytes.Equal(saved, content) {
+				t.Errorf("expected the saved file to match the content, got %d bytes (%v)", len(saved), err)
+			}
+		})
+	}
+}
+
+// TestValidatePieces tests the checks of the piece hashes of a file
+func TestValidatePieces(t *testing.T) {
+	sum := hex.EncodeToString(make([]byte, sha256.Size))
+	tests := []struct {
+		name     string
+		pieces   domain.PieceHashes
+		size     int64
+		expected bool
+	}{
+		{name: "valid", pieces: domain.PieceHashes{Size: MinPieceSize, SHA256: []string{sum, sum}}, expected: true},
+		{name: "size fits", pieces: domain.PieceHashes{Size: MinPieceSize, SHA256: []string{sum, sum}}, size: MinPieceSize + 1, expected: true},
+		{name: "too many hashes for the size", pieces: domain.PieceHashes{Size: MinPieceSize, SHA256: []string{sum, sum}}, size: MinPieceSize},
+		{name: "piece too small", pieces: domain.PieceHashes{Size: MinPieceSize - 1, SHA256: []string{sum}}},
+		{name: "no hashes", pieces: domain.PieceHashes{Size: MinPieceSize}},
+		{name: "invalid hash", pieces: domain.PieceHashes{Size: MinPieceSize, SHA256: []string{sum, "abc"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidatePieces(tt.pieces, tt.size); (err == nil) != tt.expected {
+				t.Errorf("expected valid %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"container/heap"
+	"sort"
+	"sync"
+)
+
+// popResult describes why priorityQueue.Pop returned
+type popResult int
+
+const (
+	popTask popResult = iota
+	popRetire
+	popClosed
+)
+
+type queueItem struct {
+	task DownloadTask
+	seq  uint64
+}
+
+// queueHeap orders items by descending priority, then by insertion order
+type queueHeap []queueItem
+
+func (h queueHeap) Len() int { return len(h) }
+
+func (h queueHeap) Less(i, j int) bool {
+	if h[i].task.Priority != h[j].task.Priority {
+		return h[i].task.Priority > h[j].task.Priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h queueHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *queueHeap) Push(x any) { *h = append(*h, x.(queueItem)) }
+
+func (h *queueHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// priorityQueue is an unbounded blocking queue of download tasks.
+// Higher priorities are dispatched first; equal priorities keep FIFO order.
+// A file is queued at most once.
+type priorityQueue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	items    queueHeap
+	queued   map[fileRef]bool
+	seq      uint64
+	retiring int
+	closed   bool
+	paused   bool
+}
+
+// newPriorityQueue creates an empty priority queue
+func newPriorityQueue() *priorityQueue {
+	q := &priorityQueue{queued: make(map[fileRef]bool)}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Push adds a task to the queue; it returns false if the queue is closed.
+// A file that is already queued keeps its place and is not added again.
+func (q *priorityQueue) Push(task DownloadTask) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return false
+	}
+	ref := fileRef{taskID: task.TaskID, index: task.FileIndex}
+	if q.queued[ref] {
+		return true
+	}
+
+	q.queued[ref] = true
+	heap.Push(&q.items, queueItem{task: task, seq: q.seq})
+	q.seq++
+	q.cond.Signal()
+	return true
+}
+
+// Pop blocks until a task is available, a worker is asked to retire, or the
+// queue is closed. While the queue is paused, tasks are held back.
+func (q *priorityQueue) Pop() (DownloadTask, popResult) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for {
+		switch {
+		case q.closed:
+			return DownloadTask{}, popClosed
+		case q.retiring > 0:
+			q.retiring--
+			return DownloadTask{}, popRetire
+		case len(q.items) > 0 && !q.paused:
+			item := heap.Pop(&q.items).(queueItem)
+			delete(q.queued, fileRef{taskID: item.task.TaskID, index: item.task.FileIndex})
+			return item.task, popTask
+		}
+		q.cond.Wait()
+	}
+}
+
+// Retire asks n waiting workers to exit
+func (q *priorityQueue) Retire(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.retiring += n
+	q.cond.Broadcast()
+}
+
+// SetPaused stops or resumes handing out tasks; pushes are accepted either way
+func (q *priorityQueue) SetPaused(paused bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.paused = paused
+	q.cond.Broadcast()
+}
+
+// Paused reports whether the queue holds back its tasks
+func (q *priorityQueue) Paused() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.paused
+}
+
+// Close wakes all waiting workers and rejects further pushes
+func (q *priorityQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// Len returns the number of queued tasks
+func (q *priorityQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// Queued reports whether the file with the given index of a task is waiting
+// in the queue
+func (q *priorityQueue) Queued(taskID string, index int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.queued[fileRef{taskID: taskID, index: index}]
+}
+
+// Ahead returns the number of queued tasks that are dispatched before each
+// queued file of the task with the given ID, keyed by file index
+func (q *priorityQueue) Ahead(taskID string) map[int]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var own queueHeap
+	for _, item := range q.items {
+		if item.task.TaskID == taskID {
+			own = append(own, item)
+		}
+	}
+	if len(own) == 0 {
+		return nil
+	}
+	sort.Sort(own)
+
+	// every other item is ahead of the files of the task that come after it,
+	// so counting where each one falls among them avoids sorting the queue
+	falls := make([]int, len(own)+1)
+	for _, item := range q.items {
+		if item.task.TaskID == taskID {
+			continue
+		}
+		i := sort.Search(len(own), func(j int) bool {
+			return queueHeap{item, own[j]}.Less(0, 1)
+		})
+		falls[i]++
+	}
+	ahead := make(map[int]int, len(own))
+	others := 0
+	for i, item := range own {
+		others += falls[i]
+		ahead[item.task.FileIndex] = others + i
+	}
+	return ahead
+}
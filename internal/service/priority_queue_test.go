@@ -0,0 +1,196 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+// TestPriorityQueueOrder tests priority ordering with FIFO within the same priority
+func TestPriorityQueueOrder(t *testing.T) {
+	tests := []struct {
+		name       string
+		priorities []int
+		expected   []string
+	}{
+		{
+			name:       "same priority keeps FIFO",
+			priorities: []int{0, 0, 0},
+			expected:   []string{"t0", "t1", "t2"},
+		},
+		{
+			name:       "higher priority first",
+			priorities: []int{0, 5, 1},
+			expected:   []string{"t1", "t2", "t0"},
+		},
+		{
+			name:       "mixed priorities keep FIFO per level",
+			priorities: []int{1, 0, 1, 0, 2},
+			expected:   []string{"t4", "t0", "t2", "t1", "t3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newPriorityQueue()
+			for i, p := range tt.priorities {
+				q.Push(DownloadTask{TaskID: "t" + string(rune('0'+i)), Priority: p})
+			}
+
+			for _, want := range tt.expected {
+				task, result := q.Pop()
+				if result != popTask {
+					t.Fatalf("expected task, got result %d", result)
+				}
+				if task.TaskID != want {
+					t.Errorf("expected %s, got %s", want, task.TaskID)
+				}
+			}
+		})
+	}
+}
+
+// TestPriorityQueueClose tests that Close wakes blocked consumers and rejects pushes
+func TestPriorityQueueClose(t *testing.T) {
+	q := newPriorityQueue()
+
+	done := make(chan popResult)
+	go func() {
+		_, result := q.Pop()
+		done <- result
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	q.Close()
+
+	select {
+	case result := <-done:
+		if result != popClosed {
+			t.Errorf("expected popClosed, got %d", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Pop did not return after Close")
+	}
+
+	if q.Push(DownloadTask{TaskID: "late"}) {
+		t.Errorf("expected push to closed queue to fail")
+	}
+}
+
+// TestPriorityQueuePause tests that a paused queue holds back tasks until resumed
+func TestPriorityQueuePause(t *testing.T) {
+	q := newPriorityQueue()
+	q.SetPaused(true)
+	if !q.Push(DownloadTask{TaskID: "held"}) {
+		t.Fatalf("expected push to paused queue to succeed")
+	}
+
+	done := make(chan DownloadTask)
+	go func() {
+		task, _ := q.Pop()
+		done <- task
+	}()
+
+	select {
+	case task := <-done:
+		t.Fatalf("expected paused queue to hold back tasks, got %s", task.TaskID)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	q.SetPaused(false)
+	select {
+	case task := <-done:
+		if task.TaskID != "held" {
+			t.Errorf("expected task held, got %s", task.TaskID)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Pop did not return after resume")
+	}
+}
+
+// TestPriorityQueueDedupe tests that a file already in the queue is not queued again
+func TestPriorityQueueDedupe(t *testing.T) {
+	q := newPriorityQueue()
+	q.Push(DownloadTask{TaskID: "task", FileIndex: 0})
+	q.Push(DownloadTask{TaskID: "task", FileIndex: 1})
+	q.Push(DownloadTask{TaskID: "task", FileIndex: 0, Priority: 5})
+	if q.Len() != 2 {
+		t.Fatalf("expected 2 queued files, got %d", q.Len())
+	}
+	if !q.Queued("task", 0) || q.Queued("task", 2) {
+		t.Errorf("expected only the pushed files to be queued")
+	}
+
+	if task, _ := q.Pop(); task.FileIndex != 0 || task.Priority != 0 {
+		t.Errorf("expected the file to keep its first place, got %+v", task)
+	}
+	if q.Queued("task", 0) {
+		t.Errorf("expected a popped file not to be queued")
+	}
+	q.Push(DownloadTask{TaskID: "task", FileIndex: 0})
+	if q.Len() != 2 {
+		t.Errorf("expected a popped file to be queued again, got %d queued", q.Len())
+	}
+}
+
+// TestPriorityQueueAhead tests how many queued files are dispatched before each queued file of a task
+func TestPriorityQueueAhead(t *testing.T) {
+	tests := []struct {
+		name     string
+		queued   []DownloadTask
+		expected map[int]int
+	}{
+		{
+			name:   "not queued",
+			queued: []DownloadTask{{TaskID: "other"}},
+		},
+		{
+			name: "FIFO",
+			queued: []DownloadTask{
+				{TaskID: "other"},
+				{TaskID: "task", FileIndex: 0},
+				{TaskID: "other", FileIndex: 1},
+				{TaskID: "task", FileIndex: 1},
+			},
+			expected: map[int]int{0: 1, 1: 3},
+		},
+		{
+			name: "higher priority is ahead",
+			queued: []DownloadTask{
+				{TaskID: "task", FileIndex: 0},
+				{TaskID: "task", FileIndex: 1},
+				{TaskID: "other", Priority: 5},
+				{TaskID: "other", FileIndex: 1, Priority: -1},
+			},
+			expected: map[int]int{0: 1, 1: 2},
+		},
+		{
+			name: "priorities within the task",
+			queued: []DownloadTask{
+				{TaskID: "other", Priority: 1},
+				{TaskID: "task", FileIndex: 0},
+				{TaskID: "task", FileIndex: 1, Priority: 2},
+				{TaskID: "other", FileIndex: 1},
+			},
+			expected: map[int]int{0: 2, 1: 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newPriorityQueue()
+			for _, task := range tt.queued {
+				q.Push(task)
+			}
+
+			ahead := q.Ahead("task")
+			if len(ahead) != len(tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, ahead)
+			}
+			for index, want := range tt.expected {
+				if ahead[index] != want {
+					t.Errorf("expected %d files ahead of file %d, got %d", want, index, ahead[index])
+				}
+			}
+		})
+	}
+}
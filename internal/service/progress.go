@@ -0,0 +1,76 @@
+package service
+
+import (
+	"io"
+	"time"
+)
+
+const (
+	// progressInterval is the minimum time between two progress reports of a download
+	progressInterval = time.Second
+	// speedWindow is the period the download speed is averaged over
+	speedWindow = 5 * time.Second
+)
+
+// ProgressFunc receives the bytes of a file downloaded so far, including
+// resumed bytes, and the current speed in bytes per second
+type ProgressFunc func(downloaded, speed int64)
+
+// progressReader reports the progress of a download at most once per
+// progressInterval while its body is read
+type progressReader struct {
+	r      io.Reader
+	n      int64
+	report ProgressFunc
+	meter  speedMeter
+	last   time.Time
+	now    func() time.Time
+}
+
+// newProgressReader wraps r, counting from offset bytes already downloaded
+func newProgressReader(r io.Reader, offset int64, report ProgressFunc) *progressReader {
+	p := &progressReader{r: r, n: offset, report: report, now: time.Now}
+	p.last = p.now()
+	p.meter.Add(p.last, offset)
+	return p
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.n += int64(n)
+	if now := p.now(); now.Sub(p.last) >= progressInterval {
+		p.last = now
+		p.report(p.n, p.meter.Add(now, p.n))
+	}
+	return n, err
+}
+
+// speedSample is the byte count of a download at a point in time
+type speedSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// speedMeter computes a download speed over a sliding window of samples
+type speedMeter struct {
+	samples []speedSample
+}
+
+// Add records the byte count at time at and returns the speed in bytes per
+// second across the samples still inside the window
+func (m *speedMeter) Add(at time.Time, bytes int64) int64 {
+	m.samples = append(m.samples, speedSample{at: at, bytes: bytes})
+	drop := 0
+	// keep one sample at or before the window start as the baseline
+	for drop < len(m.samples)-2 && at.Sub(m.samples[drop+1].at) >= speedWindow {
+		drop++
+	}
+	m.samples = m.samples[drop:]
+
+	first := m.samples[0]
+	elapsed := at.Sub(first.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return int64(float64(bytes-first.bytes) / elapsed)
+}
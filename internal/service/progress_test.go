@@ -0,0 +1,84 @@
+package service
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSpeedMeter tests the speed averaged over the sliding window
+func TestSpeedMeter(t *testing.T) {
+	tests := []struct {
+		name          string
+		samples       []int64
+		step          time.Duration
+		expectedSpeed int64
+	}{
+		{
+			name:          "single sample",
+			samples:       []int64{100},
+			step:          time.Second,
+			expectedSpeed: 0,
+		},
+		{
+			name:          "steady speed",
+			samples:       []int64{0, 1000, 2000, 3000},
+			step:          time.Second,
+			expectedSpeed: 1000,
+		},
+		{
+			name:          "old samples leave the window",
+			samples:       []int64{0, 100, 200, 300, 400, 500, 5500, 10500},
+			step:          time.Second,
+			expectedSpeed: 2060,
+		},
+		{
+			name:          "stalled download",
+			samples:       []int64{0, 500, 500, 500, 500, 500, 500, 500},
+			step:          time.Second,
+			expectedSpeed: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var meter speedMeter
+			start := time.Now()
+			var speed int64
+			for i, bytes := range tt.samples {
+				speed = meter.Add(start.Add(time.Duration(i)*tt.step), bytes)
+			}
+			if speed != tt.expectedSpeed {
+				t.Errorf("expected speed %d, got %d", tt.expectedSpeed, speed)
+			}
+		})
+	}
+}
+
+// TestProgressReader tests that progress is reported at most once per interval, counting from the offset
+func TestProgressReader(t *testing.T) {
+	now := time.Now()
+	var reports []int64
+	p := newProgressReader(strings.NewReader("0123456789"), 100, func(downloaded, speed int64) {
+		reports = append(reports, downloaded)
+	})
+	p.now = func() time.Time { return now }
+
+	buf := make([]byte, 2)
+	read := func() {
+		if _, err := p.Read(buf); err != nil && err != io.EOF {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	read()
+	now = now.Add(progressInterval / 2)
+	read()
+	now = now.Add(progressInterval)
+	read()
+	read()
+
+	if len(reports) != 1 || reports[0] != 106 {
+		t.Errorf("expected a single report at 106 bytes, got %v", reports)
+	}
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// proxyFunc returns the Proxy function for the downloader transport. Without an
+// explicit proxy URL the standard HTTP_PROXY/HTTPS_PROXY/NO_PROXY variables are
+// used. An explicit URL (http, https or socks5) applies to every request except
+// hosts listed in NO_PROXY.
+func proxyFunc(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	bypass := parseNoProxy(noProxy)
+
+	return func(req *http.Request) (*url.URL, error) {
+		if bypass(req.URL.Hostname()) {
+			return nil, nil
+		}
+		return u, nil
+	}, nil
+}
+
+// parseNoProxy builds a matcher for a comma-separated NO_PROXY list. Entries
+// may be "*", host names (matching the host and its subdomains), IP addresses
+// or CIDR ranges; ports are ignored.
+func parseNoProxy(list string) func(host string) bool {
+	var domains []string
+	var networks []*net.IPNet
+	var ips []net.IP
+	all := false
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			all = true
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+			continue
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			ips = append(ips, ip)
+			continue
+		}
+		domains = append(domains, strings.TrimPrefix(entry, "."))
+	}
+
+	return func(host string) bool {
+		if all {
+			return true
+		}
+		host = strings.ToLower(host)
+		if ip := net.ParseIP(host); ip != nil {
+			for _, n := range networks {
+				if n.Contains(ip) {
+					return true
+				}
+			}
+			for _, i := range ips {
+				if i.Equal(ip) {
+					return true
+				}
+			}
+			return false
+		}
+		for _, d := range domains {
+			if host == d || strings.HasSuffix(host, "."+d) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"filedownloader-20240926/internal/config"
+)
+
+// TestDownloaderProxy tests that size probes and downloads go through the configured proxy
+func TestDownloaderProxy(t *testing.T) {
+	tests := []struct {
+		name            string
+		noProxy         string
+		expectedProxied int32
+	}{
+		{
+			name:            "proxied",
+			noProxy:         "",
+			expectedProxied: 2,
+		},
+		{
+			name:            "bypassed by NO_PROXY",
+			noProxy:         "127.0.0.1",
+			expectedProxied: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				io.WriteString(w, "direct")
+			}))
+			defer target.Close()
+
+			var proxied atomic.Int32
+			proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				// a forward proxy receives the absolute target URL
+				if !r.URL.IsAbs() {
+					t.Errorf("expected absolute URL at proxy, got %s", r.URL)
+				}
+				proxied.Add(1)
+				w.Header().Set("Content-Type", "text/plain")
+				io.WriteString(w, "via proxy")
+			}))
+			defer proxy.Close()
+
+			t.Setenv("NO_PROXY", tt.noProxy)
+			d := NewDownloaderWithConfig(config.DownloadConfig{ProxyURL: proxy.URL})
+			d.downloadsDir = t.TempDir()
+
+			if _, err := d.GetFileSize(context.Background(), target.URL+"/file.txt"); err != nil {
+				t.Fatalf("GetFileSize error: %v", err)
+			}
+			if _, err := d.DownloadFile(context.Background(), target.URL+"/file.txt", "file.txt"); err != nil {
+				t.Fatalf("DownloadFile error: %v", err)
+			}
+
+			if got := proxied.Load(); got != tt.expectedProxied {
+				t.Errorf("expected %d proxied requests, got %d", tt.expectedProxied, got)
+			}
+		})
+	}
+}
+
+// TestParseNoProxy tests NO_PROXY matching
+func TestParseNoProxy(t *testing.T) {
+	tests := []struct {
+		name     string
+		list     string
+		host     string
+		expected bool
+	}{
+		{name: "empty list", list: "", host: "example.com", expected: false},
+		{name: "wildcard", list: "*", host: "example.com", expected: true},
+		{name: "exact host", list: "example.com", host: "example.com", expected: true},
+		{name: "subdomain", list: "example.com", host: "files.example.com", expected: true},
+		{name: "leading dot", list: ".example.com", host: "files.example.com", expected: true},
+		{name: "suffix is not a subdomain", list: "example.com", host: "badexample.com", expected: false},
+		{name: "host with port", list: "internal:8080", host: "internal", expected: true},
+		{name: "ip address", list: "10.0.0.1", host: "10.0.0.1", expected: true},
+		{name: "cidr range", list: "10.0.0.0/8", host: "10.1.2.3", expected: true},
+		{name: "outside cidr", list: "10.0.0.0/8", host: "192.168.0.1", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseNoProxy(tt.list)(tt.host); got != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestProxyFuncSchemes tests that explicit proxy URLs are returned for every supported scheme
+func TestProxyFuncSchemes(t *testing.T) {
+	tests := []struct {
+		name     string
+		proxyURL string
+	}{
+		{name: "http", proxyURL: "http://proxy.local:3128"},
+		{name: "https", proxyURL: "https://proxy.local:3129"},
+		{name: "socks5", proxyURL: "socks5://proxy.local:1080"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NO_PROXY", "")
+			fn, err := proxyFunc(tt.proxyURL)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			req := &http.Request{URL: &url.URL{Scheme: "https", Host: "files.example.com"}}
+			got, err := fn(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got == nil || got.String() != tt.proxyURL {
+				t.Errorf("expected proxy %s, got %v", tt.proxyURL, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package service
+
+import "time"
+
+// QueueEstimate describes where the queued files of a task stand in the
+// worker pool queue. It is a snapshot: files of higher priority queued later
+// move ahead, and the wait assumes the upcoming downloads take as long as the
+// recent ones did.
+type QueueEstimate struct {
+	// FilesAhead is the number of files dispatched before the first queued
+	// file of the task
+	FilesAhead int
+	// Files maps the index of each queued file to the files ahead of it
+	Files map[int]int
+	// Wait is the approximate time until the first queued file starts,
+	// valid when WaitKnown is set
+	Wait      time.Duration
+	WaitKnown bool
+}
+
+// QueueEstimate returns where the queued files of a task stand, or false when
+// none of its files are waiting for a worker. The wait is left unknown while
+// the pool is paused or before any download has completed.
+func (wp *WorkerPool) QueueEstimate(taskID string) (QueueEstimate, bool) {
+	files := wp.queue.Ahead(taskID)
+	if len(files) == 0 {
+		return QueueEstimate{}, false
+	}
+	est := QueueEstimate{FilesAhead: -1, Files: files}
+	for _, ahead := range files {
+		if est.FilesAhead < 0 || ahead < est.FilesAhead {
+			est.FilesAhead = ahead
+		}
+	}
+	if !wp.Paused() && wp.Running() {
+		est.Wait, est.WaitKnown = estimateWait(est.FilesAhead, wp.WorkerCount(), wp.BusyWorkers(), wp.recent.average())
+	}
+	return est, true
+}
+
+// estimateWait approximates how long a file with ahead files before it waits
+// for a worker, assuming every download takes avg and the workers finish
+// them at an even rate
+func estimateWait(ahead, workers, busy int, avg time.Duration) (time.Duration, bool) {
+	if avg <= 0 || workers <= 0 {
+		return 0, false
+	}
+	idle := max(workers-busy, 0)
+	if ahead < idle {
+		return 0, true
+	}
+	return avg * time.Duration(ahead-idle+1) / time.Duration(workers), true
+}
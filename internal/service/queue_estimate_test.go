@@ -0,0 +1,79 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"filedownloader-20240926/internal/repository"
+)
+
+// TestEstimateWait tests the approximate wait of a queued file
+func TestEstimateWait(t *testing.T) {
+	tests := []struct {
+		name          string
+		ahead         int
+		workers       int
+		busy          int
+		avg           time.Duration
+		expected      time.Duration
+		expectedKnown bool
+	}{
+		{name: "no completed downloads", ahead: 3, workers: 2, busy: 2},
+		{name: "idle worker", ahead: 0, workers: 2, busy: 1, avg: time.Minute, expectedKnown: true},
+		{name: "next for a busy worker", ahead: 0, workers: 2, busy: 2, avg: time.Minute, expected: 30 * time.Second, expectedKnown: true},
+		{name: "behind other files", ahead: 3, workers: 2, busy: 2, avg: time.Minute, expected: 2 * time.Minute, expectedKnown: true},
+		{name: "behind files taking the idle workers", ahead: 3, workers: 4, busy: 2, avg: time.Minute, expected: 30 * time.Second, expectedKnown: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, known := estimateWait(tt.ahead, tt.workers, tt.busy, tt.avg)
+			if wait != tt.expected || known != tt.expectedKnown {
+				t.Errorf("expected %v (known %v), got %v (known %v)", tt.expected, tt.expectedKnown, wait, known)
+			}
+		})
+	}
+}
+
+// TestRecentDurations tests that the average covers the latest downloads only
+func TestRecentDurations(t *testing.T) {
+	var recent recentDurations
+	if avg := recent.average(); avg != 0 {
+		t.Errorf("expected no average without downloads, got %v", avg)
+	}
+	recent.add(time.Second)
+	recent.add(3 * time.Second)
+	if avg := recent.average(); avg != 2*time.Second {
+		t.Errorf("expected an average of 2s, got %v", avg)
+	}
+	for i := 0; i < recentSamples; i++ {
+		recent.add(time.Minute)
+	}
+	if avg := recent.average(); avg != time.Minute {
+		t.Errorf("expected older downloads to drop out of the average, got %v", avg)
+	}
+}
+
+// TestWorkerPoolQueueEstimate tests the queue estimate of a task with files waiting for a worker
+func TestWorkerPoolQueueEstimate(t *testing.T) {
+	tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	wp := NewWorkerPool(1, tm)
+
+	if _, ok := wp.QueueEstimate("task"); ok {
+		t.Fatalf("expected no estimate for a task without queued files")
+	}
+	wp.queue.Push(DownloadTask{TaskID: "other"})
+	wp.queue.Push(DownloadTask{TaskID: "task", FileIndex: 1})
+	wp.queue.Push(DownloadTask{TaskID: "task", FileIndex: 2})
+
+	est, ok := wp.QueueEstimate("task")
+	if !ok {
+		t.Fatalf("expected an estimate for queued files")
+	}
+	if est.FilesAhead != 1 || est.Files[1] != 1 || est.Files[2] != 2 {
+		t.Errorf("expected files 1 and 2 behind 1 and 2 files, got %d ahead and %v", est.FilesAhead, est.Files)
+	}
+	if est.WaitKnown {
+		t.Errorf("expected no wait estimate without completed downloads, got %v", est.Wait)
+	}
+}
@@ -1,43 +1,89 @@
 package service
 
 import (
-	"log"
+	"os"
+	"sort"
 
 	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/pkg/logger"
 )
 
-// RecoverIncompleteTasks recovers incomplete tasks on startup
-func (tm *TaskManager) RecoverIncompleteTasks() {
-	log.Println("Recovering incomplete tasks...")
+// RecoverIncompleteTasks recovers incomplete tasks on startup and returns how
+// many tasks were reset to pending
+func (tm *TaskManager) RecoverIncompleteTasks() int {
+	return tm.recoverIncompleteTasks(nil)
+}
+
+// recoverIncompleteTasks resets interrupted tasks. Files for which inFlight
+// reports true are being downloaded right now and are left alone, so that
+// recovery can also run while the workers are busy.
+func (tm *TaskManager) recoverIncompleteTasks(inFlight func(taskID string, index int) bool) int {
+	logger.Logger.Info("Recovering incomplete tasks")
 
-	tasks := tm.GetAllTasks()
+	busy := func(taskID string, index int) bool {
+		return inFlight != nil && inFlight(taskID, index)
+	}
 	recovered := 0
 
-	for _, task := range tasks {
-		if task.Status == domain.StatusPending || task.Status == domain.StatusDownloading {
-			log.Printf("Recovering task %s with status %s", task.ID, task.Status)
-			originalStatus := task.Status
-			task.Status = domain.StatusPending
-			task.Progress = 0
-			for i := range task.Files {
-				if task.Files[i].Status != domain.StatusCompleted {
-					task.Files[i].Status = domain.StatusPending
-					task.Files[i].Downloaded = 0
+	for taskID := range tm.GetAllTasks() {
+		var originalStatus domain.Status
+		reset := false
+		_, err := tm.ModifyTask(taskID, func(task *domain.Task) error {
+			originalStatus = task.Status
+			switch task.Status {
+			case domain.StatusPending, domain.StatusDownloading, domain.StatusQueued:
+				active := false
+				for i := range task.Files {
+					if busy(task.ID, i) {
+						active = true
+						continue
+					}
+					// failed files have used up their attempts; retrying ones
+					// continue with the attempts they have left
+					if !fileTerminal(task.Files[i].Status) {
+						task.Files[i].Status = domain.StatusPending
+						task.Files[i].Downloaded = 0
+						task.Files[i].Speed = 0
+						task.Files[i].Error = ""
+						task.Files[i].ErrorCode = ""
+					}
+				}
+				if active {
+					refreshTaskStatus(task)
+				} else {
+					task.Status = domain.StatusPending
+					task.Progress = 0
+				}
+				reset = true
+			case domain.StatusPaused:
+				// files interrupted mid-download stay paused until the task is resumed
+				for i := range task.Files {
+					if busy(task.ID, i) {
+						continue
+					}
+					switch task.Files[i].Status {
+					case domain.StatusDownloading, domain.StatusPending, domain.StatusRetrying:
+						task.Files[i].Status = domain.StatusPaused
+						task.Files[i].Downloaded = 0
+					}
 				}
 			}
-			if err := tm.UpdateTask(task); err != nil {
-				log.Printf("Failed to update recovered task %s: %v", task.ID, err)
-			} else {
-				log.Printf("Recovered task %s from %s to %s", task.ID, originalStatus, task.Status)
-				recovered++
-			}
+			return nil
+		})
+		switch {
+		case err != nil:
+			logger.Logger.Error("Failed to update recovered task", "task_id", taskID, "error", err)
+		case reset:
+			logger.Logger.Info("Recovered task to pending", "task_id", taskID, "previous_status", originalStatus)
+			recovered++
 		}
 	}
 
-	log.Printf("Recovered %d incomplete tasks", recovered)
+	logger.Logger.Info("Recovered incomplete tasks", "count", recovered)
+	return recovered
 }
 
-// GetIncompleteTasks returns list of incomplete tasks
+// GetIncompleteTasks returns snapshots of incomplete tasks
 func (tm *TaskManager) GetIncompleteTasks() []*domain.Task {
 	tm.mutex.RLock()
 	defer tm.mutex.RUnlock()
@@ -45,26 +91,109 @@ func (tm *TaskManager) GetIncompleteTasks() []*domain.Task {
 	var incomplete []*domain.Task
 	for _, task := range tm.tasks {
 		if task.Status == domain.StatusPending || task.Status == domain.StatusDownloading {
-			incomplete = append(incomplete, task)
+			incomplete = append(incomplete, task.Clone())
 		}
 	}
 
 	return incomplete
 }
 
-// ResumeTasks resumes processing of incomplete tasks
-func (wp *WorkerPool) ResumeTasks(tasks []*domain.Task) {
-	log.Printf("Resuming %d incomplete tasks", len(tasks))
+// ResumeIncompleteTasks resets tasks interrupted by a crash or shutdown and
+// enqueues their pending files. Call it once at startup after Start.
+func (wp *WorkerPool) ResumeIncompleteTasks() {
+	if wp.tm == nil {
+		return
+	}
+	wp.tm.RecoverIncompleteTasks()
+	wp.restorePartialDownloads()
+	wp.ResumeTasks(wp.tm.GetIncompleteTasks())
+}
+
+// Recover runs the startup recovery on a running pool, for tasks left stuck
+// after an upstream outage. Files a worker is downloading right now or that
+// are still queued are not touched. It returns how many tasks were reset and how many had files
+// enqueued again.
+func (wp *WorkerPool) Recover() (recovered, resumed int, err error) {
+	if wp.tm == nil || !wp.Running() {
+		return 0, 0, ErrPoolStopped
+	}
+	recovered = wp.tm.recoverIncompleteTasks(wp.inFlight)
+	wp.restorePartialDownloads()
+	resumed = wp.ResumeTasks(wp.tm.GetIncompleteTasks())
+	return recovered, resumed, nil
+}
+
+// restorePartialDownloads sets Downloaded of interrupted files to the size of
+// their part file, so that the download continues from there. A partial
+// larger than the known remote size cannot be a prefix of the file and is
+// discarded.
+func (wp *WorkerPool) restorePartialDownloads() {
+	for taskID, task := range wp.tm.GetAllTasks() {
+		if task.Status != domain.StatusPending && task.Status != domain.StatusPaused {
+			continue
+		}
+		for i, file := range task.Files {
+			if file.Status != domain.StatusPending && file.Status != domain.StatusPaused {
+				continue
+			}
+			if wp.inFlight(taskID, i) {
+				continue
+			}
+			path := wp.downloader.PartPath(taskID, i)
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			partial := info.Size()
+			if file.Size > 0 && partial > file.Size {
+				logger.Logger.Warn("Discarding partial download larger than the file", "task_id", taskID, "file_index", i, "url", file.URL, "partial", partial, "size", file.Size)
+				os.Remove(path)
+				continue
+			}
+			logger.Logger.Info("Found partial download", "task_id", taskID, "file_index", i, "url", file.URL, "partial", partial)
+			wp.updateFile(taskID, i, func(f *domain.File) {
+				f.Downloaded = partial
+			})
+		}
+	}
+}
+
+// ResumeTasks resumes processing of incomplete tasks by enqueueing their
+// pending files and returns how many tasks had files enqueued
+func (wp *WorkerPool) ResumeTasks(tasks []*domain.Task) int {
+	logger.Logger.Info("Resuming incomplete tasks", "count", len(tasks))
 
+	// tasks over the active task limit queue up in the order they were created
+	sort.SliceStable(tasks, func(i, j int) bool {
+		return tasks[i].CreatedAt.Before(tasks[j].CreatedAt)
+	})
+
+	resumed := 0
 	for _, task := range tasks {
-		for i := range task.Files {
-			if task.Files[i].Status != domain.StatusCompleted {
-				downloadTask := DownloadTask{
-					File:   &task.Files[i],
-					TaskID: task.ID,
-				}
-				wp.AddTask(downloadTask)
+		if !wp.admitTask(task) {
+			continue
+		}
+		if wp.enqueuePending(task) {
+			resumed++
+		}
+	}
+	return resumed
+}
+
+// enqueuePending enqueues the pending files of a task that are neither
+// queued nor being processed by a worker and reports whether there were any
+func (wp *WorkerPool) enqueuePending(task *domain.Task) bool {
+	enqueued := false
+	for i := range task.Files {
+		if task.Files[i].Status == domain.StatusPending && !wp.inFlight(task.ID, i) && !wp.queue.Queued(task.ID, i) {
+			enqueued = true
+			downloadTask := DownloadTask{
+				TaskID:    task.ID,
+				FileIndex: i,
+				Priority:  task.Priority,
 			}
+			wp.AddTask(downloadTask)
 		}
 	}
+	return enqueued
 }
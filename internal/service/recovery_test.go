@@ -0,0 +1,372 @@
+package service
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+)
+
+// TestWorkerPoolResumeIncompleteTasks tests that interrupted tasks persisted to
+// disk are downloaded after the components are restarted
+func TestWorkerPoolResumeIncompleteTasks(t *testing.T) {
+	tests := []struct {
+		name      string
+		interrupt func(t *testing.T, tm *TaskManager, task *domain.Task, started <-chan struct{})
+	}{
+		{
+			name: "crash mid-download",
+			interrupt: func(t *testing.T, tm *TaskManager, task *domain.Task, started <-chan struct{}) {
+				// the process died while the second file was downloading
+				task.Status = domain.StatusDownloading
+				task.Files[0].Status = domain.StatusCompleted
+				task.Files[1].Status = domain.StatusDownloading
+				task.Files[1].Downloaded = 3
+				if err := tm.UpdateTask(task); err != nil {
+					t.Fatalf("failed to update task: %v", err)
+				}
+			},
+		},
+		{
+			name: "graceful shutdown mid-download",
+			interrupt: func(t *testing.T, tm *TaskManager, task *domain.Task, started <-chan struct{}) {
+				wp := NewWorkerPool(1, tm)
+				wp.downloader.downloadsDir = t.TempDir()
+				wp.Start()
+				wp.ProcessFiles(task.ID, task.Files)
+				select {
+				case <-started:
+				case <-time.After(2 * time.Second):
+					t.Fatal("download did not start")
+				}
+				wp.Stop()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var restarted atomic.Bool
+			started := make(chan struct{})
+			var startOnce sync.Once
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "HEAD" {
+					return
+				}
+				if !restarted.Load() && strings.HasSuffix(r.URL.Path, "/slow.txt") {
+					// before the restart the slow file never finishes
+					startOnce.Do(func() { close(started) })
+					io.WriteString(w, "partial")
+					w.(http.Flusher).Flush()
+					<-r.Context().Done()
+					return
+				}
+				io.WriteString(w, "content of "+r.URL.Path)
+			}))
+			defer srv.Close()
+
+			stateDir := t.TempDir()
+			tm := NewTaskManagerWithStorage(repository.NewTaskStorageWithDir(stateDir))
+			task, err := tm.CreateTask([]string{srv.URL + "/fast.txt", srv.URL + "/slow.txt"})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			tt.interrupt(t, tm, task, started)
+
+			// restart: fresh components load the persisted state
+			restarted.Store(true)
+			tm = NewTaskManagerWithStorage(repository.NewTaskStorageWithDir(stateDir))
+			wp := NewWorkerPool(1, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			wp.Start()
+			defer wp.Stop()
+			wp.ResumeIncompleteTasks()
+
+			deadline := time.Now().Add(3 * time.Second)
+			for time.Now().Before(deadline) {
+				if current, _ := tm.GetTask(task.ID); current.Status == domain.StatusCompleted {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			resumed, _ := tm.GetTask(task.ID)
+			if resumed.Status != domain.StatusCompleted {
+				t.Fatalf("expected task to resume and complete, got %s (files: %+v)", resumed.Status, resumed.Files)
+			}
+			if resumed.Files[1].Size != int64(len("content of /slow.txt")) {
+				t.Errorf("expected the interrupted file to be downloaded in full, got size %d", resumed.Files[1].Size)
+			}
+		})
+	}
+}
+
+// TestWorkerPoolResumePartialDownload tests that a part file left by an
+// interrupted download is continued with a range request after a restart
+func TestWorkerPoolResumePartialDownload(t *testing.T) {
+	const content = "0123456789"
+
+	tests := []struct {
+		name          string
+		partial       string
+		knownSize     int64
+		ignoreRange   bool
+		expectedRange string
+	}{
+		{
+			name:          "continue from partial",
+			partial:       "0123",
+			knownSize:     int64(len(content)),
+			expectedRange: "bytes=4-",
+		},
+		{
+			name:          "partial larger than remote size",
+			partial:       "0123456789abc",
+			knownSize:     int64(len(content)),
+			expectedRange: "",
+		},
+		{
+			name:          "server ignores range",
+			partial:       "0123",
+			ignoreRange:   true,
+			expectedRange: "bytes=4-",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var ranges []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "GET" && r.Header.Get("Range") != "bytes=0-0" {
+					mu.Lock()
+					ranges = append(ranges, r.Header.Get("Range"))
+					mu.Unlock()
+				}
+				if tt.ignoreRange {
+					io.WriteString(w, content)
+					return
+				}
+				http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader(content))
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			task, err := tm.CreateTask([]string{srv.URL + "/file.txt"})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			task.Status = domain.StatusDownloading
+			task.Files[0].Status = domain.StatusDownloading
+			task.Files[0].Size = tt.knownSize
+			if err := tm.UpdateTask(task); err != nil {
+				t.Fatalf("failed to update task: %v", err)
+			}
+
+			wp := NewWorkerPool(1, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			partPath := wp.downloader.PartPath(task.ID, 0)
+			if err := os.MkdirAll(filepath.Dir(partPath), 0755); err != nil {
+				t.Fatalf("failed to create task dir: %v", err)
+			}
+			if err := os.WriteFile(partPath, []byte(tt.partial), 0644); err != nil {
+				t.Fatalf("failed to write part file: %v", err)
+			}
+
+			wp.Start()
+			defer wp.Stop()
+			wp.ResumeIncompleteTasks()
+
+			deadline := time.Now().Add(3 * time.Second)
+			for time.Now().Before(deadline) {
+				if current, _ := tm.GetTask(task.ID); current.Status == domain.StatusCompleted {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			resumed, _ := tm.GetTask(task.ID)
+			if resumed.Status != domain.StatusCompleted {
+				t.Fatalf("expected task to complete, got %s (files: %+v)", resumed.Status, resumed.Files)
+			}
+			saved, err := os.ReadFile(filepath.Join(wp.downloader.TaskDir(task.ID), resumed.Files[0].Filename))
+			if err != nil {
+				t.Fatalf("failed to read downloaded file: %v", err)
+			}
+			if !bytes.Equal(saved, []byte(content)) {
+				t.Errorf("expected content %q, got %q", content, saved)
+			}
+			if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+				t.Errorf("expected part file to be removed, got %v", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(ranges) != 1 || ranges[0] != tt.expectedRange {
+				t.Errorf("expected one download with range %q, got %q", tt.expectedRange, ranges)
+			}
+		})
+	}
+}
+
+// TestWorkerPoolResumeRetryingFiles tests that recovery keeps the attempt count
+// of retrying files and leaves files that ran out of attempts failed
+func TestWorkerPoolResumeRetryingFiles(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			return
+		}
+		io.WriteString(w, "content of "+r.URL.Path)
+	}))
+	defer srv.Close()
+
+	stateDir := t.TempDir()
+	tm := NewTaskManagerWithStorage(repository.NewTaskStorageWithDir(stateDir))
+	task, err := tm.CreateTask([]string{srv.URL + "/retrying.txt", srv.URL + "/failed.txt"})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	// the process died while the first file waited for its second attempt
+	task.Status = domain.StatusDownloading
+	task.Files[0].Status = domain.StatusRetrying
+	task.Files[0].Attempts = 1
+	task.Files[1].Status = domain.StatusFailed
+	task.Files[1].Attempts = 3
+	if err := tm.UpdateTask(task); err != nil {
+		t.Fatalf("failed to update task: %v", err)
+	}
+
+	tm = NewTaskManagerWithStorage(repository.NewTaskStorageWithDir(stateDir))
+	wp := NewWorkerPool(1, tm)
+	wp.downloader.downloadsDir = t.TempDir()
+	wp.SetRetryPolicy(3, 0)
+	wp.Start()
+	defer wp.Stop()
+	wp.ResumeIncompleteTasks()
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if current, _ := tm.GetTask(task.ID); current.Status == domain.StatusFailed {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resumed, _ := tm.GetTask(task.ID)
+	if resumed.Status != domain.StatusFailed {
+		t.Fatalf("expected task to fail on the exhausted file, got %s", resumed.Status)
+	}
+	if resumed.Files[0].Status != domain.StatusCompleted || resumed.Files[0].Attempts != 2 {
+		t.Errorf("expected retrying file to complete on attempt 2, got %s after %d", resumed.Files[0].Status, resumed.Files[0].Attempts)
+	}
+	if resumed.Files[1].Status != domain.StatusFailed || resumed.Files[1].Attempts != 3 {
+		t.Errorf("expected exhausted file to stay failed, got %s after %d", resumed.Files[1].Status, resumed.Files[1].Attempts)
+	}
+}
+
+// TestWorkerPoolRecover tests that recovery on a running pool re-enqueues
+// stuck files without touching the file a worker is downloading
+func TestWorkerPoolRecover(t *testing.T) {
+	release := make(chan struct{})
+	var slowGets atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.Path == "/slow.txt" {
+			slowGets.Add(1)
+			<-release
+		}
+		io.WriteString(w, "content of "+r.URL.Path)
+	}))
+	defer srv.Close()
+
+	tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	wp := NewWorkerPool(2, tm)
+	wp.downloader.downloadsDir = t.TempDir()
+
+	if _, _, err := wp.Recover(); err != ErrPoolStopped {
+		t.Fatalf("expected %v before start, got %v", ErrPoolStopped, err)
+	}
+
+	wp.Start()
+	defer wp.Stop()
+
+	task, err := tm.CreateTask([]string{srv.URL + "/slow.txt", srv.URL + "/stuck.txt"})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	// only the first file reaches the queue; the second is stuck pending
+	wp.AddTask(DownloadTask{TaskID: task.ID, FileIndex: 0})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for slowGets.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	recovered, resumed, err := wp.Recover()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if recovered != 1 || resumed != 1 {
+		t.Errorf("expected 1 recovered and 1 resumed task, got %d and %d", recovered, resumed)
+	}
+	if current, _ := tm.GetTask(task.ID); current.Files[0].Status != domain.StatusDownloading {
+		t.Errorf("expected the in-flight file to keep downloading, got %s", current.Files[0].Status)
+	}
+	close(release)
+
+	deadline = time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if current, _ := tm.GetTask(task.ID); current.Status == domain.StatusCompleted {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if current, _ := tm.GetTask(task.ID); current.Status != domain.StatusCompleted {
+		t.Fatalf("expected task to complete, got %s (files: %+v)", current.Status, current.Files)
+	}
+	if n := slowGets.Load(); n != 1 {
+		t.Errorf("expected the in-flight file to be requested once, got %d", n)
+	}
+}
+
+// TestWorkerPoolRecoverQueuedFiles tests that Recover does not queue again files still waiting in the queue
+func TestWorkerPoolRecoverQueuedFiles(t *testing.T) {
+	tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	wp := NewWorkerPool(1, tm)
+	wp.downloader.downloadsDir = t.TempDir()
+	wp.Start()
+	defer wp.Stop()
+	wp.SetPaused(true)
+
+	task, err := tm.CreateTask([]string{"http://example.com/a.txt", "http://example.com/b.txt"})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	wp.ProcessFiles(task.ID, task.Files)
+	if n := wp.QueueLen(); n != 2 {
+		t.Fatalf("expected 2 queued files, got %d", n)
+	}
+
+	_, resumed, err := wp.Recover()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resumed != 0 {
+		t.Errorf("expected no task to be resumed, got %d", resumed)
+	}
+	if n := wp.QueueLen(); n != 2 {
+		t.Errorf("expected the queue to keep 2 files, got %d", n)
+	}
+	if ahead := wp.queue.Ahead(task.ID); ahead[1] != 1 {
+		t.Errorf("expected 1 file ahead of the second one, got %v", ahead)
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// MaxRequestBodySize limits the body a task may send with its requests
+const MaxRequestBodySize = 64 * 1024
+
+// ValidDownloadMethod reports whether method can download the files of a task
+func ValidDownloadMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodPost
+}
+
+// ValidProbeMethod reports whether method can probe the size of the files of a task
+func ValidProbeMethod(method string) bool {
+	return method == http.MethodHead || ValidDownloadMethod(method)
+}
+
+// managedHeaders are set by the downloader itself, so a task cannot replace them
+var managedHeaders = map[string]bool{
+	"Accept-Encoding":   true,
+	"Connection":        true,
+	"Content-Length":    true,
+	"Host":              true,
+	"If-Modified-Since": true,
+	"If-None-Match":     true,
+	"If-Range":          true,
+	"Range":             true,
+	"Te":                true,
+	"Transfer-Encoding": true,
+	"Upgrade":           true,
+}
+
+// ValidRequestHeader reports whether a task may send a header of this name
+func ValidRequestHeader(name string) bool {
+	if name == "" || strings.ContainsFunc(name, func(r rune) bool {
+		return r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r)
+	}) {
+		return false
+	}
+	return !managedHeaders[textproto.CanonicalMIMEHeaderKey(name)]
+}
+
+// method returns the method of download requests
+func (o DownloadOptions) method() string {
+	if o.Method != "" {
+		return o.Method
+	}
+	return http.MethodGet
+}
+
+// probeMethod returns the method of size probes
+func (o DownloadOptions) probeMethod() string {
+	if o.ProbeMethod != "" {
+		return o.ProbeMethod
+	}
+	return http.MethodHead
+}
+
+// customRequest reports whether the requests of a download differ from a
+// plain GET, in which case the response may depend on more than the URL
+func (o DownloadOptions) customRequest() bool {
+	return o.method() != http.MethodGet || o.Body != "" || len(o.Headers) > 0
+}
+
+// newRequest creates a request of a download with the User-Agent, the task's
+// headers, which may replace it, and the credentials. The body is sent with
+// POST requests only.
+func (d *Downloader) newRequest(ctx context.Context, method, url string, opts DownloadOptions) (*http.Request, error) {
+	var body io.Reader
+	if method == http.MethodPost && opts.Body != "" {
+		body = strings.NewReader(opts.Body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", d.userAgentFor(opts))
+	for name, value := range opts.Headers {
+		req.Header.Set(name, value)
+	}
+	setCredentials(req, opts)
+	return req, nil
+}
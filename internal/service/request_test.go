@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// TestDownloaderCustomRequest tests the methods, body and headers of size probes and downloads
+func TestDownloaderCustomRequest(t *testing.T) {
+	const content = "report content"
+	tests := []struct {
+		name           string
+		opts           DownloadOptions
+		expectedProbe  string
+		expectedFetch  string
+		expectedBodies []string
+		expectedHeader string
+		expectedAgent  string
+	}{
+		{
+			name:           "defaults",
+			expectedProbe:  "HEAD",
+			expectedFetch:  "GET",
+			expectedBodies: []string{"", ""},
+			expectedAgent:  "test-agent",
+		},
+		{
+			name:           "POST download with a body",
+			opts:           DownloadOptions{Method: "POST", Body: `{"report": 7}`},
+			expectedProbe:  "HEAD",
+			expectedFetch:  "POST",
+			expectedBodies: []string{"", `{"report": 7}`},
+			expectedAgent:  "test-agent",
+		},
+		{
+			name:           "GET probe",
+			opts:           DownloadOptions{ProbeMethod: "GET"},
+			expectedProbe:  "GET",
+			expectedFetch:  "GET",
+			expectedBodies: []string{"", ""},
+			expectedAgent:  "test-agent",
+		},
+		{
+			name:           "POST probe and download",
+			opts:           DownloadOptions{Method: "POST", ProbeMethod: "POST", Body: "q=1"},
+			expectedProbe:  "POST",
+			expectedFetch:  "POST",
+			expectedBodies: []string{"q=1", "q=1"},
+			expectedAgent:  "test-agent",
+		},
+		{
+			name:           "headers on every request",
+			opts:           DownloadOptions{Headers: map[string]string{"X-Api-Key": "secret", "User-Agent": "custom"}},
+			expectedProbe:  "HEAD",
+			expectedFetch:  "GET",
+			expectedBodies: []string{"", ""},
+			expectedHeader: "secret",
+			expectedAgent:  "custom",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu      sync.Mutex
+				methods []string
+				bodies  []string
+				headers []string
+				agents  []string
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				mu.Lock()
+				methods = append(methods, r.Method)
+				bodies = append(bodies, string(body))
+				headers = append(headers, r.Header.Get("X-Api-Key"))
+				agents = append(agents, r.Header.Get("User-Agent"))
+				mu.Unlock()
+				if r.Header.Get("Range") == "bytes=0-0" {
+					w.Header().Set("Content-Range", "bytes 0-0/"+strconv.Itoa(len(content)))
+					w.WriteHeader(http.StatusPartialContent)
+					io.WriteString(w, content[:1])
+					return
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+				if r.Method != http.MethodHead {
+					io.WriteString(w, content)
+				}
+			}))
+			defer srv.Close()
+
+			d := NewDownloader()
+			d.downloadsDir = t.TempDir()
+			d.userAgent = "test-agent"
+
+			size, err := d.GetFileSizeWithOptions(context.Background(), srv.URL+"/report", tt.opts)
+			if err != nil || size != int64(len(content)) {
+				t.Fatalf("expected size %d, got %d (%v)", len(content), size, err)
+			}
+			result, err := d.fetch(context.Background(), srv.URL+"/report", "report.json", tt.opts)
+			if err != nil {
+				t.Fatalf("download failed: %v", err)
+			}
+			if saved, err := os.ReadFile(filepath.Join(d.downloadsDir, result.Filename)); err != nil || string(saved) != content {
+				t.Errorf("expected content %q, got %q (%v)", content, saved, err)
+			}
+
+			if len(methods) != 2 || methods[0] != tt.expectedProbe || methods[1] != tt.expectedFetch {
+				t.Fatalf("expected a %s probe and a %s download, got %v", tt.expectedProbe, tt.expectedFetch, methods)
+			}
+			for i := range methods {
+				if bodies[i] != tt.expectedBodies[i] {
+					t.Errorf("expected %s request body %q, got %q", methods[i], tt.expectedBodies[i], bodies[i])
+				}
+				if headers[i] != tt.expectedHeader {
+					t.Errorf("expected %s request header %q, got %q", methods[i], tt.expectedHeader, headers[i])
+				}
+				if agents[i] != tt.expectedAgent {
+					t.Errorf("expected %s request User-Agent %q, got %q", methods[i], tt.expectedAgent, agents[i])
+				}
+			}
+		})
+	}
+}
+
+// TestValidRequestHeader tests which headers a task may set
+func TestValidRequestHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		expected bool
+	}{
+		{name: "custom header", header: "X-Api-Key", expected: true},
+		{name: "authorization", header: "authorization", expected: true},
+		{name: "managed header", header: "Range", expected: false},
+		{name: "managed header in lower case", header: "accept-encoding", expected: false},
+		{name: "empty", header: "", expected: false},
+		{name: "space", header: "X Key", expected: false},
+		{name: "separator", header: "X-Key:", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ValidRequestHeader(tt.header); got != tt.expected {
+				t.Errorf("expected %v for %q, got %v", tt.expected, tt.header, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrResumeNotHonored is returned when a partial download cannot be continued
+// and resume_fallback is disabled
+var ErrResumeNotHonored = errors.New("resume not honored")
+
+// ErrorCodeResume is recorded for files whose partial download the server would not continue
+const ErrorCodeResume = "resume"
+
+// resumeRejection reports why resp does not continue a partial of offset
+// bytes that was started from a response with partETag, or "" when the bytes
+// can be appended. 200 and 416 mean the range was ignored or does not fit the
+// remote file, a range not starting at offset or a changed ETag mean the
+// partial belongs to a different version of it.
+func resumeRejection(resp *http.Response, offset int64, partETag string) string {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return "server sent the whole file"
+	case http.StatusRequestedRangeNotSatisfiable:
+		return "range not satisfiable"
+	case http.StatusPartialContent:
+		if start := parseContentRangeStart(resp.Header.Get("Content-Range")); start != offset {
+			return fmt.Sprintf("unexpected range %q", resp.Header.Get("Content-Range"))
+		}
+		if etag := resp.Header.Get("ETag"); partETag != "" && etag != "" && etag != partETag {
+			return fmt.Sprintf("ETag changed from %s to %s", partETag, etag)
+		}
+	}
+	return ""
+}
+
+// strongETag reports whether etag may be used in If-Range, which does not
+// accept weak validators
+func strongETag(etag string) bool {
+	return etag != "" && !strings.HasPrefix(etag, "W/")
+}
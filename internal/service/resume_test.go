@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestDownloaderResumeFallback tests that a partial the server does not continue is discarded instead of appended to
+func TestDownloaderResumeFallback(t *testing.T) {
+	const content = "0123456789"
+	tests := []struct {
+		name           string
+		partial        string
+		partETag       string
+		rangeResponse  func(w http.ResponseWriter)
+		disabled       bool
+		expectedErr    error
+		expectedRanges []string
+		expectedFresh  bool
+	}{
+		{
+			name:    "server continues the partial",
+			partial: "0123",
+			rangeResponse: func(w http.ResponseWriter) {
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes 4-9/%d", len(content)))
+				w.WriteHeader(http.StatusPartialContent)
+				io.WriteString(w, content[4:])
+			},
+			expectedRanges: []string{"bytes=4-"},
+		},
+		{
+			name:    "server ignores range",
+			partial: "abcd",
+			rangeResponse: func(w http.ResponseWriter) {
+				io.WriteString(w, content)
+			},
+			expectedRanges: []string{"bytes=4-"},
+			expectedFresh:  true,
+		},
+		{
+			name:    "range not satisfiable",
+			partial: "abcd",
+			rangeResponse: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			},
+			expectedRanges: []string{"bytes=4-", ""},
+			expectedFresh:  true,
+		},
+		{
+			name:     "etag mismatch",
+			partial:  "abcd",
+			partETag: `"v1"`,
+			rangeResponse: func(w http.ResponseWriter) {
+				w.Header().Set("ETag", `"v2"`)
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes 4-9/%d", len(content)))
+				w.WriteHeader(http.StatusPartialContent)
+				io.WriteString(w, content[4:])
+			},
+			expectedRanges: []string{"bytes=4-", ""},
+			expectedFresh:  true,
+		},
+		{
+			name:    "fallback disabled",
+			partial: "abcd",
+			rangeResponse: func(w http.ResponseWriter) {
+				w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			},
+			disabled:       true,
+			expectedErr:    ErrResumeNotHonored,
+			expectedRanges: []string{"bytes=4-"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var ranges []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				ranges = append(ranges, r.Header.Get("Range"))
+				mu.Unlock()
+				if r.Header.Get("Range") != "" {
+					tt.rangeResponse(w)
+					return
+				}
+				w.Header().Set("ETag", `"v2"`)
+				io.WriteString(w, content)
+			}))
+			defer srv.Close()
+
+			d := NewDownloader()
+			d.downloadsDir = t.TempDir()
+			d.resumeFallback = !tt.disabled
+			partFile := filepath.Join(d.downloadsDir, "file.txt.part")
+			if err := os.WriteFile(partFile, []byte(tt.partial), 0644); err != nil {
+				t.Fatalf("failed to write part file: %v", err)
+			}
+
+			var started []string
+			result, err := d.fetch(context.Background(), srv.URL+"/file.txt", "file.txt", DownloadOptions{
+				PartFile:    partFile,
+				Offset:      int64(len(tt.partial)),
+				PartETag:    tt.partETag,
+				PartStarted: func(etag string) { started = append(started, etag) },
+			})
+
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			mu.Lock()
+			if fmt.Sprint(ranges) != fmt.Sprint(tt.expectedRanges) {
+				t.Errorf("expected requests with ranges %q, got %q", tt.expectedRanges, ranges)
+			}
+			mu.Unlock()
+			if tt.expectedErr != nil {
+				if _, err := os.Stat(partFile); !os.IsNotExist(err) {
+					t.Errorf("expected the partial to be discarded, got %v", err)
+				}
+				return
+			}
+
+			saved, err := os.ReadFile(filepath.Join(d.downloadsDir, result.Filename))
+			if err != nil {
+				t.Fatalf("failed to read downloaded file: %v", err)
+			}
+			if string(saved) != content {
+				t.Errorf("expected content %q, got %q", content, saved)
+			}
+			if fresh := len(started) == 1; fresh != tt.expectedFresh {
+				t.Errorf("expected a download from scratch %v, got partials started with %q", tt.expectedFresh, started)
+			}
+		})
+	}
+}
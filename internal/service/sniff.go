@@ -0,0 +1,48 @@
+package service
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"os"
+)
+
+// sniffLen is the number of bytes http.DetectContentType looks at
+const sniffLen = 512
+
+// sniffHead returns up to sniffLen bytes from the start of the content without
+// consuming them from body. When a download resumes at offset, the start is
+// read from the part file and only the missing rest is peeked from body.
+func sniffHead(body *bufio.Reader, partFile string, offset int64) []byte {
+	head := make([]byte, 0, sniffLen)
+	if offset > 0 {
+		f, err := os.Open(partFile)
+		if err != nil {
+			return nil
+		}
+		n, _ := io.ReadFull(f, head[:min(offset, sniffLen)])
+		f.Close()
+		head = head[:n]
+	}
+	if len(head) < sniffLen {
+		// a read error is not lost here: the body reports it again on the
+		// next read, after the peeked bytes
+		peeked, _ := body.Peek(sniffLen - len(head))
+		head = append(head, peeked...)
+	}
+	return head
+}
+
+// detectContentType returns the media type of content starting with head. ok
+// is false when the content is empty or only detected as generic binary or
+// plain text, which says nothing about the format.
+func detectContentType(head []byte) (mediaType string, ok bool) {
+	if len(head) == 0 {
+		return "", false
+	}
+	mediaType = mediaTypeOf(http.DetectContentType(head))
+	if genericMediaType(mediaType) || mediaType == "text/plain" || extensionForType(mediaType) == "" {
+		return "", false
+	}
+	return mediaType, true
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DownloadTotals summarizes the files a worker pool completed since it was created
+type DownloadTotals struct {
+	Completed int64
+	Bytes     int64
+	Duration  time.Duration
+}
+
+// AverageDuration returns the mean time a completed file took, 0 before the first one
+func (t DownloadTotals) AverageDuration() time.Duration {
+	if t.Completed == 0 {
+		return 0
+	}
+	return t.Duration / time.Duration(t.Completed)
+}
+
+// downloadCounters accumulates DownloadTotals without locking; a snapshot
+// taken while a file completes may count it in some fields but not yet in others
+type downloadCounters struct {
+	completed atomic.Int64
+	bytes     atomic.Int64
+	duration  atomic.Int64
+}
+
+// record adds a completed file of size bytes that took d
+func (c *downloadCounters) record(size int64, d time.Duration) {
+	c.completed.Add(1)
+	c.bytes.Add(size)
+	c.duration.Add(int64(d))
+}
+
+func (c *downloadCounters) snapshot() DownloadTotals {
+	return DownloadTotals{
+		Completed: c.completed.Load(),
+		Bytes:     c.bytes.Load(),
+		Duration:  time.Duration(c.duration.Load()),
+	}
+}
+
+// recentSamples is how many of the latest downloads the recent average covers
+const recentSamples = 20
+
+// recentDurations keeps the durations of the latest completed downloads, so
+// that estimates follow the current conditions rather than the lifetime mean
+type recentDurations struct {
+	mu      sync.Mutex
+	samples [recentSamples]time.Duration
+	n       int
+	next    int
+}
+
+// add records the duration of a completed download
+func (r *recentDurations) add(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples[r.next] = d
+	r.next = (r.next + 1) % recentSamples
+	r.n = min(r.n+1, recentSamples)
+}
+
+// average returns the mean of the recorded durations, 0 before the first one
+func (r *recentDurations) average() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.n == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range r.samples[:r.n] {
+		total += d
+	}
+	return total / time.Duration(r.n)
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"filedownloader-20240926/internal/repository"
+)
+
+// TestWorkerPoolDownloadTotals tests that completed files are counted with their size and duration and failed ones are not
+func TestWorkerPoolDownloadTotals(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.txt" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, "0123456789")
+	}))
+	defer srv.Close()
+
+	tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	wp := NewWorkerPool(1, tm)
+	wp.downloader.downloadsDir = t.TempDir()
+
+	task, err := tm.CreateTask([]string{srv.URL + "/a.txt", srv.URL + "/missing.txt", srv.URL + "/b.txt"})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	for i := range task.Files {
+		wp.processTask(0, DownloadTask{TaskID: task.ID, FileIndex: i})
+	}
+
+	totals := wp.DownloadTotals()
+	if totals.Completed != 2 || totals.Bytes != 20 {
+		t.Errorf("expected 2 files of 20 bytes in total, got %d files of %d bytes", totals.Completed, totals.Bytes)
+	}
+	if totals.Duration <= 0 || totals.AverageDuration() != totals.Duration/2 {
+		t.Errorf("expected an average of half the total duration %v, got %v", totals.Duration, totals.AverageDuration())
+	}
+	if (DownloadTotals{}).AverageDuration() != 0 {
+		t.Errorf("expected no average without completed files")
+	}
+}
@@ -0,0 +1,110 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Open requests url and returns the response for streaming its body straight
+// to a client. The status, size limit and Content-Type filter are checked as
+// for a download, but nothing is written to disk. The body fails with
+// ErrFileTooLarge once more bytes than the size limit arrive and is cut off
+// by the stall timeout or the transfer deadline. The response holds a
+// connection slot until the body is closed, which the caller must do.
+func (d *Downloader) Open(ctx context.Context, url string, opts DownloadOptions) (*http.Response, error) {
+	url, opts = withCredentials(url, opts)
+	ctx, cancel := context.WithCancelCause(ctx)
+	release, err := d.acquireConn(ctx, url)
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		release()
+		cancel(nil)
+		return nil, fmt.Errorf("failed to create request for %s: %w", url, err)
+	}
+	req.Header.Set("User-Agent", d.userAgentFor(opts))
+	setCredentials(req, opts)
+	req.Header.Set("Accept-Encoding", "identity")
+
+	resp, err := d.client().Do(req)
+	if err != nil {
+		release()
+		cancel(nil)
+		return nil, fmt.Errorf("failed to get %s: %w", url, err)
+	}
+
+	fail := func(err error) (*http.Response, error) {
+		resp.Body.Close()
+		release()
+		cancel(nil)
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fail(&HTTPStatusError{StatusCode: resp.StatusCode, URL: url})
+	}
+	if d.maxFileSize > 0 && resp.ContentLength > d.maxFileSize {
+		return fail(fmt.Errorf("%w: %d > %d", ErrFileTooLarge, resp.ContentLength, d.maxFileSize))
+	}
+	if err := d.checkContentType(resp.Header.Get("Content-Type")); err != nil {
+		return fail(fmt.Errorf("%w for %s", err, url))
+	}
+
+	body := &streamBody{body: resp.Body, ctx: ctx, cancel: cancel, release: release, limit: d.maxFileSize}
+	body.stopDeadline = d.startTransferDeadline(resp.ContentLength, cancel)
+	body.r = resp.Body
+	if stallTimeout := d.StallTimeout(); stallTimeout > 0 {
+		body.stall = newStallReader(resp.Body, stallTimeout, func() { cancel(ErrDownloadStalled) })
+		body.r = body.stall
+	}
+	resp.Body = body
+	return resp, nil
+}
+
+// streamBody enforces the size limit on a streamed response and releases
+// its context, stall timer and connection slot on Close
+type streamBody struct {
+	body    io.ReadCloser
+	r       io.Reader
+	stall   *stallReader
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	release func()
+	limit   int64
+	read    int64
+
+	stopDeadline func()
+}
+
+func (b *streamBody) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.read += int64(n)
+	if b.limit > 0 && b.read > b.limit {
+		// hand out nothing beyond the limit
+		n -= int(b.read - b.limit)
+		b.read = b.limit
+		return n, fmt.Errorf("%w: more than %d bytes streamed", ErrFileTooLarge, b.limit)
+	}
+	if err != nil && err != io.EOF {
+		if cause := context.Cause(b.ctx); cause != nil {
+			return n, cause
+		}
+	}
+	return n, err
+}
+
+func (b *streamBody) Close() error {
+	if b.stall != nil {
+		b.stall.Stop()
+	}
+	b.stopDeadline()
+	err := b.body.Close()
+	b.release()
+	b.cancel(nil)
+	return err
+}
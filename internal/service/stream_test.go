@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestDownloaderOpen tests the checks applied to streamed responses
+func TestDownloaderOpen(t *testing.T) {
+	tests := []struct {
+		name            string
+		content         string
+		chunked         bool
+		maxFileSize     int64
+		blocked         []string
+		expectedOpenErr error
+		expectedReadErr error
+	}{
+		{
+			name:    "streams body",
+			content: "streamed content",
+		},
+		{
+			name:            "declared length over limit",
+			content:         strings.Repeat("x", 20),
+			maxFileSize:     10,
+			expectedOpenErr: ErrFileTooLarge,
+		},
+		{
+			name:            "chunked body over limit",
+			content:         strings.Repeat("x", 20),
+			chunked:         true,
+			maxFileSize:     10,
+			expectedReadErr: ErrFileTooLarge,
+		},
+		{
+			name:            "blocked content type",
+			content:         "<svg/>",
+			blocked:         []string{"image/svg+xml"},
+			expectedOpenErr: ErrContentTypeNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.blocked != nil {
+					w.Header().Set("Content-Type", "image/svg+xml")
+				}
+				if !tt.chunked {
+					w.Header().Set("Content-Length", strconv.Itoa(len(tt.content)))
+				}
+				io.WriteString(w, tt.content[:1])
+				// flushing early leaves the length undeclared
+				w.(http.Flusher).Flush()
+				io.WriteString(w, tt.content[1:])
+			}))
+			defer srv.Close()
+
+			d := NewDownloader()
+			d.maxFileSize = tt.maxFileSize
+			d.blockedContentTypes = tt.blocked
+
+			resp, err := d.Open(context.Background(), srv.URL+"/file.txt", DownloadOptions{})
+			if tt.expectedOpenErr != nil {
+				if !errors.Is(err, tt.expectedOpenErr) {
+					t.Errorf("expected %v, got %v", tt.expectedOpenErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if tt.expectedReadErr != nil {
+				if !errors.Is(err, tt.expectedReadErr) {
+					t.Errorf("expected %v, got %v", tt.expectedReadErr, err)
+				}
+				if int64(len(body)) > tt.maxFileSize {
+					t.Errorf("expected at most %d bytes, got %d", tt.maxFileSize, len(body))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected read error: %v", err)
+			}
+			if string(body) != tt.content {
+				t.Errorf("expected %q, got %q", tt.content, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/pkg/logger"
+)
+
+// Sweeper periodically deletes finished tasks older than a TTL and,
+// optionally, their downloaded files
+type Sweeper struct {
+	tm          *TaskManager
+	downloader  *Downloader
+	ttl         time.Duration
+	interval    time.Duration
+	deleteFiles bool
+	stop        chan struct{}
+	done        chan struct{}
+	stopOnce    sync.Once
+}
+
+// NewSweeper creates a sweeper for tasks of tm. Files are looked up in the
+// directories of downloader; deleteFiles removes them along with the tasks.
+func NewSweeper(tm *TaskManager, downloader *Downloader, ttl, interval time.Duration, deleteFiles bool) *Sweeper {
+	return &Sweeper{
+		tm:          tm,
+		downloader:  downloader,
+		ttl:         ttl,
+		interval:    interval,
+		deleteFiles: deleteFiles,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Start runs the sweeper in the background. A zero TTL or interval disables it.
+func (s *Sweeper) Start() {
+	if s.ttl <= 0 || s.interval <= 0 {
+		close(s.done)
+		return
+	}
+
+	logger.Logger.Info("Starting task sweeper", "ttl", s.ttl, "interval", s.interval, "delete_files", s.deleteFiles)
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.Sweep(time.Now())
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the sweeper and waits for a running sweep to finish
+func (s *Sweeper) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.done
+}
+
+// Sweep deletes the tasks that finished more than the TTL before now and
+// returns how many were deleted
+func (s *Sweeper) Sweep(now time.Time) int {
+	deleted := s.tm.DeleteExpiredTasks(now.Add(-s.ttl))
+	if len(deleted) == 0 {
+		return 0
+	}
+
+	if s.deleteFiles && s.downloader != nil {
+		// the names still referred to are the same for every deleted task
+		inUse := s.tm.FilenamesInUse()
+		for _, task := range deleted {
+			s.removeFiles(task, inUse)
+		}
+	}
+	logger.Logger.Info("Deleted expired tasks", "count", len(deleted))
+	return len(deleted)
+}
+
+// removeFiles deletes the downloaded files of a task. Under the per-task
+// layout this is the task directory; under the flat layout only files that no
+// remaining task refers to, as listed in inUse, are deleted, since unchanged
+// files are shared.
+func (s *Sweeper) removeFiles(task *domain.Task, inUse map[string]bool) {
+	dir := s.downloader.TaskDir(task.ID)
+	if dir != s.downloader.TaskDir("") {
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Logger.Warn("Failed to delete task files", "task_id", task.ID, "error", err)
+		}
+		return
+	}
+
+	for i, f := range task.Files {
+		os.Remove(s.downloader.PartPath(task.ID, i))
+		if f.ExtractedTo != "" {
+			if err := os.RemoveAll(filepath.Join(dir, filepath.Base(f.ExtractedTo))); err != nil {
+				logger.Logger.Warn("Failed to delete extracted files", "task_id", task.ID, "directory", f.ExtractedTo, "error", err)
+			}
+		}
+		if f.Status != domain.StatusCompleted || f.Filename == "" || inUse[f.Filename] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, filepath.Base(f.Filename))); err != nil && !os.IsNotExist(err) {
+			logger.Logger.Warn("Failed to delete task file", "task_id", task.ID, "filename", f.Filename, "error", err)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+)
+
+// TestSweeperSweep tests which tasks and files are removed by a sweep
+func TestSweeperSweep(t *testing.T) {
+	tests := []struct {
+		name            string
+		layout          string
+		status          domain.Status
+		age             time.Duration
+		deleteFiles     bool
+		sharedFile      bool
+		expectedDeleted int
+		expectFile      bool
+	}{
+		{
+			name:            "expired completed task with files",
+			layout:          LayoutPerTask,
+			status:          domain.StatusCompleted,
+			age:             2 * time.Hour,
+			deleteFiles:     true,
+			expectedDeleted: 1,
+			expectFile:      false,
+		},
+		{
+			name:            "expired failed task keeps files",
+			layout:          LayoutPerTask,
+			status:          domain.StatusFailed,
+			age:             2 * time.Hour,
+			expectedDeleted: 1,
+			expectFile:      true,
+		},
+		{
+			name:            "recent task kept",
+			layout:          LayoutPerTask,
+			status:          domain.StatusCompleted,
+			age:             time.Minute,
+			deleteFiles:     true,
+			expectedDeleted: 0,
+			expectFile:      true,
+		},
+		{
+			name:            "unfinished task kept",
+			layout:          LayoutPerTask,
+			status:          domain.StatusDownloading,
+			age:             2 * time.Hour,
+			deleteFiles:     true,
+			expectedDeleted: 0,
+			expectFile:      true,
+		},
+		{
+			name:            "flat layout deletes own file",
+			layout:          LayoutFlat,
+			status:          domain.StatusCompleted,
+			age:             2 * time.Hour,
+			deleteFiles:     true,
+			expectedDeleted: 1,
+			expectFile:      false,
+		},
+		{
+			name:            "flat layout keeps shared file",
+			layout:          LayoutFlat,
+			status:          domain.StatusCompleted,
+			age:             2 * time.Hour,
+			deleteFiles:     true,
+			sharedFile:      true,
+			expectedDeleted: 1,
+			expectFile:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			d := NewDownloader()
+			d.downloadsDir = t.TempDir()
+			d.layout = tt.layout
+
+			now := time.Now()
+			task := finishedTask(t, tm, tt.status, now.Add(-tt.age))
+			if tt.sharedFile {
+				finishedTask(t, tm, domain.StatusCompleted, now)
+			}
+			path := filepath.Join(d.TaskDir(task.ID), task.Files[0].Filename)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatalf("failed to create dir: %v", err)
+			}
+			if err := os.WriteFile(path, []byte("content"), 0644); err != nil {
+				t.Fatalf("failed to write file: %v", err)
+			}
+
+			s := NewSweeper(tm, d, time.Hour, time.Minute, tt.deleteFiles)
+			if deleted := s.Sweep(now); deleted != tt.expectedDeleted {
+				t.Errorf("expected %d deleted tasks, got %d", tt.expectedDeleted, deleted)
+			}
+
+			_, exists := tm.GetTask(task.ID)
+			if exists == (tt.expectedDeleted > 0) {
+				t.Errorf("expected task exists=%v, got %v", tt.expectedDeleted == 0, exists)
+			}
+			_, err := os.Stat(path)
+			if fileExists := err == nil; fileExists != tt.expectFile {
+				t.Errorf("expected file exists=%v, got %v", tt.expectFile, fileExists)
+			}
+		})
+	}
+}
+
+// TestSweeperStop tests that a started sweeper runs periodically and stops cleanly
+func TestSweeperStop(t *testing.T) {
+	tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	task := finishedTask(t, tm, domain.StatusCompleted, time.Now().Add(-time.Hour))
+
+	s := NewSweeper(tm, nil, time.Minute, 10*time.Millisecond, false)
+	s.Start()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, exists := tm.GetTask(task.ID); !exists {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, exists := tm.GetTask(task.ID); exists {
+		t.Errorf("expected expired task to be swept")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Stop()
+		s.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("sweeper did not stop")
+	}
+
+	// a disabled sweeper must not block on Stop either
+	disabled := NewSweeper(tm, nil, 0, time.Minute, false)
+	disabled.Start()
+	disabled.Stop()
+}
+
+// finishedTask creates a single-file task with the given status that finished at finishedAt
+func finishedTask(t *testing.T, tm *TaskManager, status domain.Status, finishedAt time.Time) *domain.Task {
+	t.Helper()
+	task, err := tm.CreateTask([]string{"http://example.com/report.txt"})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	task.Status = status
+	task.Files[0].Status = status
+	task.Files[0].Filename = "report.txt"
+	if status == domain.StatusCompleted {
+		task.CompletedAt = &finishedAt
+	}
+	if err := tm.UpdateTask(task); err != nil {
+		t.Fatalf("failed to update task: %v", err)
+	}
+	if status != domain.StatusCompleted {
+		// UpdateTask stamps the current time; backdate it for the TTL check
+		tm.mutex.Lock()
+		tm.tasks[task.ID].UpdatedAt = finishedAt
+		tm.mutex.Unlock()
+	}
+	return task
+}
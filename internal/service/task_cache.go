@@ -0,0 +1,306 @@
+package service
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/pkg/logger"
+)
+
+// taskLRU orders the finished tasks held in memory by last use. Only
+// finished tasks are tracked, so active tasks are never chosen for eviction.
+// It has its own lock so that reads under the manager read lock can record a use.
+type taskLRU struct {
+	mu    sync.Mutex
+	order *list.List
+	elems map[string]*list.Element
+}
+
+func newTaskLRU() *taskLRU {
+	return &taskLRU{order: list.New(), elems: make(map[string]*list.Element)}
+}
+
+// add marks a task as the most recently used one
+func (l *taskLRU) add(taskID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if elem, ok := l.elems[taskID]; ok {
+		l.order.MoveToFront(elem)
+		return
+	}
+	l.elems[taskID] = l.order.PushFront(taskID)
+}
+
+// touch marks a tracked task as the most recently used one
+func (l *taskLRU) touch(taskID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if elem, ok := l.elems[taskID]; ok {
+		l.order.MoveToFront(elem)
+	}
+}
+
+// remove stops tracking a task
+func (l *taskLRU) remove(taskID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if elem, ok := l.elems[taskID]; ok {
+		l.order.Remove(elem)
+		delete(l.elems, taskID)
+	}
+}
+
+// oldest returns the least recently used task for which skip is false
+func (l *taskLRU) oldest(skip func(taskID string) bool) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for elem := l.order.Back(); elem != nil; elem = elem.Prev() {
+		if taskID := elem.Value.(string); !skip(taskID) {
+			return taskID, true
+		}
+	}
+	return "", false
+}
+
+// SetMaxTasksInMemory bounds the number of tasks held in memory; 0 disables
+// the limit. Beyond it, the least recently used finished tasks are dropped
+// from memory and loaded back from storage when they are asked for again.
+// Active tasks are never dropped, so the limit can be exceeded while they
+// alone do not fit.
+func (tm *TaskManager) SetMaxTasksInMemory(limit int) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	tm.maxInMemory = limit
+	tm.lru = nil
+	if limit <= 0 {
+		// without a limit every task is expected in memory
+		for taskID := range tm.evicted.tasks {
+			if task, err := tm.storage.LoadTask(taskID); err == nil && task.ID == taskID {
+				tm.tasks[taskID] = task
+			}
+			tm.evicted.remove(taskID)
+		}
+		return
+	}
+
+	// the tasks already loaded are ordered by their last update
+	var finished []*domain.Task
+	for _, task := range tm.tasks {
+		if taskFinished(task) {
+			finished = append(finished, task)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].UpdatedAt.Before(finished[j].UpdatedAt)
+	})
+	tm.lru = newTaskLRU()
+	for _, task := range finished {
+		tm.lru.add(task.ID)
+	}
+	tm.evict()
+}
+
+// track records a use of a stored task and evicts tasks over the limit.
+// The caller must hold the mutex.
+func (tm *TaskManager) track(task *domain.Task) {
+	if tm.lru == nil {
+		return
+	}
+	if taskFinished(task) {
+		tm.lru.add(task.ID)
+	} else {
+		tm.lru.remove(task.ID)
+	}
+	tm.evict()
+}
+
+// evict drops the least recently used finished tasks from memory while there
+// are more tasks than the limit. They are already in storage, since finished
+// tasks are written at once; a task with an unwritten update stays tracked and
+// is evicted after the flush. The caller must hold the mutex.
+func (tm *TaskManager) evict() {
+	dirty := func(taskID string) bool {
+		_, ok := tm.dirty[taskID]
+		return ok
+	}
+	for len(tm.tasks) > tm.maxInMemory {
+		taskID, ok := tm.lru.oldest(dirty)
+		if !ok {
+			return
+		}
+		tm.lru.remove(taskID)
+		tm.evicted.add(tm.tasks[taskID])
+		delete(tm.tasks, taskID)
+		logger.Logger.Debug("Evicted task from memory", "task_id", taskID)
+	}
+}
+
+// forEachTask calls fn for every task, including the ones evicted from
+// memory, which are read from storage without being loaded back. The caller
+// must hold the mutex.
+func (tm *TaskManager) forEachTask(fn func(task *domain.Task)) {
+	for _, task := range tm.tasks {
+		fn(task)
+	}
+	for taskID := range tm.evicted.tasks {
+		task, err := tm.storage.LoadTask(taskID)
+		if err != nil || task.ID != taskID {
+			logger.Logger.Error("Failed to load evicted task", "task_id", taskID, "error", err)
+			continue
+		}
+		fn(task)
+	}
+}
+
+// lookup returns a stored task, loading it back from storage when it was
+// evicted from memory. The caller must hold the mutex.
+func (tm *TaskManager) lookup(taskID string) (*domain.Task, bool) {
+	if task, exists := tm.tasks[taskID]; exists {
+		return task, true
+	}
+	task := tm.reload(taskID)
+	return task, task != nil
+}
+
+// reload loads a task evicted from memory back from storage. It returns nil
+// when the limit is disabled, since every task is in memory then, or when
+// storage has no such task. The caller must hold the mutex.
+func (tm *TaskManager) reload(taskID string) *domain.Task {
+	if tm.lru == nil || !validTaskID(taskID) {
+		return nil
+	}
+	task, err := tm.storage.LoadTask(taskID)
+	if err != nil || task.ID != taskID {
+		return nil
+	}
+	tm.tasks[taskID] = task
+	tm.evicted.remove(taskID)
+	tm.track(task)
+	logger.Logger.Debug("Reloaded task from storage", "task_id", taskID)
+	return task
+}
+
+// taskFinished reports whether a task has reached a final status
+func taskFinished(task *domain.Task) bool {
+	return task.Status == domain.StatusCompleted || task.Status == domain.StatusFailed
+}
+
+// finishedAt returns when a finished task reached its final status. Failed
+// tasks have no completion time; their last update is when they failed.
+func finishedAt(task *domain.Task) time.Time {
+	if task.CompletedAt != nil {
+		return *task.CompletedAt
+	}
+	return task.UpdatedAt
+}
+
+// evictedIndex keeps what the manager needs to know about tasks evicted from
+// memory without reading them back: their status and file counts for the
+// stats, their file names and finish time for the sweeper and their reusable
+// files for conditional requests. Accessed under the manager mutex.
+type evictedIndex struct {
+	tasks      map[string]*evictedTask
+	taskCounts map[domain.Status]int
+	fileCounts map[domain.Status]int
+	names      map[string]int
+	// validators holds the reusable files of evicted tasks by source URL
+	validators map[string][]reusableFile
+}
+
+type evictedTask struct {
+	status   domain.Status
+	finished time.Time
+	files    []domain.Status
+	names    []string
+	reusable []string
+}
+
+type reusableFile struct {
+	taskID     string
+	decompress bool
+	file       domain.File
+}
+
+func newEvictedIndex() *evictedIndex {
+	return &evictedIndex{
+		tasks:      make(map[string]*evictedTask),
+		taskCounts: make(map[domain.Status]int),
+		fileCounts: make(map[domain.Status]int),
+		names:      make(map[string]int),
+		validators: make(map[string][]reusableFile),
+	}
+}
+
+// add records a task that is dropped from memory
+func (x *evictedIndex) add(task *domain.Task) {
+	x.remove(task.ID)
+	e := &evictedTask{status: task.Status, finished: finishedAt(task)}
+	x.taskCounts[task.Status]++
+	for _, f := range task.Files {
+		e.files = append(e.files, f.Status)
+		x.fileCounts[f.Status]++
+		if f.Filename != "" {
+			e.names = append(e.names, f.Filename)
+			x.names[f.Filename]++
+		}
+		if reusable(task, f) {
+			e.reusable = append(e.reusable, f.SourceURL)
+			x.validators[f.SourceURL] = append(x.validators[f.SourceURL],
+				reusableFile{taskID: task.ID, decompress: task.Decompress, file: f})
+		}
+	}
+	x.tasks[task.ID] = e
+}
+
+// remove forgets a task that is loaded back or deleted
+func (x *evictedIndex) remove(taskID string) {
+	e, ok := x.tasks[taskID]
+	if !ok {
+		return
+	}
+	delete(x.tasks, taskID)
+	decrement(x.taskCounts, e.status)
+	for _, status := range e.files {
+		decrement(x.fileCounts, status)
+	}
+	for _, name := range e.names {
+		decrement(x.names, name)
+	}
+	for _, url := range e.reusable {
+		files := x.validators[url][:0]
+		for _, rf := range x.validators[url] {
+			if rf.taskID != taskID {
+				files = append(files, rf)
+			}
+		}
+		if len(files) == 0 {
+			delete(x.validators, url)
+		} else {
+			x.validators[url] = files
+		}
+	}
+}
+
+// expired returns the IDs of the evicted tasks that finished before cutoff
+func (x *evictedIndex) expired(cutoff time.Time) []string {
+	var ids []string
+	for taskID, e := range x.tasks {
+		if e.finished.Before(cutoff) {
+			ids = append(ids, taskID)
+		}
+	}
+	return ids
+}
+
+// decrement lowers a counter and drops it once it reaches zero
+func decrement[K comparable](counts map[K]int, key K) {
+	if counts[key] <= 1 {
+		delete(counts, key)
+		return
+	}
+	counts[key]--
+}
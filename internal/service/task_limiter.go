@@ -0,0 +1,69 @@
+package service
+
+import "sync"
+
+// taskLimiter bounds the number of files of one task that download at once.
+// A worker that finds the task at its cap parks the file instead of waiting,
+// so it stays free for other tasks; the parked file is handed back once a
+// file of the same task finishes.
+type taskLimiter struct {
+	mu    sync.Mutex
+	tasks map[string]*taskSlots
+}
+
+type taskSlots struct {
+	active int
+	parked []DownloadTask
+}
+
+func newTaskLimiter() *taskLimiter {
+	return &taskLimiter{tasks: make(map[string]*taskSlots)}
+}
+
+// TryAcquire takes a slot for task when fewer than limit files of the task
+// are active; otherwise it parks task and returns false. A limit of 0 or less
+// always succeeds without tracking the task.
+func (l *taskLimiter) TryAcquire(task DownloadTask, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slots, ok := l.tasks[task.TaskID]
+	if !ok {
+		slots = &taskSlots{}
+		l.tasks[task.TaskID] = slots
+	}
+	if slots.active < limit {
+		slots.active++
+		return true
+	}
+	slots.parked = append(slots.parked, task)
+	return false
+}
+
+// Release frees a slot taken by TryAcquire and returns the next parked file
+// of the task, if any, which the caller must put back in the queue
+func (l *taskLimiter) Release(taskID string) (DownloadTask, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	slots, ok := l.tasks[taskID]
+	if !ok {
+		return DownloadTask{}, false
+	}
+	slots.active--
+
+	var next DownloadTask
+	parked := len(slots.parked) > 0
+	if parked {
+		next = slots.parked[0]
+		slots.parked = slots.parked[1:]
+	}
+	if slots.active <= 0 && len(slots.parked) == 0 {
+		delete(l.tasks, taskID)
+	}
+	return next, parked
+}
@@ -1,27 +1,114 @@
 package service
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"maps"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"filedownloader-20240926/internal/domain"
 	"filedownloader-20240926/internal/repository"
+	"filedownloader-20240926/pkg/logger"
 )
 
+var (
+	// ErrTaskNotFound is returned when a task with the given ID does not exist
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrInvalidTransition is returned when a task cannot move to the requested status
+	ErrInvalidTransition = errors.New("invalid task status transition")
+	// ErrNoFailedFiles is returned when a retry is requested for a task without failed files
+	ErrNoFailedFiles = errors.New("no failed files to retry")
+	// ErrTooManyURLs is returned when a task is created with more URLs than allowed
+	ErrTooManyURLs = errors.New("too many URLs in task")
+	// ErrSaturated is returned when accepting a task would exceed the global limit of outstanding files
+	ErrSaturated = errors.New("too many outstanding files")
+	// ErrInvalidTask is returned when an imported task is malformed
+	ErrInvalidTask = errors.New("invalid task")
+	// ErrStaleTask is returned when a task is updated from a copy older than the stored task
+	ErrStaleTask = errors.New("task was modified since it was read")
+	// ErrFileNotFound is returned when a task has no file with the given index
+	ErrFileNotFound = errors.New("file not found")
+	// ErrFileCancelled is the cause of the interruption of a download whose file was cancelled
+	ErrFileCancelled = errors.New("file cancelled")
+)
+
+// DefaultMaxURLsPerTask is the default limit on the number of URLs in a single task
+const DefaultMaxURLsPerTask = 1000
+
+// TaskOptions holds optional settings for a new task
+type TaskOptions struct {
+	// RequestID correlates the task with the API request that created it
+	RequestID string
+	// Priority orders downloads across tasks; higher values are dispatched first
+	Priority int
+	// TimeoutSeconds bounds all downloads of the task; 0 uses the pool default
+	TimeoutSeconds int
+	// Decompress decodes gzip/deflate responses before writing them to disk
+	Decompress bool
+	// Transform names the DownloadTransform the files of the task go through
+	// before they are written to disk; empty writes them unchanged
+	Transform string
+	// CallbackURL receives a POST with the task status when the task finishes
+	CallbackURL string
+	// Mirrors holds fallback URLs for each file, aligned with the task URLs;
+	// a mirror is tried only after the primary URL and earlier mirrors failed
+	Mirrors [][]string
+	// Expected holds the size, SHA-256 and piece hashes each file must match,
+	// aligned with the task URLs; a file that does not match fails with
+	// ErrChecksumMismatch
+	Expected []ExpectedFile
+	// UserAgent overrides the configured User-Agent for the downloads of the task
+	UserAgent string
+	// DryRun only probes the URLs for their size and file name; nothing is downloaded
+	DryRun bool
+	// MaxConcurrency caps how many files of the task download at once; 0 uses the pool default
+	MaxConcurrency int
+	// FollowNext joins the pages linked by rel="next" Link headers into one file per URL
+	FollowNext bool
+	// Extract unpacks downloaded .zip and .tar.gz archives into a directory next to them
+	Extract bool
+	// OverwritePolicy decides what happens to existing files of the same name;
+	// empty uses the configured default
+	OverwritePolicy string
+	// CompletionMode is CompletionModeFailFast to cancel the remaining files
+	// as soon as one fails; empty means CompletionModeBestEffort
+	CompletionMode string
+	// Labels are free-form key-value pairs for grouping and filtering tasks
+	Labels map[string]string
+	// Request customises the method, body and headers of the task's requests
+	Request *domain.RequestOptions
+}
+
 type TaskManager struct {
-	tasks   map[string]*domain.Task
-	storage *repository.TaskStorage
-	mutex   sync.RWMutex
+	tasks          map[string]*domain.Task
+	storage        repository.Storage
+	mutex          sync.RWMutex
+	maxURLsPerTask int
+	maxOutstanding int
+	saveInterval   time.Duration
+	dirty          map[string]struct{}
+	flushTimer     *time.Timer
+	maxInMemory    int
+	lru            *taskLRU
+	evicted        *evictedIndex
 }
 
-// NewTaskManager creates a new task manager instance
+// NewTaskManager creates a new task manager instance backed by file storage
 func NewTaskManager() *TaskManager {
+	return NewTaskManagerWithStorage(repository.NewTaskStorage())
+}
+
+// NewTaskManagerWithStorage creates a new task manager instance using the given storage
+func NewTaskManagerWithStorage(storage repository.Storage) *TaskManager {
 	tm := &TaskManager{
-		tasks:   make(map[string]*domain.Task),
-		storage: repository.NewTaskStorage(),
+		tasks:          make(map[string]*domain.Task),
+		storage:        storage,
+		maxURLsPerTask: DefaultMaxURLsPerTask,
+		dirty:          make(map[string]struct{}),
+		evicted:        newEvictedIndex(),
 	}
 
 	tm.loadExistingTasks()
@@ -32,7 +119,7 @@ func NewTaskManager() *TaskManager {
 func (tm *TaskManager) loadExistingTasks() {
 	tasks, err := tm.storage.LoadAllTasks()
 	if err != nil {
-		log.Printf("Failed to load existing tasks: %v", err)
+		logger.Logger.Error("Failed to load existing tasks", "error", err)
 		return
 	}
 
@@ -40,76 +127,685 @@ func (tm *TaskManager) loadExistingTasks() {
 	tm.tasks = tasks
 	tm.mutex.Unlock()
 
-	log.Printf("Loaded %d existing tasks", len(tasks))
+	logger.Logger.Info("Loaded existing tasks", "count", len(tasks))
+}
+
+// SetMaxURLsPerTask limits the number of URLs accepted in a single task; 0 disables the limit
+func (tm *TaskManager) SetMaxURLsPerTask(limit int) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	tm.maxURLsPerTask = limit
+}
+
+// MaxURLsPerTask returns the limit on the number of URLs in a single task; 0 means unlimited
+func (tm *TaskManager) MaxURLsPerTask() int {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+	return tm.maxURLsPerTask
+}
+
+// SetMaxOutstandingFiles limits the number of unfinished files across all tasks; 0 disables the limit
+func (tm *TaskManager) SetMaxOutstandingFiles(limit int) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	tm.maxOutstanding = limit
+}
+
+// SetSaveInterval coalesces updates of a task into at most one storage
+// write per interval. Updates that finish a task are still written at once;
+// 0 writes every update through.
+func (tm *TaskManager) SetSaveInterval(interval time.Duration) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	tm.saveInterval = interval
+}
+
+// persist writes task to storage or, when saves are coalesced, marks it for
+// the next flush. The caller must hold the mutex.
+func (tm *TaskManager) persist(task *domain.Task) error {
+	finished := task.Status == domain.StatusCompleted || task.Status == domain.StatusFailed
+	if tm.saveInterval <= 0 || finished {
+		delete(tm.dirty, task.ID)
+		return tm.storage.UpdateTask(task)
+	}
+	tm.dirty[task.ID] = struct{}{}
+	if tm.flushTimer == nil {
+		tm.flushTimer = time.AfterFunc(tm.saveInterval, func() {
+			tm.mutex.Lock()
+			defer tm.mutex.Unlock()
+			tm.flushTimer = nil
+			tm.flushDirty()
+		})
+	}
+	return nil
+}
+
+// Flush writes all coalesced updates to storage right away; it is called on shutdown
+func (tm *TaskManager) Flush() error {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	if tm.flushTimer != nil {
+		tm.flushTimer.Stop()
+		tm.flushTimer = nil
+	}
+	return tm.flushDirty()
+}
+
+// flushDirty writes the tasks marked by persist. The caller must hold the mutex.
+func (tm *TaskManager) flushDirty() error {
+	var errs []error
+	for id := range tm.dirty {
+		delete(tm.dirty, id)
+		task, exists := tm.tasks[id]
+		if !exists {
+			continue
+		}
+		if err := tm.storage.UpdateTask(task); err != nil {
+			logger.Logger.Error("Failed to update task", "task_id", id, "error", err)
+			errs = append(errs, err)
+		}
+	}
+	if tm.lru != nil {
+		// written tasks can be evicted now
+		tm.evict()
+	}
+	return errors.Join(errs...)
 }
 
 // CreateTask creates a new task
 func (tm *TaskManager) CreateTask(urls []string) (*domain.Task, error) {
+	return tm.CreateTaskWithOptions(urls, TaskOptions{})
+}
+
+// CreateTaskWithOptions creates a new task with optional settings
+func (tm *TaskManager) CreateTaskWithOptions(urls []string, opts TaskOptions) (*domain.Task, error) {
+	tm.mutex.RLock()
+	maxURLs := tm.maxURLsPerTask
+	tm.mutex.RUnlock()
+	if maxURLs > 0 && len(urls) > maxURLs {
+		return nil, fmt.Errorf("%w: %d URLs, at most %d allowed", ErrTooManyURLs, len(urls), maxURLs)
+	}
+
 	taskID := generateTaskID()
 
+	now := time.Now()
+
 	var files []domain.File
-	for _, url := range urls {
-		files = append(files, domain.File{
-			URL:      url,
-			Filename: extractFilename(url),
-			Status:   domain.StatusPending,
-		})
+	sanitized := make([]string, len(urls))
+	for i, url := range urls {
+		// credentials embedded in the URL are kept apart from it, so that
+		// the URL can be shown and logged without leaking them
+		url, user := splitCredentials(url)
+		sanitized[i] = url
+		file := domain.File{
+			URL:       url,
+			Filename:  extractFilename(url),
+			Status:    domain.StatusPending,
+			CreatedAt: now,
+		}
+		if user != nil {
+			file.Credentials = user.String()
+		}
+		if i < len(opts.Mirrors) && len(opts.Mirrors[i]) > 0 {
+			file.Mirrors = append([]string(nil), opts.Mirrors[i]...)
+			splitMirrorCredentials(&file)
+		}
+		if i < len(opts.Expected) {
+			file.SHA256 = strings.ToLower(opts.Expected[i].SHA256)
+			file.ExpectedSize = opts.Expected[i].Size
+			if pieces := opts.Expected[i].Pieces; pieces != nil {
+				file.Pieces = &domain.PieceHashes{Size: pieces.Size, SHA256: append([]string(nil), pieces.SHA256...)}
+			}
+			file.Size = opts.Expected[i].Size
+		}
+		files = append(files, file)
 	}
 
 	task := &domain.Task{
-		ID:       taskID,
-		URLs:     urls,
-		Status:   domain.StatusPending,
-		Files:    files,
-		Progress: 0,
+		ID:              taskID,
+		URLs:            sanitized,
+		Status:          domain.StatusPending,
+		Files:           files,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		Progress:        0,
+		RequestID:       opts.RequestID,
+		Priority:        opts.Priority,
+		TimeoutSeconds:  opts.TimeoutSeconds,
+		Decompress:      opts.Decompress,
+		Transform:       opts.Transform,
+		CallbackURL:     opts.CallbackURL,
+		UserAgent:       opts.UserAgent,
+		DryRun:          opts.DryRun,
+		MaxConcurrency:  opts.MaxConcurrency,
+		FollowNext:      opts.FollowNext,
+		Extract:         opts.Extract,
+		OverwritePolicy: opts.OverwritePolicy,
+		CompletionMode:  opts.CompletionMode,
+		Labels:          maps.Clone(opts.Labels),
+		Request:         cloneRequest(opts.Request),
+		Version:         1,
 	}
 	tm.mutex.Lock()
+	if tm.maxOutstanding > 0 {
+		if outstanding := tm.outstandingFiles(); outstanding+len(files) > tm.maxOutstanding {
+			tm.mutex.Unlock()
+			return nil, fmt.Errorf("%w: %d files outstanding, limit is %d", ErrSaturated, outstanding, tm.maxOutstanding)
+		}
+	}
 	tm.tasks[taskID] = task
+	tm.track(task)
 	tm.mutex.Unlock()
 
 	if err := tm.storage.SaveTask(task); err != nil {
-		log.Printf("Failed to save task %s: %v", taskID, err)
+		logger.Logger.Error("Failed to save task", "task_id", taskID, "error", err)
 		return nil, err
 	}
 
-	return task, nil
+	return task.Clone(), nil
 }
 
-// GetTask returns task by ID
+// GetTask returns a snapshot of the task by ID. The snapshot is a deep copy,
+// so it can be read and modified freely; pass it to UpdateTask to store changes.
+// A task evicted from memory is loaded back from storage.
 func (tm *TaskManager) GetTask(taskID string) (*domain.Task, bool) {
 	tm.mutex.RLock()
-	defer tm.mutex.RUnlock()
-
 	task, exists := tm.tasks[taskID]
-	return task, exists
+	if exists {
+		defer tm.mutex.RUnlock()
+		if tm.lru != nil {
+			tm.lru.touch(taskID)
+		}
+		return task.Clone(), true
+	}
+	evicting := tm.lru != nil
+	tm.mutex.RUnlock()
+	if !evicting {
+		return nil, false
+	}
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	task, exists = tm.lookup(taskID)
+	if !exists {
+		return nil, false
+	}
+	return task.Clone(), true
 }
 
-// UpdateTask updates task
+// UpdateTask replaces the stored task with a copy of task and refreshes its
+// UpdatedAt timestamp. The task must carry the version of the stored task, so a
+// write based on an outdated copy fails with ErrStaleTask instead of undoing
+// newer changes; on success the version of task is bumped to the stored one.
 func (tm *TaskManager) UpdateTask(task *domain.Task) error {
 	tm.mutex.Lock()
-	tm.tasks[task.ID] = task
-	tm.mutex.Unlock()
+	defer tm.mutex.Unlock()
+
+	if current, exists := tm.lookup(task.ID); exists {
+		if task.Version != current.Version {
+			return fmt.Errorf("%w: task %s has version %d, got %d", ErrStaleTask, task.ID, current.Version, task.Version)
+		}
+	}
+	task.Version++
+	task.UpdatedAt = time.Now()
+	stored := task.Clone()
+	tm.tasks[task.ID] = stored
 
-	if err := tm.storage.UpdateTask(task); err != nil {
-		log.Printf("Failed to update task %s: %v", task.ID, err)
+	if err := tm.persist(stored); err != nil {
+		logger.Logger.Error("Failed to update task", "task_id", task.ID, "error", err)
 		return err
 	}
+	tm.track(stored)
 
 	return nil
 }
 
-// GetAllTasks returns all tasks
+// ModifyTask applies fn to a copy of the stored task and, if fn succeeds, stores
+// and persists the copy. The whole read-modify-write runs under the manager lock,
+// so concurrent modifications of the same task never overwrite each other.
+// It returns a snapshot of the updated task.
+func (tm *TaskManager) ModifyTask(taskID string, fn func(task *domain.Task) error) (*domain.Task, error) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	current, exists := tm.lookup(taskID)
+	if !exists {
+		return nil, ErrTaskNotFound
+	}
+
+	task := current.Clone()
+	if err := fn(task); err != nil {
+		return nil, err
+	}
+	task.Version = current.Version + 1
+	task.UpdatedAt = time.Now()
+	tm.tasks[taskID] = task
+
+	if err := tm.persist(task); err != nil {
+		logger.Logger.Error("Failed to update task", "task_id", taskID, "error", err)
+		return nil, err
+	}
+	tm.track(task)
+
+	return task.Clone(), nil
+}
+
+// PauseTask pauses a task so that no new files from it are downloaded.
+// Files that are already downloading are allowed to finish; pending and
+// retrying files are marked paused and skipped by the workers until the task
+// is resumed.
+func (tm *TaskManager) PauseTask(taskID string) (*domain.Task, error) {
+	return tm.ModifyTask(taskID, func(task *domain.Task) error {
+		if task.Status != domain.StatusPending && task.Status != domain.StatusDownloading && task.Status != domain.StatusQueued {
+			return fmt.Errorf("%w: cannot pause task in status %s", ErrInvalidTransition, task.Status)
+		}
+
+		task.Status = domain.StatusPaused
+		for i := range task.Files {
+			if task.Files[i].Status == domain.StatusPending || task.Files[i].Status == domain.StatusRetrying {
+				task.Files[i].Status = domain.StatusPaused
+			}
+		}
+		return nil
+	})
+}
+
+// ResumeTask resumes a paused task by marking its paused files pending again.
+// The caller is responsible for re-enqueueing the pending files.
+func (tm *TaskManager) ResumeTask(taskID string) (*domain.Task, error) {
+	return tm.ModifyTask(taskID, func(task *domain.Task) error {
+		if task.Status != domain.StatusPaused {
+			return fmt.Errorf("%w: cannot resume task in status %s", ErrInvalidTransition, task.Status)
+		}
+
+		task.Status = domain.StatusPending
+		for i := range task.Files {
+			if task.Files[i].Status == domain.StatusPaused {
+				task.Files[i].Status = domain.StatusPending
+			}
+			if task.Files[i].Status == domain.StatusDownloading {
+				task.Status = domain.StatusDownloading
+			}
+		}
+		return nil
+	})
+}
+
+// RetryFailedFiles resets failed files of a task to pending with a fresh set
+// of attempts and recomputes its status. Files a fail-fast task cancelled
+// because of the failure are reset with them. The caller is responsible for
+// re-enqueueing the pending files.
+func (tm *TaskManager) RetryFailedFiles(taskID string) (*domain.Task, error) {
+	return tm.ModifyTask(taskID, func(task *domain.Task) error {
+		retried := 0
+		for i := range task.Files {
+			if task.Files[i].Status == domain.StatusCancelled && task.Files[i].ErrorCode == ErrorCodeFailFast {
+				task.Files[i].Status = domain.StatusPending
+				task.Files[i].Downloaded = 0
+				task.Files[i].Error = ""
+				task.Files[i].ErrorCode = ""
+				task.Files[i].Attempts = 0
+				continue
+			}
+			if task.Files[i].Status == domain.StatusFailed {
+				task.Files[i].Status = domain.StatusPending
+				task.Files[i].Downloaded = 0
+				task.Files[i].Error = ""
+				task.Files[i].ErrorCode = ""
+				task.Files[i].Attempts = 0
+				retried++
+			}
+		}
+
+		if retried == 0 {
+			return ErrNoFailedFiles
+		}
+
+		if task.Status == domain.StatusFailed {
+			task.Status = domain.StatusPending
+		}
+		refreshTaskStatus(task)
+		return nil
+	})
+}
+
+// CancelFile marks a single file of a task cancelled and recomputes the task
+// progress and status without it, so that the task can complete with the
+// remaining files. The file is not interrupted if it is downloading; the
+// worker pool does that.
+func (tm *TaskManager) CancelFile(taskID string, index int) (*domain.Task, error) {
+	return tm.ModifyTask(taskID, func(task *domain.Task) error {
+		if err := cancelFile(task, index); err != nil {
+			return err
+		}
+		refreshTaskStatus(task)
+		return nil
+	})
+}
+
+// cancelFile marks a file cancelled. Files that are done or already
+// cancelled cannot be cancelled.
+func cancelFile(task *domain.Task, index int) error {
+	if index < 0 || index >= len(task.Files) {
+		return fmt.Errorf("%w: task has no file %d", ErrFileNotFound, index)
+	}
+	file := &task.Files[index]
+	if fileSucceeded(file.Status) || file.Status == domain.StatusCancelled {
+		return fmt.Errorf("%w: cannot cancel file in status %s", ErrInvalidTransition, file.Status)
+	}
+	file.Status = domain.StatusCancelled
+	file.Speed = 0
+	file.PartETag = ""
+	return nil
+}
+
+// GetAllTasks returns snapshots of all tasks
 func (tm *TaskManager) GetAllTasks() map[string]*domain.Task {
 	tm.mutex.RLock()
 	defer tm.mutex.RUnlock()
 
 	result := make(map[string]*domain.Task)
-	for k, v := range tm.tasks {
-		result[k] = v
-	}
+	tm.forEachTask(func(task *domain.Task) {
+		result[task.ID] = task.Clone()
+	})
 	return result
 }
 
+// FindTasksByLabels returns snapshots of the tasks carrying all of the given
+// labels, oldest first. An empty value matches any value of its key.
+func (tm *TaskManager) FindTasksByLabels(labels map[string]string) []*domain.Task {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	var found []*domain.Task
+	tm.forEachTask(func(task *domain.Task) {
+		if hasLabels(task, labels) {
+			found = append(found, task.Clone())
+		}
+	})
+	sort.Slice(found, func(i, j int) bool {
+		if !found[i].CreatedAt.Equal(found[j].CreatedAt) {
+			return found[i].CreatedAt.Before(found[j].CreatedAt)
+		}
+		return found[i].ID < found[j].ID
+	})
+	return found
+}
+
+// cloneRequest copies request options so that the task does not share them with the caller
+func cloneRequest(request *domain.RequestOptions) *domain.RequestOptions {
+	if request == nil {
+		return nil
+	}
+	c := *request
+	c.Headers = maps.Clone(request.Headers)
+	return &c
+}
+
+// hasLabels reports whether a task carries all of the given labels
+func hasLabels(task *domain.Task, labels map[string]string) bool {
+	for key, value := range labels {
+		got, ok := task.Labels[key]
+		if !ok || (value != "" && got != value) {
+			return false
+		}
+	}
+	return true
+}
+
+// ImportTasks stores tasks from an export dump. A task whose ID already exists
+// is skipped unless overwrite is set. Files caught mid-download in the dump are
+// reset to pending, since no worker of this process owns them. The whole dump
+// is validated before anything is stored: IDs and filenames must not hold a
+// path. It returns snapshots of the imported tasks and the number of skipped
+// ones.
+func (tm *TaskManager) ImportTasks(tasks []*domain.Task, overwrite bool) ([]*domain.Task, int, error) {
+	seen := make(map[string]bool, len(tasks))
+	for i, task := range tasks {
+		if task == nil {
+			return nil, 0, fmt.Errorf("%w: task %d is null", ErrInvalidTask, i)
+		}
+		if !validTaskID(task.ID) {
+			return nil, 0, fmt.Errorf("%w: task %d has invalid id %q", ErrInvalidTask, i, task.ID)
+		}
+		if seen[task.ID] {
+			return nil, 0, fmt.Errorf("%w: duplicate id %q", ErrInvalidTask, task.ID)
+		}
+		seen[task.ID] = true
+		for j, f := range task.Files {
+			if f.Filename != "" && !validFilename(f.Filename) {
+				return nil, 0, fmt.Errorf("%w: task %d file %d has invalid filename %q", ErrInvalidTask, i, j, f.Filename)
+			}
+		}
+	}
+
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	var imported []*domain.Task
+	skipped := 0
+	for _, task := range tasks {
+		current, exists := tm.lookup(task.ID)
+		if exists && !overwrite {
+			skipped++
+			continue
+		}
+
+		stored := task.Clone()
+		if exists && stored.Version <= current.Version {
+			stored.Version = current.Version + 1
+		}
+		for i := range stored.Files {
+			if stored.Files[i].Status == domain.StatusDownloading {
+				stored.Files[i].Status = domain.StatusPending
+			}
+			splitMirrorCredentials(&stored.Files[i])
+		}
+		refreshTaskStatus(stored)
+		tm.tasks[stored.ID] = stored
+
+		if err := tm.storage.SaveTask(stored); err != nil {
+			logger.Logger.Error("Failed to save imported task", "task_id", stored.ID, "error", err)
+			return imported, skipped, err
+		}
+		tm.track(stored)
+		imported = append(imported, stored.Clone())
+	}
+
+	return imported, skipped, nil
+}
+
+// DeleteExpiredTasks removes completed and failed tasks that finished before
+// cutoff from memory and storage and returns them. The check and the removal
+// happen under the manager lock, so a task retried in the meantime is kept.
+// Of the tasks evicted from memory only the expired ones are read back.
+func (tm *TaskManager) DeleteExpiredTasks(cutoff time.Time) []*domain.Task {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	var expired []*domain.Task
+	for _, task := range tm.tasks {
+		if taskFinished(task) && finishedAt(task).Before(cutoff) {
+			expired = append(expired, task)
+		}
+	}
+	for _, id := range tm.evicted.expired(cutoff) {
+		task, err := tm.storage.LoadTask(id)
+		if err != nil || task.ID != id {
+			// still deleted, but its files are not known
+			logger.Logger.Error("Failed to load evicted task", "task_id", id, "error", err)
+			task = &domain.Task{ID: id, Status: tm.evicted.tasks[id].status}
+		}
+		expired = append(expired, task)
+	}
+
+	var deleted []*domain.Task
+	for _, task := range expired {
+		id := task.ID
+		if err := tm.storage.DeleteTask(id); err != nil {
+			logger.Logger.Error("Failed to delete expired task", "task_id", id, "error", err)
+			continue
+		}
+		delete(tm.tasks, id)
+		delete(tm.dirty, id)
+		tm.evicted.remove(id)
+		if tm.lru != nil {
+			tm.lru.remove(id)
+		}
+		deleted = append(deleted, task)
+	}
+	return deleted
+}
+
+// FilenamesInUse returns the names of the files of all tasks, including the
+// ones evicted from memory, so that shared files are not deleted with a task
+func (tm *TaskManager) FilenamesInUse() map[string]bool {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	inUse := make(map[string]bool, len(tm.evicted.names))
+	for _, task := range tm.tasks {
+		for _, f := range task.Files {
+			inUse[f.Filename] = true
+		}
+	}
+	for name := range tm.evicted.names {
+		inUse[name] = true
+	}
+	return inUse
+}
+
+// CountByStatus returns the number of tasks in each status
+func (tm *TaskManager) CountByStatus() map[domain.Status]int {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	counts := maps.Clone(tm.evicted.taskCounts)
+	for _, task := range tm.tasks {
+		counts[task.Status]++
+	}
+	return counts
+}
+
+// TaskTotals counts the tasks and files of a TaskManager by status
+type TaskTotals struct {
+	Tasks map[domain.Status]int
+	Files map[domain.Status]int
+	// Speed is the combined speed of all downloading files in bytes per second
+	Speed int64
+}
+
+// Totals counts tasks and files by status and sums the speed of the files
+// being downloaded in a single pass over the tasks. Tasks evicted from memory
+// are counted from the evicted index.
+func (tm *TaskManager) Totals() TaskTotals {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	totals := TaskTotals{Tasks: maps.Clone(tm.evicted.taskCounts), Files: maps.Clone(tm.evicted.fileCounts)}
+	for _, task := range tm.tasks {
+		totals.Tasks[task.Status]++
+		for _, file := range task.Files {
+			totals.Files[file.Status]++
+			if file.Status == domain.StatusDownloading {
+				totals.Speed += file.Speed
+			}
+		}
+	}
+	return totals
+}
+
+// FindCompletedFile returns the most recently completed file served by url
+// that carries cache validators, together with the ID of its task. Only tasks
+// with the same decompression setting are considered, since their bytes differ.
+// Files of tasks evicted from memory are looked up in the evicted index.
+func (tm *TaskManager) FindCompletedFile(url string, decompress bool) (string, domain.File, bool) {
+	tm.mutex.RLock()
+	defer tm.mutex.RUnlock()
+
+	var (
+		taskID string
+		found  domain.File
+		ok     bool
+	)
+	consider := func(id string, f domain.File) {
+		if !ok || f.CompletedAt.After(*found.CompletedAt) {
+			taskID, found, ok = id, f, true
+		}
+	}
+	for _, task := range tm.tasks {
+		if task.Decompress != decompress {
+			continue
+		}
+		for _, f := range task.Files {
+			// validators belong to the server that served the bytes
+			if f.SourceURL == url && reusable(task, f) {
+				consider(task.ID, f)
+			}
+		}
+	}
+	for _, rf := range tm.evicted.validators[url] {
+		if rf.decompress == decompress {
+			consider(rf.taskID, rf.file)
+		}
+	}
+	if ok {
+		found.Mirrors = append([]string(nil), found.Mirrors...)
+	}
+	return taskID, found, ok
+}
+
+// reusable reports whether a file of task can stand in for a new download of
+// its source URL: it was completed with cache validators and its bytes are
+// the ones the validators describe
+func reusable(task *domain.Task, f domain.File) bool {
+	if task.Request != nil {
+		// a custom request may get other content from the same URL
+		return false
+	}
+	if task.Transform != "" && task.Transform != TransformNone {
+		// the bytes on disk are not the ones the validators describe
+		return false
+	}
+	if f.Status != domain.StatusCompleted || f.CompletedAt == nil || f.SourceURL == "" {
+		return false
+	}
+	return f.ETag != "" || f.LastModified != ""
+}
+
+// outstandingFiles counts files that are not finished yet. The caller must hold the mutex.
+func (tm *TaskManager) outstandingFiles() int {
+	count := 0
+	for _, task := range tm.tasks {
+		if task.Status == domain.StatusCompleted || task.Status == domain.StatusFailed {
+			continue
+		}
+		for _, f := range task.Files {
+			if !fileTerminal(f.Status) {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// HealthCheck reports whether the task storage can persist tasks
+func (tm *TaskManager) HealthCheck() error {
+	return tm.storage.HealthCheck()
+}
+
+// validTaskID reports whether id can be used as a task ID. IDs name the state
+// files, so they must not contain path separators.
+func validTaskID(id string) bool {
+	return id != "" && id != "." && id != ".." && !strings.ContainsAny(id, `/\`)
+}
+
+// validFilename reports whether name names a file right inside the task
+// directory rather than a path leading out of it
+func validFilename(name string) bool {
+	return name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
 // generateTaskID generates task ID
 func generateTaskID() string {
 	return fmt.Sprintf("task_%d", time.Now().UnixNano())
@@ -1,9 +1,14 @@
 package service
 
 import (
+	"errors"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
 )
 
 // TestTaskManagerCreateTask tests task creation with various inputs
@@ -32,7 +37,7 @@ func TestTaskManagerCreateTask(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tm := NewTaskManager()
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
 			task, err := tm.CreateTask(tt.urls)
 
 			if tt.expectError {
@@ -90,7 +95,7 @@ func TestTaskManagerGetTask(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tm := NewTaskManager()
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
 			taskID := tt.setup(tm)
 
 			task, exists := tm.GetTask(taskID)
@@ -135,7 +140,7 @@ func TestTaskManagerUpdateTask(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tm := NewTaskManager()
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
 			task, err := tm.CreateTask([]string{"http://example.com/test.txt"})
 			if err != nil {
 				t.Fatalf("failed to create task: %v", err)
@@ -169,3 +174,740 @@ func TestTaskManagerUpdateTask(t *testing.T) {
 		})
 	}
 }
+
+// TestTaskManagerUpdateTaskVersion tests that writes bump the task version and stale copies are rejected
+func TestTaskManagerUpdateTaskVersion(t *testing.T) {
+	tests := []struct {
+		name            string
+		write           func(tm *TaskManager, stale *domain.Task) error
+		expectedErr     error
+		expectedVersion int64
+	}{
+		{
+			name:            "current copy",
+			write:           func(tm *TaskManager, stale *domain.Task) error { return nil },
+			expectedVersion: 2,
+		},
+		{
+			name: "copy outdated by an update",
+			write: func(tm *TaskManager, stale *domain.Task) error {
+				fresh, _ := tm.GetTask(stale.ID)
+				fresh.Progress = 50
+				return tm.UpdateTask(fresh)
+			},
+			expectedErr:     ErrStaleTask,
+			expectedVersion: 2,
+		},
+		{
+			name: "copy outdated by a modification",
+			write: func(tm *TaskManager, stale *domain.Task) error {
+				_, err := tm.ModifyTask(stale.ID, func(task *domain.Task) error {
+					task.Progress = 50
+					return nil
+				})
+				return err
+			},
+			expectedErr:     ErrStaleTask,
+			expectedVersion: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			task, err := tm.CreateTask([]string{"http://example.com/test.txt"})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			if task.Version != 1 {
+				t.Fatalf("expected a new task at version 1, got %d", task.Version)
+			}
+			if err := tt.write(tm, task); err != nil {
+				t.Fatalf("failed to write task: %v", err)
+			}
+
+			task.Status = domain.StatusFailed
+			err = tm.UpdateTask(task)
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+
+			stored, _ := tm.GetTask(task.ID)
+			if stored.Version != tt.expectedVersion {
+				t.Errorf("expected version %d, got %d", tt.expectedVersion, stored.Version)
+			}
+			if tt.expectedErr != nil && stored.Status == domain.StatusFailed {
+				t.Errorf("expected the stale write to be discarded")
+			}
+			if tt.expectedErr == nil && task.Version != stored.Version {
+				t.Errorf("expected the written copy to carry version %d, got %d", stored.Version, task.Version)
+			}
+		})
+	}
+}
+
+// TestTaskManagerPauseResume tests pausing and resuming tasks
+func TestTaskManagerPauseResume(t *testing.T) {
+	tests := []struct {
+		name          string
+		initialStatus domain.Status
+		expectPause   bool
+	}{
+		{
+			name:          "pause pending task",
+			initialStatus: domain.StatusPending,
+			expectPause:   true,
+		},
+		{
+			name:          "pause downloading task",
+			initialStatus: domain.StatusDownloading,
+			expectPause:   true,
+		},
+		{
+			name:          "pause completed task",
+			initialStatus: domain.StatusCompleted,
+			expectPause:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			task, err := tm.CreateTask([]string{"http://example.com/a.txt", "http://example.com/b.txt"})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			task.Status = tt.initialStatus
+			if err := tm.UpdateTask(task); err != nil {
+				t.Fatalf("failed to update task: %v", err)
+			}
+
+			paused, err := tm.PauseTask(task.ID)
+			if !tt.expectPause {
+				if !errors.Is(err, ErrInvalidTransition) {
+					t.Errorf("expected ErrInvalidTransition, got %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if paused.Status != domain.StatusPaused {
+				t.Errorf("expected status %s, got %s", domain.StatusPaused, paused.Status)
+			}
+			for _, f := range paused.Files {
+				if f.Status != domain.StatusPaused {
+					t.Errorf("expected file status %s, got %s", domain.StatusPaused, f.Status)
+				}
+			}
+
+			resumed, err := tm.ResumeTask(task.ID)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if resumed.Status != domain.StatusPending {
+				t.Errorf("expected status %s, got %s", domain.StatusPending, resumed.Status)
+			}
+			for _, f := range resumed.Files {
+				if f.Status != domain.StatusPending {
+					t.Errorf("expected file status %s, got %s", domain.StatusPending, f.Status)
+				}
+			}
+		})
+	}
+}
+
+// TestTaskManagerRetryFailedFiles tests resetting failed files for retry
+func TestTaskManagerRetryFailedFiles(t *testing.T) {
+	tests := []struct {
+		name           string
+		fileStatuses   []domain.Status
+		expectedErr    error
+		expectedStatus domain.Status
+	}{
+		{
+			name:           "retry all failed files",
+			fileStatuses:   []domain.Status{domain.StatusFailed, domain.StatusFailed},
+			expectedStatus: domain.StatusPending,
+		},
+		{
+			name:           "retry keeps completed files",
+			fileStatuses:   []domain.Status{domain.StatusCompleted, domain.StatusFailed},
+			expectedStatus: domain.StatusDownloading,
+		},
+		{
+			name:         "nothing to retry",
+			fileStatuses: []domain.Status{domain.StatusCompleted, domain.StatusCompleted},
+			expectedErr:  ErrNoFailedFiles,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			task, err := tm.CreateTask([]string{"http://example.com/a.txt", "http://example.com/b.txt"})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			for i, status := range tt.fileStatuses {
+				task.Files[i].Status = status
+			}
+			refreshTaskStatus(task)
+			if err := tm.UpdateTask(task); err != nil {
+				t.Fatalf("failed to update task: %v", err)
+			}
+
+			retried, err := tm.RetryFailedFiles(task.ID)
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected %v, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if retried.Status != tt.expectedStatus {
+				t.Errorf("expected status %s, got %s", tt.expectedStatus, retried.Status)
+			}
+			for i, status := range tt.fileStatuses {
+				want := status
+				if status == domain.StatusFailed {
+					want = domain.StatusPending
+				}
+				if retried.Files[i].Status != want {
+					t.Errorf("file %d: expected status %s, got %s", i, want, retried.Files[i].Status)
+				}
+			}
+		})
+	}
+}
+
+// TestTaskManagerTimestamps tests that creation, update and completion times are populated
+func TestTaskManagerTimestamps(t *testing.T) {
+	tests := []struct {
+		name            string
+		fileStatus      domain.Status
+		expectCompleted bool
+	}{
+		{
+			name:            "completed task",
+			fileStatus:      domain.StatusCompleted,
+			expectCompleted: true,
+		},
+		{
+			name:            "failed task",
+			fileStatus:      domain.StatusFailed,
+			expectCompleted: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			task, err := tm.CreateTask([]string{"http://example.com/a.txt"})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+
+			if task.CreatedAt.IsZero() || task.Files[0].CreatedAt.IsZero() {
+				t.Errorf("expected created_at to be set")
+			}
+			created := task.UpdatedAt
+
+			time.Sleep(time.Millisecond)
+			task.Files[0].Status = tt.fileStatus
+			refreshTaskStatus(task)
+			if err := tm.UpdateTask(task); err != nil {
+				t.Fatalf("failed to update task: %v", err)
+			}
+
+			if !task.UpdatedAt.After(created) {
+				t.Errorf("expected updated_at to advance")
+			}
+			if got := task.CompletedAt != nil; got != tt.expectCompleted {
+				t.Errorf("expected completed_at set=%v, got %v", tt.expectCompleted, got)
+			}
+		})
+	}
+}
+
+// TestTaskManagerLimits tests the per-task URL limit and the global outstanding files limit
+func TestTaskManagerLimits(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxURLs        int
+		maxOutstanding int
+		existing       int
+		urls           int
+		expectedErr    error
+	}{
+		{
+			name:    "within URL limit",
+			maxURLs: 3,
+			urls:    3,
+		},
+		{
+			name:        "over URL limit",
+			maxURLs:     3,
+			urls:        4,
+			expectedErr: ErrTooManyURLs,
+		},
+		{
+			name:    "URL limit disabled",
+			maxURLs: 0,
+			urls:    DefaultMaxURLsPerTask + 1,
+		},
+		{
+			name:           "within outstanding limit",
+			maxOutstanding: 4,
+			existing:       2,
+			urls:           2,
+		},
+		{
+			name:           "over outstanding limit",
+			maxOutstanding: 4,
+			existing:       3,
+			urls:           2,
+			expectedErr:    ErrSaturated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			tm.SetMaxURLsPerTask(tt.maxURLs)
+			tm.SetMaxOutstandingFiles(tt.maxOutstanding)
+
+			if tt.existing > 0 {
+				if _, err := tm.CreateTask(testURLs(tt.existing)); err != nil {
+					t.Fatalf("failed to create existing task: %v", err)
+				}
+			}
+
+			_, err := tm.CreateTask(testURLs(tt.urls))
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Errorf("expected %v, got %v", tt.expectedErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// testURLs returns n distinct download URLs
+func testURLs(n int) []string {
+	urls := make([]string, n)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("http://example.com/file%d.txt", i)
+	}
+	return urls
+}
+
+// countingStorage counts UpdateTask writes and remembers the last written task
+type countingStorage struct {
+	repository.Storage
+	mu      sync.Mutex
+	updates int
+	loads   int
+	last    *domain.Task
+}
+
+func (s *countingStorage) LoadTask(taskID string) (*domain.Task, error) {
+	s.mu.Lock()
+	s.loads++
+	s.mu.Unlock()
+	return s.Storage.LoadTask(taskID)
+}
+
+func (s *countingStorage) loaded() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loads
+}
+
+func (s *countingStorage) UpdateTask(task *domain.Task) error {
+	s.mu.Lock()
+	s.updates++
+	s.last = task.Clone()
+	s.mu.Unlock()
+	return s.Storage.UpdateTask(task)
+}
+
+func (s *countingStorage) written() (int, *domain.Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updates, s.last
+}
+
+// TestTaskManagerSaveInterval tests that rapid updates of a task are coalesced into fewer writes
+func TestTaskManagerSaveInterval(t *testing.T) {
+	tests := []struct {
+		name             string
+		interval         time.Duration
+		finish           bool
+		flush            bool
+		wait             time.Duration
+		expectedWrites   int
+		expectedProgress int
+	}{
+		{
+			name:             "write through",
+			interval:         0,
+			expectedWrites:   10,
+			expectedProgress: 10,
+		},
+		{
+			name:             "coalesced until flushed",
+			interval:         time.Hour,
+			flush:            true,
+			expectedWrites:   1,
+			expectedProgress: 10,
+		},
+		{
+			name:             "finished task written at once",
+			interval:         time.Hour,
+			finish:           true,
+			expectedWrites:   1,
+			expectedProgress: 100,
+		},
+		{
+			name:             "flushed after the interval",
+			interval:         20 * time.Millisecond,
+			wait:             200 * time.Millisecond,
+			expectedWrites:   1,
+			expectedProgress: 10,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &countingStorage{Storage: repository.NewMemoryStorage()}
+			tm := NewTaskManagerWithStorage(storage)
+			tm.SetSaveInterval(tt.interval)
+
+			task, err := tm.CreateTask([]string{"http://example.com/file.txt"})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			for i := 1; i <= 10; i++ {
+				if _, err := tm.ModifyTask(task.ID, func(task *domain.Task) error {
+					task.Status = domain.StatusDownloading
+					task.Progress = i
+					return nil
+				}); err != nil {
+					t.Fatalf("failed to modify task: %v", err)
+				}
+			}
+			if tt.finish {
+				tm.ModifyTask(task.ID, func(task *domain.Task) error {
+					task.Status = domain.StatusCompleted
+					task.Progress = 100
+					return nil
+				})
+			}
+			if tt.flush {
+				if writes, _ := storage.written(); writes != 0 {
+					t.Errorf("expected no writes before the flush, got %d", writes)
+				}
+				if err := tm.Flush(); err != nil {
+					t.Fatalf("flush failed: %v", err)
+				}
+			}
+			time.Sleep(tt.wait)
+
+			writes, last := storage.written()
+			if writes != tt.expectedWrites {
+				t.Errorf("expected %d writes, got %d", tt.expectedWrites, writes)
+			}
+			if last == nil || last.Progress != tt.expectedProgress {
+				t.Errorf("expected the last write to hold progress %d, got %+v", tt.expectedProgress, last)
+			}
+		})
+	}
+}
+
+// TestTaskManagerMaxTasksInMemory tests that finished tasks beyond the limit are evicted and loaded back on demand
+func TestTaskManagerMaxTasksInMemory(t *testing.T) {
+	storage := repository.NewMemoryStorage()
+	tm := NewTaskManagerWithStorage(storage)
+	tm.SetMaxTasksInMemory(3)
+
+	create := func() string {
+		task, err := tm.CreateTask([]string{"http://example.com/file.txt"})
+		if err != nil {
+			t.Fatalf("failed to create task: %v", err)
+		}
+		return task.ID
+	}
+	setStatus := func(taskID string, status domain.Status) {
+		if _, err := tm.ModifyTask(taskID, func(task *domain.Task) error {
+			task.Status = status
+			return nil
+		}); err != nil {
+			t.Fatalf("failed to modify task: %v", err)
+		}
+	}
+	resident := func(taskID string) bool {
+		tm.mutex.RLock()
+		defer tm.mutex.RUnlock()
+		_, ok := tm.tasks[taskID]
+		return ok
+	}
+
+	a, b, c := create(), create(), create()
+	setStatus(a, domain.StatusCompleted)
+	setStatus(b, domain.StatusFailed)
+	if _, ok := tm.GetTask(a); !ok {
+		t.Fatal("expected task a to exist")
+	}
+
+	d := create()
+	if resident(b) || !resident(a) {
+		t.Errorf("expected the least recently used task b to be evicted before a")
+	}
+	e := create()
+	f := create()
+	if resident(a) {
+		t.Errorf("expected finished task a to be evicted")
+	}
+	for _, id := range []string{c, d, e, f} {
+		if !resident(id) {
+			t.Errorf("expected active task %s to stay in memory", id)
+		}
+	}
+
+	task, ok := tm.GetTask(b)
+	if !ok || task.Status != domain.StatusFailed {
+		t.Fatalf("expected evicted task b to be loaded back as failed, got %+v", task)
+	}
+	setStatus(b, domain.StatusPending)
+	if !resident(b) {
+		t.Errorf("expected task b to stay in memory once active again")
+	}
+	if _, ok := tm.GetTask("task_missing"); ok {
+		t.Errorf("expected an unknown task not to be found")
+	}
+
+	reloaded := NewTaskManagerWithStorage(storage)
+	reloaded.SetMaxTasksInMemory(1)
+	if _, ok := reloaded.GetTask(a); !ok {
+		t.Errorf("expected task a to be found after a restart")
+	}
+	reloaded.mutex.RLock()
+	count := len(reloaded.tasks)
+	reloaded.mutex.RUnlock()
+	if count != 5 {
+		t.Errorf("expected the 5 active tasks in memory after a restart, got %d", count)
+	}
+}
+
+// TestTaskManagerEvictedTasks tests that tasks evicted from memory are still listed, counted, reused and swept
+func TestTaskManagerEvictedTasks(t *testing.T) {
+	storage := &countingStorage{Storage: repository.NewMemoryStorage()}
+	tm := NewTaskManagerWithStorage(storage)
+	tm.SetMaxTasksInMemory(1)
+
+	active, err := tm.CreateTask([]string{"http://example.com/active.txt"})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	recent := time.Now()
+	var ids []string
+	for _, finished := range []time.Time{old, old, old, recent} {
+		finished := finished
+		task, err := tm.CreateTaskWithOptions([]string{"http://example.com/file.txt"}, TaskOptions{Labels: map[string]string{"batch": "old"}})
+		if err != nil {
+			t.Fatalf("failed to create task: %v", err)
+		}
+		if _, err := tm.ModifyTask(task.ID, func(task *domain.Task) error {
+			task.Status = domain.StatusCompleted
+			task.CompletedAt = &finished
+			task.Files[0].Status = domain.StatusCompleted
+			task.Files[0].CompletedAt = &finished
+			task.Files[0].SourceURL = "http://example.com/file.txt"
+			task.Files[0].ETag = `"v1"`
+			return nil
+		}); err != nil {
+			t.Fatalf("failed to modify task: %v", err)
+		}
+		ids = append(ids, task.ID)
+	}
+
+	tm.mutex.RLock()
+	resident := len(tm.tasks)
+	tm.mutex.RUnlock()
+	if resident != 1 {
+		t.Fatalf("expected only the active task in memory, got %d tasks", resident)
+	}
+	if all := tm.GetAllTasks(); len(all) != 5 {
+		t.Errorf("expected all 5 tasks to be listed, got %d", len(all))
+	}
+	if found := tm.FindTasksByLabels(map[string]string{"batch": "old"}); len(found) != 4 {
+		t.Errorf("expected all 4 finished tasks to match the label, got %d", len(found))
+	}
+
+	loads := storage.loaded()
+	counts := tm.CountByStatus()
+	if counts[domain.StatusCompleted] != 4 || counts[domain.StatusPending] != 1 {
+		t.Errorf("expected 4 completed and 1 pending task, got %v", counts)
+	}
+	totals := tm.Totals()
+	if totals.Tasks[domain.StatusCompleted] != 4 || totals.Files[domain.StatusCompleted] != 4 || totals.Files[domain.StatusPending] != 1 {
+		t.Errorf("expected the evicted tasks and files in the totals, got %+v", totals)
+	}
+	if taskID, _, ok := tm.FindCompletedFile("http://example.com/file.txt", false); !ok || taskID != ids[3] {
+		t.Errorf("expected the most recent completed file of an evicted task, got %q", taskID)
+	}
+	if inUse := tm.FilenamesInUse(); !inUse["file.txt"] || !inUse["active.txt"] {
+		t.Errorf("expected the names of evicted and active tasks in use, got %v", inUse)
+	}
+	if got := storage.loaded(); got != loads {
+		t.Errorf("expected stats and reuse lookups not to read storage, got %d reads", got-loads)
+	}
+
+	deleted := tm.DeleteExpiredTasks(time.Now().Add(-time.Minute))
+	if len(deleted) != 3 {
+		t.Errorf("expected the 3 expired tasks to be swept, got %d", len(deleted))
+	}
+	if got := storage.loaded() - loads; got != 3 {
+		t.Errorf("expected only the expired tasks to be read back, got %d reads", got)
+	}
+	stored, err := storage.LoadAllTasks()
+	if err != nil {
+		t.Fatalf("failed to load stored tasks: %v", err)
+	}
+	if len(stored) != 2 {
+		t.Errorf("expected the expired tasks to be deleted from storage, got %d left", len(stored))
+	}
+	for _, id := range ids[:3] {
+		if _, ok := tm.GetTask(id); ok {
+			t.Errorf("expected swept task %s to be gone", id)
+		}
+	}
+	counts = tm.CountByStatus()
+	if counts[domain.StatusCompleted] != 1 || counts[domain.StatusPending] != 1 {
+		t.Errorf("expected 1 completed and 1 pending task after the sweep, got %v", counts)
+	}
+	if _, ok := tm.GetTask(active.ID); !ok {
+		t.Errorf("expected the active task to be kept")
+	}
+}
+
+// TestTaskManagerEvictDirtyTask tests that a task with an unwritten update is evicted once it is flushed
+func TestTaskManagerEvictDirtyTask(t *testing.T) {
+	tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	tm.SetMaxTasksInMemory(1)
+	tm.SetSaveInterval(time.Hour)
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		task, err := tm.CreateTask([]string{"http://example.com/file.txt"})
+		if err != nil {
+			t.Fatalf("failed to create task: %v", err)
+		}
+		ids = append(ids, task.ID)
+	}
+
+	// the second task stays active, so the finished one is over the limit
+	tm.mutex.Lock()
+	tm.dirty[ids[0]] = struct{}{}
+	tm.tasks[ids[0]].Status = domain.StatusCompleted
+	tm.track(tm.tasks[ids[0]])
+	_, kept := tm.tasks[ids[0]]
+	tm.mutex.Unlock()
+	if !kept {
+		t.Fatalf("expected the dirty task to stay in memory")
+	}
+
+	if err := tm.Flush(); err != nil {
+		t.Fatalf("flush failed: %v", err)
+	}
+	tm.mutex.RLock()
+	_, kept = tm.tasks[ids[0]]
+	tm.mutex.RUnlock()
+	if kept {
+		t.Errorf("expected the flushed task to be evicted")
+	}
+}
+
+// TestTaskManagerFindTasksByLabels tests label filtering and that labels survive a restart
+func TestTaskManagerFindTasksByLabels(t *testing.T) {
+	storage := repository.NewTaskStorageWithDir(t.TempDir())
+	tm := NewTaskManagerWithStorage(storage)
+	labelled := []map[string]string{
+		{"project": "foo", "env": "prod"},
+		{"project": "foo", "env": "dev"},
+		{"project": "bar"},
+		nil,
+	}
+	var ids []string
+	for _, labels := range labelled {
+		task, err := tm.CreateTaskWithOptions([]string{"http://example.com/file.txt"}, TaskOptions{Labels: labels})
+		if err != nil {
+			t.Fatalf("failed to create task: %v", err)
+		}
+		ids = append(ids, task.ID)
+	}
+	// the task keeps its own copy of the labels
+	labelled[0]["project"] = "changed"
+
+	tests := []struct {
+		name     string
+		labels   map[string]string
+		expected []string
+	}{
+		{
+			name:     "no filter",
+			expected: ids,
+		},
+		{
+			name:     "one label",
+			labels:   map[string]string{"project": "foo"},
+			expected: ids[:2],
+		},
+		{
+			name:     "all labels must match",
+			labels:   map[string]string{"project": "foo", "env": "dev"},
+			expected: ids[1:2],
+		},
+		{
+			name:     "any value of a key",
+			labels:   map[string]string{"env": ""},
+			expected: ids[:2],
+		},
+		{
+			name:   "no match",
+			labels: map[string]string{"project": "baz"},
+		},
+	}
+
+	reloaded := NewTaskManagerWithStorage(storage)
+	for _, manager := range []*TaskManager{tm, reloaded} {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				var got []string
+				for _, task := range manager.FindTasksByLabels(tt.labels) {
+					got = append(got, task.ID)
+				}
+				if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+					t.Errorf("expected tasks %v, got %v", tt.expected, got)
+				}
+			})
+		}
+	}
+
+	task, _ := reloaded.GetTask(ids[0])
+	if task.Labels["project"] != "foo" || task.Labels["env"] != "prod" {
+		t.Errorf("expected labels to survive a restart, got %v", task.Labels)
+	}
+}
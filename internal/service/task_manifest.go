@@ -0,0 +1,95 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"filedownloader-20240926/internal/domain"
+)
+
+// ErrTaskNotFinished is returned when the manifest of a task that is still running is requested
+var ErrTaskNotFinished = errors.New("task is not finished")
+
+// TaskManifest lists the files a finished task produced with their name,
+// size and SHA-256, in the order of the task. Only completed files are
+// listed. Digests not known from the task are computed from the files on
+// disk and stored in the task, so each file is hashed once; a file that
+// cannot be read, or whose name points outside the task directory, is listed
+// with an error instead. The SHA-256 of the
+// manifest covers the whole batch: it is the digest of the listing in
+// sha256sum format, "<sha256>  <filename>" per line.
+func (wp *WorkerPool) TaskManifest(taskID string) (*domain.TaskManifest, error) {
+	if wp.tm == nil {
+		return nil, ErrTaskNotFound
+	}
+	task, ok := wp.tm.GetTask(taskID)
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	if task.Status != domain.StatusCompleted && task.Status != domain.StatusFailed {
+		return nil, fmt.Errorf("%w: task is %s", ErrTaskNotFinished, task.Status)
+	}
+
+	dir := wp.downloader.TaskDir(taskID)
+	manifest := &domain.TaskManifest{
+		TaskID:      task.ID,
+		Status:      task.Status,
+		CompletedAt: task.CompletedAt,
+		Files:       []domain.ManifestFile{},
+	}
+	computed := make(map[int]string)
+	listing := sha256.New()
+	for i, f := range task.Files {
+		if f.Status != domain.StatusCompleted {
+			continue
+		}
+		entry := domain.ManifestFile{Index: i, URL: f.URL, Filename: f.Filename, Size: f.Size, SHA256: f.SHA256}
+		if entry.SHA256 == "" {
+			var sum string
+			var err error
+			if name := filepath.Base(f.Filename); name != f.Filename {
+				// only files right in the task directory belong to the task
+				err = fmt.Errorf("invalid filename %q", f.Filename)
+			} else {
+				sum, err = hashFile(filepath.Join(dir, name))
+			}
+			if err != nil {
+				wp.taskLogger(taskID).Warn("Failed to hash file for manifest", "file_index", i, "error", err)
+				entry.Error = err.Error()
+			} else {
+				entry.SHA256 = sum
+				computed[i] = sum
+			}
+		}
+		fmt.Fprintf(listing, "%s  %s\n", entry.SHA256, entry.Filename)
+		manifest.Files = append(manifest.Files, entry)
+	}
+	manifest.SHA256 = hex.EncodeToString(listing.Sum(nil))
+	manifest.GeneratedAt = time.Now()
+
+	if len(computed) > 0 {
+		wp.storeDigests(taskID, task.Files, computed)
+	}
+	return manifest, nil
+}
+
+// storeDigests records digests computed for the manifest in the task. A file
+// that changed since it was hashed is left alone.
+func (wp *WorkerPool) storeDigests(taskID string, hashed []domain.File, digests map[int]string) {
+	_, err := wp.tm.ModifyTask(taskID, func(task *domain.Task) error {
+		for i, sum := range digests {
+			f := &task.Files[i]
+			if f.Status == domain.StatusCompleted && f.SHA256 == "" && f.Filename == hashed[i].Filename {
+				f.SHA256 = sum
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		wp.taskLogger(taskID).Warn("Failed to store file digests", "error", err)
+	}
+}
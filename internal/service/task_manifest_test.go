@@ -0,0 +1,95 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+)
+
+// TestWorkerPoolTaskManifest tests the manifest of a finished task, with digests hashed lazily and stored in the task
+func TestWorkerPoolTaskManifest(t *testing.T) {
+	digest := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+
+	tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	wp := NewWorkerPool(1, tm)
+	wp.downloader.downloadsDir = t.TempDir()
+
+	task, err := tm.CreateTask([]string{"http://example.com/a.txt", "http://example.com/b.txt", "http://example.com/c.txt", "http://example.com/d.txt", "http://example.com/e.txt"})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	if _, err := wp.TaskManifest(task.ID); !errors.Is(err, ErrTaskNotFinished) {
+		t.Errorf("expected %v for a pending task, got %v", ErrTaskNotFinished, err)
+	}
+
+	dir := wp.downloader.TaskDir(task.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatalf("failed to create task dir: %v", err)
+	}
+	for name, content := range map[string]string{"a.txt": "first", "b.txt": "second", "../outside.txt": "outside"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	if _, err := tm.ModifyTask(task.ID, func(task *domain.Task) error {
+		task.Files[0].Status = domain.StatusCompleted
+		task.Files[0].Size = 5
+		task.Files[1].Status = domain.StatusCompleted
+		task.Files[1].Size = 6
+		task.Files[1].SHA256 = digest("second")
+		task.Files[2].Status = domain.StatusCompleted
+		task.Files[3].Status = domain.StatusFailed
+		task.Files[4].Status = domain.StatusCompleted
+		task.Files[4].Filename = "../outside.txt"
+		refreshTaskStatus(task)
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to update task: %v", err)
+	}
+
+	manifest, err := wp.TaskManifest(task.ID)
+	if err != nil {
+		t.Fatalf("failed to build manifest: %v", err)
+	}
+	if manifest.Status != domain.StatusFailed || len(manifest.Files) != 4 {
+		t.Fatalf("expected the 4 completed files of the failed task, got %+v", manifest)
+	}
+	expected := []domain.ManifestFile{
+		{Index: 0, URL: "http://example.com/a.txt", Filename: "a.txt", Size: 5, SHA256: digest("first")},
+		{Index: 1, URL: "http://example.com/b.txt", Filename: "b.txt", Size: 6, SHA256: digest("second")},
+	}
+	for i, want := range expected {
+		if manifest.Files[i] != want {
+			t.Errorf("expected file %d to be %+v, got %+v", i, want, manifest.Files[i])
+		}
+	}
+	if missing := manifest.Files[2]; missing.SHA256 != "" || missing.Error == "" {
+		t.Errorf("expected a missing file to be listed with an error, got %+v", missing)
+	}
+	if outside := manifest.Files[3]; outside.SHA256 != "" || outside.Error == "" {
+		t.Errorf("expected a file outside the task directory to be listed with an error, got %+v", outside)
+	}
+	listing := fmt.Sprintf("%s  a.txt\n%s  b.txt\n  c.txt\n  ../outside.txt\n", digest("first"), digest("second"))
+	if manifest.SHA256 != digest(listing) {
+		t.Errorf("expected the manifest digest to cover the listing, got %s", manifest.SHA256)
+	}
+
+	stored, _ := tm.GetTask(task.ID)
+	if stored.Files[0].SHA256 != digest("first") || stored.Files[2].SHA256 != "" {
+		t.Errorf("expected only the computed digest to be stored, got %q and %q", stored.Files[0].SHA256, stored.Files[2].SHA256)
+	}
+
+	if _, err := wp.TaskManifest("missing"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("expected %v for an unknown task, got %v", ErrTaskNotFound, err)
+	}
+}
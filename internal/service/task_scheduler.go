@@ -0,0 +1,109 @@
+package service
+
+import "sync"
+
+// taskScheduler bounds the number of tasks whose files are handed to the
+// workers. A task over the limit waits, ordered by priority and then by
+// arrival, and is admitted once an active task finishes.
+type taskScheduler struct {
+	mu      sync.Mutex
+	limit   int
+	active  map[string]struct{}
+	waiting []waitingTask
+}
+
+type waitingTask struct {
+	taskID   string
+	priority int
+}
+
+func newTaskScheduler() *taskScheduler {
+	return &taskScheduler{active: make(map[string]struct{})}
+}
+
+// SetLimit sets the number of active tasks; 0 or less means unlimited
+func (s *taskScheduler) SetLimit(limit int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = limit
+}
+
+// TryAdmit makes a task active when it already is or a slot is free;
+// otherwise it queues the task, keeping its place if it already waits, and
+// returns false
+func (s *taskScheduler) TryAdmit(taskID string, priority int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.active[taskID]; ok {
+		return true
+	}
+	if s.position(taskID) > 0 {
+		return false
+	}
+	if s.limit <= 0 || len(s.active) < s.limit {
+		s.active[taskID] = struct{}{}
+		return true
+	}
+
+	at := len(s.waiting)
+	for i, w := range s.waiting {
+		if priority > w.priority {
+			at = i
+			break
+		}
+	}
+	s.waiting = append(s.waiting, waitingTask{})
+	copy(s.waiting[at+1:], s.waiting[at:])
+	s.waiting[at] = waitingTask{taskID: taskID, priority: priority}
+	return false
+}
+
+// Release frees the slot of a finished task, or drops it from the queue, and
+// returns the waiting tasks admitted in its place. The caller must start them.
+func (s *taskScheduler) Release(taskID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if at := s.position(taskID); at > 0 {
+		s.waiting = append(s.waiting[:at-1], s.waiting[at:]...)
+		return nil
+	}
+	if _, ok := s.active[taskID]; !ok {
+		return nil
+	}
+	delete(s.active, taskID)
+
+	var admitted []string
+	for len(s.waiting) > 0 && (s.limit <= 0 || len(s.active) < s.limit) {
+		next := s.waiting[0].taskID
+		s.waiting = s.waiting[1:]
+		s.active[next] = struct{}{}
+		admitted = append(admitted, next)
+	}
+	return admitted
+}
+
+// Position returns the 1-based place of a task in the queue, or 0 when the
+// task is not waiting
+func (s *taskScheduler) Position(taskID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.position(taskID)
+}
+
+func (s *taskScheduler) position(taskID string) int {
+	for i, w := range s.waiting {
+		if w.taskID == taskID {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Waiting returns the number of queued tasks
+func (s *taskScheduler) Waiting() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.waiting)
+}
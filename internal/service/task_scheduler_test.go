@@ -0,0 +1,80 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// TestTaskSchedulerAdmission tests the active task limit and the order in which waiting tasks are admitted
+func TestTaskSchedulerAdmission(t *testing.T) {
+	tests := []struct {
+		name             string
+		limit            int
+		priorities       []int
+		expectedAdmitted []string
+		expectedOrder    []string
+	}{
+		{
+			name:             "unlimited",
+			limit:            0,
+			priorities:       []int{0, 0, 0},
+			expectedAdmitted: []string{"t0", "t1", "t2"},
+		},
+		{
+			name:             "waiting tasks keep arrival order",
+			limit:            1,
+			priorities:       []int{0, 0, 0},
+			expectedAdmitted: []string{"t0"},
+			expectedOrder:    []string{"t1", "t2"},
+		},
+		{
+			name:             "higher priority waits in front",
+			limit:            2,
+			priorities:       []int{0, 0, 0, 5, 1},
+			expectedAdmitted: []string{"t0", "t1"},
+			expectedOrder:    []string{"t3", "t4", "t2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTaskScheduler()
+			s.SetLimit(tt.limit)
+
+			var admitted []string
+			for i, p := range tt.priorities {
+				id := fmt.Sprintf("t%d", i)
+				if s.TryAdmit(id, p) {
+					admitted = append(admitted, id)
+				}
+			}
+			if !reflect.DeepEqual(admitted, tt.expectedAdmitted) {
+				t.Fatalf("expected admitted %v, got %v", tt.expectedAdmitted, admitted)
+			}
+			for i, id := range tt.expectedOrder {
+				if pos := s.Position(id); pos != i+1 {
+					t.Errorf("expected %s at position %d, got %d", id, i+1, pos)
+				}
+			}
+			if !s.TryAdmit(admitted[0], 0) {
+				t.Errorf("expected an active task to stay admitted")
+			}
+
+			// finishing active tasks admits the waiting ones in order
+			var order []string
+			queue := append([]string(nil), admitted...)
+			for len(queue) > 0 {
+				next := s.Release(queue[0])
+				queue = append(queue[1:], next...)
+				order = append(order, next...)
+			}
+			if !reflect.DeepEqual(order, tt.expectedOrder) {
+				t.Errorf("expected admission order %v, got %v", tt.expectedOrder, order)
+			}
+			if s.Waiting() != 0 {
+				t.Errorf("expected no waiting tasks, got %d", s.Waiting())
+			}
+		})
+	}
+}
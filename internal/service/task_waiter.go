@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"filedownloader-20240926/internal/domain"
+)
+
+// taskWaiters hands the final snapshot of a task to everyone waiting for it to finish
+type taskWaiters struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan *domain.Task]struct{}
+}
+
+func newTaskWaiters() *taskWaiters {
+	return &taskWaiters{waiters: make(map[string]map[chan *domain.Task]struct{})}
+}
+
+// subscribe registers a waiter for taskID and returns its channel together
+// with the function removing it again
+func (w *taskWaiters) subscribe(taskID string) (<-chan *domain.Task, func()) {
+	ch := make(chan *domain.Task, 1)
+
+	w.mu.Lock()
+	if w.waiters[taskID] == nil {
+		w.waiters[taskID] = make(map[chan *domain.Task]struct{})
+	}
+	w.waiters[taskID][ch] = struct{}{}
+	w.mu.Unlock()
+
+	return ch, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.waiters[taskID], ch)
+		if len(w.waiters[taskID]) == 0 {
+			delete(w.waiters, taskID)
+		}
+	}
+}
+
+// publish sends the finished task to its waiters and forgets them
+func (w *taskWaiters) publish(task *domain.Task) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.waiters[task.ID] {
+		ch <- task.Clone()
+	}
+	delete(w.waiters, task.ID)
+}
+
+// WaitForTask blocks until the task is completed or failed and returns its
+// final state. It returns early with ctx.Err() when ctx is done, or with
+// ErrTaskNotFound when the task does not exist.
+func (wp *WorkerPool) WaitForTask(ctx context.Context, taskID string) (*domain.Task, error) {
+	// subscribe before looking at the task, so that a task finishing in
+	// between is not missed
+	done, unsubscribe := wp.waiters.subscribe(taskID)
+	defer unsubscribe()
+
+	task, ok := wp.tm.GetTask(taskID)
+	if !ok {
+		return nil, ErrTaskNotFound
+	}
+	if task.Status == domain.StatusCompleted || task.Status == domain.StatusFailed {
+		return task, nil
+	}
+
+	select {
+	case task := <-done:
+		return task, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
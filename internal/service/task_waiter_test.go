@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+)
+
+// TestWorkerPoolWaitForTask tests waiting for a task to finish and that abandoned waiters are removed
+func TestWorkerPoolWaitForTask(t *testing.T) {
+	tests := []struct {
+		name        string
+		finished    bool
+		finishAfter time.Duration
+		timeout     time.Duration
+		expectedErr error
+	}{
+		{
+			name:     "already finished",
+			finished: true,
+			timeout:  time.Second,
+		},
+		{
+			name:        "finishes while waiting",
+			finishAfter: 50 * time.Millisecond,
+			timeout:     time.Second,
+		},
+		{
+			name:        "gives up on timeout",
+			timeout:     50 * time.Millisecond,
+			expectedErr: context.DeadlineExceeded,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(1, tm)
+
+			task, err := tm.CreateTask([]string{"http://example.com/file.txt"})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			finish := func() {
+				wp.updateFile(task.ID, 0, func(f *domain.File) {
+					f.Status = domain.StatusCompleted
+				})
+			}
+			if tt.finished {
+				finish()
+			}
+			if tt.finishAfter > 0 {
+				time.AfterFunc(tt.finishAfter, finish)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+			defer cancel()
+			final, err := wp.WaitForTask(ctx, task.ID)
+
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected error %v, got %v", tt.expectedErr, err)
+			}
+			if err == nil && final.Status != domain.StatusCompleted {
+				t.Errorf("expected completed task, got %s", final.Status)
+			}
+			wp.waiters.mu.Lock()
+			left := len(wp.waiters.waiters)
+			wp.waiters.mu.Unlock()
+			if left != 0 {
+				t.Errorf("expected no waiters left, got %d", left)
+			}
+		})
+	}
+}
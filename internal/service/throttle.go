@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"io"
+	"math"
+	"time"
+)
+
+// rateStrategy decides how fast a throttled download may read: due returns
+// how long after the start of the transfer the first n bytes may have been read
+type rateStrategy interface {
+	due(n int64) time.Duration
+}
+
+// flatRate allows a constant number of bytes per second from the first byte on
+type flatRate struct {
+	limit float64
+}
+
+func (r flatRate) due(n int64) time.Duration {
+	return seconds(float64(n) / r.limit)
+}
+
+// slowStartRate ramps the allowed rate linearly from zero to limit over ramp
+// and holds it there, so that downloads starting together do not all burst
+// at the full rate at once
+type slowStartRate struct {
+	limit float64
+	ramp  time.Duration
+}
+
+func (r slowStartRate) due(n int64) time.Duration {
+	ramp := r.ramp.Seconds()
+	// the bytes allowed during the ramp are the area under it
+	rampBytes := r.limit * ramp / 2
+	if float64(n) <= rampBytes {
+		return seconds(math.Sqrt(2 * ramp * float64(n) / r.limit))
+	}
+	return seconds(ramp + (float64(n)-rampBytes)/r.limit)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// newRateStrategy returns the strategy for a cap of limit bytes per second,
+// ramping up over ramp when it is positive; nil means no cap
+func newRateStrategy(limit int64, ramp time.Duration) rateStrategy {
+	switch {
+	case limit <= 0:
+		return nil
+	case ramp > 0:
+		return slowStartRate{limit: float64(limit), ramp: ramp}
+	default:
+		return flatRate{limit: float64(limit)}
+	}
+}
+
+// throttledReader delays reads so that the bytes read never run ahead of
+// its strategy. Reads are cut into chunks of about a twentieth of a second
+// at the full rate, so that the stall timer keeps being reset while waiting.
+type throttledReader struct {
+	r        io.Reader
+	ctx      context.Context
+	strategy rateStrategy
+	chunk    int
+	start    time.Time
+	n        int64
+}
+
+const minThrottleChunk = 1024
+
+// newThrottledReader wraps r, or returns it as is when strategy is nil
+func newThrottledReader(ctx context.Context, r io.Reader, strategy rateStrategy, limit int64) io.Reader {
+	if strategy == nil {
+		return r
+	}
+	chunk := int(limit / 20)
+	if chunk < minThrottleChunk {
+		chunk = minThrottleChunk
+	}
+	return &throttledReader{r: r, ctx: ctx, strategy: strategy, chunk: chunk, start: time.Now()}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > t.chunk {
+		p = p[:t.chunk]
+	}
+	n, err := t.r.Read(p)
+	t.n += int64(n)
+	if wait := time.Until(t.start.Add(t.strategy.due(t.n))); n > 0 && wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-t.ctx.Done():
+			return n, context.Cause(t.ctx)
+		}
+	}
+	return n, err
+}
+
+// throttle returns the rate strategy for downloads from the configured speed cap
+func (d *Downloader) throttle() rateStrategy {
+	return newRateStrategy(d.maxSpeed, d.speedRamp)
+}
@@ -0,0 +1,119 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// TestRateStrategy tests when flat and slow-start caps allow bytes to be read
+func TestRateStrategy(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int64
+		ramp     time.Duration
+		bytes    int64
+		expected time.Duration
+	}{
+		{
+			name:     "no cap",
+			limit:    0,
+			ramp:     time.Second,
+			expected: -1,
+		},
+		{
+			name:     "flat cap",
+			limit:    1000,
+			bytes:    500,
+			expected: 500 * time.Millisecond,
+		},
+		{
+			name:     "during the ramp",
+			limit:    1000,
+			ramp:     2 * time.Second,
+			bytes:    250,
+			expected: time.Second,
+		},
+		{
+			name:     "end of the ramp",
+			limit:    1000,
+			ramp:     2 * time.Second,
+			bytes:    1000,
+			expected: 2 * time.Second,
+		},
+		{
+			name:     "after the ramp",
+			limit:    1000,
+			ramp:     2 * time.Second,
+			bytes:    3000,
+			expected: 4 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy := newRateStrategy(tt.limit, tt.ramp)
+			if tt.expected < 0 {
+				if strategy != nil {
+					t.Errorf("expected no strategy, got %T", strategy)
+				}
+				return
+			}
+			if due := strategy.due(tt.bytes); due != tt.expected {
+				t.Errorf("expected %d bytes to be due after %v, got %v", tt.bytes, tt.expected, due)
+			}
+		})
+	}
+}
+
+// TestThrottledReaderSlowStart tests that a ramping download reads less early on than once it reaches the cap
+func TestThrottledReaderSlowStart(t *testing.T) {
+	const limit = 200 * 1024
+	const ramp = 500 * time.Millisecond
+	const window = 200 * time.Millisecond
+
+	r := newThrottledReader(context.Background(), bytes.NewReader(make([]byte, 150*1024)), newRateStrategy(limit, ramp), limit)
+	start := time.Now()
+	var early, steady int
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		switch elapsed := time.Since(start); {
+		case elapsed <= window:
+			early += n
+		case elapsed > ramp+window && elapsed <= ramp+2*window:
+			steady += n
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if early*2 >= steady {
+		t.Errorf("expected the first %v to read well below the steady rate, got %d bytes early and %d steady", window, early, steady)
+	}
+	if max := int(limit * window.Seconds() * 1.5); steady > max {
+		t.Errorf("expected at most %d bytes in %v at the cap, got %d", max, window, steady)
+	}
+}
+
+// TestThrottledReaderCancel tests that a throttled read returns as soon as its context is canceled
+func TestThrottledReaderCancel(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	r := newThrottledReader(ctx, bytes.NewReader(make([]byte, 4096)), newRateStrategy(1024, 0), 1024)
+
+	time.AfterFunc(50*time.Millisecond, func() { cancel(ErrDownloadStalled) })
+	start := time.Now()
+	_, err := io.ReadAll(r)
+	if err != ErrDownloadStalled {
+		t.Errorf("expected %v, got %v", ErrDownloadStalled, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the read to stop on cancel, took %v", elapsed)
+	}
+}
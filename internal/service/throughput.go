@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// transferCounter adds up the body bytes of the responses to the requests of
+// a single download, including those of pages, pieces and restarts
+type transferCounter struct {
+	bytes      atomic.Int64
+	statusCode atomic.Int32
+}
+
+type transferCounterKey struct{}
+
+// withTransferCounter returns a context whose requests are counted by the returned counter
+func withTransferCounter(ctx context.Context) (context.Context, *transferCounter) {
+	c := &transferCounter{}
+	return context.WithValue(ctx, transferCounterKey{}, c), c
+}
+
+// countingTransport feeds the responses of requests made with a transfer
+// counter in their context into that counter. Redirects are left out, since
+// the client discards their bodies.
+type countingTransport struct {
+	rt http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.rt.RoundTrip(req)
+	c, ok := req.Context().Value(transferCounterKey{}).(*transferCounter)
+	if err != nil || !ok || (resp.StatusCode >= 300 && resp.StatusCode < 400) {
+		return resp, err
+	}
+	c.statusCode.Store(int32(resp.StatusCode))
+	resp.Body = &countedBody{ReadCloser: resp.Body, c: c}
+	return resp, nil
+}
+
+// countedBody counts the bytes read from a response body
+type countedBody struct {
+	io.ReadCloser
+	c *transferCounter
+}
+
+func (b *countedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.c.bytes.Add(int64(n))
+	return n, err
+}
+
+// throughputMBps returns the rate of bytes transferred in elapsed in
+// megabytes (10^6 bytes) per second, rounded to three decimals
+func throughputMBps(bytes int64, elapsed time.Duration) float64 {
+	if bytes <= 0 || elapsed <= 0 {
+		return 0
+	}
+	return math.Round(float64(bytes)/elapsed.Seconds()/1e6*1000) / 1000
+}
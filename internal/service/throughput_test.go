@@ -0,0 +1,173 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"filedownloader-20240926/internal/repository"
+	"filedownloader-20240926/pkg/logger"
+)
+
+// TestThroughputMBps tests the computation of the throughput logged for completed files
+func TestThroughputMBps(t *testing.T) {
+	tests := []struct {
+		name     string
+		bytes    int64
+		elapsed  time.Duration
+		expected float64
+	}{
+		{name: "one megabyte per second", bytes: 1e6, elapsed: time.Second, expected: 1},
+		{name: "fast transfer", bytes: 25e6, elapsed: 500 * time.Millisecond, expected: 50},
+		{name: "rounded", bytes: 1000, elapsed: 3 * time.Millisecond, expected: 0.333},
+		{name: "nothing transferred", bytes: 0, elapsed: time.Second, expected: 0},
+		{name: "no time elapsed", bytes: 1000, elapsed: 0, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := throughputMBps(tt.bytes, tt.elapsed); got != tt.expected {
+				t.Errorf("expected %v MB/s, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+// TestDownloaderTransferStats tests the bytes, duration and status code a download reports
+func TestDownloaderTransferStats(t *testing.T) {
+	content := strings.Repeat("0123456789", 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old.txt" {
+			http.Redirect(w, r, "/file.txt", http.StatusFound)
+			return
+		}
+		http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name             string
+		path             string
+		part             string
+		expectedBytes    int64
+		expectedStatus   int
+		expectedFinalURL string
+	}{
+		{
+			name:             "whole file",
+			path:             "/file.txt",
+			expectedBytes:    int64(len(content)),
+			expectedStatus:   http.StatusOK,
+			expectedFinalURL: srv.URL + "/file.txt",
+		},
+		{
+			name:             "resumed bytes are not counted",
+			path:             "/file.txt",
+			part:             content[:400],
+			expectedBytes:    int64(len(content) - 400),
+			expectedStatus:   http.StatusPartialContent,
+			expectedFinalURL: srv.URL + "/file.txt",
+		},
+		{
+			name:             "redirected",
+			path:             "/old.txt",
+			expectedBytes:    int64(len(content)),
+			expectedStatus:   http.StatusOK,
+			expectedFinalURL: srv.URL + "/file.txt",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDownloader()
+			d.downloadsDir = t.TempDir()
+			opts := DownloadOptions{PartFile: filepath.Join(d.downloadsDir, ".file.part")}
+			if tt.part != "" {
+				if err := os.WriteFile(opts.PartFile, []byte(tt.part), 0644); err != nil {
+					t.Fatalf("failed to write part file: %v", err)
+				}
+				opts.Offset = int64(len(tt.part))
+			}
+
+			result, err := d.fetch(context.Background(), srv.URL+tt.path, "file.txt", opts)
+			if err != nil {
+				t.Fatalf("download failed: %v", err)
+			}
+			if result.Bytes != tt.expectedBytes {
+				t.Errorf("expected %d bytes, got %d", tt.expectedBytes, result.Bytes)
+			}
+			if result.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, result.StatusCode)
+			}
+			if result.FinalURL != tt.expectedFinalURL {
+				t.Errorf("expected final URL %s, got %s", tt.expectedFinalURL, result.FinalURL)
+			}
+			if result.Duration <= 0 {
+				t.Errorf("expected a positive duration, got %v", result.Duration)
+			}
+		})
+	}
+}
+
+// TestWorkerPoolLogsThroughput tests the throughput fields of the log line of a completed file
+func TestWorkerPoolLogsThroughput(t *testing.T) {
+	content := strings.Repeat("x", 5000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			time.Sleep(10 * time.Millisecond)
+		}
+		w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	original := logger.Logger
+	logger.Logger = logger.NewJSONLogger(&buf, slog.LevelInfo)
+	defer func() { logger.Logger = original }()
+
+	tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	wp := NewWorkerPool(1, tm)
+	wp.downloader.downloadsDir = t.TempDir()
+	task, err := tm.CreateTask([]string{srv.URL + "/file.txt"})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	wp.processTask(0, DownloadTask{TaskID: task.ID, FileIndex: 0})
+
+	var completed map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("failed to parse log line %q: %v", line, err)
+		}
+		if entry["msg"] == "Download completed" {
+			completed = entry
+		}
+	}
+	if completed == nil {
+		t.Fatalf("expected a completion log line, got %s", buf.String())
+	}
+	if completed["bytes"] != float64(len(content)) {
+		t.Errorf("expected bytes %d, got %v", len(content), completed["bytes"])
+	}
+	if ms, _ := completed["duration_ms"].(float64); ms < 10 {
+		t.Errorf("expected duration_ms of at least 10, got %v", completed["duration_ms"])
+	}
+	if mbps, _ := completed["throughput_mbps"].(float64); mbps <= 0 || mbps > 0.5 {
+		t.Errorf("expected a throughput of at most 0.5 MB/s, got %v", completed["throughput_mbps"])
+	}
+	if completed["status_code"] != float64(http.StatusOK) {
+		t.Errorf("expected status_code 200, got %v", completed["status_code"])
+	}
+	if completed["final_url"] != srv.URL+"/file.txt" {
+		t.Errorf("expected final_url %s, got %v", srv.URL+"/file.txt", completed["final_url"])
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrRequestTimeout is returned when the response headers do not arrive within the request timeout
+	ErrRequestTimeout = errors.New("no response within request timeout")
+	// ErrTransferTimeout is returned when reading a body takes longer than its transfer deadline
+	ErrTransferTimeout = errors.New("transfer deadline exceeded")
+)
+
+// requestTimeoutTransport bounds every round trip until its response headers
+// arrive: DNS, connecting, TLS and the server's time to answer. Unlike
+// http.Client.Timeout it stops counting once the headers are in, so the body
+// may take as long as its transfer deadline allows.
+type requestTimeoutTransport struct {
+	rt      http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *requestTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 {
+		return t.rt.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancelCause(req.Context())
+	timer := time.AfterFunc(t.timeout, func() { cancel(ErrRequestTimeout) })
+	resp, err := t.rt.RoundTrip(req.WithContext(ctx))
+	if !timer.Stop() {
+		// the timer fired, whatever the round trip returned afterwards
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel(nil)
+		return nil, fmt.Errorf("%w of %v", ErrRequestTimeout, t.timeout)
+	}
+	if err != nil {
+		cancel(nil)
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases the context of a request when its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelCauseFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+	return err
+}
+
+// transferDeadline returns how long reading a body of expected bytes may
+// take: the transfer timeout plus, with a minimum rate set, the time the
+// expected bytes need at that rate. 0 means no deadline.
+func (d *Downloader) transferDeadline(expected int64) time.Duration {
+	deadline := d.transferTimeout
+	if d.minTransferRate > 0 && expected > 0 {
+		deadline += time.Duration(float64(expected) / float64(d.minTransferRate) * float64(time.Second))
+	}
+	return deadline
+}
+
+// startTransferDeadline cancels ctx with ErrTransferTimeout once the
+// transfer deadline for expected bytes has passed. The returned function
+// stops the timer.
+func (d *Downloader) startTransferDeadline(expected int64, cancel context.CancelCauseFunc) func() {
+	deadline := d.transferDeadline(expected)
+	if deadline <= 0 {
+		return func() {}
+	}
+	timer := time.AfterFunc(deadline, func() {
+		cancel(fmt.Errorf("%w of %v", ErrTransferTimeout, deadline))
+	})
+	return func() { timer.Stop() }
+}
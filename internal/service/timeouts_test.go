@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestDownloaderTimeouts tests that the request timeout only covers the headers and the transfer deadline the body
+func TestDownloaderTimeouts(t *testing.T) {
+	tests := []struct {
+		name            string
+		headerDelay     time.Duration
+		bodyPause       time.Duration
+		requestTimeout  time.Duration
+		transferTimeout time.Duration
+		minTransferRate int64
+		expectedErr     error
+	}{
+		{
+			name:           "slow headers fail fast",
+			headerDelay:    2 * time.Second,
+			requestTimeout: 100 * time.Millisecond,
+			expectedErr:    ErrRequestTimeout,
+		},
+		{
+			name:           "slow body outlives the request timeout",
+			bodyPause:      300 * time.Millisecond,
+			requestTimeout: 100 * time.Millisecond,
+		},
+		{
+			name:            "slow body exceeds the transfer deadline",
+			bodyPause:       2 * time.Second,
+			requestTimeout:  time.Second,
+			transferTimeout: 100 * time.Millisecond,
+			expectedErr:     ErrTransferTimeout,
+		},
+		{
+			name:            "transfer deadline scaled by size",
+			bodyPause:       300 * time.Millisecond,
+			requestTimeout:  time.Second,
+			transferTimeout: 50 * time.Millisecond,
+			minTransferRate: 200,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.Repeat("x", 200)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(tt.headerDelay):
+				case <-r.Context().Done():
+					return
+				}
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				w.Write([]byte(body[:100]))
+				w.(http.Flusher).Flush()
+				select {
+				case <-time.After(tt.bodyPause):
+				case <-r.Context().Done():
+					return
+				}
+				w.Write([]byte(body[100:]))
+			}))
+			defer srv.Close()
+
+			d := NewDownloader()
+			d.downloadsDir = t.TempDir()
+			d.requestTimeout = tt.requestTimeout
+			d.transferTimeout = tt.transferTimeout
+			d.minTransferRate = tt.minTransferRate
+
+			start := time.Now()
+			_, err := d.DownloadFile(context.Background(), srv.URL, "file.txt")
+
+			if tt.expectedErr == nil {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected %v, got %v", tt.expectedErr, err)
+			}
+			if code := errorCode(err); code != ErrorCodeTimeout {
+				t.Errorf("expected error code %s, got %s", ErrorCodeTimeout, code)
+			}
+			if elapsed := time.Since(start); elapsed >= time.Second {
+				t.Errorf("expected the timeout to fire before 1s, took %v", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"filedownloader-20240926/internal/config"
+	"filedownloader-20240926/pkg/logger"
+)
+
+// SetTLSConfig applies the TLS settings to the downloader transport. Host name
+// verification stays enabled unless insecure_skip_verify is set explicitly.
+func (d *Downloader) SetTLSConfig(cfg config.TLSConfig) error {
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+	d.transport.TLSClientConfig = tlsConfig
+	return nil
+}
+
+// newTLSConfig builds a client tls.Config from the download TLS settings
+func newTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.InsecureSkipVerify {
+		logger.Logger.Warn("TLS CERTIFICATE VERIFICATION IS DISABLED: downloads are open to man-in-the-middle attacks",
+			"setting", "download.tls.insecure_skip_verify")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return tlsConfig, nil
+}
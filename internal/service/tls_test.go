@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filedownloader-20240926/internal/config"
+)
+
+// writeTestCert writes a self-signed client certificate and key to dir
+func writeTestCert(t *testing.T, dir string) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "filedownloader-test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+
+	certPath := filepath.Join(dir, "client.crt")
+	keyPath := filepath.Join(dir, "client.key")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certPath, keyPath
+}
+
+// TestDownloaderTLS tests custom CA bundles, client certificates and skip-verify
+func TestDownloaderTLS(t *testing.T) {
+	tests := []struct {
+		name          string
+		trustCA       bool
+		insecure      bool
+		requireClient bool
+		clientCert    bool
+		expectError   bool
+	}{
+		{
+			name:        "self-signed without CA fails",
+			expectError: true,
+		},
+		{
+			name:    "custom CA trusts server",
+			trustCA: true,
+		},
+		{
+			name:     "insecure skip verify",
+			insecure: true,
+		},
+		{
+			name:          "client certificate required and missing",
+			trustCA:       true,
+			requireClient: true,
+			expectError:   true,
+		},
+		{
+			name:          "client certificate presented",
+			trustCA:       true,
+			requireClient: true,
+			clientCert:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				io.WriteString(w, "secret")
+			}))
+			if tt.requireClient {
+				srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+			}
+			srv.StartTLS()
+			defer srv.Close()
+
+			dir := t.TempDir()
+			cfg := config.TLSConfig{InsecureSkipVerify: tt.insecure}
+			if tt.trustCA {
+				cfg.CAFile = filepath.Join(dir, "ca.pem")
+				caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+				if err := os.WriteFile(cfg.CAFile, caPEM, 0600); err != nil {
+					t.Fatalf("failed to write CA file: %v", err)
+				}
+			}
+			if tt.clientCert {
+				cfg.CertFile, cfg.KeyFile = writeTestCert(t, dir)
+			}
+
+			d := NewDownloader()
+			d.downloadsDir = t.TempDir()
+			if err := d.SetTLSConfig(cfg); err != nil {
+				t.Fatalf("SetTLSConfig error: %v", err)
+			}
+
+			_, err := d.DownloadFile(context.Background(), srv.URL+"/secret.txt", "secret.txt")
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+// TestNewTLSConfigErrors tests that unreadable TLS files are reported
+func TestNewTLSConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	garbage := filepath.Join(dir, "garbage.pem")
+	os.WriteFile(garbage, []byte("not a certificate"), 0600)
+
+	tests := []struct {
+		name string
+		cfg  config.TLSConfig
+	}{
+		{name: "missing CA file", cfg: config.TLSConfig{CAFile: filepath.Join(dir, "missing.pem")}},
+		{name: "CA file without certificates", cfg: config.TLSConfig{CAFile: garbage}},
+		{name: "invalid client pair", cfg: config.TLSConfig{CertFile: garbage, KeyFile: garbage}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newTLSConfig(tt.cfg); err == nil {
+				t.Errorf("expected error but got none")
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrTransformFailed is returned when the transform of a task cannot process the downloaded bytes
+var ErrTransformFailed = errors.New("transform failed")
+
+// ErrorCodeTransform is recorded for files whose transform failed
+const ErrorCodeTransform = "transform"
+
+// DownloadTransform rewrites the bytes of a download before they are written
+// to disk. Wrap is called once per download with the response body, after any
+// Content-Encoding was decoded, and returns the reader the file is copied from.
+type DownloadTransform interface {
+	Wrap(r io.Reader) (io.Reader, error)
+}
+
+const (
+	// TransformNone writes the bytes as they arrive
+	TransformNone = "none"
+	// TransformGunzip decompresses a gzip file, e.g. a .gz served without
+	// a Content-Encoding
+	TransformGunzip = "gunzip"
+)
+
+// transforms are the transforms a task can select by name
+var transforms = map[string]DownloadTransform{
+	TransformNone:   noopTransform{},
+	TransformGunzip: gunzipTransform{},
+}
+
+// ValidTransform reports whether name is one of the transforms
+func ValidTransform(name string) bool {
+	_, ok := transforms[name]
+	return ok
+}
+
+// transformed reports whether a download goes through a transform that
+// changes its bytes, so that they differ from the bytes on the wire
+func (o DownloadOptions) transformed() bool {
+	return o.Transform != "" && o.Transform != TransformNone
+}
+
+// noopTransform passes the bytes through unchanged
+type noopTransform struct{}
+
+func (noopTransform) Wrap(r io.Reader) (io.Reader, error) {
+	return r, nil
+}
+
+// gunzipTransform decompresses gzip data, joining concatenated members
+type gunzipTransform struct{}
+
+func (gunzipTransform) Wrap(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+// applyTransform wraps body in the named transform. Errors of the transform
+// are reported as ErrTransformFailed, while errors of the body itself pass
+// through unchanged, so that e.g. a network error is still retried.
+func applyTransform(name string, body io.Reader) (io.Reader, error) {
+	transform, ok := transforms[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown transform %q", ErrTransformFailed, name)
+	}
+	src := &sourceReader{r: body}
+	wrapped, err := transform.Wrap(src)
+	if err != nil {
+		return nil, src.wrap(err)
+	}
+	return &transformReader{r: wrapped, src: src}, nil
+}
+
+// sourceReader remembers the last error of the reader a transform reads from
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+// wrap attributes an error returned through a transform either to the
+// source or to the transform
+func (s *sourceReader) wrap(err error) error {
+	if s.err != nil {
+		return s.err
+	}
+	return fmt.Errorf("%w: %v", ErrTransformFailed, err)
+}
+
+// transformReader reads the output of a transform
+type transformReader struct {
+	r   io.Reader
+	src *sourceReader
+}
+
+func (t *transformReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = t.src.wrap(err)
+	}
+	return n, err
+}
@@ -0,0 +1,263 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+)
+
+// gzipBytes compresses each part into its own gzip member
+func gzipBytes(t *testing.T, parts ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, part := range parts {
+		zw := gzip.NewWriter(&buf)
+		if _, err := io.WriteString(zw, part); err != nil {
+			t.Fatalf("failed to compress: %v", err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("failed to compress: %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// TestDownloaderTransform tests that downloads go through the transform of their task before they are written
+func TestDownloaderTransform(t *testing.T) {
+	compressed := gzipBytes(t, "id,name\n1,a\n")
+	tests := []struct {
+		name          string
+		transform     string
+		served        []byte
+		part          []byte
+		expected      string
+		expectedErr   error
+		expectedRange string
+	}{
+		{
+			name:     "no transform",
+			served:   compressed,
+			expected: string(compressed),
+		},
+		{
+			name:      "none",
+			transform: TransformNone,
+			served:    []byte("plain"),
+			expected:  "plain",
+		},
+		{
+			name:      "gunzip",
+			transform: TransformGunzip,
+			served:    compressed,
+			expected:  "id,name\n1,a\n",
+		},
+		{
+			name:      "gunzip joins members",
+			transform: TransformGunzip,
+			served:    gzipBytes(t, "first\n", "second\n"),
+			expected:  "first\nsecond\n",
+		},
+		{
+			name:      "gunzip starts over instead of resuming",
+			transform: TransformGunzip,
+			served:    compressed,
+			part:      []byte("id,"),
+			expected:  "id,name\n1,a\n",
+		},
+		{
+			name:        "gunzip of data that is not gzip",
+			transform:   TransformGunzip,
+			served:      []byte("plain text"),
+			expectedErr: ErrTransformFailed,
+		},
+		{
+			name:        "gunzip of truncated data",
+			transform:   TransformGunzip,
+			served:      compressed[:len(compressed)-6],
+			expectedErr: ErrTransformFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				ranges []string
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				ranges = append(ranges, r.Header.Get("Range"))
+				mu.Unlock()
+				w.Header().Set("Content-Length", strconv.Itoa(len(tt.served)))
+				w.Write(tt.served)
+			}))
+			defer srv.Close()
+
+			d := NewDownloader()
+			d.downloadsDir = t.TempDir()
+			part := filepath.Join(d.downloadsDir, ".data.part")
+			opts := DownloadOptions{Transform: tt.transform, PartFile: part}
+			if tt.part != nil {
+				if err := os.WriteFile(part, tt.part, 0644); err != nil {
+					t.Fatalf("failed to write part file: %v", err)
+				}
+				opts.Offset = int64(len(tt.part))
+			}
+
+			result, err := d.fetch(context.Background(), srv.URL+"/data.csv.gz", "data.csv.gz", opts)
+			if tt.expectedErr != nil {
+				if !errors.Is(err, tt.expectedErr) {
+					t.Fatalf("expected %v, got %v", tt.expectedErr, err)
+				}
+				if code := errorCode(err); code != ErrorCodeTransform {
+					t.Errorf("expected error code %q, got %q", ErrorCodeTransform, code)
+				}
+				if retryable(err) {
+					t.Errorf("expected a transform error not to be retried")
+				}
+				if _, err := os.Stat(part); !os.IsNotExist(err) {
+					t.Errorf("expected the partial output to be removed, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("download failed: %v", err)
+			}
+			saved, err := os.ReadFile(filepath.Join(d.downloadsDir, result.Filename))
+			if err != nil || string(saved) != tt.expected {
+				t.Errorf("expected content %q, got %q (%v)", tt.expected, saved, err)
+			}
+			if len(ranges) != 1 || ranges[0] != tt.expectedRange {
+				t.Errorf("expected one request with range %q, got %q", tt.expectedRange, ranges)
+			}
+		})
+	}
+}
+
+// failingTransform fails every read after the first byte
+type failingTransform struct{}
+
+func (failingTransform) Wrap(r io.Reader) (io.Reader, error) {
+	return io.MultiReader(io.LimitReader(r, 1), failingReader{}), nil
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("line filter failed")
+}
+
+// TestApplyTransform tests that errors are attributed to the transform or to the body it reads
+func TestApplyTransform(t *testing.T) {
+	transforms["failing"] = failingTransform{}
+	defer delete(transforms, "failing")
+	networkErr := errors.New("connection reset")
+
+	tests := []struct {
+		name        string
+		transform   string
+		body        io.Reader
+		expectedErr error
+	}{
+		{name: "transform error", transform: "failing", body: bytes.NewReader([]byte("data")), expectedErr: ErrTransformFailed},
+		{name: "body error", transform: TransformGunzip, body: io.MultiReader(bytes.NewReader(gzipBytes(t, "data")[:12]), &errReader{networkErr}), expectedErr: networkErr},
+		{name: "body error before the header", transform: TransformGunzip, body: &errReader{networkErr}, expectedErr: networkErr},
+		{name: "unknown transform", transform: "gpg", body: bytes.NewReader(nil), expectedErr: ErrTransformFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := applyTransform(tt.transform, tt.body)
+			if err == nil {
+				_, err = io.ReadAll(r)
+			}
+			if !errors.Is(err, tt.expectedErr) {
+				t.Fatalf("expected %v, got %v", tt.expectedErr, err)
+			}
+			if tt.expectedErr != ErrTransformFailed && errors.Is(err, ErrTransformFailed) {
+				t.Errorf("expected an error of the body not to be blamed on the transform: %v", err)
+			}
+		})
+	}
+}
+
+// errReader fails every read with err
+type errReader struct {
+	err error
+}
+
+func (e *errReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
+
+// TestWorkerPoolTransform tests that a failed transform fails the file without leaving partial output
+func TestWorkerPoolTransform(t *testing.T) {
+	tests := []struct {
+		name           string
+		served         []byte
+		expectedStatus domain.Status
+		expectedCode   string
+	}{
+		{
+			name:           "transformed",
+			served:         gzipBytes(t, "data"),
+			expectedStatus: domain.StatusCompleted,
+		},
+		{
+			name:           "transform fails",
+			served:         []byte("not gzip"),
+			expectedStatus: domain.StatusFailed,
+			expectedCode:   ErrorCodeTransform,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(tt.served)
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(1, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			task, err := tm.CreateTaskWithOptions([]string{srv.URL + "/data.gz"}, TaskOptions{Transform: TransformGunzip})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			wp.processTask(0, DownloadTask{TaskID: task.ID, FileIndex: 0})
+
+			task, _ = tm.GetTask(task.ID)
+			file := task.Files[0]
+			if file.Status != tt.expectedStatus || file.ErrorCode != tt.expectedCode {
+				t.Fatalf("expected %s (%q), got %s (%q): %s", tt.expectedStatus, tt.expectedCode, file.Status, file.ErrorCode, file.Error)
+			}
+			if _, err := os.Stat(wp.downloader.PartPath(task.ID, 0)); !os.IsNotExist(err) {
+				t.Errorf("expected no part file to be left, got %v", err)
+			}
+			entries, _ := os.ReadDir(wp.downloader.TaskDir(task.ID))
+			if tt.expectedStatus == domain.StatusFailed && len(entries) != 0 {
+				t.Errorf("expected no output to be left, got %d files", len(entries))
+			}
+			if tt.expectedStatus != domain.StatusCompleted {
+				return
+			}
+			saved, err := os.ReadFile(filepath.Join(wp.downloader.TaskDir(task.ID), file.Filename))
+			if err != nil || string(saved) != "data" {
+				t.Errorf("expected the saved file to hold the decompressed data, got %q (%v)", saved, err)
+			}
+		})
+	}
+}
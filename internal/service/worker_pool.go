@@ -2,62 +2,314 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"filedownloader-20240926/internal/config"
 	"filedownloader-20240926/internal/domain"
 	"filedownloader-20240926/pkg/logger"
 )
 
+// DownloadTask identifies a file of a task by its index in Task.Files.
+// Workers never share file pointers; all file updates go through the TaskManager.
 type DownloadTask struct {
-	File   *domain.File
-	TaskID string
+	TaskID    string
+	FileIndex int
+	Priority  int
 }
 
+// ErrPoolStopped is returned when an operation requires a pool that has not been stopped
+var ErrPoolStopped = errors.New("worker pool stopped")
+
+// ErrPoolDraining is reported for new work submitted while the pool drains for shutdown
+var ErrPoolDraining = errors.New("service is shutting down")
+
 type WorkerPool struct {
 	workers    int
 	downloader *Downloader
-	taskChan   chan DownloadTask
+	notifier   *Notifier
+	waiters    *taskWaiters
+	queue      *priorityQueue
 	ctx        context.Context
 	cancel     context.CancelFunc
 	wg         sync.WaitGroup
 	tm         *TaskManager
-	once       sync.Once
+	mu         sync.Mutex
+	running    bool
+	nextID     int
+	alive      atomic.Int32
+	busy       atomic.Int32
+	draining   atomic.Bool
+	hosts      *hostLimiter
+	taskSlots  *taskLimiter
+	maxPerTask int
+	maxConns   int
+	scheduler  *taskScheduler
+
+	maxAttempts  int
+	retryBackoff time.Duration
+
+	extract config.ExtractConfig
+
+	taskTimeout time.Duration
+	taskCtxMu   sync.Mutex
+	taskCtxs    map[string]*taskContext
+
+	activeMu sync.Mutex
+	active   map[fileRef]struct{}
+	cancels  map[fileRef]context.CancelCauseFunc
+
+	startedAt time.Time
+	totals    downloadCounters
+	recent    recentDurations
+}
+
+// fileRef identifies a file of a task
+type fileRef struct {
+	taskID string
+	index  int
+}
+
+// taskContext bounds all downloads of a single task
+type taskContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // NewWorkerPool creates a new worker pool with specified number of workers
 func NewWorkerPool(workers int, tm *TaskManager) *WorkerPool {
-	ctx, cancel := context.WithCancel(context.Background())
-	return &WorkerPool{
-		workers:    workers,
-		downloader: NewDownloader(),
-		taskChan:   make(chan DownloadTask, workers*2),
-		ctx:        ctx,
-		cancel:     cancel,
-		tm:         tm,
-	}
+	return NewWorkerPoolWithContext(context.Background(), workers, tm)
 }
 
 // NewWorkerPoolWithContext creates WorkerPool with external context
 func NewWorkerPoolWithContext(ctx context.Context, workers int, tm *TaskManager) *WorkerPool {
 	workerCtx, cancel := context.WithCancel(ctx)
-	return &WorkerPool{
-		workers:    workers,
-		downloader: NewDownloader(),
-		taskChan:   make(chan DownloadTask, workers*2),
-		ctx:        workerCtx,
-		cancel:     cancel,
-		tm:         tm,
+	wp := &WorkerPool{
+		workers:     workers,
+		downloader:  NewDownloader(),
+		notifier:    NewNotifier(),
+		waiters:     newTaskWaiters(),
+		queue:       newPriorityQueue(),
+		ctx:         workerCtx,
+		cancel:      cancel,
+		tm:          tm,
+		hosts:       newHostLimiter(0),
+		taskSlots:   newTaskLimiter(),
+		scheduler:   newTaskScheduler(),
+		maxAttempts: 1,
+		extract:     config.ExtractConfig{MaxSizeMB: 1024, MaxFiles: 10000, KeepArchive: true},
+		taskCtxs:    make(map[string]*taskContext),
+		active:      make(map[fileRef]struct{}),
+		cancels:     make(map[fileRef]context.CancelCauseFunc),
+		startedAt:   time.Now(),
 	}
+
+	wp.applyMaxConnections()
+
+	go func() {
+		<-workerCtx.Done()
+		wp.queue.Close()
+	}()
+
+	return wp
+}
+
+// SetDownloader replaces the downloader used by the workers; call it before Start
+func (wp *WorkerPool) SetDownloader(d *Downloader) {
+	wp.downloader = d
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	wp.applyMaxConnections()
+}
+
+// Downloader returns the downloader used by the workers
+func (wp *WorkerPool) Downloader() *Downloader {
+	return wp.downloader
+}
+
+// SetTaskTimeout sets the default overall timeout for tasks without their own; 0 disables it.
+// A change applies to tasks that have not started downloading yet.
+func (wp *WorkerPool) SetTaskTimeout(timeout time.Duration) {
+	wp.taskCtxMu.Lock()
+	defer wp.taskCtxMu.Unlock()
+	wp.taskTimeout = timeout
+}
+
+// SetMaxPerHost limits the number of concurrent downloads from the same host;
+// 0 means unlimited. Workers wait for a free slot instead of failing. Call it before Start.
+func (wp *WorkerPool) SetMaxPerHost(limit int) {
+	wp.hosts = newHostLimiter(limit)
 }
 
-// Start starts all workers in the pool
+// SetMaxConnections caps the concurrent requests of all workers together,
+// independently of the worker count; 0 allows one per worker and follows
+// Resize. Workers wait for a free slot instead of failing.
+func (wp *WorkerPool) SetMaxConnections(limit int) {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	wp.maxConns = limit
+	wp.applyMaxConnections()
+}
+
+// applyMaxConnections passes the connection cap on to the downloader; wp.mu is held
+func (wp *WorkerPool) applyMaxConnections() {
+	limit := wp.maxConns
+	if limit <= 0 {
+		limit = wp.workers
+	}
+	wp.downloader.SetMaxConnections(limit)
+}
+
+// SetMaxPerTask limits how many files of one task download at once for tasks
+// without their own max_concurrency; 0 means unlimited. Files over the cap
+// wait aside while the workers serve other tasks.
+func (wp *WorkerPool) SetMaxPerTask(limit int) {
+	wp.maxPerTask = limit
+}
+
+// SetMaxActiveTasks limits how many tasks have their files handed to the
+// workers at once; 0 means unlimited. Further tasks are queued and start in
+// priority order as active tasks finish. Call it before Start.
+func (wp *WorkerPool) SetMaxActiveTasks(limit int) {
+	wp.scheduler.SetLimit(limit)
+}
+
+// QueuePosition returns the 1-based place of a task waiting for an active
+// task slot, or 0 when the task is not queued
+func (wp *WorkerPool) QueuePosition(taskID string) int {
+	return wp.scheduler.Position(taskID)
+}
+
+// SetRetryPolicy makes a file that failed with a transient error go back to
+// the queue until it has been tried maxAttempts times. The n-th retry waits
+// backoff * 2^(n-1), capped at maxRetryBackoff. Call it before Start.
+func (wp *WorkerPool) SetRetryPolicy(maxAttempts int, backoff time.Duration) {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	wp.maxAttempts = maxAttempts
+	wp.retryBackoff = backoff
+}
+
+// Start starts all workers in the pool; calling it on a running pool is a no-op
 func (wp *WorkerPool) Start() {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	if wp.running {
+		return
+	}
+	wp.running = true
+
 	logger.Logger.Info("Starting workers", "count", wp.workers)
 
 	for i := 0; i < wp.workers; i++ {
-		wp.wg.Add(1)
-		go wp.worker(i)
+		wp.spawnWorker()
+	}
+}
+
+// spawnWorker starts one worker goroutine; wp.mu must be held
+func (wp *WorkerPool) spawnWorker() {
+	id := wp.nextID
+	wp.nextID++
+	wp.wg.Add(1)
+	go wp.worker(id)
+}
+
+// Resize changes the number of workers at runtime. Extra workers are started
+// immediately; excess workers exit after finishing their current download.
+func (wp *WorkerPool) Resize(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("worker count must be positive: %d", n)
+	}
+
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+
+	if wp.ctx.Err() != nil {
+		return ErrPoolStopped
+	}
+
+	logger.Logger.Info("Resizing worker pool", "from", wp.workers, "to", n)
+
+	if wp.running {
+		for i := wp.workers; i < n; i++ {
+			wp.spawnWorker()
+		}
+		if n < wp.workers {
+			wp.queue.Retire(wp.workers - n)
+		}
 	}
+
+	wp.workers = n
+	wp.applyMaxConnections()
+	return nil
+}
+
+// WorkerCount returns the configured number of workers
+func (wp *WorkerPool) WorkerCount() int {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	return wp.workers
+}
+
+// Running reports whether the pool has been started and not stopped
+func (wp *WorkerPool) Running() bool {
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	return wp.running && wp.ctx.Err() == nil
+}
+
+// BusyWorkers returns the number of workers currently processing a file
+func (wp *WorkerPool) BusyWorkers() int {
+	return int(wp.busy.Load())
+}
+
+// StartedAt returns when the pool was created
+func (wp *WorkerPool) StartedAt() time.Time {
+	return wp.startedAt
+}
+
+// DownloadTotals returns the totals over all files the pool completed
+func (wp *WorkerPool) DownloadTotals() DownloadTotals {
+	return wp.totals.snapshot()
+}
+
+// QueueLen returns the number of files waiting for a worker
+func (wp *WorkerPool) QueueLen() int {
+	return wp.queue.Len()
+}
+
+// SetPaused stops or resumes dispatching queued files to the workers.
+// Downloads already running continue, and new files are still queued.
+func (wp *WorkerPool) SetPaused(paused bool) {
+	wp.queue.SetPaused(paused)
+}
+
+// StartDraining marks the pool as shutting down. Queued and running files are
+// not affected; the flag tells the API to stop accepting new work that the
+// pool would never get to.
+func (wp *WorkerPool) StartDraining() {
+	wp.draining.Store(true)
+}
+
+// Draining reports whether StartDraining was called
+func (wp *WorkerPool) Draining() bool {
+	return wp.draining.Load()
+}
+
+// Paused reports whether dispatching is paused
+func (wp *WorkerPool) Paused() bool {
+	return wp.queue.Paused()
 }
 
 // Stop stops all workers in the pool
@@ -65,11 +317,14 @@ func (wp *WorkerPool) Stop() {
 	logger.Logger.Info("Stopping workers")
 	wp.cancel()
 
-	wp.once.Do(func() {
-		close(wp.taskChan)
-	})
+	wp.mu.Lock()
+	wp.running = false
+	wp.mu.Unlock()
+
+	wp.queue.Close()
 
 	wp.wg.Wait()
+	wp.notifier.Wait()
 	logger.Logger.Info("All workers stopped")
 }
 
@@ -77,124 +332,919 @@ func (wp *WorkerPool) Stop() {
 func (wp *WorkerPool) worker(id int) {
 	defer wp.wg.Done()
 
+	wp.alive.Add(1)
+	defer wp.alive.Add(-1)
+
 	logger.Logger.Debug("Worker started", "worker_id", id)
 
 	for {
-		select {
-		case task, ok := <-wp.taskChan:
-			if !ok {
-				logger.Logger.Debug("Worker channel closed", "worker_id", id)
+		task, result := wp.queue.Pop()
+		switch result {
+		case popRetire:
+			logger.Logger.Debug("Worker retired", "worker_id", id)
+			return
+		case popClosed:
+			logger.Logger.Debug("Worker queue closed", "worker_id", id)
+			return
+		}
+
+		wp.runTask(id, task)
+	}
+}
+
+// runTask processes a file within the concurrency cap of its task. A file
+// over the cap is parked and requeued when another file of the task finishes.
+func (wp *WorkerPool) runTask(workerID int, task DownloadTask) {
+	if !wp.taskSlots.TryAcquire(task, wp.taskConcurrency(task.TaskID)) {
+		logger.Logger.Debug("Task at its concurrency cap, parking file",
+			"worker_id", workerID, "task_id", task.TaskID, "file_index", task.FileIndex)
+		return
+	}
+	defer func() {
+		if next, ok := wp.taskSlots.Release(task.TaskID); ok {
+			wp.AddTask(next)
+		}
+	}()
+	wp.safeProcessTask(workerID, task)
+}
+
+// taskConcurrency returns how many files of a task may download at once; 0 means unlimited
+func (wp *WorkerPool) taskConcurrency(taskID string) int {
+	if wp.tm != nil {
+		if task, ok := wp.tm.GetTask(taskID); ok && task.MaxConcurrency > 0 {
+			return task.MaxConcurrency
+		}
+	}
+	return wp.maxPerTask
+}
+
+// safeProcessTask runs processTask and recovers from panics so the worker stays alive
+func (wp *WorkerPool) safeProcessTask(workerID int, task DownloadTask) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Logger.Error("Worker recovered from panic",
+				"worker_id", workerID,
+				"task_id", task.TaskID,
+				"panic", fmt.Sprint(r),
+				"stack", string(debug.Stack()))
+
+			wp.updateFile(task.TaskID, task.FileIndex, func(file *domain.File) {
+				failFile(file, ErrorCodePanic, fmt.Sprintf("internal error: %v", r))
+			})
+		}
+	}()
+
+	wp.busy.Add(1)
+	defer wp.busy.Add(-1)
+	ref := fileRef{taskID: task.TaskID, index: task.FileIndex}
+	wp.activeMu.Lock()
+	wp.active[ref] = struct{}{}
+	wp.activeMu.Unlock()
+	defer func() {
+		wp.activeMu.Lock()
+		delete(wp.active, ref)
+		wp.activeMu.Unlock()
+	}()
+	wp.processTask(workerID, task)
+}
+
+// inFlight reports whether a worker is processing the file right now
+func (wp *WorkerPool) inFlight(taskID string, index int) bool {
+	wp.activeMu.Lock()
+	defer wp.activeMu.Unlock()
+	_, ok := wp.active[fileRef{taskID: taskID, index: index}]
+	return ok
+}
+
+// CancelFile cancels a single file of a task and interrupts its download
+// if a worker is processing it. The task progress and status are recomputed
+// without the file, so the task completes once the remaining files do.
+func (wp *WorkerPool) CancelFile(taskID string, index int) (*domain.Task, error) {
+	if wp.tm == nil {
+		return nil, ErrTaskNotFound
+	}
+
+	interrupted := false
+	task, err := wp.modifyProgress(taskID, func(task *domain.Task) error {
+		if err := cancelFile(task, index); err != nil {
+			return err
+		}
+		// interrupt before the update can finish the task and release its context
+		interrupted = wp.interruptFile(fileRef{taskID: taskID, index: index})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !interrupted {
+		// an interrupted worker removes the partial download itself
+		os.Remove(wp.downloader.PartPath(taskID, index))
+	}
+	wp.taskLogger(taskID).Info("File cancelled", "file_index", index, "interrupted", interrupted)
+	return task, nil
+}
+
+// interruptFile cancels the download of a file a worker is processing and
+// reports whether there was one
+func (wp *WorkerPool) interruptFile(ref fileRef) bool {
+	wp.activeMu.Lock()
+	defer wp.activeMu.Unlock()
+	cancel, ok := wp.cancels[ref]
+	if ok {
+		cancel(ErrFileCancelled)
+	}
+	return ok
+}
+
+// processTask processes a single download task on the worker with the given ID
+func (wp *WorkerPool) processTask(workerID int, task DownloadTask) {
+	log := wp.taskLogger(task.TaskID).With("worker_id", workerID)
+	if wp.tm == nil {
+		return
+	}
+	snapshot, ok := wp.tm.GetTask(task.TaskID)
+	if !ok {
+		log.Warn("Task not found, skipping file", "file_index", task.FileIndex)
+		return
+	}
+	file := snapshot.Files[task.FileIndex]
+	if file.Status != domain.StatusPending {
+		log.Debug("Skipping file", "url", file.URL, "status", file.Status)
+		return
+	}
+
+	if wp.ctx.Err() != nil {
+		// the pool is shutting down; the file stays pending for recovery
+		return
+	}
+
+	ctx := wp.taskContext(task.TaskID)
+	if ctx.Err() != nil {
+		log.Warn("Task deadline exceeded, skipping file", "url", file.URL)
+		wp.updateFile(task.TaskID, task.FileIndex, func(f *domain.File) {
+			failFile(f, ErrorCodeTimeout, "task deadline exceeded")
+		})
+		return
+	}
+
+	// the download can be interrupted from the moment it may be claimed, so
+	// that cancelling the file right after the claim still stops it
+	ref := fileRef{taskID: task.TaskID, index: task.FileIndex}
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	wp.activeMu.Lock()
+	wp.cancels[ref] = cancel
+	wp.activeMu.Unlock()
+	defer func() {
+		wp.activeMu.Lock()
+		delete(wp.cancels, ref)
+		wp.activeMu.Unlock()
+	}()
+
+	// claim the file under the manager lock so a concurrent pause cannot be overwritten
+	claimed := false
+	wp.updateFile(task.TaskID, task.FileIndex, func(f *domain.File) {
+		if f.Status == domain.StatusPending {
+			f.Status = domain.StatusDownloading
+			f.Attempts++
+			f.Speed = 0
+			claimed = true
+		}
+	})
+	if !claimed {
+		log.Debug("File no longer pending, skipping", "url", file.URL)
+		return
+	}
+
+	if snapshot.DryRun {
+		wp.validateFile(ctx, log, task, file)
+		return
+	}
+
+	log.Debug("Processing file", "url", file.URL, "mirrors", len(file.Mirrors))
+	started := time.Now()
+
+	// the name always comes from the primary URL so that the saved file does
+	// not depend on which mirror happened to serve it
+	filename := wp.downloader.ExtractFilename(file.URL)
+	opts := wp.downloadOptions(task.TaskID)
+	opts.Logger = log
+	opts.PartFile = wp.downloader.PartPath(task.TaskID, task.FileIndex)
+	opts.Offset = file.Downloaded
+	opts.PartETag = file.PartETag
+	opts.Pieces = file.Pieces
+	opts.PartStarted = func(etag string) {
+		wp.updateFile(task.TaskID, task.FileIndex, func(f *domain.File) {
+			f.PartETag = etag
+		})
+	}
+	opts.Progress = func(downloaded, speed int64) {
+		wp.updateFile(task.TaskID, task.FileIndex, func(f *domain.File) {
+			if f.Status == domain.StatusDownloading {
+				f.Downloaded = downloaded
+				f.Speed = speed
+			}
+		})
+	}
+
+	sources := append([]string{file.URL}, file.Mirrors...)
+	var (
+		result downloadResult
+		size   int64
+		source string
+		err    error
+	)
+	for _, source = range sources {
+		opts.Credentials = sourceCredentials(file, source)
+		result, size, err = wp.downloadFrom(ctx, log, task, source, filename, opts, ExpectedFile{SHA256: file.SHA256, Size: file.ExpectedSize})
+		if err == nil {
+			err = wp.verifyDownload(task.TaskID, file, result.Filename)
+			if errors.Is(err, ErrChecksumMismatch) {
+				// the saved file is gone, the next source starts from scratch
+				opts.Offset = 0
+			}
+		}
+		if err == nil {
+			break
+		}
+		log.Error("Download failed", "url", redactURL(source), "error", err)
+		if ctx.Err() != nil {
+			// the task deadline applies to all mirrors
+			break
+		}
+	}
+	if err != nil && errors.Is(context.Cause(ctx), ErrFileCancelled) {
+		log.Info("Download cancelled", "url", file.URL)
+		os.Remove(opts.PartFile)
+		return
+	}
+	if err != nil && wp.ctx.Err() != nil {
+		log.Info("Download interrupted by shutdown", "url", file.URL)
+		wp.unclaimFile(task)
+		return
+	}
+	if err != nil {
+		if len(sources) > 1 {
+			err = fmt.Errorf("all %d sources failed, last error: %w", len(sources), err)
+		}
+		wp.failOrRetry(log, task, err, ctx.Err() == nil && retryable(err))
+		return
+	}
+
+	// the probed size may be unknown or, when decompressing, the compressed
+	// length; report what actually landed on disk
+	savedName := result.Filename
+	digest := result.SHA256
+	if digest == "" {
+		// a verified file already has its digest and is not hashed again
+		digest = wp.downloader.dedup(log, task.TaskID, savedName, file.SHA256)
+	}
+	if info, err := os.Stat(filepath.Join(wp.downloader.TaskDir(task.TaskID), savedName)); err == nil {
+		size = info.Size()
+	}
+
+	var extracted domain.File
+	if snapshot.Extract || wp.extract.Enabled {
+		wp.extractDownload(log, task.TaskID, savedName, &extracted)
+	}
+
+	completedAt := time.Now()
+	cancelled := false
+	wp.updateFile(task.TaskID, task.FileIndex, func(f *domain.File) {
+		if f.Status == domain.StatusCancelled {
+			// cancelled after the transfer had already finished
+			cancelled = true
+			return
+		}
+		f.Status = domain.StatusCompleted
+		f.ExtractedTo = extracted.ExtractedTo
+		f.ExtractedFiles = extracted.ExtractedFiles
+		f.ExtractError = extracted.ExtractError
+		f.Size = size
+		f.Downloaded = size
+		f.Speed = 0
+		f.Filename = savedName
+		f.SourceURL = source
+		f.FinalURL = result.FinalURL
+		f.ContentType = result.ContentType
+		f.ETag = result.ETag
+		f.LastModified = result.LastModified
+		f.PartETag = ""
+		if f.SHA256 == "" {
+			f.SHA256 = digest
+		}
+		f.CompletedAt = &completedAt
+	})
+	if cancelled {
+		log.Info("Download cancelled", "url", redactURL(source))
+		return
+	}
+	wp.totals.record(size, completedAt.Sub(started))
+	wp.recent.add(completedAt.Sub(started))
+
+	if result.FinalURL != "" && result.FinalURL != source {
+		log.Info("Download was redirected", "url", redactURL(source), "final_url", result.FinalURL, "content_type", result.ContentType)
+	}
+	// the transfer alone is timed, so that throughput can be aggregated from
+	// the logs; files reused without a transfer report 0 bytes
+	log.Info("Download completed", "url", redactURL(source), "size", size, "filename", filename,
+		"bytes", result.Bytes,
+		"duration_ms", result.Duration.Milliseconds(),
+		"throughput_mbps", throughputMBps(result.Bytes, result.Duration),
+		"status_code", result.StatusCode,
+		"final_url", result.FinalURL)
+}
+
+// verifyDownload checks a saved file against the size and SHA-256 expected
+// for it and removes it when it does not match
+func (wp *WorkerPool) verifyDownload(taskID string, file domain.File, savedName string) error {
+	path := filepath.Join(wp.downloader.TaskDir(taskID), savedName)
+	err := verifyFile(path, ExpectedFile{SHA256: file.SHA256, Size: file.ExpectedSize})
+	if errors.Is(err, ErrChecksumMismatch) {
+		os.Remove(path)
+	}
+	return err
+}
+
+// validateFile resolves the size and name of a file for a dry run without
+// downloading it. Mirrors are tried like in a real download.
+func (wp *WorkerPool) validateFile(ctx context.Context, log *slog.Logger, task DownloadTask, file domain.File) {
+	opts := wp.downloadOptions(task.TaskID)
+	opts.Logger = log
+	filename := wp.downloader.ExtractFilename(file.URL)
+
+	sources := append([]string{file.URL}, file.Mirrors...)
+	var err error
+	for _, source := range sources {
+		var (
+			size    int64
+			name    string
+			release func()
+		)
+		opts.Credentials = sourceCredentials(file, source)
+		if release, err = wp.acquireHost(ctx, source); err == nil {
+			size, name, err = wp.downloader.Probe(ctx, source, filename, opts)
+			release()
+		}
+		if err != nil {
+			log.Warn("Validation failed", "url", redactURL(source), "error", err)
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		validatedAt := time.Now()
+		wp.updateFile(task.TaskID, task.FileIndex, func(f *domain.File) {
+			if f.Status == domain.StatusCancelled {
 				return
 			}
+			f.Status = domain.StatusValidated
+			f.Size = size
+			f.Filename = name
+			f.SourceURL = source
+			f.CompletedAt = &validatedAt
+		})
+		log.Info("File validated", "url", redactURL(source), "size", size, "filename", name)
+		return
+	}
 
-			wp.processTask(task)
+	if wp.ctx.Err() != nil {
+		wp.unclaimFile(task)
+		return
+	}
+	if len(sources) > 1 {
+		err = fmt.Errorf("all %d sources failed, last error: %w", len(sources), err)
+	}
+	wp.updateFile(task.TaskID, task.FileIndex, func(f *domain.File) {
+		if f.Status != domain.StatusCancelled {
+			failFile(f, errorCode(err), err.Error())
+		}
+	})
+}
 
-		case <-wp.ctx.Done():
-			logger.Logger.Debug("Worker context cancelled", "worker_id", id)
+// unclaimFile returns a file interrupted by shutdown to pending instead of
+// failing it, so that recovery processes it again after a restart
+func (wp *WorkerPool) unclaimFile(task DownloadTask) {
+	wp.updateFile(task.TaskID, task.FileIndex, func(f *domain.File) {
+		if f.Status == domain.StatusCancelled {
 			return
 		}
+		f.Status = domain.StatusPending
+		f.Downloaded = 0
+		f.Speed = 0
+		if f.Attempts > 0 {
+			// an interrupted attempt does not count
+			f.Attempts--
+		}
+	})
+}
+
+// maxRetryBackoff caps the exponential delay between attempts
+const maxRetryBackoff = 5 * time.Minute
+
+// failOrRetry records a failed attempt. While attempts remain and the error
+// is transient, the file is marked retrying and requeued after a backoff;
+// otherwise it fails for good.
+func (wp *WorkerPool) failOrRetry(log *slog.Logger, task DownloadTask, err error, transient bool) {
+	retry := false
+	attempts := 0
+	wp.updateFile(task.TaskID, task.FileIndex, func(f *domain.File) {
+		if f.Status == domain.StatusCancelled {
+			return
+		}
+		failFile(f, errorCode(err), err.Error())
+		attempts = f.Attempts
+		if transient && f.Attempts < wp.maxAttempts {
+			f.Status = domain.StatusRetrying
+			f.Downloaded = 0
+			retry = true
+		}
+	})
+	if !retry {
+		// a piece-verified download keeps its good pieces for a retry only
+		os.Remove(wp.downloader.PartPath(task.TaskID, task.FileIndex))
+		return
+	}
+
+	delay := wp.retryBackoff
+	for i := 1; i < attempts && delay < maxRetryBackoff; i++ {
+		delay *= 2
 	}
+	delay = min(delay, maxRetryBackoff)
+	log.Warn("Download failed, retrying",
+		"file_index", task.FileIndex, "attempt", attempts, "max_attempts", wp.maxAttempts, "backoff", delay)
+	time.AfterFunc(delay, func() { wp.requeueRetry(task) })
 }
 
-// processTask processes a single download task
-func (wp *WorkerPool) processTask(task DownloadTask) {
-	file := task.File
-	logger.Logger.Debug("Processing file", "url", file.URL, "task_id", task.TaskID)
+// requeueRetry puts a retrying file back in the queue. A file that was paused
+// or changed in the meantime is left alone, and on shutdown the file stays
+// retrying so that recovery picks it up.
+func (wp *WorkerPool) requeueRetry(task DownloadTask) {
+	if wp.ctx.Err() != nil {
+		return
+	}
+	requeue := false
+	wp.updateFile(task.TaskID, task.FileIndex, func(f *domain.File) {
+		if f.Status == domain.StatusRetrying {
+			f.Status = domain.StatusPending
+			requeue = true
+		}
+	})
+	if requeue {
+		wp.AddTask(task)
+	}
+}
 
-	file.Status = domain.StatusDownloading
+// downloadFrom probes the size of a single source of a file and downloads it,
+// returning the download result and the probed size. When an earlier task
+// already downloaded the same source, the request is made conditional and an
+// unchanged file is copied from the local copy instead of being transferred.
+// Under the skip overwrite policy, an existing file matching the probed size
+// and the expected checksum is kept instead. With dedup enabled, a file whose
+// expected checksum is already in the blob store is linked to it without any
+// request.
+func (wp *WorkerPool) downloadFrom(ctx context.Context, log *slog.Logger, task DownloadTask, url, filename string, opts DownloadOptions, expected ExpectedFile) (downloadResult, int64, error) {
+	if name, ok := wp.downloader.linkBlob(log, task.TaskID, filename, expected.SHA256, opts); ok {
+		os.Remove(opts.PartFile)
+		return downloadResult{Filename: name, SHA256: expected.SHA256}, 0, nil
+	}
 
-	size, err := wp.downloader.GetFileSize(file.URL)
+	release, err := wp.acquireHost(ctx, url)
 	if err != nil {
-		logger.Logger.Error("Failed to get file size", "url", file.URL, "error", err)
-		file.Status = domain.StatusFailed
-		return
+		return downloadResult{}, 0, err
 	}
-	file.Size = size
+	defer release()
 
-	filename := wp.downloader.ExtractFilename(file.URL)
-	savedName, err := wp.downloader.DownloadFile(file.URL, filename)
+	if opts.FollowNext {
+		// the pages are joined from scratch on every attempt, and neither a
+		// probe nor a cached copy of the first page describes the joined file
+		opts.Offset = 0
+		wp.updateFile(task.TaskID, task.FileIndex, func(f *domain.File) {
+			f.Size = 0
+			f.Downloaded = 0
+		})
+		result, err := wp.downloader.fetch(ctx, url, filename, opts)
+		return result, 0, err
+	}
+
+	cachedPath, cached, hasCache := wp.cachedCopy(url, opts)
+	if hasCache {
+		opts.ETag = cached.ETag
+		opts.LastModified = cached.LastModified
+	}
+
+	// the size probe is best-effort: a server that cannot report the size may
+	// still serve the file, so a failed probe only leaves the size unknown
+	size, err := wp.downloader.GetFileSizeWithOptions(ctx, url, opts)
+	if err != nil {
+		log.Warn("Failed to get file size, downloading anyway", "url", url, "error", err)
+		size = 0
+	}
+	if size == 0 {
+		// progress counts the file as a whole until the download completes
+		log.Debug("File size unknown", "url", url)
+	}
+	if opts.Offset > 0 && size > 0 && opts.Offset > size {
+		log.Warn("Partial download larger than remote file, restarting", "url", url, "partial", opts.Offset, "size", size)
+		os.Remove(opts.PartFile)
+		opts.Offset = 0
+	}
+	if wp.downloader.overwritePolicy(opts) == OverwritePolicySkip {
+		path := filepath.Join(wp.downloader.TaskDir(task.TaskID), filename)
+		if sameFile(path, size, expected) {
+			log.Info("Existing file matches, skipping download", "url", url, "path", path)
+			os.Remove(opts.PartFile)
+			return downloadResult{Filename: filename}, size, nil
+		}
+	}
+	wp.updateFile(task.TaskID, task.FileIndex, func(f *domain.File) {
+		f.Size = size
+		f.Downloaded = opts.Offset
+	})
+
+	result, err := wp.downloader.fetch(ctx, url, filename, opts)
+	if hasCache && errors.Is(err, ErrNotModified) {
+		log.Info("File not modified, reusing local copy", "url", url, "path", cachedPath)
+		savedName, err := wp.reuseLocalCopy(cachedPath, task.TaskID)
+		if err != nil {
+			return downloadResult{}, 0, fmt.Errorf("failed to reuse local copy of %s: %w", url, err)
+		}
+		wp.downloader.applyLastModified(filepath.Join(wp.downloader.TaskDir(task.TaskID), savedName), cached.LastModified)
+		return downloadResult{
+			Filename:     savedName,
+			ETag:         cached.ETag,
+			LastModified: cached.LastModified,
+			FinalURL:     cached.FinalURL,
+			ContentType:  cached.ContentType,
+			StatusCode:   http.StatusNotModified,
+		}, size, nil
+	}
 	if err != nil {
-		logger.Logger.Error("Download failed", "url", file.URL, "error", err)
-		file.Status = domain.StatusFailed
+		return downloadResult{}, 0, err
+	}
+	return result, size, nil
+}
+
+// acquireHost waits for a download slot for the host of url and returns the
+// function releasing it
+func (wp *WorkerPool) acquireHost(ctx context.Context, url string) (func(), error) {
+	host := hostOf(url)
+	if err := wp.hosts.Acquire(ctx, host); err != nil {
+		return nil, fmt.Errorf("waiting for a download slot for %s: %w", host, err)
+	}
+	return func() { wp.hosts.Release(host) }, nil
+}
+
+// cachedCopy looks up a completed download of url that still exists on disk.
+// Downloads with a custom request are never matched, since their content may
+// depend on more than the URL, and neither are transformed downloads.
+func (wp *WorkerPool) cachedCopy(url string, opts DownloadOptions) (string, domain.File, bool) {
+	if wp.tm == nil || !wp.downloader.conditionalRequests || opts.customRequest() || opts.transformed() {
+		return "", domain.File{}, false
+	}
+	taskID, file, ok := wp.tm.FindCompletedFile(url, opts.Decompress)
+	if !ok {
+		return "", domain.File{}, false
+	}
+	path := filepath.Join(wp.downloader.TaskDir(taskID), file.Filename)
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return "", domain.File{}, false
+	}
+	return path, file, true
+}
+
+// reuseLocalCopy makes the local copy at path available in the directory of
+// the task and returns its name there. Under the flat layout the copy is
+// usually already in place; otherwise it is copied.
+func (wp *WorkerPool) reuseLocalCopy(path, taskID string) (string, error) {
+	dir := wp.downloader.TaskDir(taskID)
+	if filepath.Clean(filepath.Dir(path)) == filepath.Clean(dir) {
+		return filepath.Base(path), nil
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	dst, name, err := createUniqueFile(dir, filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return name, dst.Close()
+}
+
+// downloadOptions returns the per-task download settings
+func (wp *WorkerPool) downloadOptions(taskID string) DownloadOptions {
+	if wp.tm == nil {
+		return DownloadOptions{}
+	}
+	task, ok := wp.tm.GetTask(taskID)
+	if !ok {
+		return DownloadOptions{}
+	}
+	opts := DownloadOptions{
+		Decompress:      task.Decompress,
+		Transform:       task.Transform,
+		TaskID:          taskID,
+		UserAgent:       task.UserAgent,
+		FollowNext:      task.FollowNext,
+		OverwritePolicy: task.OverwritePolicy,
+	}
+	if request := task.Request; request != nil {
+		opts.Method = request.Method
+		opts.ProbeMethod = request.ProbeMethod
+		opts.Body = request.Body
+		opts.Headers = request.Headers
+	}
+	return opts
+}
+
+// taskContext returns the context bounding the downloads of a task, creating it on first use.
+// When the task has a timeout, the context expires at the deadline and the
+// remaining files of the task are marked failed.
+func (wp *WorkerPool) taskContext(taskID string) context.Context {
+	wp.taskCtxMu.Lock()
+	defer wp.taskCtxMu.Unlock()
+
+	if tc, ok := wp.taskCtxs[taskID]; ok {
+		return tc.ctx
+	}
+
+	timeout := wp.taskTimeout
+	if wp.tm != nil {
+		if task, ok := wp.tm.GetTask(taskID); ok && task.TimeoutSeconds > 0 {
+			timeout = time.Duration(task.TimeoutSeconds) * time.Second
+		}
+	}
+
+	tc := &taskContext{}
+	if timeout > 0 {
+		tc.ctx, tc.cancel = context.WithTimeout(wp.ctx, timeout)
+		context.AfterFunc(tc.ctx, func() {
+			if errors.Is(tc.ctx.Err(), context.DeadlineExceeded) {
+				wp.expireTask(taskID)
+			}
+		})
+	} else {
+		tc.ctx, tc.cancel = context.WithCancel(wp.ctx)
+	}
+
+	wp.taskCtxs[taskID] = tc
+	return tc.ctx
+}
+
+// releaseTaskContext cancels and forgets the context of a finished task
+func (wp *WorkerPool) releaseTaskContext(taskID string) {
+	wp.taskCtxMu.Lock()
+	defer wp.taskCtxMu.Unlock()
+
+	if tc, ok := wp.taskCtxs[taskID]; ok {
+		tc.cancel()
+		delete(wp.taskCtxs, taskID)
+	}
+}
+
+// expireTask marks all files of a task that have not started yet as failed after its deadline.
+// Files that are downloading are interrupted through the cancelled context.
+func (wp *WorkerPool) expireTask(taskID string) {
+	if wp.tm == nil {
 		return
 	}
 
-	file.Status = domain.StatusCompleted
-	file.Downloaded = file.Size
-	file.Filename = savedName
+	wp.taskLogger(taskID).Warn("Task deadline exceeded")
 
-	logger.Logger.Info("Download completed", "url", file.URL, "size", file.Size, "filename", filename)
+	wp.modifyProgress(taskID, func(task *domain.Task) error {
+		for i := range task.Files {
+			switch task.Files[i].Status {
+			case domain.StatusPending, domain.StatusPaused, domain.StatusRetrying:
+				failFile(&task.Files[i], ErrorCodeTimeout, "task deadline exceeded")
+			}
+		}
+		return nil
+	})
+}
 
-	wp.updateTaskProgress(task.TaskID)
+// failFile marks a file failed and records the reason
+func failFile(file *domain.File, code, reason string) {
+	file.Status = domain.StatusFailed
+	file.ErrorCode = code
+	file.Error = reason
+	file.Speed = 0
 }
 
-// AddTask adds a task to the queue
+// taskLogger returns a logger annotated with the task and originating request IDs
+func (wp *WorkerPool) taskLogger(taskID string) *slog.Logger {
+	log := logger.Logger.With("task_id", taskID)
+	if wp.tm == nil {
+		return log
+	}
+	if task, ok := wp.tm.GetTask(taskID); ok && task.RequestID != "" {
+		log = log.With("request_id", task.RequestID)
+	}
+	return log
+}
+
+// AddTask adds a task to the queue. The queue is unbounded, so tasks are
+// never dropped; they are only rejected once the pool has been stopped.
 func (wp *WorkerPool) AddTask(task DownloadTask) {
-	select {
-	case wp.taskChan <- task:
-		logger.Logger.Debug("Task added to queue", "url", task.File.URL, "task_id", task.TaskID)
-	case <-wp.ctx.Done():
+	if !wp.queue.Push(task) {
 		logger.Logger.Warn("Worker pool stopped, cannot add task")
-	default:
-		logger.Logger.Warn("Task queue full, dropping task")
+		return
 	}
+	logger.Logger.Debug("Task added to queue", "task_id", task.TaskID, "file_index", task.FileIndex, "priority", task.Priority)
 }
 
 // ProcessFiles processes a list of files
 func (wp *WorkerPool) ProcessFiles(taskID string, files []domain.File) {
 	logger.Logger.Info("Processing files", "task_id", taskID, "files_count", len(files))
 
+	if wp.tm == nil {
+		return
+	}
+	task, ok := wp.tm.GetTask(taskID)
+	if !ok {
+		logger.Logger.Warn("Task not found, cannot process files", "task_id", taskID)
+		return
+	}
+	if len(files) > len(task.Files) {
+		logger.Logger.Warn("More files than the task has, ignoring the rest",
+			"task_id", taskID, "files_count", len(files), "task_files_count", len(task.Files))
+		files = files[:len(task.Files)]
+	}
+
+	if !wp.admitTask(task) {
+		return
+	}
 	for i := range files {
 		downloadTask := DownloadTask{
-			File:   &files[i],
-			TaskID: taskID,
+			TaskID:    taskID,
+			FileIndex: i,
+			Priority:  task.Priority,
 		}
 		wp.AddTask(downloadTask)
 	}
 }
 
-// updateTaskProgress updates the progress of a task based on file completion status
-func (wp *WorkerPool) updateTaskProgress(taskID string) {
+// admitTask reports whether the files of a task may be enqueued. A task over
+// the active task limit is marked queued instead and started by
+// releaseTaskSlot once a slot frees up.
+func (wp *WorkerPool) admitTask(task *domain.Task) bool {
+	if wp.scheduler.TryAdmit(task.ID, task.Priority) {
+		return true
+	}
 	if wp.tm == nil {
-		return
+		return false
 	}
-	task, ok := wp.tm.GetTask(taskID)
-	if !ok {
+	_, err := wp.tm.ModifyTask(task.ID, func(task *domain.Task) error {
+		// a slot may have freed up since; releaseTaskSlot then sets the status
+		if task.Status != domain.StatusPaused && wp.scheduler.Position(task.ID) > 0 {
+			task.Status = domain.StatusQueued
+		}
+		return nil
+	})
+	if err != nil {
+		wp.taskLogger(task.ID).Warn("Failed to queue task", "error", err)
+	}
+	wp.taskLogger(task.ID).Info("Active task limit reached, task queued", "position", wp.scheduler.Position(task.ID))
+	return false
+}
+
+// releaseTaskSlot frees the active task slot of a finished task and starts
+// the queued tasks admitted in its place
+func (wp *WorkerPool) releaseTaskSlot(taskID string) {
+	admitted := wp.scheduler.Release(taskID)
+	for len(admitted) > 0 {
+		next := admitted[0]
+		admitted = admitted[1:]
+
+		snapshot, err := wp.modifyProgress(next, func(task *domain.Task) error {
+			if task.Status == domain.StatusQueued {
+				task.Status = domain.StatusPending
+			}
+			return nil
+		})
+		if err != nil || snapshot.Status == domain.StatusPaused {
+			// the task is gone or was paused while queued; hand its slot on,
+			// a resumed task queues up again
+			admitted = append(admitted, wp.scheduler.Release(next)...)
+			continue
+		}
+		wp.taskLogger(next).Info("Task slot free, starting queued task")
+		wp.enqueuePending(snapshot)
+	}
+}
+
+// updateFile applies fn to a file of a task and recomputes the task progress
+// in a single atomic update of the TaskManager
+func (wp *WorkerPool) updateFile(taskID string, index int, fn func(file *domain.File)) {
+	if wp.tm == nil {
 		return
 	}
 
-	var totalSize int64
-	var downloaded int64
+	_, err := wp.modifyProgress(taskID, func(task *domain.Task) error {
+		if index < 0 || index >= len(task.Files) {
+			return fmt.Errorf("file index %d out of range", index)
+		}
+		fn(&task.Files[index])
+		return nil
+	})
+	if err != nil {
+		wp.taskLogger(taskID).Warn("Failed to update file", "file_index", index, "error", err)
+	}
+}
+
+// modifyProgress applies fn to a task and recomputes its progress atomically.
+// A failed file of a fail-fast task cancels its other files in the same
+// update. When the update moves the task into a terminal state, the task
+// context is released and the completion callback is sent.
+func (wp *WorkerPool) modifyProgress(taskID string, fn func(task *domain.Task) error) (*domain.Task, error) {
+	wasFinished := false
+	allTerminal := false
+	failed, cancelled := -1, []int(nil)
+	snapshot, err := wp.tm.ModifyTask(taskID, func(task *domain.Task) error {
+		wasFinished = task.Status == domain.StatusCompleted || task.Status == domain.StatusFailed
+		if err := fn(task); err != nil {
+			return err
+		}
+		failed, cancelled = wp.failFast(task)
+		allTerminal = refreshTaskStatus(task)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if failed >= 0 {
+		wp.taskLogger(taskID).Warn("File failed, cancelled the remaining files of the fail-fast task",
+			"file_index", failed, "cancelled", len(cancelled))
+		for _, index := range cancelled {
+			os.Remove(wp.downloader.PartPath(taskID, index))
+		}
+	}
+
+	if allTerminal {
+		wp.releaseTaskContext(taskID)
+		if !wasFinished {
+			wp.notifier.Notify(snapshot)
+			wp.waiters.publish(snapshot)
+		}
+		wp.releaseTaskSlot(taskID)
+	}
+	return snapshot, nil
+}
+
+// refreshTaskStatus recomputes task progress and status from its files.
+// It reports whether every file has reached a terminal state.
+func refreshTaskStatus(task *domain.Task) bool {
 	allCompleted := true
+	allTerminal := true
 	anyInProgress := false
+	succeeded := 0
 	for i := range task.Files {
-		totalSize += task.Files[i].Size
-		downloaded += task.Files[i].Downloaded
-		if task.Files[i].Status != domain.StatusCompleted {
+		switch status := task.Files[i].Status; {
+		case fileSucceeded(status):
+			succeeded++
+		case status != domain.StatusCancelled:
+			// cancelled files do not hold the task back
 			allCompleted = false
 		}
-		if task.Files[i].Status == domain.StatusDownloading {
+		if !fileTerminal(task.Files[i].Status) {
+			allTerminal = false
+		}
+		if task.Files[i].Status == domain.StatusDownloading || task.Files[i].Status == domain.StatusRetrying {
 			anyInProgress = true
 		}
 	}
 
-	if totalSize > 0 {
-		task.Progress = int(float64(downloaded) / float64(totalSize) * 100)
-	} else {
-		completed := 0
-		for i := range task.Files {
-			if task.Files[i].Status == domain.StatusCompleted {
-				completed++
-			}
-		}
-		if len(task.Files) > 0 {
-			task.Progress = int(float64(completed) / float64(len(task.Files)) * 100)
-		}
+	if len(task.Files) > 0 && succeeded == 0 {
+		// a task whose files were all cancelled did not complete
+		allCompleted = false
 	}
+	task.Progress = computeProgress(task.Files, allCompleted)
 
 	switch {
 	case allCompleted:
 		task.Status = domain.StatusCompleted
+		if task.CompletedAt == nil {
+			completedAt := time.Now()
+			task.CompletedAt = &completedAt
+		}
+	case allTerminal:
+		task.Status = domain.StatusFailed
+	case task.Status == domain.StatusPaused:
+		// keep paused until the task is explicitly resumed
+	case task.Status == domain.StatusQueued:
+		// keep queued until the task gets an active task slot
 	case anyInProgress:
 		task.Status = domain.StatusDownloading
 	default:
@@ -205,5 +1255,71 @@ func (wp *WorkerPool) updateTaskProgress(taskID string) {
 		}
 	}
 
-	_ = wp.tm.UpdateTask(task)
+	if task.Status != domain.StatusCompleted {
+		task.CompletedAt = nil
+	}
+
+	return allTerminal
+}
+
+// computeProgress returns the task progress in percent. When every file has a
+// known size, progress is weighted by bytes; otherwise each file counts
+// equally, a file of unknown size counting as done only once it succeeds.
+// Cancelled files are left out. The result is always within 0-100 and
+// reaches 100 only when allCompleted.
+func computeProgress(files []domain.File, allCompleted bool) int {
+	if len(files) == 0 || allCompleted {
+		return 100
+	}
+
+	allSized := true
+	counted := 0
+	var totalSize, downloaded int64
+	var fractions float64
+	for _, f := range files {
+		if f.Status == domain.StatusCancelled {
+			continue
+		}
+		counted++
+		size := max(f.Size, 0)
+		done := min(max(f.Downloaded, 0), size)
+		if fileSucceeded(f.Status) {
+			// a dry run transfers nothing but the file is done
+			done = size
+		}
+
+		switch {
+		case fileSucceeded(f.Status):
+			fractions++
+		case size > 0:
+			fractions += float64(done) / float64(size)
+		}
+		if size == 0 {
+			allSized = false
+		}
+		totalSize += size
+		downloaded += done
+	}
+
+	var progress int
+	switch {
+	case counted == 0:
+		progress = 0
+	case allSized:
+		progress = int(float64(downloaded) / float64(totalSize) * 100)
+	default:
+		progress = int(fractions / float64(counted) * 100)
+	}
+	return min(max(progress, 0), 99)
+}
+
+// fileSucceeded reports whether a file needs no more work: it was downloaded,
+// or validated by a dry run
+func fileSucceeded(status domain.Status) bool {
+	return status == domain.StatusCompleted || status == domain.StatusValidated
+}
+
+// fileTerminal reports whether a file has reached a final status
+func fileTerminal(status domain.Status) bool {
+	return fileSucceeded(status) || status == domain.StatusFailed || status == domain.StatusCancelled
 }
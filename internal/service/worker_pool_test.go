@@ -1,11 +1,25 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"filedownloader-20240926/internal/domain"
+	"filedownloader-20240926/internal/repository"
+	"filedownloader-20240926/pkg/logger"
 )
 
 // TestWorkerPoolCreation tests worker pool creation with different configurations
@@ -30,7 +44,7 @@ func TestWorkerPoolCreation(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tm := NewTaskManager()
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
 			wp := NewWorkerPool(tt.workers, tm)
 
 			if wp == nil {
@@ -46,8 +60,8 @@ func TestWorkerPoolCreation(t *testing.T) {
 				t.Errorf("expected downloader but got nil")
 			}
 
-			if wp.taskChan == nil {
-				t.Errorf("expected task channel but got nil")
+			if wp.queue == nil {
+				t.Errorf("expected task queue but got nil")
 			}
 		})
 	}
@@ -71,7 +85,7 @@ func TestWorkerPoolStartStop(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tm := NewTaskManager()
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
 			wp := NewWorkerPool(tt.workers, tm)
 
 			wp.Start()
@@ -119,7 +133,7 @@ func TestWorkerPoolAddTask(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tm := NewTaskManager()
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
 			wp := NewWorkerPool(2, tm)
 			wp.Start()
 			defer wp.Stop()
@@ -164,7 +178,7 @@ func TestWorkerPoolWithContext(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tm := NewTaskManager()
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
 
@@ -208,7 +222,7 @@ func TestWorkerPoolProcessFiles(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tm := NewTaskManager()
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
 			wp := NewWorkerPool(2, tm)
 			wp.Start()
 			defer wp.Stop()
@@ -234,3 +248,1458 @@ func TestWorkerPoolProcessFiles(t *testing.T) {
 		})
 	}
 }
+
+// TestWorkerPoolRecoversFromPanic tests that a panicking task does not kill the worker
+func TestWorkerPoolRecoversFromPanic(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		panics  int
+	}{
+		{
+			name:    "single worker survives panic",
+			workers: 1,
+			panics:  1,
+		},
+		{
+			name:    "single worker survives repeated panics",
+			workers: 1,
+			panics:  3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "content")
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(tt.workers, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			wp.Start()
+			defer wp.Stop()
+
+			task, err := tm.CreateTask([]string{srv.URL + "/file.txt"})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+
+			// an out-of-range file index makes processTask panic
+			for i := 0; i < tt.panics; i++ {
+				wp.queue.Push(DownloadTask{TaskID: task.ID, FileIndex: len(task.Files)})
+			}
+			wp.ProcessFiles(task.ID, task.Files)
+
+			var status domain.Status
+			deadline := time.Now().Add(2 * time.Second)
+			for time.Now().Before(deadline) {
+				current, _ := tm.GetTask(task.ID)
+				status = current.Files[0].Status
+				if status == domain.StatusCompleted {
+					return
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			t.Errorf("expected file to complete after panic, got status %s", status)
+		})
+	}
+}
+
+// TestWorkerPoolResize tests growing and shrinking the pool at runtime
+func TestWorkerPoolResize(t *testing.T) {
+	tests := []struct {
+		name        string
+		initial     int
+		resizeTo    []int
+		expectError bool
+	}{
+		{
+			name:     "grow",
+			initial:  1,
+			resizeTo: []int{4},
+		},
+		{
+			name:     "shrink",
+			initial:  4,
+			resizeTo: []int{1},
+		},
+		{
+			name:     "grow then shrink",
+			initial:  2,
+			resizeTo: []int{6, 3},
+		},
+		{
+			name:        "invalid size",
+			initial:     2,
+			resizeTo:    []int{0},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(tt.initial, tm)
+			wp.Start()
+
+			for _, n := range tt.resizeTo {
+				err := wp.Resize(n)
+				if tt.expectError {
+					if err == nil {
+						t.Errorf("expected error but got none")
+					}
+					wp.Stop()
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			expected := tt.resizeTo[len(tt.resizeTo)-1]
+			if wp.WorkerCount() != expected {
+				t.Errorf("expected %d workers, got %d", expected, wp.WorkerCount())
+			}
+
+			deadline := time.Now().Add(time.Second)
+			for int(wp.alive.Load()) != expected && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+			}
+			if int(wp.alive.Load()) != expected {
+				t.Errorf("expected %d running workers, got %d", expected, wp.alive.Load())
+			}
+
+			wp.Stop()
+			if wp.alive.Load() != 0 {
+				t.Errorf("expected all workers stopped, got %d", wp.alive.Load())
+			}
+		})
+	}
+}
+
+// TestWorkerPoolTaskTimeout tests that a task reaches a terminal state when its deadline passes
+func TestWorkerPoolTaskTimeout(t *testing.T) {
+	tests := []struct {
+		name           string
+		timeoutSeconds int
+		poolTimeout    time.Duration
+	}{
+		{
+			name:           "per-task timeout",
+			timeoutSeconds: 1,
+		},
+		{
+			name:        "pool default timeout",
+			poolTimeout: 300 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "HEAD" {
+					return
+				}
+				<-r.Context().Done()
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(1, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			wp.SetTaskTimeout(tt.poolTimeout)
+			wp.Start()
+			defer wp.Stop()
+
+			task, err := tm.CreateTaskWithOptions(
+				[]string{srv.URL + "/hung1.bin", srv.URL + "/hung2.bin"},
+				TaskOptions{TimeoutSeconds: tt.timeoutSeconds},
+			)
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+
+			wp.ProcessFiles(task.ID, task.Files)
+
+			deadline := time.Now().Add(3 * time.Second)
+			for time.Now().Before(deadline) {
+				task, _ = tm.GetTask(task.ID)
+				if task.Status == domain.StatusFailed {
+					break
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+
+			if task.Status != domain.StatusFailed {
+				t.Fatalf("expected task status %s, got %s", domain.StatusFailed, task.Status)
+			}
+			for _, f := range task.Files {
+				if f.Status != domain.StatusFailed {
+					t.Errorf("expected file status %s, got %s", domain.StatusFailed, f.Status)
+				}
+			}
+		})
+	}
+}
+
+// TestWorkerPoolRecordsFailureReason tests that failed files carry an error code and message
+func TestWorkerPoolRecordsFailureReason(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		contentType  string
+		allowed      []string
+		expectedCode string
+	}{
+		{
+			name:         "not found",
+			status:       http.StatusNotFound,
+			expectedCode: "http_404",
+		},
+		{
+			name:         "server error",
+			status:       http.StatusInternalServerError,
+			expectedCode: "http_500",
+		},
+		{
+			name:         "content type rejected",
+			status:       http.StatusOK,
+			contentType:  "text/html",
+			allowed:      []string{"image/*"},
+			expectedCode: ErrorCodeContentType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(1, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			wp.downloader.allowedContentTypes = tt.allowed
+
+			task, err := tm.CreateTask([]string{srv.URL + "/file.png"})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+
+			wp.processTask(0, DownloadTask{TaskID: task.ID, FileIndex: 0})
+
+			task, _ = tm.GetTask(task.ID)
+			file := task.Files[0]
+			if file.Status != domain.StatusFailed {
+				t.Fatalf("expected status %s, got %s", domain.StatusFailed, file.Status)
+			}
+			if file.ErrorCode != tt.expectedCode {
+				t.Errorf("expected error code %s, got %s", tt.expectedCode, file.ErrorCode)
+			}
+			if file.Error == "" {
+				t.Errorf("expected error message")
+			}
+			if task.Status != domain.StatusFailed {
+				t.Errorf("expected task status %s, got %s", domain.StatusFailed, task.Status)
+			}
+		})
+	}
+}
+
+// TestWorkerPoolConcurrentReads tests that task snapshots can be read while workers update files
+func TestWorkerPoolConcurrentReads(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		files   int
+	}{
+		{
+			name:    "single worker",
+			workers: 1,
+			files:   3,
+		},
+		{
+			name:    "several workers",
+			workers: 3,
+			files:   6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "content")
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(tt.workers, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			wp.Start()
+			defer wp.Stop()
+
+			var urls []string
+			for i := 0; i < tt.files; i++ {
+				urls = append(urls, fmt.Sprintf("%s/file%d.txt", srv.URL, i))
+			}
+			task, err := tm.CreateTask(urls)
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			wp.ProcessFiles(task.ID, task.Files)
+
+			deadline := time.Now().Add(3 * time.Second)
+			for time.Now().Before(deadline) {
+				task, _ = tm.GetTask(task.ID)
+				for _, f := range task.Files {
+					_ = f.Status
+				}
+				if task.Status == domain.StatusCompleted {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			if task.Status != domain.StatusCompleted {
+				t.Fatalf("expected task status %s, got %s", domain.StatusCompleted, task.Status)
+			}
+			if task.Progress != 100 {
+				t.Errorf("expected progress 100, got %d", task.Progress)
+			}
+		})
+	}
+}
+
+// TestWorkerPoolFilenameCollisions tests that files of a task with the same name do not overwrite each other
+func TestWorkerPoolFilenameCollisions(t *testing.T) {
+	tests := []struct {
+		name          string
+		paths         []string
+		expectedNames []string
+	}{
+		{
+			name:          "two versions of a report",
+			paths:         []string{"/v1/report", "/v2/report"},
+			expectedNames: []string{"report.txt", "report (1).txt"},
+		},
+		{
+			name:          "three files with the same name",
+			paths:         []string{"/a/data.txt", "/b/data.txt", "/c/data.txt"},
+			expectedNames: []string{"data.txt", "data (1).txt", "data (2).txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				io.WriteString(w, r.URL.Path)
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(1, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+
+			var urls []string
+			for _, p := range tt.paths {
+				urls = append(urls, srv.URL+p)
+			}
+			task, err := tm.CreateTask(urls)
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+
+			for i := range task.Files {
+				wp.processTask(0, DownloadTask{TaskID: task.ID, FileIndex: i})
+			}
+
+			task, _ = tm.GetTask(task.ID)
+			for i, f := range task.Files {
+				if f.Filename != tt.expectedNames[i] {
+					t.Errorf("file %d: expected filename %s, got %s", i, tt.expectedNames[i], f.Filename)
+				}
+				content, err := os.ReadFile(filepath.Join(wp.downloader.TaskDir(task.ID), f.Filename))
+				if err != nil {
+					t.Errorf("file %d: %v", i, err)
+					continue
+				}
+				if string(content) != tt.paths[i] {
+					t.Errorf("file %d: expected content %s, got %s", i, tt.paths[i], content)
+				}
+			}
+		})
+	}
+}
+
+// TestWorkerPoolSizeProbeBestEffort tests that a failing size probe does not fail the download
+func TestWorkerPoolSizeProbeBestEffort(t *testing.T) {
+	tests := []struct {
+		name       string
+		headStatus int
+		content    string
+	}{
+		{
+			name:       "HEAD and range rejected",
+			headStatus: http.StatusMethodNotAllowed,
+			content:    "served by GET only",
+		},
+		{
+			name:       "HEAD errors",
+			headStatus: http.StatusInternalServerError,
+			content:    "still downloadable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "HEAD" || r.Header.Get("Range") != "" {
+					w.WriteHeader(tt.headStatus)
+					return
+				}
+				w.Header().Set("Content-Type", "text/plain")
+				io.WriteString(w, tt.content)
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(1, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+
+			task, err := tm.CreateTask([]string{srv.URL + "/file.txt"})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			wp.processTask(0, DownloadTask{TaskID: task.ID, FileIndex: 0})
+
+			task, _ = tm.GetTask(task.ID)
+			file := task.Files[0]
+			if file.Status != domain.StatusCompleted {
+				t.Fatalf("expected status %s, got %s (%s)", domain.StatusCompleted, file.Status, file.Error)
+			}
+			if file.Size != int64(len(tt.content)) {
+				t.Errorf("expected size %d, got %d", len(tt.content), file.Size)
+			}
+			if task.Progress != 100 {
+				t.Errorf("expected progress 100, got %d", task.Progress)
+			}
+		})
+	}
+}
+
+// TestWorkerPoolRecordsFinalURL tests that the URL serving the bytes after redirects and its Content-Type are stored
+func TestWorkerPoolRecordsFinalURL(t *testing.T) {
+	tests := []struct {
+		name                string
+		path                string
+		expectedPath        string
+		expectedContentType string
+	}{
+		{
+			name:                "direct download",
+			path:                "/report.pdf",
+			expectedPath:        "/report.pdf",
+			expectedContentType: "application/pdf",
+		},
+		{
+			name:                "redirect to login page",
+			path:                "/private/report.pdf",
+			expectedPath:        "/login?next=report.pdf",
+			expectedContentType: "text/html; charset=utf-8",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/report.pdf", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/pdf")
+				io.WriteString(w, "%PDF-1.4")
+			})
+			mux.HandleFunc("/private/report.pdf", func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "/login?next=report.pdf", http.StatusFound)
+			})
+			mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				io.WriteString(w, "<html>please log in</html>")
+			})
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(1, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+
+			task, err := tm.CreateTask([]string{srv.URL + tt.path})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			wp.processTask(0, DownloadTask{TaskID: task.ID, FileIndex: 0})
+
+			task, _ = tm.GetTask(task.ID)
+			file := task.Files[0]
+			if file.Status != domain.StatusCompleted {
+				t.Fatalf("expected status %s, got %s (%s)", domain.StatusCompleted, file.Status, file.Error)
+			}
+			if file.FinalURL != srv.URL+tt.expectedPath {
+				t.Errorf("expected final URL %s, got %s", srv.URL+tt.expectedPath, file.FinalURL)
+			}
+			if file.ContentType != tt.expectedContentType {
+				t.Errorf("expected content type %q, got %q", tt.expectedContentType, file.ContentType)
+			}
+		})
+	}
+}
+
+// TestWorkerPoolMirrors tests that mirrors are tried in order until one succeeds
+func TestWorkerPoolMirrors(t *testing.T) {
+	tests := []struct {
+		name           string
+		paths          []string
+		expectedStatus domain.Status
+		expectedSource string
+	}{
+		{
+			name:           "primary succeeds",
+			paths:          []string{"/ok/file.txt", "/broken/file.txt"},
+			expectedStatus: domain.StatusCompleted,
+			expectedSource: "/ok/file.txt",
+		},
+		{
+			name:           "falls back to second mirror",
+			paths:          []string{"/broken/file.txt", "/missing/file.txt", "/ok/file.txt"},
+			expectedStatus: domain.StatusCompleted,
+			expectedSource: "/ok/file.txt",
+		},
+		{
+			name:           "all mirrors fail",
+			paths:          []string{"/broken/file.txt", "/missing/file.txt"},
+			expectedStatus: domain.StatusFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case strings.HasPrefix(r.URL.Path, "/ok/"):
+					w.Header().Set("Content-Type", "text/plain")
+					io.WriteString(w, "mirrored content")
+				case strings.HasPrefix(r.URL.Path, "/missing/"):
+					http.NotFound(w, r)
+				default:
+					w.WriteHeader(http.StatusBadGateway)
+				}
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(1, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+
+			var mirrors []string
+			for _, p := range tt.paths[1:] {
+				mirrors = append(mirrors, srv.URL+p)
+			}
+			task, err := tm.CreateTaskWithOptions([]string{srv.URL + tt.paths[0]}, TaskOptions{
+				Mirrors: [][]string{mirrors},
+			})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			wp.processTask(0, DownloadTask{TaskID: task.ID, FileIndex: 0})
+
+			task, _ = tm.GetTask(task.ID)
+			file := task.Files[0]
+			if file.Status != tt.expectedStatus {
+				t.Fatalf("expected status %s, got %s (%s)", tt.expectedStatus, file.Status, file.Error)
+			}
+			if tt.expectedSource != "" && file.SourceURL != srv.URL+tt.expectedSource {
+				t.Errorf("expected source %s, got %s", srv.URL+tt.expectedSource, file.SourceURL)
+			}
+			if tt.expectedStatus == domain.StatusFailed && file.ErrorCode != "http_404" {
+				t.Errorf("expected error code of the last mirror http_404, got %s", file.ErrorCode)
+			}
+			if tt.expectedStatus == domain.StatusCompleted && file.Filename != "file.txt" {
+				t.Errorf("expected filename file.txt, got %s", file.Filename)
+			}
+		})
+	}
+}
+
+// TestWorkerPoolConditionalRequests tests that unchanged files are reused from the local copy
+func TestWorkerPoolConditionalRequests(t *testing.T) {
+	tests := []struct {
+		name              string
+		layout            string
+		conditional       bool
+		expectedTransfers int
+	}{
+		{
+			name:              "per task layout reuses copy",
+			layout:            LayoutPerTask,
+			conditional:       true,
+			expectedTransfers: 1,
+		},
+		{
+			name:              "flat layout reuses copy",
+			layout:            LayoutFlat,
+			conditional:       true,
+			expectedTransfers: 1,
+		},
+		{
+			name:              "disabled always downloads",
+			layout:            LayoutPerTask,
+			conditional:       false,
+			expectedTransfers: 2,
+		},
+	}
+
+	const etag = `"v1"`
+	const content = "cached content"
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var transfers atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", etag)
+				if r.Header.Get("If-None-Match") == etag {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Header().Set("Content-Type", "text/plain")
+				if r.Method == "GET" && r.Header.Get("Range") == "" {
+					transfers.Add(1)
+				}
+				io.WriteString(w, content)
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(1, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			wp.downloader.layout = tt.layout
+			wp.downloader.conditionalRequests = tt.conditional
+
+			var last *domain.Task
+			for i := 0; i < 2; i++ {
+				task, err := tm.CreateTask([]string{srv.URL + "/file.txt"})
+				if err != nil {
+					t.Fatalf("failed to create task: %v", err)
+				}
+				wp.processTask(0, DownloadTask{TaskID: task.ID, FileIndex: 0})
+				last, _ = tm.GetTask(task.ID)
+				// distinct completion times keep the lookup deterministic
+				time.Sleep(time.Millisecond)
+			}
+
+			file := last.Files[0]
+			if file.Status != domain.StatusCompleted {
+				t.Fatalf("expected status %s, got %s (%s)", domain.StatusCompleted, file.Status, file.Error)
+			}
+			if file.ETag != etag {
+				t.Errorf("expected etag %s, got %s", etag, file.ETag)
+			}
+			if got := int(transfers.Load()); got != tt.expectedTransfers {
+				t.Errorf("expected %d transfers, got %d", tt.expectedTransfers, got)
+			}
+
+			data, err := os.ReadFile(filepath.Join(wp.downloader.TaskDir(last.ID), file.Filename))
+			if err != nil {
+				t.Fatalf("failed to read file: %v", err)
+			}
+			if string(data) != content || file.Size != int64(len(content)) {
+				t.Errorf("expected %q of size %d, got %q of size %d", content, len(content), data, file.Size)
+			}
+		})
+	}
+}
+
+// TestWorkerPoolStopAbortsDownloads tests that stopping the pool interrupts active transfers
+func TestWorkerPoolStopAbortsDownloads(t *testing.T) {
+	started := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			return
+		}
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		for {
+			if _, err := io.WriteString(w, "chunk"); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	wp := NewWorkerPool(1, tm)
+	wp.downloader.downloadsDir = t.TempDir()
+	wp.Start()
+
+	task, err := tm.CreateTask([]string{srv.URL + "/endless.bin"})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	wp.ProcessFiles(task.ID, task.Files)
+
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("download did not start")
+	}
+
+	start := time.Now()
+	wp.Stop()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected Stop to abort the transfer promptly, took %v", elapsed)
+	}
+
+	task, _ = tm.GetTask(task.ID)
+	if task.Files[0].Status != domain.StatusPending {
+		t.Errorf("expected interrupted file to stay %s for recovery, got %s", domain.StatusPending, task.Files[0].Status)
+	}
+}
+
+// TestWorkerPoolCancelFile tests that cancelling a file interrupts its download and lets the task complete with the rest
+func TestWorkerPoolCancelFile(t *testing.T) {
+	started := make(chan struct{}, 1)
+	interrupted := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/endless.bin" {
+			io.WriteString(w, "content")
+			return
+		}
+		if r.Method == "HEAD" {
+			return
+		}
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		for {
+			if _, err := io.WriteString(w, "chunk"); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-r.Context().Done():
+				close(interrupted)
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	wp := NewWorkerPool(2, tm)
+	wp.downloader.downloadsDir = t.TempDir()
+	wp.Start()
+	defer wp.Stop()
+
+	task, err := tm.CreateTask([]string{srv.URL + "/a.txt", srv.URL + "/endless.bin", srv.URL + "/b.txt"})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	if _, err := tm.PauseTask(task.ID); err != nil {
+		t.Fatalf("failed to pause task: %v", err)
+	}
+	if _, err := wp.CancelFile(task.ID, 2); err != nil {
+		t.Fatalf("failed to cancel a paused file: %v", err)
+	}
+	task, err = tm.ResumeTask(task.ID)
+	if err != nil {
+		t.Fatalf("failed to resume task: %v", err)
+	}
+	wp.ProcessFiles(task.ID, task.Files)
+
+	select {
+	case <-started:
+	case <-time.After(2 * time.Second):
+		t.Fatal("download did not start")
+	}
+	if _, err := wp.CancelFile(task.ID, 1); err != nil {
+		t.Fatalf("failed to cancel a downloading file: %v", err)
+	}
+
+	select {
+	case <-interrupted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("download of the cancelled file was not interrupted")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	task, err = wp.WaitForTask(ctx, task.ID)
+	if err != nil {
+		t.Fatalf("task did not finish: %v", err)
+	}
+	if task.Status != domain.StatusCompleted || task.Progress != 100 {
+		t.Errorf("expected the task to complete with the remaining file, got %s at %d%%", task.Status, task.Progress)
+	}
+	expected := []domain.Status{domain.StatusCompleted, domain.StatusCancelled, domain.StatusCancelled}
+	for i, status := range expected {
+		if task.Files[i].Status != status {
+			t.Errorf("expected file %d to be %s, got %s", i, status, task.Files[i].Status)
+		}
+	}
+	if _, err := os.Stat(wp.downloader.PartPath(task.ID, 1)); !os.IsNotExist(err) {
+		t.Errorf("expected the partial download to be removed, got %v", err)
+	}
+
+	if _, err := wp.CancelFile(task.ID, 0); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("expected %v for a completed file, got %v", ErrInvalidTransition, err)
+	}
+	if _, err := wp.CancelFile(task.ID, 3); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("expected %v for an index out of range, got %v", ErrFileNotFound, err)
+	}
+}
+
+// TestWorkerPoolDryRun tests that a dry run resolves sizes and names without downloading
+func TestWorkerPoolDryRun(t *testing.T) {
+	tests := []struct {
+		name             string
+		path             string
+		expectedStatus   domain.Status
+		expectedSize     int64
+		expectedFilename string
+	}{
+		{
+			name:             "reachable file",
+			path:             "/report",
+			expectedStatus:   domain.StatusValidated,
+			expectedSize:     11,
+			expectedFilename: "annual.pdf",
+		},
+		{
+			name:           "missing file",
+			path:           "/missing",
+			expectedStatus: domain.StatusFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gets atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/missing" {
+					http.NotFound(w, r)
+					return
+				}
+				if r.Method == "GET" {
+					gets.Add(1)
+				}
+				w.Header().Set("Content-Type", "application/pdf")
+				w.Header().Set("Content-Disposition", `attachment; filename="annual.pdf"`)
+				io.WriteString(w, "pdf content")
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(1, tm)
+			downloadsDir := t.TempDir()
+			wp.downloader.downloadsDir = downloadsDir
+
+			task, err := tm.CreateTaskWithOptions([]string{srv.URL + tt.path}, TaskOptions{DryRun: true})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			wp.processTask(0, DownloadTask{TaskID: task.ID, FileIndex: 0})
+
+			task, _ = tm.GetTask(task.ID)
+			file := task.Files[0]
+			if file.Status != tt.expectedStatus {
+				t.Fatalf("expected status %s, got %s (%s)", tt.expectedStatus, file.Status, file.Error)
+			}
+			if gets.Load() != 0 {
+				t.Errorf("expected no GET requests, got %d", gets.Load())
+			}
+			if entries, _ := os.ReadDir(downloadsDir); len(entries) != 0 {
+				t.Errorf("expected nothing written to disk, found %d entries", len(entries))
+			}
+			if tt.expectedStatus != domain.StatusValidated {
+				return
+			}
+			if file.Size != tt.expectedSize || file.Downloaded != 0 {
+				t.Errorf("expected size %d and nothing downloaded, got size %d downloaded %d", tt.expectedSize, file.Size, file.Downloaded)
+			}
+			if file.Filename != tt.expectedFilename {
+				t.Errorf("expected filename %s, got %s", tt.expectedFilename, file.Filename)
+			}
+			if task.Status != domain.StatusCompleted || task.Progress != 100 || !task.DryRun {
+				t.Errorf("expected completed dry run at 100%%, got %s at %d%% (dry_run=%v)", task.Status, task.Progress, task.DryRun)
+			}
+		})
+	}
+}
+
+// TestComputeProgress tests progress for known, unknown and inconsistent file sizes
+func TestComputeProgress(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []domain.File
+		expected int
+	}{
+		{
+			name: "known sizes weighted by bytes",
+			files: []domain.File{
+				{Size: 100, Downloaded: 100, Status: domain.StatusCompleted},
+				{Size: 300, Downloaded: 0, Status: domain.StatusDownloading},
+			},
+			expected: 25,
+		},
+		{
+			name: "unknown sizes count files",
+			files: []domain.File{
+				{Size: 0, Downloaded: 500, Status: domain.StatusCompleted},
+				{Size: 0, Downloaded: 200, Status: domain.StatusDownloading},
+			},
+			expected: 50,
+		},
+		{
+			name: "mix of known and unknown sizes",
+			files: []domain.File{
+				{Size: 100, Downloaded: 50, Status: domain.StatusDownloading},
+				{Size: 0, Downloaded: 700, Status: domain.StatusDownloading},
+			},
+			expected: 25,
+		},
+		{
+			name: "downloaded beyond size",
+			files: []domain.File{
+				{Size: 100, Downloaded: 250, Status: domain.StatusDownloading},
+			},
+			expected: 99,
+		},
+		{
+			name: "negative values",
+			files: []domain.File{
+				{Size: -1, Downloaded: -10, Status: domain.StatusDownloading},
+			},
+			expected: 0,
+		},
+		{
+			name: "failed empty file",
+			files: []domain.File{
+				{Size: 0, Status: domain.StatusCompleted},
+				{Size: 0, Status: domain.StatusFailed},
+			},
+			expected: 50,
+		},
+		{
+			name: "all completed",
+			files: []domain.File{
+				{Size: 0, Status: domain.StatusCompleted},
+				{Size: 100, Downloaded: 100, Status: domain.StatusCompleted},
+			},
+			expected: 100,
+		},
+		{
+			name: "cancelled files are left out",
+			files: []domain.File{
+				{Size: 300, Downloaded: 0, Status: domain.StatusCancelled},
+				{Size: 100, Downloaded: 50, Status: domain.StatusDownloading},
+			},
+			expected: 50,
+		},
+		{
+			name: "completed apart from cancelled files",
+			files: []domain.File{
+				{Size: 100, Downloaded: 100, Status: domain.StatusCompleted},
+				{Size: 300, Downloaded: 10, Status: domain.StatusCancelled},
+			},
+			expected: 100,
+		},
+		{
+			name: "all cancelled",
+			files: []domain.File{
+				{Size: 100, Downloaded: 10, Status: domain.StatusCancelled},
+			},
+			expected: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := &domain.Task{Status: domain.StatusDownloading, Files: tt.files}
+			refreshTaskStatus(task)
+			if task.Progress != tt.expected {
+				t.Errorf("expected progress %d, got %d", tt.expected, task.Progress)
+			}
+			if task.Progress < 0 || task.Progress > 100 {
+				t.Errorf("progress %d out of range", task.Progress)
+			}
+		})
+	}
+}
+
+// TestWorkerPoolMaxPerHost tests that downloads from one host are limited while all files still complete
+func TestWorkerPoolMaxPerHost(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxPerHost  int
+		expectedMax int32
+	}{
+		{
+			name:        "limited",
+			maxPerHost:  1,
+			expectedMax: 1,
+		},
+		{
+			name:        "unlimited",
+			maxPerHost:  0,
+			expectedMax: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var active, maxActive atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "HEAD" {
+					return
+				}
+				n := active.Add(1)
+				defer active.Add(-1)
+				for {
+					m := maxActive.Load()
+					if n <= m || maxActive.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(50 * time.Millisecond)
+				io.WriteString(w, "content")
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(4, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			wp.SetMaxPerHost(tt.maxPerHost)
+			wp.Start()
+			defer wp.Stop()
+
+			urls := make([]string, 4)
+			for i := range urls {
+				urls[i] = fmt.Sprintf("%s/file%d.txt", srv.URL, i)
+			}
+			task, err := tm.CreateTask(urls)
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			wp.ProcessFiles(task.ID, task.Files)
+
+			deadline := time.Now().Add(3 * time.Second)
+			for time.Now().Before(deadline) {
+				if current, _ := tm.GetTask(task.ID); current.Status == domain.StatusCompleted {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			task, _ = tm.GetTask(task.ID)
+			if task.Status != domain.StatusCompleted {
+				t.Fatalf("expected task to complete, got %s", task.Status)
+			}
+			if maxActive.Load() != tt.expectedMax {
+				t.Errorf("expected %d concurrent downloads, got %d", tt.expectedMax, maxActive.Load())
+			}
+		})
+	}
+}
+
+// TestWorkerPoolMaxPerTask tests that downloads of one task are capped while all files still complete
+func TestWorkerPoolMaxPerTask(t *testing.T) {
+	tests := []struct {
+		name           string
+		workers        int
+		maxPerTask     int
+		maxConcurrency int
+		files          int
+		expectedMax    int32
+	}{
+		{
+			name:        "pool default",
+			workers:     4,
+			maxPerTask:  2,
+			files:       6,
+			expectedMax: 2,
+		},
+		{
+			name:           "per task override",
+			workers:        4,
+			maxPerTask:     3,
+			maxConcurrency: 1,
+			files:          4,
+			expectedMax:    1,
+		},
+		{
+			name:        "fewer workers than cap",
+			workers:     1,
+			maxPerTask:  3,
+			files:       3,
+			expectedMax: 1,
+		},
+		{
+			name:        "fewer files than cap",
+			workers:     4,
+			maxPerTask:  5,
+			files:       2,
+			expectedMax: 2,
+		},
+		{
+			name:        "unlimited",
+			workers:     4,
+			files:       4,
+			expectedMax: 4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var active, maxActive atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "HEAD" {
+					return
+				}
+				n := active.Add(1)
+				defer active.Add(-1)
+				for {
+					m := maxActive.Load()
+					if n <= m || maxActive.CompareAndSwap(m, n) {
+						break
+					}
+				}
+				time.Sleep(50 * time.Millisecond)
+				io.WriteString(w, "content")
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(tt.workers, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			wp.SetMaxPerTask(tt.maxPerTask)
+			wp.Start()
+			defer wp.Stop()
+
+			urls := make([]string, tt.files)
+			for i := range urls {
+				urls[i] = fmt.Sprintf("%s/file%d.txt", srv.URL, i)
+			}
+			task, err := tm.CreateTaskWithOptions(urls, TaskOptions{MaxConcurrency: tt.maxConcurrency})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			wp.ProcessFiles(task.ID, task.Files)
+
+			deadline := time.Now().Add(3 * time.Second)
+			for time.Now().Before(deadline) {
+				if current, _ := tm.GetTask(task.ID); current.Status == domain.StatusCompleted {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			task, _ = tm.GetTask(task.ID)
+			if task.Status != domain.StatusCompleted {
+				t.Fatalf("expected task to complete, got %s", task.Status)
+			}
+			if maxActive.Load() != tt.expectedMax {
+				t.Errorf("expected %d concurrent downloads, got %d", tt.expectedMax, maxActive.Load())
+			}
+		})
+	}
+}
+
+// TestWorkerPoolMaxPerTaskLeavesHeadroom tests that a capped task does not keep other tasks waiting
+func TestWorkerPoolMaxPerTaskLeavesHeadroom(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/slow") {
+			<-release
+		}
+		io.WriteString(w, "content")
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	wp := NewWorkerPool(2, tm)
+	wp.downloader.downloadsDir = t.TempDir()
+	wp.SetMaxPerTask(1)
+	wp.Start()
+	defer wp.Stop()
+
+	slow, err := tm.CreateTask([]string{srv.URL + "/slow1.txt", srv.URL + "/slow2.txt", srv.URL + "/slow3.txt"})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	wp.ProcessFiles(slow.ID, slow.Files)
+	fast, err := tm.CreateTask([]string{srv.URL + "/fast.txt"})
+	if err != nil {
+		t.Fatalf("failed to create task: %v", err)
+	}
+	wp.ProcessFiles(fast.ID, fast.Files)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		if current, _ := tm.GetTask(fast.ID); current.Status == domain.StatusCompleted {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("expected the second task to complete while the first one is capped")
+}
+
+// TestWorkerPoolRetries tests that transient failures are retried up to the attempt limit
+func TestWorkerPoolRetries(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxAttempts      int
+		failures         int32
+		failStatus       int
+		backoff          time.Duration
+		expectedStatus   domain.Status
+		expectedFile     domain.Status
+		expectedAttempts int
+	}{
+		{
+			name:             "succeeds after retries",
+			maxAttempts:      3,
+			failures:         2,
+			failStatus:       http.StatusServiceUnavailable,
+			expectedStatus:   domain.StatusCompleted,
+			expectedFile:     domain.StatusCompleted,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "gives up after max attempts",
+			maxAttempts:      2,
+			failures:         5,
+			failStatus:       http.StatusServiceUnavailable,
+			expectedStatus:   domain.StatusFailed,
+			expectedFile:     domain.StatusFailed,
+			expectedAttempts: 2,
+		},
+		{
+			name:             "permanent error is not retried",
+			maxAttempts:      3,
+			failures:         5,
+			failStatus:       http.StatusNotFound,
+			expectedStatus:   domain.StatusFailed,
+			expectedFile:     domain.StatusFailed,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "waits in retrying state",
+			maxAttempts:      3,
+			failures:         5,
+			failStatus:       http.StatusBadGateway,
+			backoff:          time.Hour,
+			expectedStatus:   domain.StatusDownloading,
+			expectedFile:     domain.StatusRetrying,
+			expectedAttempts: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "HEAD" {
+					return
+				}
+				if requests.Add(1) <= tt.failures {
+					w.WriteHeader(tt.failStatus)
+					return
+				}
+				io.WriteString(w, "content")
+			}))
+			defer srv.Close()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(1, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			wp.SetRetryPolicy(tt.maxAttempts, tt.backoff)
+			wp.Start()
+			defer wp.Stop()
+
+			task, err := tm.CreateTask([]string{srv.URL + "/file.txt"})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			wp.ProcessFiles(task.ID, task.Files)
+
+			deadline := time.Now().Add(3 * time.Second)
+			for time.Now().Before(deadline) {
+				current, _ := tm.GetTask(task.ID)
+				if current.Files[0].Status == tt.expectedFile && current.Files[0].Attempts == tt.expectedAttempts {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			task, _ = tm.GetTask(task.ID)
+			file := task.Files[0]
+			if file.Status != tt.expectedFile {
+				t.Fatalf("expected file status %s, got %s (%s)", tt.expectedFile, file.Status, file.Error)
+			}
+			if file.Attempts != tt.expectedAttempts {
+				t.Errorf("expected %d attempts, got %d", tt.expectedAttempts, file.Attempts)
+			}
+			if task.Status != tt.expectedStatus {
+				t.Errorf("expected task status %s, got %s", tt.expectedStatus, task.Status)
+			}
+		})
+	}
+}
+
+// TestWorkerPoolMaxActiveTasks tests that tasks over the active task limit wait queued until a slot frees up
+func TestWorkerPoolMaxActiveTasks(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/slow") {
+			<-release
+		}
+		io.WriteString(w, "content")
+	}))
+	defer srv.Close()
+
+	tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+	wp := NewWorkerPool(4, tm)
+	wp.downloader.downloadsDir = t.TempDir()
+	wp.SetMaxActiveTasks(1)
+	wp.Start()
+	defer wp.Stop()
+
+	var tasks []*domain.Task
+	for _, path := range []string{"/slow.txt", "/second.txt", "/third.txt"} {
+		task, err := tm.CreateTask([]string{srv.URL + path})
+		if err != nil {
+			t.Fatalf("failed to create task: %v", err)
+		}
+		wp.ProcessFiles(task.ID, task.Files)
+		tasks = append(tasks, task)
+	}
+
+	for i, task := range tasks[1:] {
+		current, _ := tm.GetTask(task.ID)
+		if current.Status != domain.StatusQueued {
+			t.Errorf("expected task %d to be queued, got %s", i+1, current.Status)
+		}
+		if pos := wp.QueuePosition(task.ID); pos != i+1 {
+			t.Errorf("expected task %d at queue position %d, got %d", i+1, i+1, pos)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if current, _ := tm.GetTask(tasks[1].ID); current.Status != domain.StatusQueued {
+		t.Fatalf("expected the queued task to wait for the active one, got %s", current.Status)
+	}
+
+	close(release)
+	for _, task := range tasks {
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			if current, _ := tm.GetTask(task.ID); current.Status == domain.StatusCompleted {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if current, _ := tm.GetTask(task.ID); current.Status != domain.StatusCompleted {
+			t.Fatalf("expected task %s to complete, got %s", task.ID, current.Status)
+		}
+		if pos := wp.QueuePosition(task.ID); pos != 0 {
+			t.Errorf("expected no queue position after completion, got %d", pos)
+		}
+	}
+}
+
+// TestWorkerPoolLogsWorkerID tests that the download log lines of a file carry the ID of the worker processing it
+func TestWorkerPoolLogsWorkerID(t *testing.T) {
+	tests := []struct {
+		name             string
+		path             string
+		expectedMessages []string
+	}{
+		{
+			name:             "completed download",
+			path:             "/file.txt",
+			expectedMessages: []string{"Processing file", "Download completed"},
+		},
+		{
+			name:             "failed download",
+			path:             "/missing.txt",
+			expectedMessages: []string{"Processing file", "Download failed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/missing.txt" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				io.WriteString(w, "content")
+			}))
+			defer srv.Close()
+
+			var buf bytes.Buffer
+			original := logger.Logger
+			logger.Logger = logger.NewJSONLogger(&buf, slog.LevelDebug)
+			defer func() { logger.Logger = original }()
+
+			tm := NewTaskManagerWithStorage(repository.NewMemoryStorage())
+			wp := NewWorkerPool(1, tm)
+			wp.downloader.downloadsDir = t.TempDir()
+			task, err := tm.CreateTask([]string{srv.URL + tt.path})
+			if err != nil {
+				t.Fatalf("failed to create task: %v", err)
+			}
+			wp.processTask(7, DownloadTask{TaskID: task.ID, FileIndex: 0})
+
+			seen := make(map[string]bool)
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var entry map[string]interface{}
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("failed to parse log line %q: %v", line, err)
+				}
+				if entry["task_id"] != task.ID || entry["msg"] == "Created task" {
+					continue
+				}
+				msg, _ := entry["msg"].(string)
+				seen[msg] = true
+				if entry["worker_id"] != float64(7) {
+					t.Errorf("expected worker_id 7 on %q, got %v", msg, entry["worker_id"])
+				}
+			}
+			for _, msg := range tt.expectedMessages {
+				if !seen[msg] {
+					t.Errorf("expected a %q log line, got %v", msg, seen)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+package version
+
+// Version is the build version of the service. It is "dev" for local builds
+// and is set at link time for releases:
+//
+//	go build -ldflags "-X filedownloader-20240926/internal/version.Version=v1.2.3"
+var Version = "dev"
+
+// UserAgent returns the default User-Agent sent with download requests
+func UserAgent() string {
+	return "FileDownloader/" + Version
+}
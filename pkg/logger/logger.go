@@ -8,6 +8,7 @@ import (
 	"log/slog"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,17 +16,27 @@ import (
 // CustomHandler - custom handler for slog
 type CustomHandler struct {
 	opts   HandlerOptions
+	level  *slog.LevelVar
 	mu     *sync.Mutex
 	writer io.Writer
 	attrs  []slog.Attr
 	groups []string
 }
 
+// Special TimeFormat values that render numeric Unix timestamps
+const (
+	TimeFormatUnix      = "unix"
+	TimeFormatUnixMilli = "unix_ms"
+)
+
 // HandlerOptions - options for custom handler
 type HandlerOptions struct {
 	Level     slog.Leveler
 	AddSource bool
 	Formatter Formatter
+	// TimeFormat is a time layout, TimeFormatUnix or TimeFormatUnixMilli.
+	// Empty keeps the formatter's default layout.
+	TimeFormat string
 }
 
 // Formatter - interface for log formatting
@@ -33,14 +44,41 @@ type Formatter interface {
 	Format(record slog.Record, attrs []slog.Attr, groups []string) ([]byte, error)
 }
 
+// timeFormatter - formatter that supports a custom time format
+type timeFormatter interface {
+	withTimeFormat(layout string) Formatter
+}
+
+// formatTime renders the time with the layout or as a Unix timestamp
+func formatTime(t time.Time, layout string) interface{} {
+	switch layout {
+	case TimeFormatUnix:
+		return t.Unix()
+	case TimeFormatUnixMilli:
+		return t.UnixMilli()
+	}
+	return t.Format(layout)
+}
+
 // JSONFormatter - JSON formatter
-type JSONFormatter struct{}
+type JSONFormatter struct {
+	// TimeFormat overrides the default RFC3339Nano layout
+	TimeFormat string
+}
+
+func (f *JSONFormatter) withTimeFormat(layout string) Formatter {
+	return &JSONFormatter{TimeFormat: layout}
+}
 
 func (f *JSONFormatter) Format(record slog.Record, attrs []slog.Attr, groups []string) ([]byte, error) {
 	logEntry := make(map[string]interface{})
 
 	if !record.Time.IsZero() {
-		logEntry["time"] = record.Time.Format(time.RFC3339Nano)
+		layout := f.TimeFormat
+		if layout == "" {
+			layout = time.RFC3339Nano
+		}
+		logEntry["time"] = formatTime(record.Time, layout)
 	}
 	logEntry["level"] = record.Level.String()
 	logEntry["msg"] = record.Message
@@ -63,11 +101,11 @@ func (f *JSONFormatter) Format(record slog.Record, attrs []slog.Attr, groups []s
 	}
 
 	for _, attr := range attrs {
-		current[attr.Key] = attr.Value.Any()
+		current[attr.Key] = jsonValue(attr.Value)
 	}
 
 	record.Attrs(func(attr slog.Attr) bool {
-		current[attr.Key] = attr.Value.Any()
+		current[attr.Key] = jsonValue(attr.Value)
 		return true
 	})
 
@@ -79,15 +117,39 @@ func (f *JSONFormatter) Format(record slog.Record, attrs []slog.Attr, groups []s
 	return b, nil
 }
 
+// jsonValue returns the value to marshal for an attribute. Errors have no
+// exported fields and would encode as {}, so their message is used instead.
+func jsonValue(v slog.Value) interface{} {
+	a := v.Any()
+	if _, ok := a.(json.Marshaler); ok {
+		return a
+	}
+	if err, ok := a.(error); ok {
+		return err.Error()
+	}
+	return a
+}
+
 // TextFormatter - text formatter
-type TextFormatter struct{}
+type TextFormatter struct {
+	// TimeFormat overrides the default "2006-01-02T15:04:05" layout
+	TimeFormat string
+}
+
+func (f *TextFormatter) withTimeFormat(layout string) Formatter {
+	return &TextFormatter{TimeFormat: layout}
+}
 
 func (f *TextFormatter) Format(record slog.Record, attrs []slog.Attr, groups []string) ([]byte, error) {
 	var buf []byte
 
 	// Time
 	if !record.Time.IsZero() {
-		buf = fmt.Appendf(buf, "time=%s ", record.Time.Format("2006-01-02T15:04:05"))
+		layout := f.TimeFormat
+		if layout == "" {
+			layout = "2006-01-02T15:04:05"
+		}
+		buf = fmt.Appendf(buf, "time=%v ", formatTime(record.Time, layout))
 	}
 
 	// Level
@@ -135,9 +197,19 @@ func NewCustomHandler(writer io.Writer, opts *HandlerOptions) *CustomHandler {
 	if opts.Formatter == nil {
 		opts.Formatter = &JSONFormatter{}
 	}
+	if tf, ok := opts.Formatter.(timeFormatter); ok && opts.TimeFormat != "" {
+		opts.Formatter = tf.withTimeFormat(opts.TimeFormat)
+	}
+
+	level, ok := opts.Level.(*slog.LevelVar)
+	if !ok {
+		level = new(slog.LevelVar)
+		level.Set(opts.Level.Level())
+	}
 
 	return &CustomHandler{
 		opts:   *opts,
+		level:  level,
 		mu:     &sync.Mutex{},
 		writer: writer,
 		attrs:  make([]slog.Attr, 0),
@@ -147,7 +219,17 @@ func NewCustomHandler(writer io.Writer, opts *HandlerOptions) *CustomHandler {
 
 // Enabled checks if the given log level is enabled
 func (h *CustomHandler) Enabled(ctx context.Context, level slog.Level) bool {
-	return level >= h.opts.Level.Level()
+	return level >= h.level.Level()
+}
+
+// SetLevel changes the minimum level of the handler and all handlers derived from it
+func (h *CustomHandler) SetLevel(level slog.Level) {
+	h.level.Set(level)
+}
+
+// Level returns the current minimum level of the handler
+func (h *CustomHandler) Level() slog.Level {
+	return h.level.Level()
 }
 
 // Handle processes a log record
@@ -202,12 +284,13 @@ func (h *CustomHandler) WithGroup(name string) slog.Handler {
 	return &h2
 }
 
-// NewLogger creates a new logger with custom handler
-func NewLogger(writer io.Writer, level slog.Level, formatter Formatter, addSource bool) *slog.Logger {
+// NewLogger creates a new logger with custom handler; an empty timeFormat keeps the formatter default
+func NewLogger(writer io.Writer, level slog.Level, formatter Formatter, addSource bool, timeFormat string) *slog.Logger {
 	opts := &HandlerOptions{
-		Level:     level,
-		Formatter: formatter,
-		AddSource: addSource,
+		Level:      level,
+		Formatter:  formatter,
+		AddSource:  addSource,
+		TimeFormat: timeFormat,
 	}
 
 	handler := NewCustomHandler(writer, opts)
@@ -217,12 +300,12 @@ func NewLogger(writer io.Writer, level slog.Level, formatter Formatter, addSourc
 // Helper functions for creating different types of loggers
 // NewJSONLogger creates a new JSON logger
 func NewJSONLogger(writer io.Writer, level slog.Level) *slog.Logger {
-	return NewLogger(writer, level, &JSONFormatter{}, false)
+	return NewLogger(writer, level, &JSONFormatter{}, false, "")
 }
 
 // NewTextLogger creates a new text logger
 func NewTextLogger(writer io.Writer, level slog.Level) *slog.Logger {
-	return NewLogger(writer, level, &TextFormatter{}, false)
+	return NewLogger(writer, level, &TextFormatter{}, false, "")
 }
 
 // NewDevelopmentLogger creates a logger for development environment
@@ -246,3 +329,39 @@ func SetDebug() {
 func SetProduction() {
 	Logger = NewProductionLogger()
 }
+
+// levelSetter - handler whose level can be changed at runtime
+type levelSetter interface {
+	SetLevel(level slog.Level)
+	Level() slog.Level
+}
+
+// SetLevel changes the level of the global logger at runtime without recreating it
+func SetLevel(level slog.Level) {
+	if h, ok := Logger.Handler().(levelSetter); ok {
+		h.SetLevel(level)
+	}
+}
+
+// GetLevel returns the current level of the global logger
+func GetLevel() slog.Level {
+	if h, ok := Logger.Handler().(levelSetter); ok {
+		return h.Level()
+	}
+	return slog.LevelInfo
+}
+
+// ParseLevel parses one of the supported level names: debug, info, warn, error
+func ParseLevel(name string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("invalid log level: %s", name)
+}
@@ -0,0 +1,214 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCustomHandlerSetLevel tests changing the level at runtime for derived loggers
+func TestCustomHandlerSetLevel(t *testing.T) {
+	tests := []struct {
+		name        string
+		initial     slog.Level
+		updated     slog.Level
+		expectDebug bool
+	}{
+		{
+			name:        "raise to debug",
+			initial:     slog.LevelInfo,
+			updated:     slog.LevelDebug,
+			expectDebug: true,
+		},
+		{
+			name:        "lower to error",
+			initial:     slog.LevelDebug,
+			updated:     slog.LevelError,
+			expectDebug: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			log := NewJSONLogger(&buf, tt.initial)
+			derived := log.With("component", "test")
+
+			log.Handler().(*CustomHandler).SetLevel(tt.updated)
+			derived.Debug("debug message")
+
+			if got := buf.Len() > 0; got != tt.expectDebug {
+				t.Errorf("expected debug output=%v, got %v", tt.expectDebug, got)
+			}
+		})
+	}
+}
+
+// TestParseLevel tests parsing of supported level names
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		expected    slog.Level
+		expectError bool
+	}{
+		{name: "debug", input: "debug", expected: slog.LevelDebug},
+		{name: "upper case", input: "WARN", expected: slog.LevelWarn},
+		{name: "invalid", input: "verbose", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			level, err := ParseLevel(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			if level != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, level)
+			}
+		})
+	}
+}
+
+// TestMultiHandler tests fan-out to handlers with independent formats and levels
+func TestMultiHandler(t *testing.T) {
+	tests := []struct {
+		name         string
+		level        slog.Level
+		expectJSON   bool
+		expectText   bool
+		textContains string
+	}{
+		{
+			name:         "info goes to both",
+			level:        slog.LevelInfo,
+			expectJSON:   true,
+			expectText:   true,
+			textContains: `msg="message"`,
+		},
+		{
+			name:         "debug goes to text only",
+			level:        slog.LevelDebug,
+			expectJSON:   false,
+			expectText:   true,
+			textContains: "level=DEBUG",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var jsonBuf, textBuf bytes.Buffer
+			log := slog.New(NewMultiHandler(
+				NewCustomHandler(&jsonBuf, &HandlerOptions{Level: slog.LevelInfo, Formatter: &JSONFormatter{}}),
+				NewCustomHandler(&textBuf, &HandlerOptions{Level: slog.LevelDebug, Formatter: &TextFormatter{}}),
+			)).With("component", "test")
+
+			log.Log(context.Background(), tt.level, "message")
+
+			if got := jsonBuf.Len() > 0; got != tt.expectJSON {
+				t.Errorf("expected JSON output=%v, got %v", tt.expectJSON, got)
+			}
+			if got := textBuf.Len() > 0; got != tt.expectText {
+				t.Errorf("expected text output=%v, got %v", tt.expectText, got)
+			}
+			if tt.expectJSON && !strings.HasPrefix(jsonBuf.String(), "{") {
+				t.Errorf("expected JSON line, got %q", jsonBuf.String())
+			}
+			if !strings.Contains(textBuf.String(), tt.textContains) || !strings.Contains(textBuf.String(), "component=test") {
+				t.Errorf("expected text line to contain %q and attrs, got %q", tt.textContains, textBuf.String())
+			}
+		})
+	}
+}
+
+// TestFormatterTimeFormat tests rendering of record time with configured formats
+func TestFormatterTimeFormat(t *testing.T) {
+	recordTime := time.Date(2024, 9, 26, 13, 45, 30, 123000000, time.UTC)
+
+	tests := []struct {
+		name       string
+		formatter  Formatter
+		timeFormat string
+		expected   string
+	}{
+		{
+			name:      "json default",
+			formatter: &JSONFormatter{},
+			expected:  `"time":"2024-09-26T13:45:30.123Z"`,
+		},
+		{
+			name:       "json custom layout",
+			formatter:  &JSONFormatter{},
+			timeFormat: time.DateTime,
+			expected:   `"time":"2024-09-26 13:45:30"`,
+		},
+		{
+			name:       "json unix",
+			formatter:  &JSONFormatter{},
+			timeFormat: TimeFormatUnix,
+			expected:   `"time":1727358330`,
+		},
+		{
+			name:      "text default",
+			formatter: &TextFormatter{},
+			expected:  "time=2024-09-26T13:45:30 ",
+		},
+		{
+			name:       "text custom layout",
+			formatter:  &TextFormatter{},
+			timeFormat: time.RFC3339,
+			expected:   "time=2024-09-26T13:45:30Z ",
+		},
+		{
+			name:       "text unix millis",
+			formatter:  &TextFormatter{},
+			timeFormat: TimeFormatUnixMilli,
+			expected:   "time=1727358330123 ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			h := NewCustomHandler(&buf, &HandlerOptions{Formatter: tt.formatter, TimeFormat: tt.timeFormat})
+
+			record := slog.NewRecord(recordTime, slog.LevelInfo, "message", 0)
+			if err := h.Handle(context.Background(), record); err != nil {
+				t.Fatalf("handle failed: %v", err)
+			}
+
+			if !strings.Contains(buf.String(), tt.expected) {
+				t.Errorf("expected output to contain %q, got %q", tt.expected, buf.String())
+			}
+		})
+	}
+}
+
+// TestJSONFormatterErrors tests that error attributes are written as their message
+func TestJSONFormatterErrors(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewCustomHandler(&buf, &HandlerOptions{Formatter: &JSONFormatter{}})
+
+	record := slog.NewRecord(time.Now(), slog.LevelError, "message", 0)
+	record.AddAttrs(slog.Any("error", fmt.Errorf("invalid configuration: %w", errors.Join(errors.New("a"), errors.New("b")))))
+	if err := h.Handle(context.Background(), record); err != nil {
+		t.Fatalf("handle failed: %v", err)
+	}
+
+	expected := `"error":"invalid configuration: a\nb"`
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("expected output to contain %q, got %q", expected, buf.String())
+	}
+}
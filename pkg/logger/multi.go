@@ -0,0 +1,89 @@
+package logger
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+)
+
+// MultiHandler - handler that fans out records to several handlers,
+// each with its own writer, formatter and level
+type MultiHandler struct {
+	handlers []slog.Handler
+}
+
+// NewMultiHandler creates a handler writing every record to all enabled sub-handlers
+func NewMultiHandler(handlers ...slog.Handler) *MultiHandler {
+	return &MultiHandler{handlers: handlers}
+}
+
+// Enabled reports whether any sub-handler accepts the level
+func (h *MultiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, sub := range h.handlers {
+		if sub.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle passes the record to every sub-handler that accepts its level
+func (h *MultiHandler) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, sub := range h.handlers {
+		if !sub.Enabled(ctx, record.Level) {
+			continue
+		}
+		if err := sub.Handle(ctx, record.Clone()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WithAttrs creates a new multi handler with additional attributes on every sub-handler
+func (h *MultiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make([]slog.Handler, 0, len(h.handlers))
+	for _, sub := range h.handlers {
+		handlers = append(handlers, sub.WithAttrs(attrs))
+	}
+	return &MultiHandler{handlers: handlers}
+}
+
+// WithGroup creates a new multi handler with a group on every sub-handler
+func (h *MultiHandler) WithGroup(name string) slog.Handler {
+	handlers := make([]slog.Handler, 0, len(h.handlers))
+	for _, sub := range h.handlers {
+		handlers = append(handlers, sub.WithGroup(name))
+	}
+	return &MultiHandler{handlers: handlers}
+}
+
+// SetLevel sets the same level on every sub-handler that supports it
+func (h *MultiHandler) SetLevel(level slog.Level) {
+	for _, sub := range h.handlers {
+		if ls, ok := sub.(levelSetter); ok {
+			ls.SetLevel(level)
+		}
+	}
+}
+
+// Level returns the lowest level accepted by any sub-handler
+func (h *MultiHandler) Level() slog.Level {
+	level := slog.LevelError + 1
+	for _, sub := range h.handlers {
+		if ls, ok := sub.(levelSetter); ok && ls.Level() < level {
+			level = ls.Level()
+		}
+	}
+	return level
+}
+
+// NewFormatter returns the formatter for a format name: json or text
+func NewFormatter(format string) Formatter {
+	if strings.ToLower(format) == "text" {
+		return &TextFormatter{}
+	}
+	return &JSONFormatter{}
+}
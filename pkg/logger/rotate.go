@@ -0,0 +1,126 @@
+package logger
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RotatingWriter - io.Writer that rotates the file when it exceeds the size limit
+type RotatingWriter struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	mu         sync.Mutex
+	file       *os.File
+	size       int64
+}
+
+// NewRotatingWriter opens (or creates) the log file and returns a rotating writer.
+// Rotated files are kept as path.1 ... path.N, where path.1 is the most recent.
+func NewRotatingWriter(path string, maxSizeMB, maxBackups int) (*RotatingWriter, error) {
+	if maxSizeMB <= 0 {
+		return nil, fmt.Errorf("max size must be positive: %d", maxSizeMB)
+	}
+	if maxBackups < 0 {
+		return nil, fmt.Errorf("max backups must not be negative: %d", maxBackups)
+	}
+
+	w := &RotatingWriter{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write writes data to the current file, rotating first if the write would exceed the limit
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.size+int64(len(p)) > w.maxSize && w.size > 0 {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the current log file
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// open opens the log file in append mode and records its current size
+func (w *RotatingWriter) open() error {
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		return fmt.Errorf("failed to create log dir: %w", err)
+	}
+
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+// rotate shifts existing backups, moves the current file to path.1 and reopens
+func (w *RotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+
+	if w.maxBackups == 0 {
+		os.Remove(w.path)
+	} else {
+		os.Remove(w.backupName(w.maxBackups))
+		for i := w.maxBackups - 1; i >= 1; i-- {
+			os.Rename(w.backupName(i), w.backupName(i+1))
+		}
+		if err := os.Rename(w.path, w.backupName(1)); err != nil {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	}
+
+	return w.open()
+}
+
+// backupName returns the file name of the n-th backup
+func (w *RotatingWriter) backupName(n int) string {
+	return fmt.Sprintf("%s.%d", w.path, n)
+}
+
+// NewRotatingFileLogger creates a JSON logger writing to a size-rotated file
+func NewRotatingFileLogger(path string, maxSizeMB, maxBackups int) (*slog.Logger, error) {
+	w, err := NewRotatingWriter(path, maxSizeMB, maxBackups)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONLogger(w, slog.LevelInfo), nil
+}
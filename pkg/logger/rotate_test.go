@@ -0,0 +1,100 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestRotatingWriter tests size-based rotation and backup retention
+func TestRotatingWriter(t *testing.T) {
+	tests := []struct {
+		name            string
+		maxBackups      int
+		writes          int
+		expectedBackups int
+	}{
+		{
+			name:            "no rotation under limit",
+			maxBackups:      2,
+			writes:          1,
+			expectedBackups: 0,
+		},
+		{
+			name:            "rotation keeps backups",
+			maxBackups:      2,
+			writes:          3,
+			expectedBackups: 2,
+		},
+		{
+			name:            "rotation without backups",
+			maxBackups:      0,
+			writes:          3,
+			expectedBackups: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "app.log")
+			w, err := NewRotatingWriter(path, 1, tt.maxBackups)
+			if err != nil {
+				t.Fatalf("failed to create writer: %v", err)
+			}
+			defer w.Close()
+
+			chunk := []byte(strings.Repeat("x", 700*1024))
+			for i := 0; i < tt.writes; i++ {
+				if _, err := w.Write(chunk); err != nil {
+					t.Fatalf("write failed: %v", err)
+				}
+			}
+
+			backups, _ := filepath.Glob(path + ".*")
+			if len(backups) != tt.expectedBackups {
+				t.Errorf("expected %d backups, got %d", tt.expectedBackups, len(backups))
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("log file missing: %v", err)
+			}
+			if info.Size() > 1024*1024 {
+				t.Errorf("current log file exceeds limit: %d", info.Size())
+			}
+		})
+	}
+}
+
+// TestRotatingFileLoggerConcurrent tests concurrent writes from many goroutines
+func TestRotatingFileLoggerConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	log, err := NewRotatingFileLogger(path, 1, 1)
+	if err != nil {
+		t.Fatalf("failed to create logger: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				log.Info("concurrent message", "goroutine", id, "iteration", j)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if !strings.HasPrefix(line, "{") || !strings.HasSuffix(line, "}") {
+			t.Fatalf("interleaved log line: %q", line)
+		}
+	}
+}